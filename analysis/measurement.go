@@ -2,6 +2,7 @@
 package analysis
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
@@ -13,6 +14,7 @@ import (
 	"github.com/peterbourgon/diskv"
 	"math"
 	"strings"
+	"sync"
 )
 
 // Measurement is a representation for how a measurement fits into the pipeline.
@@ -46,7 +48,26 @@ func (m MemoryMeasurementCache) Write(key string, val []byte) error {
 	return nil
 }
 
-// MeasurementExecutor executes measurements while caching the results to improve performance.
+// lockedMeasurementCache is a measurement cache that is safe to use from multiple goroutines.
+type lockedMeasurementCache struct {
+	sync.RWMutex
+	cache MeasurementCacher
+}
+
+func (l *lockedMeasurementCache) Read(key string) ([]byte, error) {
+	l.RLock()
+	defer l.RUnlock()
+	return l.cache.Read(key)
+}
+
+func (l *lockedMeasurementCache) Write(key string, val []byte) error {
+	l.Lock()
+	defer l.Unlock()
+	return l.cache.Write(key, val)
+}
+
+// MeasurementExecutor executes measurements while caching the results to improve performance. Measurement executors
+// may be used by multiple goroutines at once.
 type MeasurementExecutor struct {
 	cache MeasurementCacher
 }
@@ -61,11 +82,12 @@ func NewDiskMeasurementExecutor(d *diskv.Diskv) MeasurementExecutor {
 // NewMemoryMeasurementExecutor creates a measurement executor that caches to memory.
 func NewMemoryMeasurementExecutor() MeasurementExecutor {
 	return MeasurementExecutor{
-		cache: make(MemoryMeasurementCache),
+		cache: &lockedMeasurementCache{cache: make(MemoryMeasurementCache)},
 	}
 }
 
-// hash hashes a query and measurement pair ready to be cached.
+// hash hashes a query and measurement pair ready to be cached. Queries are hashed as they are written, since many
+// measurements are of the structure of a query, which differs between equivalent queries.
 func hash(representation cqr.CommonQueryRepresentation, measurement Measurement) string {
 	if representation == nil {
 		return "0"
@@ -76,10 +98,27 @@ func hash(representation cqr.CommonQueryRepresentation, measurement Measurement)
 	//return strconv.Itoa(int(h.Sum32()))
 }
 
+// Cached reports whether the measurement of a query is in the cache, so that executing it makes no requests to the
+// statistics source.
+func (m MeasurementExecutor) Cached(query pipeline.Query, measurement Measurement) bool {
+	v, err := m.cache.Read(hash(query.Query, measurement))
+	return err == nil && len(v) > 0
+}
+
 // Execute executes the specified measurements on the query using the statistics source.
 func (m MeasurementExecutor) Execute(query pipeline.Query, ss stats.StatisticsSource, measurements ...Measurement) ([]float64, error) {
+	return m.ExecuteContext(context.Background(), query, ss, measurements...)
+}
+
+// ExecuteContext executes the specified measurements on the query using the statistics source bound to ctx. Execution
+// stops with the error of ctx once it is done.
+func (m MeasurementExecutor) ExecuteContext(ctx context.Context, query pipeline.Query, ss stats.StatisticsSource, measurements ...Measurement) ([]float64, error) {
+	ss = stats.WithContext(ctx, ss)
 	results := make([]float64, len(measurements))
 	for i, measurement := range measurements {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		qHash := hash(query.Query, measurement)
 		if v, err := m.cache.Read(qHash); err == nil && len(v) > 0 {
 			bits := binary.BigEndian.Uint64(v)
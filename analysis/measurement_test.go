@@ -0,0 +1,76 @@
+package analysis_test
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/pipeline"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestMeasurementExecutorConcurrent(t *testing.T) {
+	me := analysis.NewMemoryMeasurementExecutor()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Half of the queries are shared so that the cache is both read and written concurrently.
+			q := pipeline.NewQuery(strconv.Itoa(i), strconv.Itoa(i%16), cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{
+				cqr.NewKeyword("heart"+strconv.Itoa(i%16), "title"),
+				cqr.NewKeyword("attack", "title"),
+			}))
+			v, err := me.Execute(q, nil, analysis.TermCount, analysis.BooleanKeywords)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if v[0] != 2 || v[1] != 2 {
+				t.Errorf("expected [2 2], got %v", v)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestMeasurementExecutorCached(t *testing.T) {
+	me := analysis.NewMemoryMeasurementExecutor()
+	q := pipeline.NewQuery("1", "1", cqr.NewKeyword("heart", "title"))
+	if me.Cached(q, analysis.TermCount) {
+		t.Error("expected the measurement not to be cached")
+	}
+	if _, err := me.Execute(q, nil, analysis.TermCount); err != nil {
+		t.Fatal(err)
+	}
+	if !me.Cached(q, analysis.TermCount) {
+		t.Error("expected the measurement to be cached")
+	}
+	if me.Cached(q, analysis.BooleanKeywords) {
+		t.Error("expected only the executed measurement to be cached")
+	}
+}
+
+func TestMeasurementExecutorEquivalent(t *testing.T) {
+	// Equivalent queries are measured separately, since measurements of the structure of queries differ between them.
+	me := analysis.NewMemoryMeasurementExecutor()
+	a, b := cqr.NewKeyword("heart", "title"), cqr.NewKeyword("attack", "title")
+	q := pipeline.NewQuery("1", "1", cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{a, b}))
+	if _, err := me.Execute(q, nil, analysis.BooleanKeywords); err != nil {
+		t.Fatal(err)
+	}
+	equivalent := pipeline.NewQuery("1", "1", cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{
+		b, cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{a, b}),
+	}))
+	if me.Cached(equivalent, analysis.BooleanKeywords) {
+		t.Error("expected the measurement of an equivalent query not to be cached")
+	}
+	v, err := me.Execute(equivalent, nil, analysis.BooleanKeywords)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v[0] != 3 {
+		t.Errorf("expected the keywords of the equivalent query, got %f", v[0])
+	}
+}
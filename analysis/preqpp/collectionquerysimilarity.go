@@ -31,21 +31,13 @@ func (sc summedCollectionQuerySimilarity) Name() string {
 
 func (sc summedCollectionQuerySimilarity) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
 	terms := analysis.QueryTerms(q.Query)
-
-	sumSCQ := 0.0
 	fields := analysis.QueryFields(q.Query)
 
-	for _, field := range fields {
-		for _, term := range terms {
-			s, err := collectionQuerySimilarity(term, field, s)
-			if err != nil {
-				return 0.0, err
-			}
-			sumSCQ += s
-		}
+	scq, err := collectionQuerySimilarities(terms, fields, s)
+	if err != nil {
+		return 0.0, err
 	}
-
-	return sumSCQ, nil
+	return floats.Sum(scq), nil
 }
 
 func (sc maxCollectionQuerySimilarity) Name() string {
@@ -54,18 +46,11 @@ func (sc maxCollectionQuerySimilarity) Name() string {
 
 func (sc maxCollectionQuerySimilarity) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
 	terms := analysis.QueryTerms(q.Query)
-
-	var scq []float64
 	fields := analysis.QueryFields(q.Query)
 
-	for _, field := range fields {
-		for _, term := range terms {
-			s, err := collectionQuerySimilarity(term, field, s)
-			if err != nil {
-				return 0.0, err
-			}
-			scq = append(scq, s)
-		}
+	scq, err := collectionQuerySimilarities(terms, fields, s)
+	if err != nil {
+		return 0.0, err
 	}
 	return floats.Max(scq), nil
 }
@@ -76,31 +61,34 @@ func (sc averageCollectionQuerySimilarity) Name() string {
 
 func (sc averageCollectionQuerySimilarity) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
 	terms := analysis.QueryTerms(q.Query)
-
-	var scq []float64
 	fields := analysis.QueryFields(q.Query)
 
-	for _, field := range fields {
-		for _, term := range terms {
-			s, err := collectionQuerySimilarity(term, field, s)
-			if err != nil {
-				return 0.0, err
-			}
-			scq = append(scq, s)
-		}
+	scq, err := collectionQuerySimilarities(terms, fields, s)
+	if err != nil {
+		return 0.0, err
 	}
 
 	return stat.Mean(scq, nil), nil
 }
 
-func collectionQuerySimilarity(term, field string, s stats.StatisticsSource) (float64, error) {
-	tf, err := s.TotalTermFrequency(term, field)
+// collectionQuerySimilarities are the collection query similarities of the terms in each of the fields, whose
+// statistics are requested in a batch if the statistics source supports it.
+func collectionQuerySimilarities(terms, fields []string, s stats.StatisticsSource) ([]float64, error) {
+	var tfs []stats.TermField
+	for _, field := range fields {
+		tfs = append(tfs, termFields(terms, field)...)
+	}
+	ttf, err := stats.TotalTermFrequencies(s, tfs)
 	if err != nil {
-		return 0.0, err
+		return nil, err
 	}
-	idf, err := s.InverseDocumentFrequency(term, field)
+	idf, err := stats.InverseDocumentFrequencies(s, tfs)
 	if err != nil {
-		return 0.0, err
+		return nil, err
+	}
+	scq := make([]float64, len(tfs))
+	for i := range tfs {
+		scq[i] = (1.0 + math.Log(1.0+ttf[i])) * math.Log(1.0+idf[i])
 	}
-	return (1.0 + math.Log(1.0+tf)) * math.Log(1.0+idf), nil
+	return scq, nil
 }
@@ -33,13 +33,22 @@ func (avgi avgICTF) Execute(q pipeline.Query, s stats.StatisticsSource) (float64
 		if err != nil {
 			return 0.0, err
 		}
-		for _, term := range terms {
-			tf, err := s.TotalTermFrequency(term, field)
-			if err != nil {
-				return 0.0, err
-			}
+		tfs, err := stats.TotalTermFrequencies(s, termFields(terms, field))
+		if err != nil {
+			return 0.0, err
+		}
+		for _, tf := range tfs {
 			sumICTF += math.Log2(W) - math.Log2(1+tf)
 		}
 	}
 	return (1.0 / float64(len(terms))) * sumICTF, nil
 }
+
+// termFields are the terms in a field, for requesting their statistics in a batch.
+func termFields(terms []string, field string) []stats.TermField {
+	tf := make([]stats.TermField, len(terms))
+	for i, term := range terms {
+		tf[i] = stats.TermField{Term: term, Field: field}
+	}
+	return tf
+}
@@ -1,6 +1,7 @@
 package preqpp
 
 import (
+	"github.com/hscells/cqr"
 	"github.com/hscells/groove/analysis"
 	"github.com/hscells/groove/pipeline"
 	"github.com/hscells/groove/stats"
@@ -31,19 +32,11 @@ func (avg avgIDF) Name() string {
 
 func (avg avgIDF) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
 	keywords := analysis.QueryKeywords(q.Query)
-	sumIDF := 0.0
-
-	for _, k := range keywords {
-		for _, field := range k.Fields {
-			idf, err := s.InverseDocumentFrequency(k.QueryString, field)
-			if err != nil {
-				return 0.0, err
-			}
-			sumIDF += idf
-		}
+	idfs, err := keywordIDFs(keywords, s)
+	if err != nil {
+		return 0.0, err
 	}
-
-	return sumIDF / float64(len(keywords)), nil
+	return floats.Sum(idfs) / float64(len(keywords)), nil
 }
 
 func (sum sumIDF) Name() string {
@@ -52,19 +45,11 @@ func (sum sumIDF) Name() string {
 
 func (sum sumIDF) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
 	keywords := analysis.QueryKeywords(q.Query)
-	sumIDF := 0.0
-
-	for _, k := range keywords {
-		for _, field := range k.Fields {
-			idf, err := s.InverseDocumentFrequency(k.QueryString, field)
-			if err != nil {
-				return 0.0, err
-			}
-			sumIDF += idf
-		}
+	idfs, err := keywordIDFs(keywords, s)
+	if err != nil {
+		return 0.0, err
 	}
-
-	return sumIDF, nil
+	return floats.Sum(idfs), nil
 }
 
 func (sum maxIDF) Name() string {
@@ -72,17 +57,10 @@ func (sum maxIDF) Name() string {
 }
 
 func (sum maxIDF) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
-	var scores []float64
 	keywords := analysis.QueryKeywords(q.Query)
-
-	for _, k := range keywords {
-		for _, field := range k.Fields {
-			idf, err := s.InverseDocumentFrequency(k.QueryString, field)
-			if err != nil {
-				return 0.0, err
-			}
-			scores = append(scores, idf)
-		}
+	scores, err := keywordIDFs(keywords, s)
+	if err != nil {
+		return 0.0, err
 	}
 
 	if len(scores) == 0 {
@@ -97,21 +75,15 @@ func (sum stdDevIDF) Name() string {
 }
 
 func (sum stdDevIDF) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
-	var scores []float64
 	keywords := analysis.QueryKeywords(q.Query)
 
 	if len(keywords) == 1 {
 		return 0, nil
 	}
 
-	for _, k := range keywords {
-		for _, field := range k.Fields {
-			idf, err := s.InverseDocumentFrequency(k.QueryString, field)
-			if err != nil {
-				return 0.0, err
-			}
-			scores = append(scores, idf)
-		}
+	scores, err := keywordIDFs(keywords, s)
+	if err != nil {
+		return 0.0, err
 	}
 
 	stdDev := stat.StdDev(scores, nil)
@@ -121,3 +93,15 @@ func (sum stdDevIDF) Execute(q pipeline.Query, s stats.StatisticsSource) (float6
 	}
 	return 0, nil
 }
+
+// keywordIDFs are the IDFs of the keywords in each of their fields, requested in a batch if the statistics source
+// supports it.
+func keywordIDFs(keywords []cqr.Keyword, s stats.StatisticsSource) ([]float64, error) {
+	var terms []stats.TermField
+	for _, k := range keywords {
+		for _, field := range k.Fields {
+			terms = append(terms, stats.TermField{Term: k.QueryString, Field: field})
+		}
+	}
+	return stats.InverseDocumentFrequencies(s, terms)
+}
@@ -22,16 +22,20 @@ func (scq SCQ) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, err
 	if err != nil {
 		return 0, err
 	}
-	for _, term := range terms {
-		ttf, err := s.TotalTermFrequency(term, fields.TitleAbstract)
-		if err != nil {
-			return 0, err
-		}
-		df, err := s.RetrievalSize(cqr.NewKeyword(term, fields.TitleAbstract))
-		if err != nil {
-			return 0, err
-		}
-		sum += (1 + math.Log(ttf)) * math.Log(1+(N/df))
+	ttf, err := stats.TotalTermFrequencies(s, termFields(terms, fields.TitleAbstract))
+	if err != nil {
+		return 0, err
+	}
+	queries := make([]cqr.CommonQueryRepresentation, len(terms))
+	for i, term := range terms {
+		queries[i] = cqr.NewKeyword(term, fields.TitleAbstract)
+	}
+	df, err := stats.RetrievalSizes(s, queries)
+	if err != nil {
+		return 0, err
+	}
+	for i := range terms {
+		sum += (1 + math.Log(ttf[i])) * math.Log(1+(N/df[i]))
 	}
 	return sum, nil
 }
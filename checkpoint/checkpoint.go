@@ -0,0 +1,58 @@
+// Package checkpoint records the progress of pipeline runs so that a run which crashed or was cancelled can be resumed
+// where it stopped.
+//
+// A checkpoint is kept for every topic that completes a stage of the pipeline. The checkpoint contains the results that
+// the stage produced for the topic, so a resumed run replays the results of completed topics instead of computing them
+// again. Stages that are not specific to a topic (e.g. training a model) are recorded with an empty topic.
+package checkpoint
+
+import (
+	"encoding/gob"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/pipeline"
+)
+
+// Store records the stages each topic of a run has completed.
+type Store interface {
+	// Load returns the results recorded for a topic in a stage, and whether the topic has completed the stage.
+	Load(topic, stage string) ([]pipeline.Result, bool)
+	// Save records that a topic completed a stage, producing the results.
+	Save(topic, stage string, results []pipeline.Result) error
+}
+
+// Bytes is supplemental data that has already been marshalled. The supplemental data of formulations is recorded in
+// this form, since the original types are not known when the results are loaded again.
+type Bytes []byte
+
+// Marshal returns the bytes.
+func (b Bytes) Marshal() ([]byte, error) {
+	return b, nil
+}
+
+func init() {
+	gob.Register(cqr.Keyword{})
+	gob.Register(cqr.BooleanQuery{})
+	gob.Register(Bytes{})
+}
+
+// portable prepares a result to be recorded, by marshalling supplemental data and dropping errors.
+func portable(r pipeline.Result) (pipeline.Result, error) {
+	r.Error = nil
+	r.Failures = nil
+	if len(r.Formulation.Sup) == 0 {
+		return r, nil
+	}
+	sup := make([]pipeline.SupplementalData, len(r.Formulation.Sup))
+	for i, s := range r.Formulation.Sup {
+		sup[i] = pipeline.SupplementalData{Name: s.Name, Data: make([]pipeline.Data, len(s.Data))}
+		for j, d := range s.Data {
+			b, err := d.Value.Marshal()
+			if err != nil {
+				return r, err
+			}
+			sup[i].Data[j] = pipeline.Data{Name: d.Name, Value: Bytes(b)}
+		}
+	}
+	r.Formulation.Sup = sup
+	return r, nil
+}
@@ -0,0 +1,141 @@
+package checkpoint
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"github.com/hscells/groove/pipeline"
+	"hash/crc32"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+)
+
+// Journal is a Store that appends checkpoints to a file on disk. Each run has its own journal, named by the ID of the
+// run, so a run only resumes from checkpoints made by a run with the same ID.
+//
+// Each checkpoint is written as a record prefixed by its length and checksum, and is synced to disk before Save
+// returns. A record that was only partially written when a run crashed is discarded when the journal is opened. When
+// a topic completes the same stage more than once (e.g. because the stage was recomputed), the last record is used.
+type Journal struct {
+	mu      sync.Mutex
+	f       *os.File
+	entries map[entryKey][]pipeline.Result
+}
+
+type entryKey struct {
+	topic, stage string
+}
+
+type record struct {
+	Topic   string
+	Stage   string
+	Results []pipeline.Result
+}
+
+// OpenJournal opens the journal of a run in dir, creating it if it does not exist.
+func OpenJournal(dir, run string) (*Journal, error) {
+	if len(run) == 0 {
+		return nil, errors.New("checkpoint: a run ID is required")
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path.Join(dir, url.PathEscape(run)+".journal"), os.O_RDWR|os.O_CREATE, 0664)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{
+		f:       f,
+		entries: make(map[entryKey][]pipeline.Result),
+	}
+	if err := j.replay(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// replay reads the records of the journal, truncating the journal after the last complete record.
+func (j *Journal) replay() error {
+	var (
+		offset int64
+		header [8]byte
+	)
+	for {
+		if _, err := io.ReadFull(j.f, header[:]); err != nil {
+			break
+		}
+		size, sum := binary.BigEndian.Uint32(header[:4]), binary.BigEndian.Uint32(header[4:])
+		b := make([]byte, size)
+		if _, err := io.ReadFull(j.f, b); err != nil || crc32.ChecksumIEEE(b) != sum {
+			break
+		}
+		var r record
+		if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&r); err != nil {
+			return err
+		}
+		j.entries[entryKey{r.Topic, r.Stage}] = r.Results
+		offset += int64(len(header) + len(b))
+	}
+	if err := j.f.Truncate(offset); err != nil {
+		return err
+	}
+	_, err := j.f.Seek(offset, io.SeekStart)
+	return err
+}
+
+// Load returns the results recorded for a topic in a stage, and whether the topic has completed the stage.
+func (j *Journal) Load(topic, stage string) ([]pipeline.Result, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	results, ok := j.entries[entryKey{topic, stage}]
+	return results, ok
+}
+
+// Save records that a topic completed a stage, producing the results.
+func (j *Journal) Save(topic, stage string, results []pipeline.Result) error {
+	r := record{
+		Topic:   topic,
+		Stage:   stage,
+		Results: make([]pipeline.Result, len(results)),
+	}
+	for i, result := range results {
+		var err error
+		r.Results[i], err = portable(result)
+		if err != nil {
+			return err
+		}
+	}
+
+	var buff bytes.Buffer
+	buff.Write(make([]byte, 8))
+	if err := gob.NewEncoder(&buff).Encode(r); err != nil {
+		return err
+	}
+	b := buff.Bytes()
+	binary.BigEndian.PutUint32(b[:4], uint32(len(b)-8))
+	binary.BigEndian.PutUint32(b[4:8], crc32.ChecksumIEEE(b[8:]))
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.f.Write(b); err != nil {
+		return err
+	}
+	if err := j.f.Sync(); err != nil {
+		return err
+	}
+	j.entries[entryKey{topic, stage}] = r.Results
+	return nil
+}
+
+// Close closes the journal.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f.Close()
+}
@@ -0,0 +1,121 @@
+package checkpoint
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/trecresults"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+type supplemental string
+
+func (s supplemental) Marshal() ([]byte, error) {
+	return []byte(s), nil
+}
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q := cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{
+		cqr.NewKeyword("heart attack", "title").SetOption(cqr.ExplodedString, true),
+		cqr.NewKeyword("myocardial infarction", "abstract"),
+	})
+	measurement := pipeline.Result{Topic: "1", Measurements: map[string]float64{"AvgIDF": 1.5}, Type: pipeline.Measurement}
+	retrieval := []pipeline.Result{
+		{Topic: "1", TrecResults: &trecresults.ResultList{{Topic: "1", DocId: "123", Rank: 1, Score: 2}}, Type: pipeline.TrecResult},
+		{Topic: "1", Transformation: pipeline.QueryResult{Topic: "1", Name: "q", Transformation: q}, Type: pipeline.Transformation},
+	}
+	formulation := pipeline.Result{
+		Topic: "1",
+		Formulation: pipeline.FormulationResut{
+			Queries: []cqr.CommonQueryRepresentation{q},
+			Sup:     []pipeline.SupplementalData{{Name: "sup", Data: []pipeline.Data{{Name: "a", Value: supplemental("data")}}}},
+		},
+		Type: pipeline.Formulation,
+	}
+
+	j, err := OpenJournal(dir, "run/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, save := range []struct {
+		stage   string
+		results []pipeline.Result
+	}{
+		{pipeline.MeasurementStage, []pipeline.Result{{Topic: "1", Measurements: map[string]float64{"AvgIDF": 0}}}},
+		{pipeline.MeasurementStage, []pipeline.Result{measurement}},
+		{pipeline.RetrievalStage, retrieval},
+		{pipeline.FormulationStage, []pipeline.Result{formulation}},
+	} {
+		if err := j.Save("1", save.stage, save.results); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Save("", pipeline.TrainStage, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash part way through writing a record.
+	file := path.Join(dir, "run%2F1.journal")
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte{0, 0, 1, 0, 1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	j, err = OpenJournal(dir, "run/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r, ok := j.Load("1", pipeline.MeasurementStage); !ok || !reflect.DeepEqual(r, []pipeline.Result{measurement}) {
+		t.Errorf("expected the last measurement to be resumed, got %v", r)
+	}
+	if r, ok := j.Load("1", pipeline.RetrievalStage); !ok || !reflect.DeepEqual(r, retrieval) {
+		t.Errorf("expected the retrieval results to be resumed, got %v", r)
+	}
+	r, ok := j.Load("1", pipeline.FormulationStage)
+	if !ok || len(r) != 1 || !reflect.DeepEqual(r[0].Formulation.Queries, formulation.Formulation.Queries) {
+		t.Fatalf("expected the formulation to be resumed, got %v", r)
+	}
+	if b, err := r[0].Formulation.Sup[0].Data[0].Value.Marshal(); err != nil || string(b) != "data" {
+		t.Errorf("expected the supplemental data to be resumed, got %s", b)
+	}
+	if _, ok := j.Load("", pipeline.TrainStage); !ok {
+		t.Error("expected the train stage to be resumed")
+	}
+	if _, ok := j.Load("2", pipeline.MeasurementStage); ok {
+		t.Error("expected topic 2 not to be resumed")
+	}
+
+	// The partial record is discarded, so new records can be appended and read back.
+	if err := j.Save("2", pipeline.MeasurementStage, nil); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	j, err = OpenJournal(dir, "run/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if _, ok := j.Load("2", pipeline.MeasurementStage); !ok {
+		t.Error("expected topic 2 to be resumed")
+	}
+	if _, ok := j.Load("1", pipeline.RetrievalStage); !ok {
+		t.Error("expected topic 1 to be resumed")
+	}
+}
@@ -0,0 +1,129 @@
+package groove
+
+import (
+	"context"
+	"github.com/hscells/groove/checkpoint"
+	"github.com/hscells/groove/output"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"github.com/hscells/trecresults"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		mu                 sync.Mutex
+		measured, executed map[string]int
+	)
+	m := measurementFunc{name: "Measured", fn: func(q pipeline.Query, ss stats.StatisticsSource) (float64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		measured[q.Topic]++
+		return float64(len(q.Topic)), nil
+	}}
+	ss := statisticsSource{execute: func(ctx context.Context, q pipeline.Query) (trecresults.ResultList, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		executed[q.Topic]++
+		return trecresults.ResultList{{Topic: q.Topic, DocId: "1", Rank: 1, Score: 1, RunName: "test"}}, nil
+	}}
+
+	// run runs the pipeline with the journal of the run, and returns its results by type.
+	run := func(components ...func() interface{}) map[pipeline.ResultType][]pipeline.Result {
+		measured, executed = make(map[string]int), make(map[string]int)
+		journal, err := checkpoint.OpenJournal(dir, "run")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer journal.Close()
+		p := NewGroovePipeline(topics("1", "2", "3"), ss, append([]func() interface{}{
+			measuring(m),
+			MeasurementOutput(output.JsonMeasurementFormatter),
+			TrecOutput(filepath.Join(dir, "run.trec")),
+			ScheduleTopics(FileOrder),
+			Checkpoint(journal),
+		}, components...)...)
+		byType := make(map[pipeline.ResultType][]pipeline.Result)
+		for _, r := range execute(t, context.Background(), p) {
+			byType[r.Type] = append(byType[r.Type], r)
+		}
+		if len(byType[pipeline.Error]) > 0 {
+			t.Fatalf("unexpected errors %v", byType[pipeline.Error])
+		}
+		return byType
+	}
+
+	first := run()
+	if len(measured) != 3 || len(executed) != 3 {
+		t.Fatalf("expected every topic to be measured and retrieved, got %v and %v", measured, executed)
+	}
+
+	// Every stage is replayed from the journal, without measuring or retrieving the topics again.
+	resumed := run()
+	if len(measured) != 0 || len(executed) != 0 {
+		t.Errorf("expected the topics to be resumed, got %v and %v", measured, executed)
+	}
+	if !reflect.DeepEqual(resumed[pipeline.Measurement], first[pipeline.Measurement]) {
+		t.Errorf("expected measurements %v, got %v", first[pipeline.Measurement], resumed[pipeline.Measurement])
+	}
+	for _, typ := range []pipeline.ResultType{pipeline.TrecResult, pipeline.Transformation} {
+		if len(resumed[typ]) != 3 {
+			t.Errorf("expected 3 %s results to be replayed, got %v", typ, resumed[typ])
+		}
+	}
+
+	// Recomputed stages ignore the journal.
+	run(Recompute(pipeline.MeasurementStage))
+	if len(measured) != 3 || len(executed) != 0 {
+		t.Errorf("expected only the measurements to be recomputed, got %v and %v", measured, executed)
+	}
+}
+
+func TestCheckpointResumeRunFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "run.trec")
+
+	// Each run resumes the topics of the last from the journal, and appends to the same run file.
+	for i := 0; i < 3; i++ {
+		journal, err := checkpoint.OpenJournal(dir, "run")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := NewGroovePipeline(topics("1", "2"), statisticsSource{}, TrecOutput(file), Checkpoint(journal))
+		results := execute(t, context.Background(), p, output.NewTrecSink(file))
+		journal.Close()
+		if n := len(resultsOf(results, pipeline.Transformation)); n != 2 {
+			t.Errorf("run %d: expected 2 transformations, got %d", i, n)
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := trecresults.ResultsFromReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, topic := range []string{"1", "2"} {
+		if n := len(r.Results[topic]); n != 1 {
+			t.Errorf("expected topic %s to be in the run file once, got %d results", topic, n)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/hscells/groove/cmd/qrel_server/qrelrpc"
 	"github.com/hscells/groove/eval"
 	"github.com/hscells/groove/output"
+	"github.com/hscells/groove/registry"
 	"github.com/hscells/groove/retrieval"
 	"github.com/hscells/groove/stats"
 	"github.com/hscells/guru"
@@ -109,37 +110,13 @@ func main() {
 		N = args.EstimateN
 	}
 
-	evaluationMeasures["precision"] = eval.Precision
-	evaluationMeasures["precision_res"] = eval.NewResidualEvaluator(eval.Precision)
-	evaluationMeasures["precision_mle"] = eval.NewMaximumLikelihoodEvaluator(eval.Precision)
-	evaluationMeasures["recall"] = eval.Recall
-	evaluationMeasures["recall_res"] = eval.NewResidualEvaluator(eval.Recall)
-	evaluationMeasures["recall_mle"] = eval.NewMaximumLikelihoodEvaluator(eval.Recall)
-	evaluationMeasures["f1"] = eval.F1Measure
-	evaluationMeasures["f1_res"] = eval.NewResidualEvaluator(eval.F1Measure)
-	evaluationMeasures["f1_mle"] = eval.NewMaximumLikelihoodEvaluator(eval.F1Measure)
-	evaluationMeasures["f0.5"] = eval.F05Measure
-	evaluationMeasures["f0.5_res"] = eval.NewResidualEvaluator(eval.F05Measure)
-	evaluationMeasures["f0.5_mle"] = eval.NewMaximumLikelihoodEvaluator(eval.F05Measure)
-	evaluationMeasures["f3"] = eval.F3Measure
-	evaluationMeasures["f3_res"] = eval.NewResidualEvaluator(eval.F3Measure)
-	evaluationMeasures["f3_mle"] = eval.NewMaximumLikelihoodEvaluator(eval.F3Measure)
-	evaluationMeasures["nnr"] = eval.NNR
-	evaluationMeasures["wss"] = eval.NewWSSEvaluator(N)
-	evaluationMeasures["wss_res"] = eval.NewResidualEvaluator(eval.NewWSSEvaluator(N))
-	evaluationMeasures["wss_mle"] = eval.NewMaximumLikelihoodEvaluator(eval.NewWSSEvaluator(N))
-	evaluationMeasures["num_ret"] = eval.NumRet
-	evaluationMeasures["num_rel"] = eval.NumRel
-	evaluationMeasures["num_rel_ret"] = eval.NumRelRet
-	evaluationMeasures["ap"] = eval.AP
-	evaluationMeasures["p@10"] = eval.PrecisionAtK{K: 10}
-	evaluationMeasures["p@1000"] = eval.PrecisionAtK{K: 1000}
-	evaluationMeasures["ndcg"] = eval.NDCG{}
-	evaluationMeasures["ndcg@5"] = eval.NDCG{K: 5}
-	evaluationMeasures["ndcg@10"] = eval.NDCG{K: 10}
-	evaluationMeasures["ndcg@100"] = eval.NDCG{K: 100}
-	evaluationMeasures["ndcg@200"] = eval.NDCG{K: 200}
-	evaluationMeasures["ndcg@500"] = eval.NDCG{K: 500}
+	for _, ev := range args.Evaluation {
+		m, err := registry.Evaluator(ev, registry.Bind("N", N))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		evaluationMeasures[ev] = m
+	}
 
 	eval.RelevanceGrade = args.RelevanceGrade
 
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alexflint/go-arg"
+	"github.com/hscells/groove/config"
+	"github.com/hscells/groove/stats"
+	"log"
+)
+
+var (
+	name    = "snapshot"
+	version = "17.Oct.2026"
+	author  = "Harry Scells"
+)
+
+type args struct {
+	Output       string `help:"Path to write the snapshot to" arg:"-o" default:"snapshot.json"`
+	Measurements bool   `help:"Also capture the statistics requested by the measurements of the experiment" arg:"-m"`
+	Config       string `help:"Path to experiment configuration file" arg:"required,positional"`
+}
+
+func (args) Version() string {
+	return version
+}
+
+func (args) Description() string {
+	return fmt.Sprintf(`%s
+@ %s
+# %s`, name, author, version)
+}
+
+func main() {
+	var args args
+	arg.MustParse(&args)
+
+	p, err := config.Load(args.Config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	queries, err := p.LoadQueries()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for i, q := range queries {
+		queries[i], err = p.ProcessQuery(q)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	log.Printf("capturing the statistics of %d queries...\n", len(queries))
+
+	snapshot := stats.NewSnapshot(p.StatisticsSource)
+	recorder := stats.NewSnapshotRecorder(p.StatisticsSource, snapshot)
+	if err := recorder.Capture(queries); err != nil {
+		log.Fatalln(err)
+	}
+	if args.Measurements {
+		for _, q := range queries {
+			for _, m := range p.Measurements {
+				if _, err := m.Execute(q, recorder); err != nil {
+					log.Printf("%s %s: %v\n", q.Topic, m.Name(), err)
+				}
+			}
+		}
+	}
+
+	if err := snapshot.Write(args.Output); err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("captured %d terms in %s\n", snapshot.Len(), args.Output)
+}
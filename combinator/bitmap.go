@@ -0,0 +1,506 @@
+package combinator
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"sort"
+)
+
+const (
+	// arrayMax is the largest number of documents stored in an array container; larger containers are bitmaps.
+	arrayMax = 4096
+	// bitmapWords is the number of words in a bitmap container (one bit for each of the 2^16 low bits of a document).
+	bitmapWords = 1 << 16 / 64
+	// bitmapCookie identifies the binary encoding of a bitmap. As a little-endian document it is far larger than any
+	// PMID, so it is not mistaken for the first document of a cache file written before bitmaps.
+	bitmapCookie = 0x4252ffff
+)
+
+var errInvalidBitmap = errors.New("invalid bitmap encoding")
+
+// Bitmap is a compressed set of documents, in the style of a roaring bitmap: documents are partitioned by their high
+// 16 bits into containers, which are sorted arrays of the low 16 bits when sparse, or bitmaps of them when dense.
+// Bitmaps are immutable, so they (and their containers) are safely shared by caches and logical trees.
+type Bitmap struct {
+	keys       []uint16
+	containers []container
+}
+
+// container holds the low 16 bits of the documents with the same high bits, either as a sorted array or a bitmap.
+type container struct {
+	array []uint16
+	bits  []uint64
+	n     int
+}
+
+// NewBitmap creates a bitmap of documents, which do not need to be sorted or unique.
+func NewBitmap(docs Documents) *Bitmap {
+	if !sort.IsSorted(docs) {
+		sorted := make(Documents, len(docs))
+		copy(sorted, docs)
+		sort.Sort(sorted)
+		docs = sorted
+	}
+	b := &Bitmap{}
+	for i := 0; i < len(docs); {
+		key := uint16(docs[i] >> 16)
+		j := i
+		for j < len(docs) && uint16(docs[j]>>16) == key {
+			j++
+		}
+		b.keys = append(b.keys, key)
+		b.containers = append(b.containers, newContainer(docs[i:j]))
+		i = j
+	}
+	return b
+}
+
+// newContainer creates a container of sorted documents with the same high bits.
+func newContainer(docs Documents) container {
+	array := make([]uint16, 0, len(docs))
+	for i, doc := range docs {
+		if i == 0 || doc != docs[i-1] {
+			array = append(array, uint16(doc))
+		}
+	}
+	return normalise(container{array: array, n: len(array)})
+}
+
+// Bitmap creates a bitmap of the documents.
+func (d Documents) Bitmap() *Bitmap {
+	return NewBitmap(d)
+}
+
+// Cardinality is the number of documents in the bitmap.
+func (b *Bitmap) Cardinality() int {
+	n := 0
+	for _, c := range b.containers {
+		n += c.n
+	}
+	return n
+}
+
+// Contains is whether the document is in the bitmap.
+func (b *Bitmap) Contains(doc Document) bool {
+	i, ok := b.find(uint16(doc >> 16))
+	return ok && b.containers[i].contains(uint16(doc))
+}
+
+// Equals is whether the bitmaps contain the same documents.
+func (b *Bitmap) Equals(o *Bitmap) bool {
+	if len(b.keys) != len(o.keys) {
+		return false
+	}
+	for i, key := range b.keys {
+		if key != o.keys[i] || b.containers[i].n != o.containers[i].n {
+			return false
+		}
+		if andNot(b.containers[i], o.containers[i]).n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Documents is a sorted slice view of the documents in the bitmap.
+func (b *Bitmap) Documents() Documents {
+	docs := make(Documents, 0, b.Cardinality())
+	for i, c := range b.containers {
+		high := Document(b.keys[i]) << 16
+		if c.bits == nil {
+			for _, low := range c.array {
+				docs = append(docs, high|Document(low))
+			}
+			continue
+		}
+		for w, word := range c.bits {
+			for word != 0 {
+				docs = append(docs, high|Document(w*64+bits.TrailingZeros64(word)))
+				word &= word - 1
+			}
+		}
+	}
+	return docs
+}
+
+// And is the intersection of the bitmaps.
+func (b *Bitmap) And(o *Bitmap) *Bitmap {
+	r := &Bitmap{}
+	for i, j := 0, 0; i < len(b.keys) && j < len(o.keys); {
+		switch {
+		case b.keys[i] < o.keys[j]:
+			i++
+		case b.keys[i] > o.keys[j]:
+			j++
+		default:
+			if c := and(b.containers[i], o.containers[j]); c.n > 0 {
+				r.keys = append(r.keys, b.keys[i])
+				r.containers = append(r.containers, c)
+			}
+			i++
+			j++
+		}
+	}
+	return r
+}
+
+// Or is the union of the bitmaps.
+func (b *Bitmap) Or(o *Bitmap) *Bitmap {
+	return Union(b, o)
+}
+
+// AndNot is the documents of the bitmap that are not in the other bitmap.
+func (b *Bitmap) AndNot(o *Bitmap) *Bitmap {
+	r := &Bitmap{}
+	j := 0
+	for i, key := range b.keys {
+		for j < len(o.keys) && o.keys[j] < key {
+			j++
+		}
+		c := b.containers[i]
+		if j < len(o.keys) && o.keys[j] == key {
+			c = andNot(c, o.containers[j])
+		}
+		if c.n > 0 {
+			r.keys = append(r.keys, key)
+			r.containers = append(r.containers, c)
+		}
+	}
+	return r
+}
+
+// Intersect is the intersection of any number of bitmaps. The smallest bitmaps are intersected first, so that the
+// intersection can stop as soon as it is empty.
+func Intersect(bitmaps ...*Bitmap) *Bitmap {
+	if len(bitmaps) == 0 {
+		return &Bitmap{}
+	}
+	sorted := make([]*Bitmap, len(bitmaps))
+	copy(sorted, bitmaps)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Cardinality() < sorted[j].Cardinality()
+	})
+	r := sorted[0]
+	for _, b := range sorted[1:] {
+		if len(r.keys) == 0 {
+			break
+		}
+		r = r.And(b)
+	}
+	return r
+}
+
+// Union is the union of any number of bitmaps. The containers of each key are merged at once, into a bitmap container
+// when they are dense, rather than by merging the bitmaps in pairs.
+func Union(bitmaps ...*Bitmap) *Bitmap {
+	merge := make(map[uint16][]container)
+	for _, b := range bitmaps {
+		for i, key := range b.keys {
+			merge[key] = append(merge[key], b.containers[i])
+		}
+	}
+	r := &Bitmap{keys: make([]uint16, 0, len(merge))}
+	for key := range merge {
+		r.keys = append(r.keys, key)
+	}
+	sort.Slice(r.keys, func(i, j int) bool {
+		return r.keys[i] < r.keys[j]
+	})
+	r.containers = make([]container, len(r.keys))
+	for i, key := range r.keys {
+		r.containers[i] = union(merge[key])
+	}
+	return r
+}
+
+// Size is the number of bytes of the encoded bitmap (see MarshalBinary).
+func (b *Bitmap) Size() int {
+	size := 8
+	for _, c := range b.containers {
+		size += 4 + c.size()
+	}
+	return size
+}
+
+// MarshalBinary encodes the bitmap. The encoding is a cookie and the number of containers, followed by the key,
+// cardinality minus one, and the values of each container (little-endian low bits for arrays, or words for bitmaps).
+func (b *Bitmap) MarshalBinary() ([]byte, error) {
+	data := make([]byte, b.Size())
+	binary.LittleEndian.PutUint32(data, bitmapCookie)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(b.keys)))
+	p := 8
+	for i, c := range b.containers {
+		binary.LittleEndian.PutUint16(data[p:], b.keys[i])
+		binary.LittleEndian.PutUint16(data[p+2:], uint16(c.n-1))
+		p += 4
+		if c.bits == nil {
+			for _, v := range c.array {
+				binary.LittleEndian.PutUint16(data[p:], v)
+				p += 2
+			}
+			continue
+		}
+		for _, w := range c.bits {
+			binary.LittleEndian.PutUint64(data[p:], w)
+			p += 8
+		}
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes a bitmap encoded by MarshalBinary. Data that is not exactly a valid encoding is an error.
+func (b *Bitmap) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 || binary.LittleEndian.Uint32(data) != bitmapCookie {
+		return errInvalidBitmap
+	}
+	n := int(binary.LittleEndian.Uint32(data[4:]))
+	if n > len(data)/4 {
+		return errInvalidBitmap
+	}
+	keys := make([]uint16, n)
+	containers := make([]container, n)
+	p := 8
+	for i := 0; i < n; i++ {
+		if p+4 > len(data) {
+			return errInvalidBitmap
+		}
+		keys[i] = binary.LittleEndian.Uint16(data[p:])
+		if i > 0 && keys[i] <= keys[i-1] {
+			return errInvalidBitmap
+		}
+		c := container{n: int(binary.LittleEndian.Uint16(data[p+2:])) + 1}
+		p += 4
+		if p+c.size() > len(data) {
+			return errInvalidBitmap
+		}
+		if c.n <= arrayMax {
+			c.array = make([]uint16, c.n)
+			for j := range c.array {
+				c.array[j] = binary.LittleEndian.Uint16(data[p:])
+				if j > 0 && c.array[j] <= c.array[j-1] {
+					return errInvalidBitmap
+				}
+				p += 2
+			}
+		} else {
+			c.bits = make([]uint64, bitmapWords)
+			for j := range c.bits {
+				c.bits[j] = binary.LittleEndian.Uint64(data[p:])
+				p += 8
+			}
+			if cardinality(c.bits) != c.n {
+				return errInvalidBitmap
+			}
+		}
+		containers[i] = c
+	}
+	if p != len(data) {
+		return errInvalidBitmap
+	}
+	b.keys, b.containers = keys, containers
+	return nil
+}
+
+// find is the index of the container of a key, and whether the bitmap has one.
+func (b *Bitmap) find(key uint16) (int, bool) {
+	i := sort.Search(len(b.keys), func(i int) bool {
+		return b.keys[i] >= key
+	})
+	return i, i < len(b.keys) && b.keys[i] == key
+}
+
+// size is the number of bytes of the encoded values of the container.
+func (c container) size() int {
+	if c.n <= arrayMax {
+		return c.n * 2
+	}
+	return bitmapWords * 8
+}
+
+func (c container) contains(v uint16) bool {
+	if c.bits != nil {
+		return c.bits[v/64]&(1<<(v%64)) != 0
+	}
+	i := sort.Search(len(c.array), func(i int) bool {
+		return c.array[i] >= v
+	})
+	return i < len(c.array) && c.array[i] == v
+}
+
+// toBits is the container as a (new) bitmap.
+func (c container) toBits() []uint64 {
+	b := make([]uint64, bitmapWords)
+	if c.bits != nil {
+		copy(b, c.bits)
+		return b
+	}
+	for _, v := range c.array {
+		b[v/64] |= 1 << (v % 64)
+	}
+	return b
+}
+
+// normalise stores the container as an array when it is sparse, and as a bitmap when it is dense.
+func normalise(c container) container {
+	switch {
+	case c.bits != nil && c.n <= arrayMax:
+		array := make([]uint16, 0, c.n)
+		for w, word := range c.bits {
+			for word != 0 {
+				array = append(array, uint16(w*64+bits.TrailingZeros64(word)))
+				word &= word - 1
+			}
+		}
+		return container{array: array, n: c.n}
+	case c.bits == nil && c.n > arrayMax:
+		return container{bits: c.toBits(), n: c.n}
+	}
+	return c
+}
+
+func cardinality(words []uint64) int {
+	n := 0
+	for _, w := range words {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+func and(a, b container) container {
+	switch {
+	case a.bits != nil && b.bits != nil:
+		r := make([]uint64, bitmapWords)
+		for i := range r {
+			r[i] = a.bits[i] & b.bits[i]
+		}
+		return normalise(container{bits: r, n: cardinality(r)})
+	case a.bits != nil:
+		a, b = b, a
+		fallthrough
+	case b.bits != nil:
+		r := make([]uint16, 0, len(a.array))
+		for _, v := range a.array {
+			if b.contains(v) {
+				r = append(r, v)
+			}
+		}
+		return container{array: r, n: len(r)}
+	}
+	if len(a.array) > len(b.array) {
+		a, b = b, a
+	}
+	r := make([]uint16, 0, len(a.array))
+	if len(b.array) > 32*len(a.array) {
+		// Search the larger array when the arrays are skewed, rather than merging them.
+		for _, v := range a.array {
+			if b.contains(v) {
+				r = append(r, v)
+			}
+		}
+		return container{array: r, n: len(r)}
+	}
+	for i, j := 0, 0; i < len(a.array) && j < len(b.array); {
+		switch {
+		case a.array[i] < b.array[j]:
+			i++
+		case a.array[i] > b.array[j]:
+			j++
+		default:
+			r = append(r, a.array[i])
+			i++
+			j++
+		}
+	}
+	return container{array: r, n: len(r)}
+}
+
+func andNot(a, b container) container {
+	switch {
+	case a.bits != nil:
+		r := a.toBits()
+		if b.bits != nil {
+			for i := range r {
+				r[i] &^= b.bits[i]
+			}
+		} else {
+			for _, v := range b.array {
+				r[v/64] &^= 1 << (v % 64)
+			}
+		}
+		return normalise(container{bits: r, n: cardinality(r)})
+	case b.bits != nil:
+		r := make([]uint16, 0, len(a.array))
+		for _, v := range a.array {
+			if !b.contains(v) {
+				r = append(r, v)
+			}
+		}
+		return container{array: r, n: len(r)}
+	}
+	r := make([]uint16, 0, len(a.array))
+	j := 0
+	for _, v := range a.array {
+		for j < len(b.array) && b.array[j] < v {
+			j++
+		}
+		if j == len(b.array) || b.array[j] != v {
+			r = append(r, v)
+		}
+	}
+	return container{array: r, n: len(r)}
+}
+
+// union merges any number of containers with the same key.
+func union(containers []container) container {
+	if len(containers) == 1 {
+		return containers[0]
+	}
+	n := 0
+	for _, c := range containers {
+		n += c.n
+	}
+	if n <= arrayMax {
+		// Every container is an array, as are the merged containers.
+		r := containers[0].array
+		for _, c := range containers[1:] {
+			r = mergeArrays(r, c.array)
+		}
+		return container{array: r, n: len(r)}
+	}
+	r := make([]uint64, bitmapWords)
+	for _, c := range containers {
+		if c.bits != nil {
+			for i, w := range c.bits {
+				r[i] |= w
+			}
+			continue
+		}
+		for _, v := range c.array {
+			r[v/64] |= 1 << (v % 64)
+		}
+	}
+	return normalise(container{bits: r, n: cardinality(r)})
+}
+
+// mergeArrays is the sorted union of two sorted arrays.
+func mergeArrays(a, b []uint16) []uint16 {
+	r := make([]uint16, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			r = append(r, a[i])
+			i++
+		case a[i] > b[j]:
+			r = append(r, b[j])
+			j++
+		default:
+			r = append(r, a[i])
+			i++
+			j++
+		}
+	}
+	r = append(r, a[i:]...)
+	return append(r, b[j:]...)
+}
@@ -0,0 +1,269 @@
+package combinator
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/xtgo/set"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// randomDocuments are n random documents below max, which are unsorted and may repeat.
+func randomDocuments(r *rand.Rand, n, max int) Documents {
+	docs := make(Documents, n)
+	for i := range docs {
+		docs[i] = Document(r.Intn(max))
+	}
+	return docs
+}
+
+// reference is the sorted, unique documents of a set.
+func reference(m map[Document]struct{}) Documents {
+	docs := make(Documents, 0, len(m))
+	for doc := range m {
+		docs = append(docs, doc)
+	}
+	sort.Sort(docs)
+	return docs
+}
+
+func TestBitmap(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, c := range []struct {
+		name string
+		n    int
+		max  int
+	}{
+		{"sparse", 1000, 1 << 24},
+		{"dense", 100000, 1 << 18},
+		{"mixed", 20000, 1 << 20},
+	} {
+		a, b := randomDocuments(r, c.n, c.max), randomDocuments(r, c.n/2, c.max)
+		// Ensure the sets overlap.
+		b = append(b, a[:c.n/4]...)
+		ba, bb := NewBitmap(a), NewBitmap(b)
+		sa, sb := a.Set(), b.Set()
+
+		and, or, not := make(map[Document]struct{}), make(map[Document]struct{}), make(map[Document]struct{})
+		for doc := range sa {
+			or[doc] = struct{}{}
+			if _, ok := sb[doc]; ok {
+				and[doc] = struct{}{}
+			} else {
+				not[doc] = struct{}{}
+			}
+		}
+		for doc := range sb {
+			or[doc] = struct{}{}
+		}
+
+		for _, op := range []struct {
+			name string
+			got  *Bitmap
+			want map[Document]struct{}
+		}{
+			{"documents", ba, sa},
+			{"and", ba.And(bb), and},
+			{"intersect", Intersect(bb, ba), and},
+			{"or", ba.Or(bb), or},
+			{"union", Union(bb, ba, bb), or},
+			{"and not", ba.AndNot(bb), not},
+		} {
+			want := reference(op.want)
+			if got := op.got.Documents(); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s %s: expected %d documents, got %d", c.name, op.name, len(want), len(got))
+			}
+			if op.got.Cardinality() != len(want) {
+				t.Errorf("%s %s: expected a cardinality of %d, got %d", c.name, op.name, len(want), op.got.Cardinality())
+			}
+		}
+		if !ba.Contains(a[0]) || ba.Contains(Document(c.max)) {
+			t.Errorf("%s: unexpected membership", c.name)
+		}
+
+		data, err := ba.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Bitmap
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		if !decoded.Equals(ba) || decoded.Equals(bb) {
+			t.Errorf("%s: expected the decoded bitmap to equal the encoded bitmap", c.name)
+		}
+		if err := decoded.UnmarshalBinary(data[:len(data)-1]); err == nil {
+			t.Errorf("%s: expected an error decoding a truncated bitmap", c.name)
+		}
+	}
+}
+
+func TestBitmapOperators(t *testing.T) {
+	cache := NewMapQueryCache()
+	atoms := make([]LogicalTreeNode, 3)
+	for i, docs := range []Documents{{5, 1, 3, 1 << 20}, {3, 4, 5, 1 << 20}, {5, 6}} {
+		k := cqr.NewKeyword(fmt.Sprintf("term%d", i), "title")
+		if err := cache.Set(k, docs); err != nil {
+			t.Fatal(err)
+		}
+		atoms[i] = NewAtom(k)
+	}
+	for _, c := range []struct {
+		operator Operator
+		want     Documents
+	}{
+		{AndOperator, Documents{5}},
+		{OrOperator, Documents{1, 3, 4, 5, 6, 1 << 20}},
+		{NotOperator, Documents{1}},
+	} {
+		if got := c.operator.Combine(atoms, cache); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: expected %v, got %v", c.operator, c.want, got)
+		}
+	}
+	tree := NewCombinator(cqr.NewBooleanQuery(cqr.OR, nil), OrOperator,
+		NewCombinator(cqr.NewBooleanQuery(cqr.AND, nil), AndOperator, atoms[:2]...), atoms[2])
+	if got := (LogicalTree{Root: tree}).Documents(cache); !reflect.DeepEqual(got, Documents{3, 5, 6, 1 << 20}) {
+		t.Errorf("unexpected documents of the tree %v", got)
+	}
+}
+
+func TestFileQueryCache_Legacy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Caches written before bitmaps are consecutive little-endian documents.
+	k := cqr.NewKeyword("legacy", "title")
+	b := make([]byte, 12)
+	for i, doc := range []uint32{30000000, 7, 1 << 20} {
+		binary.LittleEndian.PutUint32(b[i*4:], doc)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, fmt.Sprintf("%v", HashCQR(k))), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewFileQueryCache(dir)
+	docs, err := cache.Get(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(docs, Documents{7, 1 << 20, 30000000}) {
+		t.Errorf("unexpected legacy documents %v", docs)
+	}
+
+	k = cqr.NewKeyword("bitmap", "title")
+	if err := cache.Set(k, Documents{9, 2, 2}); err != nil {
+		t.Fatal(err)
+	}
+	docs, err = NewFileQueryCache(dir).Get(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(docs, Documents{2, 9}) {
+		t.Errorf("unexpected documents %v", docs)
+	}
+}
+
+// benchmarkSets are synthetic document sets the size of the clauses of systematic review queries: hundreds of clauses
+// retrieving up to hundreds of thousands of PMIDs each.
+func benchmarkSets(clauses, size int) []Documents {
+	r := rand.New(rand.NewSource(1))
+	sets := make([]Documents, clauses)
+	for i := range sets {
+		sets[i] = randomDocuments(r, 1+r.Intn(size), 32000000)
+		sort.Sort(sets[i])
+		sets[i] = sets[i][:set.Uniq(sets[i])]
+	}
+	return sets
+}
+
+func bitmaps(sets []Documents) []*Bitmap {
+	b := make([]*Bitmap, len(sets))
+	for i, docs := range sets {
+		b[i] = docs.Bitmap()
+	}
+	return b
+}
+
+// sliceUnion is the union of sorted slices with xtgo/set, similar to how documents were combined before bitmaps.
+func sliceUnion(sets []Documents) Documents {
+	var docs Documents
+	for _, s := range sets {
+		docs = append(docs, s...)
+	}
+	sort.Sort(docs)
+	return docs[:set.Uniq(docs)]
+}
+
+// sliceIntersect is the intersection of sorted slices, as documents were combined before bitmaps.
+func sliceIntersect(sets []Documents) Documents {
+	docs := append(Documents{}, sets[0]...)
+	for _, s := range sets[1:] {
+		pivot := len(docs)
+		docs = append(docs, s...)
+		docs = docs[:set.Inter(docs, pivot)]
+	}
+	return docs
+}
+
+func BenchmarkUnion(b *testing.B) {
+	sets := benchmarkSets(200, 200000)
+	b.Run("slice", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sliceUnion(sets)
+		}
+	})
+	bm := bitmaps(sets)
+	b.Run("bitmap", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			Union(bm...)
+		}
+	})
+}
+
+func BenchmarkIntersect(b *testing.B) {
+	sets := benchmarkSets(50, 2000000)
+	b.Run("slice", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sliceIntersect(sets)
+		}
+	})
+	bm := bitmaps(sets)
+	b.Run("bitmap", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			Intersect(bm...)
+		}
+	})
+}
+
+func BenchmarkAndNot(b *testing.B) {
+	sets := benchmarkSets(2, 2000000)
+	bm := bitmaps(sets)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bm[0].AndNot(bm[1])
+	}
+}
+
+func BenchmarkBitmap_MarshalBinary(b *testing.B) {
+	bm := bitmaps(benchmarkSets(1, 500000))[0]
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data, err := bm.MarshalBinary()
+		if err != nil {
+			b.Fatal(err)
+		}
+		var decoded Bitmap
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
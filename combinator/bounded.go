@@ -0,0 +1,444 @@
+package combinator
+
+import (
+	"fmt"
+	"github.com/hashicorp/golang-lru/simplelru"
+	"github.com/hscells/cqr"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats are the statistics of a query cache.
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	// Evictions are the queries removed to bound the cache, and Expirations those removed once their TTL passed.
+	Evictions   int64 `json:"evictions"`
+	Expirations int64 `json:"expirations"`
+	// Entries and Bytes are the number of queries in the cache, and the size of their documents (on disk, for disk
+	// caches).
+	Entries int64 `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// HitRate is the fraction of lookups that were hits.
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+func (s CacheStats) String() string {
+	return fmt.Sprintf("%d hits, %d misses (%.1f%%), %d evictions, %d expirations, %d entries, %d bytes",
+		s.Hits, s.Misses, 100*s.HitRate(), s.Evictions, s.Expirations, s.Entries, s.Bytes)
+}
+
+// ObservableQueryCacher is a query cache that reports its statistics.
+type ObservableQueryCacher interface {
+	QueryCacher
+	Stats() CacheStats
+}
+
+// CacheBounds bound the queries of a cache (see NewMemoryQueryCache, NewFileQueryCache and NewDiskvQueryCache). The
+// least recently used queries are evicted once the cache has more than MaxEntries queries, or MaxBytes bytes of
+// documents. Queries cached for longer than TTL (e.g. of a source that changes, such as PubMed) expire. Zero values
+// are unbounded.
+type CacheBounds struct {
+	MaxEntries int
+	MaxBytes   int64
+	TTL        time.Duration
+}
+
+// CacheMaxEntries bounds the number of queries in a cache.
+func CacheMaxEntries(n int) func(*CacheBounds) {
+	return func(b *CacheBounds) {
+		b.MaxEntries = n
+	}
+}
+
+// CacheMaxBytes bounds the size of the documents in a cache.
+func CacheMaxBytes(n int64) func(*CacheBounds) {
+	return func(b *CacheBounds) {
+		b.MaxBytes = n
+	}
+}
+
+// CacheTTL expires the queries of a cache once they have been cached for the duration.
+func CacheTTL(ttl time.Duration) func(*CacheBounds) {
+	return func(b *CacheBounds) {
+		b.TTL = ttl
+	}
+}
+
+func newCacheBounds(options []func(*CacheBounds)) CacheBounds {
+	var b CacheBounds
+	for _, option := range options {
+		option(&b)
+	}
+	return b
+}
+
+// over is whether a cache of n entries and size bytes exceeds the bounds.
+func (b CacheBounds) over(n int, size int64) bool {
+	return (b.MaxEntries > 0 && n > b.MaxEntries) || (b.MaxBytes > 0 && size > b.MaxBytes)
+}
+
+// expired is whether a query cached at the time has expired.
+func (b CacheBounds) expired(cached time.Time) bool {
+	return b.TTL > 0 && time.Since(cached) > b.TTL
+}
+
+// boundedQueryCacher is a query cache with bounds.
+type boundedQueryCacher interface {
+	cacheBounds() CacheBounds
+}
+
+// cacheBoundsOf are the bounds of a query cache, which are zero if the cache is unbounded.
+func cacheBoundsOf(cache QueryCacher) CacheBounds {
+	if c, ok := cache.(boundedQueryCacher); ok {
+		return c.cacheBounds()
+	}
+	return CacheBounds{}
+}
+
+// cacheCounters count the lookups and removals of a cache.
+type cacheCounters struct {
+	hits, misses, evictions, expirations int64
+}
+
+func (c *cacheCounters) lookup(hit bool) {
+	if hit {
+		atomic.AddInt64(&c.hits, 1)
+	} else {
+		atomic.AddInt64(&c.misses, 1)
+	}
+}
+
+func (c *cacheCounters) stats(entries, bytes int64) CacheStats {
+	return CacheStats{
+		Hits:        atomic.LoadInt64(&c.hits),
+		Misses:      atomic.LoadInt64(&c.misses),
+		Evictions:   atomic.LoadInt64(&c.evictions),
+		Expirations: atomic.LoadInt64(&c.expirations),
+		Entries:     entries,
+		Bytes:       bytes,
+	}
+}
+
+// cacheEntry is a cached query in a bounded cache.
+type cacheEntry struct {
+	bitmap *Bitmap
+	size   int64
+	cached time.Time
+}
+
+// MemoryQueryCache is a bounded in-memory query cache, which evicts the least recently used queries. Equivalent queries
+// (see Canonicalise) share results.
+type MemoryQueryCache struct {
+	bounds   CacheBounds
+	mu       sync.Mutex
+	lru      *simplelru.LRU
+	bytes    int64
+	expiring bool
+	counters cacheCounters
+}
+
+// NewMemoryQueryCache creates an in-memory query cache with the bounds. Bytes are the size of encoded documents (see
+// Bitmap.Size).
+func NewMemoryQueryCache(options ...func(*CacheBounds)) QueryCacher {
+	m := &MemoryQueryCache{bounds: newCacheBounds(options)}
+	m.lru, _ = simplelru.NewLRU(math.MaxInt32, func(key, value interface{}) {
+		m.bytes -= value.(*cacheEntry).size
+		if !m.expiring {
+			m.counters.evictions++
+		}
+	})
+	return m
+}
+
+// Get looks up results in memory.
+func (m *MemoryQueryCache) Get(query cqr.CommonQueryRepresentation) (Documents, error) {
+	b, err := m.GetBitmap(query)
+	if err != nil {
+		return Documents{}, err
+	}
+	return b.Documents(), nil
+}
+
+// Set caches results in memory.
+func (m *MemoryQueryCache) Set(query cqr.CommonQueryRepresentation, docs Documents) error {
+	return m.SetBitmap(query, docs.Bitmap())
+}
+
+// GetBitmap looks up results in memory.
+func (m *MemoryQueryCache) GetBitmap(query cqr.CommonQueryRepresentation) (*Bitmap, error) {
+	h := CanonicalHash(query)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.lru.Get(h)
+	if ok && m.bounds.expired(v.(*cacheEntry).cached) {
+		m.expiring = true
+		m.lru.Remove(h)
+		m.expiring = false
+		m.counters.expirations++
+		ok = false
+	}
+	m.counters.lookup(ok)
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	return v.(*cacheEntry).bitmap, nil
+}
+
+// SetBitmap caches results in memory, evicting the least recently used queries to stay within the bounds. Results
+// larger than the cache are not cached.
+func (m *MemoryQueryCache) SetBitmap(query cqr.CommonQueryRepresentation, bitmap *Bitmap) error {
+	e := &cacheEntry{bitmap: bitmap, size: int64(bitmap.Size()), cached: time.Now()}
+	if m.bounds.MaxBytes > 0 && e.size > m.bounds.MaxBytes {
+		return nil
+	}
+	h := CanonicalHash(query)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v, ok := m.lru.Peek(h); ok {
+		m.bytes -= v.(*cacheEntry).size
+	}
+	m.lru.Add(h, e)
+	m.bytes += e.size
+	for m.bounds.over(m.lru.Len(), m.bytes) {
+		m.lru.RemoveOldest()
+	}
+	return nil
+}
+
+func (m *MemoryQueryCache) cacheBounds() CacheBounds {
+	return m.bounds
+}
+
+// Stats are the statistics of the cache.
+func (m *MemoryQueryCache) Stats() CacheStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counters.stats(int64(m.lru.Len()), m.bytes)
+}
+
+// diskIndex tracks the files of a disk cache, to report their statistics, and to evict and expire them. The least
+// recently used files are tracked in memory. The files already in the cache are indexed when the index is first used,
+// and are assumed to have been used when they were written.
+type diskIndex struct {
+	dir      string
+	bounds   CacheBounds
+	remove   func(key string) error
+	once     sync.Once
+	err      error
+	mu       sync.Mutex
+	lru      *simplelru.LRU
+	bytes    int64
+	evicting bool
+	counters cacheCounters
+}
+
+// diskFile is a file in a disk cache.
+type diskFile struct {
+	size    int64
+	written time.Time
+}
+
+// newDiskIndex creates an index of the files in a directory (recursively), which are removed with remove.
+func newDiskIndex(dir string, bounds CacheBounds, remove func(key string) error) *diskIndex {
+	d := &diskIndex{dir: dir, bounds: bounds, remove: remove}
+	d.lru, _ = simplelru.NewLRU(math.MaxInt32, func(key, value interface{}) {
+		d.bytes -= value.(*diskFile).size
+		if d.evicting {
+			d.counters.evictions++
+			d.remove(key.(string))
+		}
+	})
+	return d
+}
+
+// load indexes the files already in the directory, once.
+func (d *diskIndex) load() error {
+	d.once.Do(func() {
+		type file struct {
+			key string
+			diskFile
+		}
+		var files []file
+		d.err = filepath.Walk(d.dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && path == d.dir {
+					return nil
+				}
+				return err
+			}
+			if !info.IsDir() {
+				files = append(files, file{key: info.Name(), diskFile: diskFile{size: info.Size(), written: info.ModTime()}})
+			}
+			return nil
+		})
+		if d.err != nil {
+			return
+		}
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].written.Before(files[j].written)
+		})
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for _, f := range files {
+			d.add(f.key, f.diskFile)
+		}
+	})
+	return d.err
+}
+
+// add indexes a file, evicting the least recently used files to stay within the bounds. The index must be locked.
+func (d *diskIndex) add(key string, f diskFile) {
+	if v, ok := d.lru.Peek(key); ok {
+		d.bytes -= v.(*diskFile).size
+	}
+	d.lru.Add(key, &f)
+	d.bytes += f.size
+	d.evicting = true
+	for d.bounds.over(d.lru.Len(), d.bytes) && d.lru.Len() > 1 {
+		d.lru.RemoveOldest()
+	}
+	d.evicting = false
+}
+
+// written indexes a file that was written.
+func (d *diskIndex) written(key string, size int64) error {
+	if err := d.load(); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.add(key, diskFile{size: size, written: time.Now()})
+	return nil
+}
+
+// used marks a file as used, and reports whether it has expired, in which case it is removed.
+func (d *diskIndex) used(key string) (expired bool, err error) {
+	if err := d.load(); err != nil {
+		return false, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v, ok := d.lru.Get(key)
+	if !ok || !d.bounds.expired(v.(*diskFile).written) {
+		return false, nil
+	}
+	d.lru.Remove(key)
+	d.counters.expirations++
+	d.remove(key)
+	return true, nil
+}
+
+// stats are the statistics of the indexed files. Files that could not be indexed are not counted.
+func (d *diskIndex) stats() CacheStats {
+	d.load()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.counters.stats(int64(d.lru.Len()), d.bytes)
+}
+
+// TieredQueryCache is a query cache in memory over a (larger) query cache on disk. Queries found on disk are promoted
+// into memory, and queries are written to both.
+type TieredQueryCache struct {
+	memory, disk QueryCacher
+	counters     *cacheCounters
+}
+
+// NewTieredQueryCache creates a query cache of a memory cache (e.g. a bounded MemoryQueryCache) over a disk cache.
+func NewTieredQueryCache(memory, disk QueryCacher) QueryCacher {
+	return TieredQueryCache{memory: memory, disk: disk, counters: new(cacheCounters)}
+}
+
+// Get looks up results in memory, then on disk.
+func (t TieredQueryCache) Get(query cqr.CommonQueryRepresentation) (Documents, error) {
+	b, err := t.GetBitmap(query)
+	if err != nil {
+		return Documents{}, err
+	}
+	return b.Documents(), nil
+}
+
+// Set caches results in memory and on disk.
+func (t TieredQueryCache) Set(query cqr.CommonQueryRepresentation, docs Documents) error {
+	return t.SetBitmap(query, docs.Bitmap())
+}
+
+// GetBitmap looks up results in memory, then on disk.
+func (t TieredQueryCache) GetBitmap(query cqr.CommonQueryRepresentation) (*Bitmap, error) {
+	b, err := getBitmap(t.memory, query)
+	if err == nil {
+		t.counters.lookup(true)
+		return b, nil
+	} else if err != ErrCacheMiss {
+		return nil, err
+	}
+	b, err = getBitmap(t.disk, query)
+	if err == ErrCacheMiss {
+		t.counters.lookup(false)
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+	t.counters.lookup(true)
+	return b, setBitmap(t.memory, query, b)
+}
+
+// SetBitmap caches results in memory and on disk.
+func (t TieredQueryCache) SetBitmap(query cqr.CommonQueryRepresentation, bitmap *Bitmap) error {
+	if err := setBitmap(t.memory, query, bitmap); err != nil {
+		return err
+	}
+	return setBitmap(t.disk, query, bitmap)
+}
+
+// Stats are the statistics of lookups in either tier, and the evictions, expirations, entries and bytes of the disk
+// cache (when it reports statistics).
+func (t TieredQueryCache) Stats() CacheStats {
+	var s CacheStats
+	if c, ok := t.disk.(ObservableQueryCacher); ok {
+		s = c.Stats()
+	}
+	c := t.counters.stats(s.Entries, s.Bytes)
+	c.Evictions, c.Expirations = s.Evictions, s.Expirations
+	return c
+}
+
+// cacheBounds are the bounds of the memory cache, which expires queries with the shorter TTL of either tier.
+func (t TieredQueryCache) cacheBounds() CacheBounds {
+	b, disk := cacheBoundsOf(t.memory), cacheBoundsOf(t.disk)
+	if disk.TTL > 0 && (b.TTL == 0 || disk.TTL < b.TTL) {
+		b.TTL = disk.TTL
+	}
+	return b
+}
+
+// getBitmap looks up results as a bitmap in any query cache.
+func getBitmap(cache QueryCacher, query cqr.CommonQueryRepresentation) (*Bitmap, error) {
+	if c, ok := cache.(BitmapQueryCacher); ok {
+		return c.GetBitmap(query)
+	}
+	docs, err := cache.Get(query)
+	if err != nil {
+		return nil, err
+	}
+	return docs.Bitmap(), nil
+}
+
+// setBitmap caches results as a bitmap in any query cache.
+func setBitmap(cache QueryCacher, query cqr.CommonQueryRepresentation, bitmap *Bitmap) error {
+	if c, ok := cache.(BitmapQueryCacher); ok {
+		return c.SetBitmap(query, bitmap)
+	}
+	return cache.Set(query, bitmap.Documents())
+}
@@ -0,0 +1,280 @@
+package combinator
+
+import (
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/peterbourgon/diskv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMemoryQueryCache(t *testing.T) {
+	cache := NewMemoryQueryCache(CacheMaxEntries(2))
+	a, b, c := cqr.NewKeyword("a", "title"), cqr.NewKeyword("b", "title"), cqr.NewKeyword("c", "title")
+	for _, k := range []cqr.Keyword{a, b} {
+		if err := cache.Set(k, Documents{1, 2, 3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a is used more recently than b, so b is evicted.
+	if _, err := cache.Get(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Set(c, Documents{4}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Get(b); err != ErrCacheMiss {
+		t.Errorf("expected b to be evicted, got %v", err)
+	}
+	if docs, err := cache.Get(c); err != nil || len(docs) != 1 {
+		t.Errorf("unexpected documents %v (%v)", docs, err)
+	}
+
+	s := cache.(ObservableQueryCacher).Stats()
+	want := CacheStats{Hits: 2, Misses: 1, Evictions: 1, Entries: 2, Bytes: int64(NewBitmap(Documents{1, 2, 3}).Size() + NewBitmap(Documents{4}).Size())}
+	if s != want {
+		t.Errorf("expected stats %+v, got %+v", want, s)
+	}
+	if s.HitRate() != 2.0/3.0 {
+		t.Errorf("unexpected hit rate %f", s.HitRate())
+	}
+
+	// Bounding the bytes evicts until the documents fit.
+	cache = NewMemoryQueryCache(CacheMaxBytes(int64(2 * NewBitmap(Documents{1}).Size())))
+	for i := 0; i < 5; i++ {
+		if err := cache.Set(cqr.NewKeyword(fmt.Sprint(i), "title"), Documents{Document(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := cache.(ObservableQueryCacher).Stats(); s.Entries != 2 || s.Evictions != 3 {
+		t.Errorf("expected 2 entries and 3 evictions, got %+v", s)
+	}
+}
+
+func TestQueryCacheTTL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ttl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, cache := range map[string]QueryCacher{
+		"memory": NewMemoryQueryCache(CacheTTL(20 * time.Millisecond)),
+		"file":   NewFileQueryCache(dir, CacheTTL(20*time.Millisecond)),
+	} {
+		k := cqr.NewKeyword("pubmed", "title")
+		if err := cache.Set(k, Documents{1}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cache.Get(k); err != nil {
+			t.Errorf("%s: expected a hit before the ttl, got %v", name, err)
+		}
+		time.Sleep(30 * time.Millisecond)
+		if _, err := cache.Get(k); err != ErrCacheMiss {
+			t.Errorf("%s: expected a miss after the ttl, got %v", name, err)
+		}
+		if s := cache.(ObservableQueryCacher).Stats(); s.Expirations != 1 || s.Entries != 0 || s.Bytes != 0 {
+			t.Errorf("%s: expected an expiration, got %+v", name, s)
+		}
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("expected the expired file to be removed, got %d files", len(files))
+	}
+}
+
+func TestFileQueryCacheEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eviction")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache := NewFileQueryCache(dir, CacheMaxEntries(3))
+	for i := 0; i < 5; i++ {
+		if err := cache.Set(cqr.NewKeyword(fmt.Sprint(i), "title"), Documents{Document(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Errorf("expected 3 files, got %d", len(files))
+	}
+	// Evicted queries are also removed from the in-memory lru.
+	if _, err := cache.Get(cqr.NewKeyword("0", "title")); err != ErrCacheMiss {
+		t.Errorf("expected the first query to be evicted, got %v", err)
+	}
+	s := cache.(ObservableQueryCacher).Stats()
+	if s.Entries != 3 || s.Evictions != 2 || s.Bytes != int64(3*NewBitmap(Documents{1}).Size()) {
+		t.Errorf("unexpected stats %+v", s)
+	}
+
+	// Files already in the cache are indexed, and evicted when the cache is reopened with smaller bounds.
+	s = NewFileQueryCache(dir, CacheMaxEntries(1)).(ObservableQueryCacher).Stats()
+	if s.Entries != 1 || s.Evictions != 2 {
+		t.Errorf("unexpected stats of the reopened cache %+v", s)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("expected 1 file, got %d", len(files))
+	}
+}
+
+func TestFileQueryCacheIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The files of the cache are indexed when it is first used, rather than when it is created.
+	cache := NewFileQueryCache(dir, CacheMaxEntries(2))
+	for i := 0; i < 3; i++ {
+		if err := NewFileQueryCache(dir).Set(cqr.NewKeyword(fmt.Sprint(i), "title"), Documents{Document(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := cache.(ObservableQueryCacher).Stats(); s.Entries != 2 || s.Evictions != 1 {
+		t.Errorf("expected the files to be indexed on first use, got %+v", s)
+	}
+
+	// A directory that cannot be indexed fails the first use of the cache.
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache = NewFileQueryCache(dir)
+	cache.(FileQueryCache).index.dir = filepath.Join(file, "missing")
+	if _, err := cache.Get(cqr.NewKeyword("0", "title")); err == nil || err == ErrCacheMiss {
+		t.Errorf("expected an error indexing the cache, got %v", err)
+	}
+}
+
+func TestDiskvQueryCacheEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache := NewDiskvQueryCache(diskv.New(diskv.Options{
+		BasePath:    dir,
+		Transform:   BlockTransform(8),
+		Compression: diskv.NewGzipCompression(),
+	}), CacheMaxEntries(2))
+	for i := 0; i < 4; i++ {
+		if err := cache.Set(cqr.NewKeyword(fmt.Sprint(i), "title"), Documents{Document(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var files int
+	var size int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files++
+			size += info.Size()
+		}
+		return nil
+	})
+	s := cache.(ObservableQueryCacher).Stats()
+	if files != 2 || s.Entries != 2 || s.Evictions != 2 || s.Bytes != size {
+		t.Errorf("expected 2 files of %d bytes, got %d files and stats %+v", size, files, s)
+	}
+}
+
+func TestDiskQueryCacheKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a, b := cqr.NewKeyword("a", "title"), cqr.NewKeyword("b", "title")
+	q := cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{a, b})
+	equivalent := cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{b, a, a})
+	legacy := cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{a, b})
+	dv := diskv.New(diskv.Options{BasePath: filepath.Join(dir, "diskv"), Transform: BlockTransform(8)})
+	for name, cache := range map[string]QueryCacher{
+		"file":  NewFileQueryCache(filepath.Join(dir, "file")),
+		"diskv": NewDiskvQueryCache(dv),
+	} {
+		// Equivalent queries share results.
+		if err := cache.Set(q, Documents{1, 2}); err != nil {
+			t.Fatal(err)
+		}
+		if docs, err := cache.Get(equivalent); err != nil || len(docs) != 2 {
+			t.Errorf("%s: expected the documents of the equivalent query, got %v (%v)", name, docs, err)
+		}
+	}
+
+	// Results cached by HashCQR, before queries were canonicalised, are found.
+	bitmap, err := Documents{3}.Bitmap().MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dv.Write(strconv.Itoa(int(HashCQR(legacy))), bitmap); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "file", fmt.Sprint(HashCQR(legacy))), bitmap, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, cache := range map[string]QueryCacher{
+		"file":  NewFileQueryCache(filepath.Join(dir, "file")),
+		"diskv": NewDiskvQueryCache(dv),
+	} {
+		if docs, err := cache.Get(legacy); err != nil || len(docs) != 1 || docs[0] != 3 {
+			t.Errorf("%s: expected the documents cached by HashCQR, got %v (%v)", name, docs, err)
+		}
+		if s := cache.(ObservableQueryCacher).Stats(); s.Hits != 1 || s.Misses != 0 {
+			t.Errorf("%s: expected one hit, got %+v", name, s)
+		}
+	}
+}
+
+func TestTieredQueryCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tiered")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	k := cqr.NewKeyword("tiered", "title")
+	if err := NewFileQueryCache(dir).Set(k, Documents{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	memory := NewMemoryQueryCache(CacheMaxEntries(10))
+	cache := NewTieredQueryCache(memory, NewFileQueryCache(dir))
+
+	// Documents found on disk are promoted into memory.
+	if docs, err := cache.Get(k); err != nil || len(docs) != 2 {
+		t.Fatalf("unexpected documents %v (%v)", docs, err)
+	}
+	if _, err := memory.Get(k); err != nil {
+		t.Errorf("expected the documents to be promoted, got %v", err)
+	}
+	if _, err := cache.Get(cqr.NewKeyword("missing", "title")); err != ErrCacheMiss {
+		t.Errorf("expected a miss, got %v", err)
+	}
+
+	// Documents are written to both tiers.
+	k = cqr.NewKeyword("both", "title")
+	if err := cache.Set(k, Documents{3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileQueryCache(dir).Get(k); err != nil {
+		t.Errorf("expected the documents on disk, got %v", err)
+	}
+
+	s := cache.(ObservableQueryCacher).Stats()
+	if s.Hits != 1 || s.Misses != 1 || s.Entries != 2 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+	if s := NewSubtreeQueryCache(cache, 0).(ObservableQueryCacher).Stats(); s.Hits != 1 {
+		t.Errorf("expected the subtree cache to report the stats of its cache, got %+v", s)
+	}
+}
@@ -12,8 +12,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
-	"sort"
+	"path/filepath"
 	"strconv"
+	"sync"
 )
 
 // ErrCacheMiss indicates that a read did not fail, but the item was not present in the cache.
@@ -34,18 +35,35 @@ func BlockTransform(blockSize int) func(string) []string {
 	}
 }
 
-// docsToBytes encodes a retrieved set of documents  to bytes.
-func docsToBytes(docs Documents) ([]byte, error) {
-	if len(docs) == 0 {
-		return []byte{}, nil
+// bytesToBitmap decodes documents cached on disk. Documents are encoded as bitmaps, but caches written before bitmaps
+// encoded them with decode, which is used when the bytes are not a bitmap.
+func bytesToBitmap(b []byte, decode func([]byte) (Documents, error)) (*Bitmap, error) {
+	bitmap := &Bitmap{}
+	if len(b) == 0 || bitmap.UnmarshalBinary(b) == nil {
+		return bitmap, nil
 	}
-	var buff bytes.Buffer
-	enc := gob.NewEncoder(&buff)
-	err := enc.Encode(docs)
+	docs, err := decode(b)
 	if err != nil {
 		return nil, err
 	}
-	return buff.Bytes(), nil
+	return docs.Bitmap(), nil
+}
+
+// gobDocuments decodes gob encoded documents.
+func gobDocuments(b []byte) (Documents, error) {
+	var docs Documents
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&docs)
+	return docs, err
+}
+
+// littleEndianDocuments decodes documents encoded as consecutive little-endian integers.
+func littleEndianDocuments(b []byte) (Documents, error) {
+	d := make(Documents, len(b)/4)
+	for i, j := 0, 0; i+4 <= len(b); i += 4 {
+		d[j] = Document(binary.LittleEndian.Uint32(b[i : i+4]))
+		j++
+	}
+	return d, nil
 }
 
 func constructor() {
@@ -60,70 +78,156 @@ type QueryCacher interface {
 	Set(query cqr.CommonQueryRepresentation, docs Documents) error
 }
 
-// MapQueryCache caches results to memory.
+// BitmapQueryCacher is a query cache that stores documents as bitmaps. Logical trees combine the bitmaps of a bitmap
+// query cache directly, and the documents of Get are a slice view of them.
+type BitmapQueryCacher interface {
+	QueryCacher
+	GetBitmap(query cqr.CommonQueryRepresentation) (*Bitmap, error)
+	SetBitmap(query cqr.CommonQueryRepresentation, bitmap *Bitmap) error
+}
+
+// MapQueryCache caches results to memory, where equivalent queries (see Canonicalise) share results. The cache is
+// unbounded; see NewMemoryQueryCache for a bounded cache.
 type MapQueryCache struct {
-	m map[uint64]Documents
+	m        map[uint64]*Bitmap
+	mu       *sync.RWMutex
+	bytes    *int64
+	counters *cacheCounters
 }
 
 // Get looks up results in a map.
 func (m MapQueryCache) Get(query cqr.CommonQueryRepresentation) (Documents, error) {
-	if d, ok := m.m[HashCQR(query)]; ok {
-		return d, nil
+	b, err := m.GetBitmap(query)
+	if err != nil {
+		return Documents{}, err
 	}
-	return Documents{}, ErrCacheMiss
+	return b.Documents(), nil
 }
 
 // Set caches results to a map.
 func (m MapQueryCache) Set(query cqr.CommonQueryRepresentation, docs Documents) error {
-	sort.Sort(docs)
-	m.m[HashCQR(query)] = docs
+	return m.SetBitmap(query, docs.Bitmap())
+}
+
+// GetBitmap looks up results in a map.
+func (m MapQueryCache) GetBitmap(query cqr.CommonQueryRepresentation) (*Bitmap, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	b, ok := m.m[CanonicalHash(query)]
+	m.counters.lookup(ok)
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	return b, nil
+}
+
+// SetBitmap caches results to a map.
+func (m MapQueryCache) SetBitmap(query cqr.CommonQueryRepresentation, bitmap *Bitmap) error {
+	h := CanonicalHash(query)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if b, ok := m.m[h]; ok {
+		*m.bytes -= int64(b.Size())
+	}
+	m.m[h] = bitmap
+	*m.bytes += int64(bitmap.Size())
 	return nil
 }
 
+// Stats are the statistics of the cache.
+func (m MapQueryCache) Stats() CacheStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.counters.stats(int64(len(m.m)), *m.bytes)
+}
+
 // NewMapQueryCache creates a query cache out of a regular go map.
 func NewMapQueryCache() QueryCacher {
 	constructor()
-	return MapQueryCache{make(map[uint64]Documents)}
+	return MapQueryCache{m: make(map[uint64]*Bitmap), mu: new(sync.RWMutex), bytes: new(int64), counters: new(cacheCounters)}
 }
 
 // DiskvQueryCache caches results using diskv.
 type DiskvQueryCache struct {
 	*diskv.Diskv
+	index *diskIndex
 }
 
 // Get looks up results from disk.
 func (d DiskvQueryCache) Get(query cqr.CommonQueryRepresentation) (Documents, error) {
-	b, err := d.Read(strconv.Itoa(int(HashCQR(query))))
-	if err != nil {
-		return Documents{}, ErrCacheMiss
-	}
-	if len(b) == 0 {
-		return Documents{}, nil
-	}
-	dec := gob.NewDecoder(bytes.NewReader(b))
-	var c Documents
-	err = dec.Decode(&c)
+	b, err := d.GetBitmap(query)
 	if err != nil {
 		return Documents{}, err
 	}
-	return c, nil
+	return b.Documents(), nil
 }
 
 // Set caches results to disk.
 func (d DiskvQueryCache) Set(query cqr.CommonQueryRepresentation, docs Documents) error {
-	sort.Sort(docs)
-	b, err := docsToBytes(docs)
+	return d.SetBitmap(query, docs.Bitmap())
+}
+
+// GetBitmap looks up results from disk. Queries are keyed by their canonical hash (see CanonicalHash); queries cached
+// before queries were canonicalised are found by their HashCQR.
+func (d DiskvQueryCache) GetBitmap(query cqr.CommonQueryRepresentation) (*Bitmap, error) {
+	b, err := d.read(CanonicalHash(query))
+	if err == ErrCacheMiss {
+		b, err = d.read(HashCQR(query))
+	}
+	if err == nil || err == ErrCacheMiss {
+		d.index.counters.lookup(err == nil)
+	}
+	return b, err
+}
+
+// read reads the results of a hash from disk.
+func (d DiskvQueryCache) read(h uint64) (*Bitmap, error) {
+	key := strconv.Itoa(int(h))
+	if expired, err := d.index.used(key); err != nil {
+		return nil, err
+	} else if expired {
+		return nil, ErrCacheMiss
+	}
+	b, err := d.Read(key)
+	if err != nil {
+		return nil, ErrCacheMiss
+	}
+	return bytesToBitmap(b, gobDocuments)
+}
+
+// SetBitmap caches results to disk.
+func (d DiskvQueryCache) SetBitmap(query cqr.CommonQueryRepresentation, bitmap *Bitmap) error {
+	b, err := bitmap.MarshalBinary()
 	if err != nil {
-		fmt.Println(err)
 		return err
 	}
-	return d.Write(strconv.Itoa(int(HashCQR(query))), b)
+	key := strconv.Itoa(int(CanonicalHash(query)))
+	if err := d.Write(key, b); err != nil {
+		return err
+	}
+	// Files are compressed by diskv, so their size is that on disk.
+	size := int64(len(b))
+	if info, err := os.Stat(filepath.Join(append(append([]string{d.BasePath}, d.Transform(key)...), key)...)); err == nil {
+		size = info.Size()
+	}
+	return d.index.written(key, size)
+}
+
+// Stats are the statistics of the cache. Bytes are the size of the cached files.
+func (d DiskvQueryCache) Stats() CacheStats {
+	return d.index.stats()
+}
+
+func (d DiskvQueryCache) cacheBounds() CacheBounds {
+	return d.index.bounds
 }
 
-// NewDiskvQueryCache creates a new on-disk cache with the specified diskv parameters.
-func NewDiskvQueryCache(dv *diskv.Diskv) QueryCacher {
+// NewDiskvQueryCache creates a new on-disk cache with the specified diskv parameters and bounds. The files already in
+// the cache are indexed when the cache is first used, and evicted (least recently written first) if the cache is over
+// its bounds.
+func NewDiskvQueryCache(dv *diskv.Diskv, options ...func(*CacheBounds)) QueryCacher {
 	constructor()
-	return DiskvQueryCache{dv}
+	return DiskvQueryCache{Diskv: dv, index: newDiskIndex(dv.BasePath, newCacheBounds(options), dv.Erase)}
 }
 
 // FileQueryCache caches results in a flat-file format in a single directory. This cacher will be faster than diskv as
@@ -131,11 +235,13 @@ func NewDiskvQueryCache(dv *diskv.Diskv) QueryCacher {
 type FileQueryCache struct {
 	path  string
 	cache *lru.Cache
+	index *diskIndex
 }
 
-// NewFileQueryCache creates a new disk-based file query cache.
-func NewFileQueryCache(path string) QueryCacher {
-	err := os.MkdirAll(path, 0700)
+// NewFileQueryCache creates a new disk-based file query cache with the bounds. The files already in the cache are
+// indexed when the cache is first used, and evicted (least recently written first) if the cache is over its bounds.
+func NewFileQueryCache(dir string, options ...func(*CacheBounds)) QueryCacher {
+	err := os.MkdirAll(dir, 0700)
 	if err != nil {
 		panic(err)
 	}
@@ -143,20 +249,59 @@ func NewFileQueryCache(path string) QueryCacher {
 	if err != nil {
 		panic(err)
 	}
+	index := newDiskIndex(dir, newCacheBounds(options), func(key string) error {
+		if h, err := strconv.ParseUint(key, 10, 64); err == nil {
+			c.Remove(h)
+		}
+		return os.Remove(path.Join(dir, key))
+	})
 	return FileQueryCache{
-		path:  path,
+		path:  dir,
 		cache: c,
+		index: index,
 	}
 }
 
 // Get looks up results from disk.
 func (f FileQueryCache) Get(query cqr.CommonQueryRepresentation) (Documents, error) {
-	h := HashCQR(query)
+	b, err := f.GetBitmap(query)
+	if err != nil {
+		return nil, err
+	}
+	return b.Documents(), nil
+}
+
+// Set caches results to disk.
+func (f FileQueryCache) Set(query cqr.CommonQueryRepresentation, docs Documents) error {
+	return f.SetBitmap(query, docs.Bitmap())
+}
+
+// GetBitmap looks up results from disk. Queries are keyed by their canonical hash (see CanonicalHash); queries cached
+// before queries were canonicalised are found by their HashCQR.
+func (f FileQueryCache) GetBitmap(query cqr.CommonQueryRepresentation) (*Bitmap, error) {
+	b, err := f.read(CanonicalHash(query))
+	if err == ErrCacheMiss {
+		b, err = f.read(HashCQR(query))
+	}
+	if err == nil || err == ErrCacheMiss {
+		f.index.counters.lookup(err == nil)
+	}
+	return b, err
+}
+
+// read reads the results of a hash from memory, or from disk.
+func (f FileQueryCache) read(h uint64) (*Bitmap, error) {
+	key := fmt.Sprintf("%v", h)
+	if expired, err := f.index.used(key); err != nil {
+		return nil, err
+	} else if expired {
+		return nil, ErrCacheMiss
+	}
 	if v, ok := f.cache.Get(h); ok {
-		return v.(Documents), nil
+		return v.(*Bitmap), nil
 	}
 
-	fn := path.Join(f.path, fmt.Sprintf("%v", h))
+	fn := path.Join(f.path, key)
 	if _, err := os.Stat(fn); err != nil && os.IsNotExist(err) {
 		return nil, ErrCacheMiss
 	} else if err != nil {
@@ -166,29 +311,34 @@ func (f FileQueryCache) Get(query cqr.CommonQueryRepresentation) (Documents, err
 	if err != nil {
 		return nil, err
 	}
-	d := make(Documents, len(b)/4)
-	for i, j := 0, 0; i < len(b); i += 4 {
-		d[j] = Document(binary.LittleEndian.Uint32(b[i : i+4]))
-		j++
+	bitmap, err := bytesToBitmap(b, littleEndianDocuments)
+	if err != nil {
+		return nil, err
 	}
-	return d, nil
+	f.cache.Add(h, bitmap)
+	return bitmap, nil
 }
 
-// Set caches results to disk.
-func (f FileQueryCache) Set(query cqr.CommonQueryRepresentation, docs Documents) error {
-	sort.Sort(docs)
-	h := HashCQR(query)
-	f.cache.Add(h, docs)
-	b := make([]byte, len(docs)*4)
-	i := 0
-	for _, id := range docs {
-		d := make([]byte, 4)
-		binary.LittleEndian.PutUint32(d, uint32(id))
-		b[i] = d[0]
-		b[i+1] = d[1]
-		b[i+2] = d[2]
-		b[i+3] = d[3]
-		i += 4
-	}
-	return ioutil.WriteFile(path.Join(f.path, fmt.Sprintf("%v", h)), b, 0644)
+// SetBitmap caches results to disk, keyed by the canonical hash of the query.
+func (f FileQueryCache) SetBitmap(query cqr.CommonQueryRepresentation, bitmap *Bitmap) error {
+	h := CanonicalHash(query)
+	key := fmt.Sprintf("%v", h)
+	f.cache.Add(h, bitmap)
+	b, err := bitmap.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(f.path, key), b, 0644); err != nil {
+		return err
+	}
+	return f.index.written(key, int64(len(b)))
+}
+
+// Stats are the statistics of the cache. Bytes are the size of the cached files.
+func (f FileQueryCache) Stats() CacheStats {
+	return f.index.stats()
+}
+
+func (f FileQueryCache) cacheBounds() CacheBounds {
+	return f.index.bounds
 }
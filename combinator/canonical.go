@@ -0,0 +1,177 @@
+package combinator
+
+import (
+	"fmt"
+	"github.com/hscells/cqr"
+	"hash/crc64"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Canonicalise rewrites a query into a canonical form that retrieves the same documents, so that equivalent queries
+// have the same key (see CanonicalKey, and CanonicalHash). In the canonical form:
+//   - operators are lower case, and `and` and `or` queries nested in a query with the same operator and options are
+//     flattened into it;
+//   - the children of `and` and `or` queries, and the negated children of `not` queries, are sorted and unique, and an
+//     `and` or `or` query of a single child (and no options) is the child;
+//   - whitespace in keywords is collapsed, fields are sorted and unique, and options without a value are removed.
+//
+// The children of other operators (e.g. adjacency) are not reordered. The query is not modified.
+func Canonicalise(query cqr.CommonQueryRepresentation) cqr.CommonQueryRepresentation {
+	switch q := query.(type) {
+	case cqr.Keyword:
+		return canonicalKeyword(q)
+	case cqr.BooleanQuery:
+		return canonicalBooleanQuery(q)
+	}
+	return query
+}
+
+func canonicalKeyword(q cqr.Keyword) cqr.Keyword {
+	k := cqr.NewKeyword(strings.Join(strings.Fields(q.QueryString), " "))
+	seen := make(map[string]bool, len(q.Fields))
+	for _, field := range q.Fields {
+		field = strings.TrimSpace(field)
+		if len(field) > 0 && !seen[field] {
+			seen[field] = true
+			k.Fields = append(k.Fields, field)
+		}
+	}
+	sort.Strings(k.Fields)
+	k.Options = canonicalOptions(q.Options)
+	return k
+}
+
+func canonicalBooleanQuery(q cqr.BooleanQuery) cqr.CommonQueryRepresentation {
+	b := cqr.NewBooleanQuery(strings.ToLower(strings.TrimSpace(q.Operator)), nil)
+	b.Options = canonicalOptions(q.Options)
+	for _, child := range q.Children {
+		if child != nil {
+			b.Children = append(b.Children, Canonicalise(child))
+		}
+	}
+
+	switch b.Operator {
+	case cqr.AND, cqr.OR:
+		var children []cqr.CommonQueryRepresentation
+		for _, child := range b.Children {
+			if c, ok := child.(cqr.BooleanQuery); ok && c.Operator == b.Operator && reflect.DeepEqual(c.Options, b.Options) {
+				children = append(children, c.Children...)
+				continue
+			}
+			children = append(children, child)
+		}
+		b.Children = uniqueQueries(children)
+		if len(b.Children) == 1 && len(b.Options) == 0 {
+			return b.Children[0]
+		}
+	case cqr.NOT:
+		if len(b.Children) == 0 {
+			break
+		}
+		// The documents of a nested `not` in the first child are removed by its other children, as well as ours.
+		children := b.Children
+		if c, ok := children[0].(cqr.BooleanQuery); ok && c.Operator == cqr.NOT && len(c.Children) > 0 && reflect.DeepEqual(c.Options, b.Options) {
+			children = append(append([]cqr.CommonQueryRepresentation{}, c.Children...), children[1:]...)
+		}
+		b.Children = append([]cqr.CommonQueryRepresentation{children[0]}, uniqueQueries(children[1:])...)
+	}
+	return b
+}
+
+// CanonicalKey is a string that is the same for equivalent queries, and different otherwise. Unlike the string
+// representation of a query, the key keeps the order of the children of operators such as `not` and adjacency.
+func CanonicalKey(query cqr.CommonQueryRepresentation) string {
+	var b strings.Builder
+	writeKey(&b, Canonicalise(query))
+	return b.String()
+}
+
+// CanonicalHash creates a hash of the canonical key of the query, so that equivalent queries have the same hash. It
+// keys the query caches.
+func CanonicalHash(query cqr.CommonQueryRepresentation) uint64 {
+	if query == nil {
+		return 0
+	}
+	return crc64.Checksum([]byte(CanonicalKey(query)), crc64.MakeTable(crc64.ISO))
+}
+
+// exactKey is a string that is the same only for queries written the same way. Unlike the string representation of a
+// query, the key keeps the order and duplicates of the children of every operator.
+func exactKey(query cqr.CommonQueryRepresentation) string {
+	var b strings.Builder
+	writeKey(&b, query)
+	return b.String()
+}
+
+// writeKey writes the key of a query, keeping the order of its children.
+func writeKey(b *strings.Builder, query cqr.CommonQueryRepresentation) {
+	switch q := query.(type) {
+	case cqr.Keyword:
+		b.WriteString(strconv.Quote(q.QueryString))
+		b.WriteString(" [")
+		b.WriteString(strings.Join(q.Fields, " "))
+		b.WriteString("]")
+		writeOptions(b, q.Options)
+	case cqr.BooleanQuery:
+		b.WriteString("(")
+		b.WriteString(q.Operator)
+		writeOptions(b, q.Options)
+		for _, child := range q.Children {
+			b.WriteString(" ")
+			writeKey(b, child)
+		}
+		b.WriteString(")")
+	default:
+		b.WriteString(query.String())
+	}
+}
+
+func writeOptions(b *strings.Builder, options map[string]interface{}) {
+	o := make([]string, 0, len(options))
+	for k, v := range options {
+		o = append(o, fmt.Sprintf("%s:%v", k, v))
+	}
+	sort.Strings(o)
+	b.WriteString(" {")
+	b.WriteString(strings.Join(o, " "))
+	b.WriteString("}")
+}
+
+// canonicalOptions copies options, removing those without a value.
+func canonicalOptions(options map[string]interface{}) map[string]interface{} {
+	o := make(map[string]interface{}, len(options))
+	for k, v := range options {
+		if v != nil {
+			o[k] = v
+		}
+	}
+	return o
+}
+
+// uniqueQueries sorts canonical queries by their keys and removes duplicates.
+func uniqueQueries(queries []cqr.CommonQueryRepresentation) []cqr.CommonQueryRepresentation {
+	keys := make([]string, len(queries))
+	for i, q := range queries {
+		var b strings.Builder
+		writeKey(&b, q)
+		keys[i] = b.String()
+	}
+	idx := make([]int, len(queries))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return keys[idx[i]] < keys[idx[j]]
+	})
+	unique := make([]cqr.CommonQueryRepresentation, 0, len(queries))
+	for i, j := range idx {
+		if i > 0 && keys[j] == keys[idx[i-1]] {
+			continue
+		}
+		unique = append(unique, queries[j])
+	}
+	return unique
+}
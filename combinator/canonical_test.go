@@ -0,0 +1,65 @@
+package combinator
+
+import (
+	"github.com/hscells/cqr"
+	"testing"
+)
+
+func TestCanonicalise(t *testing.T) {
+	a, b, c := cqr.NewKeyword("a", "title"), cqr.NewKeyword("b", "title"), cqr.NewKeyword("c", "title")
+	or := func(children ...cqr.CommonQueryRepresentation) cqr.BooleanQuery {
+		return cqr.NewBooleanQuery(cqr.OR, children)
+	}
+	not := func(children ...cqr.CommonQueryRepresentation) cqr.BooleanQuery {
+		return cqr.NewBooleanQuery(cqr.NOT, children)
+	}
+	adj := func(children ...cqr.CommonQueryRepresentation) cqr.BooleanQuery {
+		return cqr.NewBooleanQuery("adj2", children)
+	}
+
+	for _, e := range []struct {
+		name string
+		x, y cqr.CommonQueryRepresentation
+	}{
+		{"commutative", or(a, b), or(b, a)},
+		{"duplicates", or(a, b, a), or(a, b)},
+		{"nested", or(a, or(b, or(c))), or(c, b, a)},
+		{"single child", or(a), a},
+		{"operator case", cqr.NewBooleanQuery("OR", []cqr.CommonQueryRepresentation{a, b}), or(a, b)},
+		{"fields", cqr.NewKeyword("a", "title", "text", "title"), cqr.NewKeyword("a", "text", "title")},
+		{"whitespace", cqr.NewKeyword(" type  2 diabetes", "title"), cqr.NewKeyword("type 2 diabetes", "title")},
+		{"options", cqr.NewKeyword("a", "title").SetOption(cqr.ExplodedString, nil), a},
+		{"negated", not(a, c, b, c), not(a, b, c)},
+		{"nested not", not(not(a, b), c), not(a, c, b)},
+	} {
+		if CanonicalHash(e.x) != CanonicalHash(e.y) {
+			t.Errorf("%s: expected %v and %v to be equivalent", e.name, Canonicalise(e.x), Canonicalise(e.y))
+		}
+	}
+
+	for _, e := range []struct {
+		name string
+		x, y cqr.CommonQueryRepresentation
+	}{
+		{"and or", or(a, b), cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{a, b})},
+		{"not order", not(a, b), not(b, a)},
+		{"adjacency order", adj(a, b), adj(b, a)},
+		{"nested options", or(a, or(b, c).SetOption("x", 1)), or(a, b, c)},
+		{"option values", cqr.NewKeyword("a", "title").SetOption(cqr.ExplodedString, true), a},
+	} {
+		if CanonicalHash(e.x) == CanonicalHash(e.y) {
+			t.Errorf("%s: expected %v and %v not to be equivalent", e.name, Canonicalise(e.x), Canonicalise(e.y))
+		}
+	}
+
+	// Caches on disk keep the hash of the query as it is written.
+	if HashCQR(or(a, a, b)) == HashCQR(or(a, b)) {
+		t.Error("expected the hash of the query as it is written")
+	}
+
+	q := or(b, a)
+	Canonicalise(q)
+	if q.Children[0].String() != b.String() {
+		t.Error("expected the query not to be modified")
+	}
+}
@@ -0,0 +1,193 @@
+package combinator
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/eval"
+	"github.com/hscells/trecresults"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Explanation reports the documents retrieved by a node of a logical tree, and what they contribute to its parent.
+// Explanations are written as JSON with encoding/json, or as Graphviz with WriteDOT.
+type Explanation struct {
+	// Operator is the operator of a combinator (e.g. or, adj3), and Query and Fields are the keyword of an atom.
+	Operator string   `json:"operator,omitempty"`
+	Query    string   `json:"query,omitempty"`
+	Fields   []string `json:"fields,omitempty"`
+	// Retrieved is the number of documents the node retrieves. Of those, Unique are retrieved by none of its siblings
+	// (so for the children of an `or`, the documents that would be lost without the node), and Overlap by at least one.
+	Retrieved int `json:"retrieved"`
+	Unique    int `json:"unique"`
+	Overlap   int `json:"overlap"`
+	// Relevance is only reported when qrels are supplied.
+	Relevance *RelevanceExplanation `json:"relevance,omitempty"`
+	Children  []Explanation         `json:"children,omitempty"`
+}
+
+// RelevanceExplanation reports the relevant documents retrieved by a node of a logical tree.
+type RelevanceExplanation struct {
+	// Retrieved is the number of relevant documents the node retrieves, Unique the number of them retrieved by none of
+	// its siblings, and Recall the fraction of all relevant documents it retrieves.
+	Retrieved int     `json:"retrieved"`
+	Unique    int     `json:"unique"`
+	Recall    float64 `json:"recall"`
+	// Lost is the number of relevant documents retrieved by the children of the node, but not by the node (e.g. those
+	// removed by an `and` or `not`). The children of a `not` are its first clause; the clauses it negates are not.
+	Lost int `json:"lost"`
+}
+
+// Explain reports the documents retrieved by each node of the tree. When qrels are supplied (i.e. not nil), the
+// relevant documents (those with a grade above eval.RelevanceGrade) retrieved and lost by each node are also reported.
+func (root LogicalTree) Explain(cache QueryCacher, qrels trecresults.Qrels) Explanation {
+	var relevant *Bitmap
+	if qrels != nil {
+		var docs Documents
+		for _, qrel := range qrels {
+			if qrel.Score > eval.RelevanceGrade {
+				if id, err := strconv.ParseUint(qrel.DocId, 10, 32); err == nil {
+					docs = append(docs, Document(id))
+				}
+			}
+		}
+		relevant = docs.Bitmap()
+	}
+	e, _ := explain(root.Root, cache, relevant)
+	return e
+}
+
+// explain explains a node, and returns the documents it retrieves.
+func explain(node LogicalTreeNode, cache QueryCacher, relevant *Bitmap) (Explanation, *Bitmap) {
+	var e Explanation
+	switch n := node.(type) {
+	case nil:
+		return e, &Bitmap{}
+	case Combinator:
+		e.Operator = n.Operator.String()
+		if q, ok := n.Query().(cqr.BooleanQuery); ok && len(q.Operator) > 0 {
+			e.Operator = strings.ToLower(q.Operator)
+		}
+	default:
+		switch q := node.Query().(type) {
+		case cqr.Keyword:
+			e.Query = q.QueryString
+			e.Fields = q.Fields
+		case cqr.BooleanQuery:
+			e.Operator = strings.ToLower(q.Operator)
+			e.Query = q.String()
+		}
+	}
+
+	c, ok := node.(Combinator)
+	if !ok || len(c.Clauses) == 0 {
+		b := nodeBitmap(node, cache)
+		e.Retrieved = b.Cardinality()
+		e.Relevance = explainRelevance(b, nil, relevant)
+		return e, b
+	}
+
+	// Documents retrieved by two or more children are tracked, so that the documents unique to each child are found
+	// without unioning its siblings.
+	bitmaps := make([]*Bitmap, len(c.Clauses))
+	e.Children = make([]Explanation, len(c.Clauses))
+	once, twice := &Bitmap{}, &Bitmap{}
+	for i, clause := range c.Clauses {
+		e.Children[i], bitmaps[i] = explain(clause, cache, relevant)
+		twice = Union(twice, once.And(bitmaps[i]))
+		once = Union(once, bitmaps[i])
+	}
+	for i, b := range bitmaps {
+		unique := b.AndNot(twice)
+		e.Children[i].Unique = unique.Cardinality()
+		e.Children[i].Overlap = e.Children[i].Retrieved - e.Children[i].Unique
+		if relevant != nil {
+			e.Children[i].Relevance.Unique = unique.And(relevant).Cardinality()
+		}
+	}
+
+	// Documents are lost from those the children retrieve, except for a `not`, which only retrieves documents of its
+	// first clause.
+	var b *Bitmap
+	children := once
+	switch c.Operator.(type) {
+	case andOperator:
+		b = Intersect(bitmaps...)
+	case orOperator:
+		b = once
+	case notOperator:
+		b = bitmaps[0].AndNot(Union(bitmaps[1:]...))
+		children = bitmaps[0]
+	default:
+		b = nodeBitmap(node, cache)
+	}
+	e.Retrieved = b.Cardinality()
+	e.Relevance = explainRelevance(b, children, relevant)
+	return e, b
+}
+
+// explainRelevance reports the relevant documents retrieved by a node, and those retrieved by its children.
+func explainRelevance(retrieved, children, relevant *Bitmap) *RelevanceExplanation {
+	if relevant == nil {
+		return nil
+	}
+	r := &RelevanceExplanation{Retrieved: retrieved.And(relevant).Cardinality()}
+	if n := relevant.Cardinality(); n > 0 {
+		r.Recall = float64(r.Retrieved) / float64(n)
+	}
+	if children != nil {
+		r.Lost = children.And(relevant).Cardinality() - r.Retrieved
+	}
+	return r
+}
+
+// WriteDOT writes the explanation as a Graphviz graph. Nodes that contribute no unique documents to their parent are
+// dashed, and nodes that lose relevant documents are red.
+func (e Explanation) WriteDOT(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	buf.WriteString("digraph explanation {\n\tnode [shape=box];\n")
+	id := 0
+	var write func(e Explanation, parent int)
+	write = func(e Explanation, parent int) {
+		n := id
+		id++
+		label := e.Operator
+		if len(e.Query) > 0 {
+			label = e.Query
+			if len(e.Fields) > 0 {
+				label += " [" + strings.Join(e.Fields, ", ") + "]"
+			}
+		}
+		label += fmt.Sprintf("\nretrieved %d", e.Retrieved)
+		var style []string
+		if parent >= 0 {
+			label += fmt.Sprintf("\nunique %d, overlap %d", e.Unique, e.Overlap)
+			if e.Unique == 0 {
+				style = append(style, `style=dashed`)
+			}
+		}
+		if r := e.Relevance; r != nil {
+			label += fmt.Sprintf("\nrelevant %d (unique %d), recall %.3f", r.Retrieved, r.Unique, r.Recall)
+			if r.Lost > 0 {
+				label += fmt.Sprintf("\nlost %d relevant", r.Lost)
+				style = append(style, `color=red`)
+			}
+		}
+		fmt.Fprintf(buf, "\tn%d [label=%s", n, strconv.Quote(label))
+		for _, s := range style {
+			buf.WriteString(", " + s)
+		}
+		buf.WriteString("];\n")
+		if parent >= 0 {
+			fmt.Fprintf(buf, "\tn%d -> n%d;\n", parent, n)
+		}
+		for _, child := range e.Children {
+			write(child, n)
+		}
+	}
+	write(e, -1)
+	buf.WriteString("}\n")
+	return buf.Flush()
+}
@@ -0,0 +1,120 @@
+package combinator
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/hscells/cqr"
+	"github.com/hscells/trecresults"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLogicalTree_Explain(t *testing.T) {
+	cache := NewMapQueryCache()
+	keywords := make([]cqr.CommonQueryRepresentation, 4)
+	atoms := make([]LogicalTreeNode, 4)
+	for i, docs := range []Documents{{1, 2, 3}, {3, 4}, {5}, {2, 5}} {
+		k := cqr.NewKeyword(string('a'+rune(i)), "title")
+		if err := cache.Set(k, docs); err != nil {
+			t.Fatal(err)
+		}
+		keywords[i], atoms[i] = k, NewAtom(k)
+	}
+	// ((a or b or c) not d)
+	or := cqr.NewBooleanQuery(cqr.OR, keywords[:3])
+	not := cqr.NewBooleanQuery(cqr.NOT, []cqr.CommonQueryRepresentation{or, keywords[3]})
+	tree := LogicalTree{Root: NewCombinator(not, NotOperator, NewCombinator(or, OrOperator, atoms[:3]...), atoms[3])}
+
+	qrels := trecresults.Qrels{
+		"2": {DocId: "2", Score: 2},
+		"4": {DocId: "4", Score: 2},
+		"6": {DocId: "6", Score: 2},
+		"3": {DocId: "3", Score: 0},
+	}
+	e := tree.Explain(cache, qrels)
+	if e.Operator != "not" || e.Retrieved != 3 || e.Relevance.Retrieved != 1 || e.Relevance.Lost != 1 {
+		t.Errorf("unexpected explanation of the root %+v %+v", e, e.Relevance)
+	}
+	if e.Relevance.Recall != 1.0/3 {
+		t.Errorf("expected a recall of 1/3, got %f", e.Relevance.Recall)
+	}
+	or0 := e.Children[0]
+	if or0.Retrieved != 5 || or0.Unique != 3 || or0.Overlap != 2 || or0.Relevance.Retrieved != 2 || or0.Relevance.Unique != 1 {
+		t.Errorf("unexpected explanation of the or %+v %+v", or0, or0.Relevance)
+	}
+	var got [][3]int
+	for _, c := range or0.Children {
+		got = append(got, [3]int{c.Retrieved, c.Unique, c.Overlap})
+	}
+	if !reflect.DeepEqual(got, [][3]int{{3, 2, 1}, {2, 1, 1}, {1, 1, 0}}) {
+		t.Errorf("unexpected explanations of the keywords %v", got)
+	}
+	if c := or0.Children[0]; c.Query != "a" || !reflect.DeepEqual(c.Fields, []string{"title"}) {
+		t.Errorf("unexpected keyword %q %v", c.Query, c.Fields)
+	}
+
+	if e := tree.Explain(cache, nil); e.Relevance != nil || e.Children[0].Children[0].Relevance != nil {
+		t.Error("expected no relevance without qrels")
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Explanation
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, e) {
+		t.Errorf("expected the JSON explanation to decode to %+v, got %+v", e, decoded)
+	}
+
+	var dot bytes.Buffer
+	if err := e.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"digraph", "n0 -> n1;", "n1 -> n2;", `label="a [title]\nretrieved 3`, "color=red"} {
+		if !strings.Contains(dot.String(), want) {
+			t.Errorf("expected the graph to contain %q:\n%s", want, dot.String())
+		}
+	}
+}
+
+func TestLogicalTree_ExplainNot(t *testing.T) {
+	cache := NewMapQueryCache()
+	a, b := cqr.NewKeyword("a", "title"), cqr.NewKeyword("b", "title")
+	if err := cache.Set(a, Documents{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Set(b, Documents{2}); err != nil {
+		t.Fatal(err)
+	}
+	qrels := trecresults.Qrels{"2": {DocId: "2", Score: 2}}
+
+	// Relevant documents only retrieved by the negated clause are not lost.
+	not := cqr.NewBooleanQuery(cqr.NOT, []cqr.CommonQueryRepresentation{a, b})
+	tree := LogicalTree{Root: NewCombinator(not, NotOperator, NewAtom(a), NewAtom(b))}
+	e := tree.Explain(cache, qrels)
+	if e.Retrieved != 1 || e.Relevance.Retrieved != 0 || e.Relevance.Lost != 0 {
+		t.Errorf("unexpected explanation %+v %+v", e, e.Relevance)
+	}
+	var dot bytes.Buffer
+	if err := e.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(dot.String(), "color=red") {
+		t.Errorf("expected no node to lose relevant documents:\n%s", dot.String())
+	}
+
+	// Relevant documents of the first clause that are negated are lost.
+	c := cqr.NewKeyword("c", "title")
+	if err := cache.Set(c, Documents{2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	not = cqr.NewBooleanQuery(cqr.NOT, []cqr.CommonQueryRepresentation{b, c})
+	tree = LogicalTree{Root: NewCombinator(not, NotOperator, NewAtom(b), NewAtom(c))}
+	if e := tree.Explain(cache, qrels); e.Retrieved != 0 || e.Relevance.Lost != 1 {
+		t.Errorf("unexpected explanation %+v %+v", e, e.Relevance)
+	}
+}
@@ -9,10 +9,7 @@ import (
 	"github.com/hscells/transmute/fields"
 	"github.com/hscells/trecresults"
 	"github.com/pkg/errors"
-	"github.com/xtgo/set"
 	"hash/crc64"
-	"math"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -35,6 +32,11 @@ type Operator interface {
 	String() string
 }
 
+// BitmapOperator is an operator that combines the documents of nodes as bitmaps, without converting them to slices.
+type BitmapOperator interface {
+	CombineBitmap(clauses []LogicalTreeNode, cache QueryCacher) *Bitmap
+}
+
 // LogicalTree can compute the number of documents retrieved for atomic components.
 type LogicalTree struct {
 	Root  LogicalTreeNode
@@ -48,6 +50,11 @@ type LogicalTreeNode interface {
 	String() string
 }
 
+// BitmapNode is a node of a logical tree that can retrieve its documents as a bitmap.
+type BitmapNode interface {
+	Bitmap(cache QueryCacher) *Bitmap
+}
+
 // Clause is the most basic component of a logical tree.
 type Clause struct {
 	Hash  uint64
@@ -131,38 +138,12 @@ func (d Documents) Set() map[Document]struct{} {
 }
 
 func (andOperator) Combine(nodes []LogicalTreeNode, cache QueryCacher) Documents {
-	if len(nodes) == 0 {
-		return Documents{}
-	}
-	if len(nodes) == 1 {
-		return nodes[0].Documents(cache)
-	}
-
-	var wg sync.WaitGroup
-	docIDs := make([]Documents, len(nodes))
-	for i, node := range nodes {
-		wg.Add(1)
-		go func(n LogicalTreeNode, j int) {
-			defer wg.Done()
-			docIDs[j] = n.Documents(cache)
-			if !sort.IsSorted(docIDs[j]) {
-				sort.Sort(docIDs[j])
-			}
-		}(node, i)
-	}
-	wg.Wait()
-
-	// initial set of nodes
-	docs := docIDs[0]
-	for i := 1; i < len(docIDs); i++ {
-		pivot := len(docs)
-
-		docs = append(docs /* next */, docIDs[i]...)
+	return AndOperator.CombineBitmap(nodes, cache).Documents()
+}
 
-		size := set.Inter(docs, pivot)
-		docs = docs[:size]
-	}
-	return docs
+// CombineBitmap intersects the documents of the nodes.
+func (andOperator) CombineBitmap(nodes []LogicalTreeNode, cache QueryCacher) *Bitmap {
+	return Intersect(nodeBitmaps(nodes, cache)...)
 }
 
 func (andOperator) String() string {
@@ -170,106 +151,83 @@ func (andOperator) String() string {
 }
 
 func (orOperator) Combine(nodes []LogicalTreeNode, cache QueryCacher) Documents {
+	return OrOperator.CombineBitmap(nodes, cache).Documents()
+}
+
+// CombineBitmap unions the documents of the nodes.
+func (orOperator) CombineBitmap(nodes []LogicalTreeNode, cache QueryCacher) *Bitmap {
+	return Union(nodeBitmaps(nodes, cache)...)
+}
+
+func (orOperator) String() string {
+	return "or"
+}
+
+func (notOperator) Combine(nodes []LogicalTreeNode, cache QueryCacher) Documents {
+	return NotOperator.CombineBitmap(nodes, cache).Documents()
+}
+
+// CombineBitmap removes the documents of the other nodes from the documents of the first node.
+func (notOperator) CombineBitmap(nodes []LogicalTreeNode, cache QueryCacher) *Bitmap {
 	if len(nodes) == 0 {
-		return Documents{}
+		return &Bitmap{}
 	}
+	bitmaps := nodeBitmaps(nodes, cache)
+	return bitmaps[0].AndNot(Union(bitmaps[1:]...))
+}
+
+// nodeBitmaps retrieves the documents of each node concurrently.
+func nodeBitmaps(nodes []LogicalTreeNode, cache QueryCacher) []*Bitmap {
+	bitmaps := make([]*Bitmap, len(nodes))
 	if len(nodes) == 1 {
-		if nodes[0] == nil {
-			return Documents{}
-		}
-		return nodes[0].Documents(cache)
+		bitmaps[0] = nodeBitmap(nodes[0], cache)
+		return bitmaps
 	}
-
-	totalDocs := 0
-	var docIds []Documents
 	var wg sync.WaitGroup
-	var mu2 sync.Mutex
 	for i, node := range nodes {
 		wg.Add(1)
 		go func(n LogicalTreeNode, j int) {
 			defer wg.Done()
-			d := n.Documents(cache)
-			if len(d) > 0 {
-				mu2.Lock()
-				docIds = append(docIds, d)
-				totalDocs += len(d)
-				mu2.Unlock()
-			}
+			bitmaps[j] = nodeBitmap(n, cache)
 		}(node, i)
 	}
 	wg.Wait()
-
-	idx := make([]int, len(docIds))
-	docs := make(Documents, totalDocs)
-	k := 0
-	//var docs Documents
-	for len(docIds) > 0 {
-		j := -1
-		minDoc := Document(math.MaxUint32)
-		for i := 0; i < len(docIds); i++ {
-			ptr := idx[i]
-			d := docIds[i]
-			if d[ptr] < minDoc {
-				minDoc = d[ptr]
-				j = i
-			}
-		}
-		docs[k] = minDoc
-		k++
-		//docs = append(docs, minDoc)
-		idx[j]++
-
-		if idx[j] >= len(docIds[j]) {
-			// Delete the slice.
-			docIds = append(docIds[:j], docIds[j+1:]...)
-			idx = append(idx[:j], idx[j+1:]...)
-		}
-	}
-
-	//sort.Sort(docs)
-	size := set.Uniq(docs)
-	docs = docs[:size]
-	return docs
+	return bitmaps
 }
 
-func (orOperator) String() string {
-	return "or"
-}
-
-func (notOperator) Combine(nodes []LogicalTreeNode, cache QueryCacher) Documents {
-	if len(nodes) == 0 {
-		return Documents{}
-	}
-	if len(nodes) == 1 {
-		return nodes[0].Documents(cache)
+// nodeBitmap is the documents retrieved by a node as a bitmap. Nodes that are not bitmap nodes are converted from their
+// documents.
+func nodeBitmap(node LogicalTreeNode, cache QueryCacher) *Bitmap {
+	switch n := node.(type) {
+	case nil:
+		return &Bitmap{}
+	case BitmapNode:
+		return n.Bitmap(cache)
 	}
-
-	var a Documents
-	b := make([]map[Document]struct{}, len(nodes))
-
-	a = append(a, nodes[0].Documents(cache)...)
-
-	for i := 1; i < len(nodes); i++ {
-		b[i] = nodes[i].Documents(cache).Set()
+	return node.Documents(cache).Bitmap()
+}
+
+// cachedBitmap looks up the documents of a query in the cache as a bitmap. Queries that are not cached retrieve no
+// documents.
+func cachedBitmap(query cqr.CommonQueryRepresentation, cache QueryCacher) *Bitmap {
+	var (
+		b   *Bitmap
+		err error
+	)
+	if c, ok := cache.(BitmapQueryCacher); ok {
+		b, err = c.GetBitmap(query)
+	} else {
+		var docs Documents
+		docs, err = cache.Get(query)
+		b = docs.Bitmap()
 	}
-
-	// Now make b prime, comprising the docs not in a.
-	bP := make(map[Document]bool)
-	for i := 0; i < len(b); i++ {
-		for k := range b[i] {
-			bP[k] = true
-		}
+	if err == ErrCacheMiss {
+		return &Bitmap{}
 	}
-
-	// Relative compliment.
-	var docs Documents
-	for _, doc := range a {
-		if !bP[doc] {
-			docs = append(docs, doc)
-		}
+	if err != nil {
+		panic(err)
 	}
-
-	return docs
+	return b
 }
 
 func (notOperator) String() string {
@@ -283,9 +241,35 @@ func (c Combinator) Query() cqr.CommonQueryRepresentation {
 
 // Documents returns the documents retrieved by the combinator.
 func (c Combinator) Documents(cache QueryCacher) Documents {
+	if _, ok := c.Operator.(BitmapOperator); ok {
+		return c.Bitmap(cache).Documents()
+	}
 	return c.Combine(c.Clauses, cache)
 }
 
+// Bitmap returns the documents retrieved by the combinator as a bitmap. The documents are memoised when the cache is a
+// subtree query cache.
+func (c Combinator) Bitmap(cache QueryCacher) *Bitmap {
+	memo, ok := cache.(subtreeMemo)
+	var h uint64
+	if ok {
+		h = CanonicalHash(c.Query())
+		if b, ok := memo.bitmap(h); ok {
+			return b
+		}
+	}
+	var b *Bitmap
+	if o, ok := c.Operator.(BitmapOperator); ok {
+		b = o.CombineBitmap(c.Clauses, cache)
+	} else {
+		b = c.Combine(c.Clauses, cache).Bitmap()
+	}
+	if memo != nil {
+		memo.setBitmap(h, b)
+	}
+	return b
+}
+
 // String is the combinator name.
 func (c Combinator) String() string {
 	return c.Operator.String()
@@ -308,6 +292,11 @@ func (a Atom) Documents(cache QueryCacher) Documents {
 	return docs
 }
 
+// Bitmap returns the documents retrieved by the atom as a bitmap.
+func (a Atom) Bitmap(cache QueryCacher) *Bitmap {
+	return cachedBitmap(a.Clause.Query, cache)
+}
+
 // String returns the query string.
 func (a Atom) String() string {
 	return a.Query().StringPretty()
@@ -330,6 +319,11 @@ func (a AdjAtom) Documents(cache QueryCacher) Documents {
 	return docs
 }
 
+// Bitmap returns the documents retrieved by the adjacency operator as a bitmap.
+func (a AdjAtom) Bitmap(cache QueryCacher) *Bitmap {
+	return cachedBitmap(a.Clause.Query, cache)
+}
+
 // String returns the query string.
 func (a AdjAtom) String() string {
 	return a.Query().String()
@@ -372,7 +366,10 @@ func NewCombinator(query cqr.BooleanQuery, operator Operator, clauses ...Logical
 	}
 }
 
-// HashCQR creates a hash of the query.
+// HashCQR creates a hash of the string representation of the query. The string sorts the children and options of
+// every operator (including `not` and adjacency), but keeps duplicate children and differences in whitespace and
+// nesting, so it is neither the same for every equivalent query nor different for every query that is not; see
+// CanonicalHash for a hash that is. Query caches on disk were keyed by HashCQR before they were keyed by CanonicalHash.
 func HashCQR(representation cqr.CommonQueryRepresentation) uint64 {
 	if representation == nil {
 		return 0
@@ -434,6 +431,16 @@ func constructTree(query pipeline.Query, ss stats.StatisticsSource, seen QueryCa
 			return a, seen, nil
 		}
 	case cqr.BooleanQuery:
+		// Reuse a subtree constructed for the same query.
+		memo, _ := seen.(subtreeMemo)
+		var key string
+		if memo != nil {
+			key = exactKey(q)
+			if n, ok := memo.node(key); ok {
+				return n, seen, nil
+			}
+		}
+
 		var operator Operator
 		switch strings.ToLower(q.Operator) {
 		case "or":
@@ -485,7 +492,7 @@ func constructTree(query pipeline.Query, ss stats.StatisticsSource, seen QueryCa
 			go func(idx int, c cqr.CommonQueryRepresentation) {
 				defer wg.Done()
 				var err error
-				clauses[idx], seen, err = constructTree(pipeline.NewQuery(query.Name, query.Topic, c), ss, seen)
+				clauses[idx], _, err = constructTree(pipeline.NewQuery(query.Name, query.Topic, c), ss, seen)
 				if err != nil {
 					once.Do(func() {
 						errOnce = err
@@ -497,7 +504,11 @@ func constructTree(query pipeline.Query, ss stats.StatisticsSource, seen QueryCa
 		if errOnce != nil {
 			return nil, nil, errOnce
 		}
-		return NewCombinator(q, operator, clauses...), seen, nil
+		c := NewCombinator(q, operator, clauses...)
+		if memo != nil {
+			memo.setNode(key, c)
+		}
+		return c, seen, nil
 	}
 	return nil, nil, errors.New(fmt.Sprintf("supplied query is not supported: %s", query.Query))
 }
@@ -580,10 +591,12 @@ func constructShallowTree(query pipeline.Query, s stats.StatisticsSource, releva
 // NewLogicalTree creates a new logical tree.  If the operator of the query is unknown
 // (i.e. it is not one of `or`, `and`, `not`, or an `adj` operator) the default operator will be `or`.
 //
-// Note that once one tree has been constructed, the returned map can be used to save processing.
+// Note that once one tree has been constructed, the returned map can be used to save processing. When the map is a
+// subtree query cache (see NewSubtreeQueryCache), which it is by default, the subtrees constructed and evaluated are
+// reused by subsequent trees.
 func NewLogicalTree(query pipeline.Query, ss stats.StatisticsSource, seen QueryCacher) (LogicalTree, QueryCacher, error) {
 	if seen == nil {
-		seen = NewMapQueryCache()
+		seen = NewSubtreeQueryCache(nil, 0)
 	}
 	root, seen, err := constructTree(query, ss, seen)
 	if err != nil {
@@ -606,6 +619,11 @@ func (root LogicalTree) Documents(cache QueryCacher) Documents {
 	return root.Root.Documents(cache)
 }
 
+// Bitmap returns the documents that the tree (query) would return if executed as a bitmap.
+func (root LogicalTree) Bitmap(cache QueryCacher) *Bitmap {
+	return nodeBitmap(root.Root, cache)
+}
+
 // ToCQR creates a query backwards from a logical tree.
 func (root LogicalTree) ToCQR() cqr.CommonQueryRepresentation {
 	switch c := root.Root.(type) {
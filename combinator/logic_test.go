@@ -3,17 +3,24 @@ package combinator_test
 import (
 	"fmt"
 	"github.com/hscells/cqr"
-	"github.com/hscells/groove"
 	"github.com/hscells/groove/combinator"
+	gpipeline "github.com/hscells/groove/pipeline"
 	"github.com/hscells/groove/stats"
 	"github.com/hscells/transmute/backend"
 	"github.com/hscells/transmute/lexer"
 	"github.com/hscells/transmute/parser"
 	"github.com/hscells/transmute/pipeline"
+	"os"
 	"testing"
 )
 
 func TestLogicalTree(t *testing.T) {
+	// The tree is constructed from the documents of an Elasticsearch index of MEDLINE.
+	host := os.Getenv("GROOVE_ELASTICSEARCH")
+	if len(host) == 0 {
+		t.Skip("GROOVE_ELASTICSEARCH is not set")
+	}
+
 	cqrPipeline := pipeline.NewPipeline(
 		parser.NewMedlineParser(),
 		backend.NewCQRBackend(),
@@ -142,12 +149,15 @@ func TestLogicalTree(t *testing.T) {
 116. 69 or 70 or 71 or 72 or 73 or 74 or 75 or 76 or 77 or 78 or 79 or 80 or 81 or 82 or 83 or 84 or 85 or 86 or 87 or 88 or 89 or 90 or 91 or 92 or 93 or 94 or 95 or 96 or 97 or 98 or 99 or 100 or 101 or 102 or 103 or 104 or 105 or 106 or 107 or 108 or 109 or 110 or 111 or 112 or 113 or 114 or 115
 117. 15 and 25 and 49 and 63 and 68 and 116`
 
-	ss := stats.NewElasticsearchStatisticsSource(stats.ElasticsearchHosts("http://sef-is-017660:8200/"),
+	ss, err := stats.NewElasticsearchStatisticsSource(stats.ElasticsearchHosts(host),
 		stats.ElasticsearchIndex("med_stem_sim2"),
 		stats.ElasticsearchDocumentType("doc"),
 		stats.ElasticsearchAnalysedField("stemmed"),
 		stats.ElasticsearchScroll(true),
 		stats.ElasticsearchSearchOptions(stats.SearchOptions{Size: 10000, RunName: "test"}))
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	cq, err := cqrPipeline.Execute(rawQuery)
 	if err != nil {
@@ -157,9 +167,10 @@ func TestLogicalTree(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	query := groove.NewPipelineQuery("0", "1", repr.(cqr.CommonQueryRepresentation))
+	query := gpipeline.NewQuery("0", "1", repr.(cqr.CommonQueryRepresentation))
 
 	cache := combinator.NewFileQueryCache("cache")
+	defer os.RemoveAll("cache")
 
 	//f, _ := os.Create("logic_test.pprof")
 	//pprof.StartCPUProfile(f)
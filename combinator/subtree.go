@@ -0,0 +1,152 @@
+package combinator
+
+import (
+	"github.com/hashicorp/golang-lru"
+	"github.com/hscells/cqr"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultSubtreeCacheSize is the number of subtrees memoised by a subtree query cache by default.
+const DefaultSubtreeCacheSize = 10000
+
+// SubtreeQueryCache is a query cache that also memoises, in memory, the nodes and documents of the subtrees of logical
+// trees. Nodes are memoised by their query exactly as it is written, and documents by the canonical hash of their
+// query (see CanonicalHash), which keeps the order of the children of `not` and adjacency queries. Trees constructed and evaluated with a subtree query cache reuse the subtrees they share with earlier
+// trees, so a variation of a query that changes one clause only constructs and evaluates the path from that clause to
+// the root.
+//
+// The memoised subtrees are bounded by, and expire with, the bounds of the underlying cache (see CacheBounds).
+type SubtreeQueryCache struct {
+	QueryCacher
+	bounds CacheBounds
+	nodes  *lru.Cache
+	docs   *lru.Cache
+	mu     *sync.Mutex
+	bytes  *int64
+}
+
+// subtreeEntry is a memoised node or bitmap.
+type subtreeEntry struct {
+	value  interface{}
+	size   int64
+	cached time.Time
+}
+
+// NewSubtreeQueryCache creates a query cache that memoises up to size subtrees (or DefaultSubtreeCacheSize, if size is
+// not positive), and caches the documents of queries in cache (or in memory, if cache is nil). Fewer subtrees are
+// memoised if cache is bounded to fewer queries.
+func NewSubtreeQueryCache(cache QueryCacher, size int) QueryCacher {
+	if c, ok := cache.(SubtreeQueryCache); ok {
+		return c
+	}
+	if cache == nil {
+		cache = NewMapQueryCache()
+	}
+	bounds := cacheBoundsOf(cache)
+	if size <= 0 {
+		size = DefaultSubtreeCacheSize
+	}
+	if bounds.MaxEntries > 0 && bounds.MaxEntries < size {
+		size = bounds.MaxEntries
+	}
+	nodes, err := lru.New(size)
+	if err != nil {
+		panic(err)
+	}
+	bytes := new(int64)
+	docs, err := lru.NewWithEvict(size, func(key, value interface{}) {
+		atomic.AddInt64(bytes, -value.(subtreeEntry).size)
+	})
+	if err != nil {
+		panic(err)
+	}
+	return SubtreeQueryCache{
+		QueryCacher: cache,
+		bounds:      bounds,
+		nodes:       nodes,
+		docs:        docs,
+		mu:          new(sync.Mutex),
+		bytes:       bytes,
+	}
+}
+
+// GetBitmap looks up results in the underlying cache.
+func (s SubtreeQueryCache) GetBitmap(query cqr.CommonQueryRepresentation) (*Bitmap, error) {
+	return getBitmap(s.QueryCacher, query)
+}
+
+// SetBitmap caches results in the underlying cache.
+func (s SubtreeQueryCache) SetBitmap(query cqr.CommonQueryRepresentation, bitmap *Bitmap) error {
+	return setBitmap(s.QueryCacher, query, bitmap)
+}
+
+// Stats are the statistics of the underlying cache, if it reports them.
+func (s SubtreeQueryCache) Stats() CacheStats {
+	if c, ok := s.QueryCacher.(ObservableQueryCacher); ok {
+		return c.Stats()
+	}
+	return CacheStats{}
+}
+
+// Len is the number of subtrees whose documents are memoised.
+func (s SubtreeQueryCache) Len() int {
+	return s.docs.Len()
+}
+
+// subtreeMemo is a cache that memoises subtrees.
+type subtreeMemo interface {
+	node(key string) (LogicalTreeNode, bool)
+	setNode(key string, node LogicalTreeNode)
+	bitmap(hash uint64) (*Bitmap, bool)
+	setBitmap(hash uint64, bitmap *Bitmap)
+}
+
+// get looks up a memoised entry, removing it if it has expired.
+func (s SubtreeQueryCache) get(c *lru.Cache, key interface{}) (interface{}, bool) {
+	v, ok := c.Get(key)
+	if !ok {
+		return nil, false
+	}
+	if e := v.(subtreeEntry); !s.bounds.expired(e.cached) {
+		return e.value, true
+	}
+	c.Remove(key)
+	return nil, false
+}
+
+func (s SubtreeQueryCache) node(key string) (LogicalTreeNode, bool) {
+	if v, ok := s.get(s.nodes, key); ok {
+		return v.(LogicalTreeNode), true
+	}
+	return nil, false
+}
+
+func (s SubtreeQueryCache) setNode(key string, node LogicalTreeNode) {
+	s.nodes.Add(key, subtreeEntry{value: node, cached: time.Now()})
+}
+
+func (s SubtreeQueryCache) bitmap(hash uint64) (*Bitmap, bool) {
+	if v, ok := s.get(s.docs, hash); ok {
+		return v.(*Bitmap), true
+	}
+	return nil, false
+}
+
+func (s SubtreeQueryCache) setBitmap(hash uint64, bitmap *Bitmap) {
+	e := subtreeEntry{value: bitmap, size: int64(bitmap.Size()), cached: time.Now()}
+	if s.bounds.MaxBytes > 0 && e.size > s.bounds.MaxBytes {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs.Remove(hash)
+	atomic.AddInt64(s.bytes, e.size)
+	s.docs.Add(hash, e)
+	for s.bounds.MaxBytes > 0 && atomic.LoadInt64(s.bytes) > s.bounds.MaxBytes {
+		if _, _, ok := s.docs.RemoveOldest(); !ok {
+			break
+		}
+	}
+}
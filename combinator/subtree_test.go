@@ -0,0 +1,205 @@
+package combinator
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/pipeline"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingQueryCache counts the lookups of each query.
+type countingQueryCache struct {
+	QueryCacher
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (c *countingQueryCache) Get(query cqr.CommonQueryRepresentation) (Documents, error) {
+	c.mu.Lock()
+	c.counts[query.(cqr.Keyword).QueryString]++
+	c.mu.Unlock()
+	return c.QueryCacher.Get(query)
+}
+
+func TestSubtreeQueryCache(t *testing.T) {
+	counting := &countingQueryCache{QueryCacher: NewMapQueryCache(), counts: make(map[string]int)}
+	k := make([]cqr.CommonQueryRepresentation, 6)
+	for i, docs := range []Documents{{1, 2}, {3}, {1, 3, 4}, {5}, {1, 3, 5}, {1}} {
+		k[i] = cqr.NewKeyword(string('a'+rune(i)), "title")
+		if err := counting.Set(k[i], docs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	or := func(children ...cqr.CommonQueryRepresentation) cqr.CommonQueryRepresentation {
+		return cqr.NewBooleanQuery(cqr.OR, children)
+	}
+	and := func(children ...cqr.CommonQueryRepresentation) cqr.CommonQueryRepresentation {
+		return cqr.NewBooleanQuery(cqr.AND, children)
+	}
+	cache := NewSubtreeQueryCache(counting, 0)
+	documents := func(q cqr.CommonQueryRepresentation) Documents {
+		tree, _, err := NewLogicalTree(pipeline.NewQuery("1", "1", q), nil, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tree.Documents(cache)
+	}
+
+	if docs := documents(and(or(k[0], k[1]), or(k[2], k[3]), k[4])); !reflect.DeepEqual(docs, Documents{1, 3}) {
+		t.Errorf("unexpected documents %v", docs)
+	}
+
+	// Only the changed clause (f) and its siblings on the path to the root (c and e) are looked up.
+	counting.counts = make(map[string]int)
+	if docs := documents(and(or(k[0], k[1]), or(k[2], k[5]), k[4])); !reflect.DeepEqual(docs, Documents{1, 3}) {
+		t.Errorf("unexpected documents %v", docs)
+	}
+	for _, s := range []string{"a", "b", "d"} {
+		if counting.counts[s] != 0 {
+			t.Errorf("expected the memoised clause %s not to be looked up, got %d lookups", s, counting.counts[s])
+		}
+	}
+	if counting.counts["f"] == 0 {
+		t.Error("expected the changed clause to be looked up")
+	}
+
+	// The same query is entirely memoised.
+	counting.counts = make(map[string]int)
+	if docs := documents(and(or(k[0], k[1]), or(k[2], k[5]), k[4])); !reflect.DeepEqual(docs, Documents{1, 3}) {
+		t.Errorf("unexpected documents %v", docs)
+	}
+	for s, n := range counting.counts {
+		if s != "e" && n != 0 {
+			t.Errorf("expected only the keyword of the root to be looked up, got %v", counting.counts)
+		}
+	}
+
+	// An equivalent query reuses the memoised documents, but its tree has its own clauses.
+	for _, q := range []cqr.CommonQueryRepresentation{
+		and(k[4], or(k[3], k[2]), or(k[1], k[0])),
+		and(or(k[0], k[0], k[1]), or(k[2], k[3]), k[4]),
+	} {
+		tree, _, err := NewLogicalTree(pipeline.NewQuery("1", "1", q), nil, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if docs := tree.Documents(cache); !reflect.DeepEqual(docs, Documents{1, 3}) {
+			t.Errorf("unexpected documents %v", docs)
+		}
+		if key := exactKey(tree.ToCQR()); key != exactKey(q) {
+			t.Errorf("expected the tree of %s, got %s", exactKey(q), key)
+		}
+	}
+	// Equivalent queries (see CanonicalHash) share their memoised documents.
+	if n := cache.(SubtreeQueryCache).Len(); n != 5 {
+		t.Errorf("expected 5 memoised subtrees, got %d", n)
+	}
+}
+
+func TestSubtreeQueryCacheOrder(t *testing.T) {
+	a, b := cqr.NewKeyword("a", "title"), cqr.NewKeyword("b", "title")
+	cache := NewSubtreeQueryCache(nil, 0)
+	if err := cache.Set(a, Documents{1, 2, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Set(b, Documents{3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	// The children of `not` (and adjacency) queries are not reordered, so the documents of one order are not those of
+	// the other.
+	for _, e := range []struct {
+		query cqr.CommonQueryRepresentation
+		docs  Documents
+	}{
+		{cqr.NewBooleanQuery(cqr.NOT, []cqr.CommonQueryRepresentation{a, b}), Documents{1, 2}},
+		{cqr.NewBooleanQuery(cqr.NOT, []cqr.CommonQueryRepresentation{b, a}), Documents{3}},
+	} {
+		tree, _, err := NewLogicalTree(pipeline.NewQuery("1", "1", e.query), nil, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if docs := tree.Documents(cache); !reflect.DeepEqual(docs, e.docs) {
+			t.Errorf("%s: expected %v, got %v", exactKey(e.query), e.docs, docs)
+		}
+	}
+}
+
+func TestSubtreeQueryCacheBounds(t *testing.T) {
+	k := cqr.NewKeyword("a", "title")
+	q := cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{k, cqr.NewKeyword("b", "title")})
+	cache := NewSubtreeQueryCache(NewMemoryQueryCache(CacheTTL(20*time.Millisecond), CacheMaxEntries(1)), 0)
+	if err := cache.Set(k, Documents{1}); err != nil {
+		t.Fatal(err)
+	}
+	memo := cache.(SubtreeQueryCache)
+	memo.setNode(exactKey(q), NewAtom(k))
+	memo.setBitmap(CanonicalHash(q), Documents{1}.Bitmap())
+	memo.setBitmap(CanonicalHash(k), Documents{1}.Bitmap())
+
+	// The memo is bounded by the entries of the cache.
+	if memo.Len() != 1 {
+		t.Errorf("expected 1 memoised subtree, got %d", memo.Len())
+	}
+	if _, ok := memo.bitmap(CanonicalHash(k)); !ok {
+		t.Error("expected the documents to be memoised")
+	}
+
+	// Memoised subtrees expire with the queries of the cache.
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := memo.node(exactKey(q)); ok {
+		t.Error("expected the node to expire")
+	}
+	if _, ok := memo.bitmap(CanonicalHash(k)); ok {
+		t.Error("expected the documents to expire")
+	}
+
+	// Documents larger than the cache are not memoised.
+	memo = NewSubtreeQueryCache(NewMemoryQueryCache(CacheMaxBytes(int64(Documents{1}.Bitmap().Size()))), 0).(SubtreeQueryCache)
+	memo.setBitmap(CanonicalHash(k), Documents{1, 2, 3, 4, 5, 6, 7, 8}.Bitmap())
+	if memo.Len() != 0 {
+		t.Errorf("expected the documents not to be memoised, got %d subtrees", memo.Len())
+	}
+}
+
+// BenchmarkLogicalTree_Variation evaluates variations of a large query that each change one clause.
+func BenchmarkLogicalTree_Variation(b *testing.B) {
+	sets := benchmarkSets(1000, 20000)
+	keywords := make([]cqr.CommonQueryRepresentation, len(sets))
+	for _, memoise := range []bool{false, true} {
+		var cache QueryCacher = NewMapQueryCache()
+		for i, docs := range sets {
+			keywords[i] = cqr.NewKeyword(string(rune('a'+i%26))+string(rune('a'+i/26)), "title")
+			if err := cache.Set(keywords[i], docs); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if memoise {
+			cache = NewSubtreeQueryCache(cache, 0)
+		}
+		variation := func(n int) cqr.CommonQueryRepresentation {
+			clauses := make([]cqr.CommonQueryRepresentation, 0, 100)
+			for i := 0; i < 100; i++ {
+				children := append([]cqr.CommonQueryRepresentation{}, keywords[i*10:i*10+9]...)
+				// The last keyword of one clause changes in each variation.
+				if i == n%100 {
+					children = append(children, keywords[(i*10+9+n)%len(keywords)])
+				} else {
+					children = append(children, keywords[i*10+9])
+				}
+				clauses = append(clauses, cqr.NewBooleanQuery(cqr.OR, children))
+			}
+			return cqr.NewBooleanQuery(cqr.OR, clauses)
+		}
+		b.Run(map[bool]string{false: "map", true: "subtree"}[memoise], func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tree, _, err := NewLogicalTree(pipeline.NewQuery("1", "1", variation(i)), nil, cache)
+				if err != nil {
+					b.Fatal(err)
+				}
+				tree.Documents(cache)
+			}
+		})
+	}
+}
@@ -0,0 +1,494 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/hscells/groove"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/checkpoint"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/eval"
+	"github.com/hscells/groove/formulation"
+	"github.com/hscells/groove/output"
+	"github.com/hscells/groove/preprocess"
+	"github.com/hscells/groove/query"
+	"github.com/hscells/groove/registry"
+	"github.com/hscells/groove/stats"
+	"github.com/hscells/meshexp"
+	"github.com/hscells/metawrap"
+	"github.com/hscells/trecresults"
+	"github.com/olivere/elastic/v7"
+	"github.com/peterbourgon/diskv"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Pipeline validates the experiment and builds the pipeline it describes. The statistics source is created (and, for
+// entrez, contacted) only once the experiment is known to be valid.
+func (e Experiment) Pipeline() (groove.Pipeline, error) {
+	if err := e.Validate(); err != nil {
+		return groove.Pipeline{}, err
+	}
+
+	qs := queriesSources[e.Queries.Format]
+	if e.Queries.Format == "keyword" {
+		qs = query.NewKeywordQuerySource(e.Queries.Fields...)
+	}
+
+	source, err := e.statisticsSource()
+	if err != nil {
+		return groove.Pipeline{}, fmt.Errorf("statistics: %w", err)
+	}
+	ss := source
+	if c := e.Statistics.Cache; c != nil {
+		cached, err := stats.NewCachingStatisticsSource(source, stats.CacheDirectory(c.Dir), stats.CacheNamespace(c.Namespace))
+		if err != nil {
+			return groove.Pipeline{}, fmt.Errorf("statistics.cache: %w", err)
+		}
+		if len(c.Invalidate) > 0 {
+			if err := cached.Invalidate(c.Invalidate...); err != nil {
+				return groove.Pipeline{}, fmt.Errorf("statistics.cache.invalidate: %w", err)
+			}
+		}
+		ss = cached
+	}
+
+	// The collection size is only requested from the statistics source when a component needs it.
+	var n float64
+	bindings := []registry.Binding{
+		registry.BindFunc("N", func() (interface{}, error) {
+			var err error
+			if n == 0 {
+				n, err = ss.CollectionSize()
+			}
+			return n, err
+		}),
+		registry.Bind("pubdates", e.PubDatesFile),
+	}
+
+	var (
+		preprocessors         []preprocess.QueryProcessor
+		transformations       preprocess.QueryTransformations
+		measurements          []analysis.Measurement
+		evaluations           []eval.Evaluator
+		measurementFormatters []output.MeasurementFormatter
+	)
+	for _, name := range e.Preprocess {
+		p, err := registry.QueryProcessor(name, bindings...)
+		if err != nil {
+			return groove.Pipeline{}, err
+		}
+		preprocessors = append(preprocessors, p)
+	}
+	for _, name := range e.Transformations {
+		if registry.Check(registry.BooleanTransformations, name) == nil {
+			t, err := registry.BooleanTransformation(name, bindings...)
+			if err != nil {
+				return groove.Pipeline{}, err
+			}
+			transformations.BooleanTransformations = append(transformations.BooleanTransformations, t)
+		} else {
+			t, err := registry.ElasticsearchTransformation(name, bindings...)
+			if err != nil {
+				return groove.Pipeline{}, err
+			}
+			transformations.ElasticsearchTransformations = append(transformations.ElasticsearchTransformations, t)
+		}
+	}
+	for _, name := range e.Measurements {
+		m, err := registry.Measurement(name, bindings...)
+		if err != nil {
+			return groove.Pipeline{}, err
+		}
+		measurements = append(measurements, m)
+	}
+	for _, name := range e.Evaluations {
+		ev, err := registry.Evaluator(name, bindings...)
+		if err != nil {
+			return groove.Pipeline{}, err
+		}
+		evaluations = append(evaluations, ev)
+	}
+	for _, name := range e.Output.Measurements {
+		f, err := registry.MeasurementFormatter(name, bindings...)
+		if err != nil {
+			return groove.Pipeline{}, err
+		}
+		measurementFormatters = append(measurementFormatters, f)
+	}
+
+	components := []func() interface{}{
+		groove.Preprocess(preprocessors...),
+		groove.Measurement(measurements...),
+		groove.Evaluation(evaluations...),
+		groove.MeasurementOutput(measurementFormatters...),
+		groove.MeasurementWorkers(e.MeasurementWorkers),
+		groove.CLF(e.CLF),
+		groove.SelectTopics(e.selection()),
+		func() interface{} {
+			return transformations
+		},
+	}
+
+	switch e.Statistics.Source {
+	case "index":
+		inputs := append([]string{}, e.Statistics.Documents...)
+		if len(e.Statistics.MeSHTree) > 0 {
+			inputs = append(inputs, e.Statistics.MeSHTree)
+		}
+		components = append(components, groove.Inputs(inputs...))
+	case "replay":
+		components = append(components, groove.Inputs(e.Statistics.Fixture))
+	}
+
+	if len(e.Output.Evaluations.Qrels) > 0 {
+		qrels, err := readQrels(e.Output.Evaluations.Qrels)
+		if err != nil {
+			return groove.Pipeline{}, fmt.Errorf("output.evaluations.qrels: %w", err)
+		}
+		var formatters []output.EvaluationFormatter
+		for _, name := range e.Output.Evaluations.Formatters {
+			f, err := registry.EvaluationFormatter(name, bindings...)
+			if err != nil {
+				return groove.Pipeline{}, err
+			}
+			formatters = append(formatters, f)
+		}
+		components = append(components, func() interface{} {
+			return groove.EvaluationOutputFormat{
+				EvaluationQrels:      qrels,
+				EvaluationFormatters: formatters,
+				QrelsFile:            e.Output.Evaluations.Qrels,
+			}
+		})
+	}
+
+	if len(e.Output.Trec) > 0 {
+		components = append(components, groove.TrecOutput(e.Output.Trec))
+	}
+
+	if s := e.Schedule; s != nil {
+		scheduler := schedules[s.Order]
+		if s.Order == "random" {
+			scheduler = groove.Random(s.Seed)
+		}
+		components = append(components, groove.ScheduleTopics(scheduler))
+	}
+
+	if e.Cache != nil {
+		components = append(components, groove.QueryCache(e.queryCache()))
+	}
+
+	if e.Formulator != nil {
+		f, err := e.formulator(source.(stats.EntrezStatisticsSource), bindings)
+		if err != nil {
+			return groove.Pipeline{}, fmt.Errorf("formulator: %w", err)
+		}
+		components = append(components, groove.QueryFormulator(f))
+		if len(e.Formulator.Qrels) > 0 {
+			components = append(components, groove.Inputs(e.Formulator.Qrels))
+		}
+	}
+
+	if e.Failure != nil {
+		// The backoff has already been validated.
+		backoff, _ := time.ParseDuration(e.Failure.Backoff)
+		components = append(components, groove.OnFailure(groove.FailurePolicy{
+			Mode:    failureModes[e.Failure.Mode],
+			Retries: e.Failure.Retries,
+			Backoff: backoff,
+		}))
+	}
+
+	if c := e.Checkpoint; c != nil {
+		journal, err := checkpoint.OpenJournal(c.Dir, c.Run)
+		if err != nil {
+			return groove.Pipeline{}, fmt.Errorf("checkpoint: %w", err)
+		}
+		components = append(components, groove.Checkpoint(journal), groove.Recompute(c.Recompute...))
+	}
+
+	if len(e.Manifest) > 0 {
+		components = append(components, groove.WriteManifest(e.Manifest))
+	}
+
+	p := groove.NewGroovePipeline(qs, ss, components...)
+	p.QueryPath = e.QueryPath
+	p.PubDatesFile = e.PubDatesFile
+
+	// The API key is not recorded in manifests, as they are often shared along with the results.
+	recorded := e
+	recorded.Statistics.Key = ""
+	p.Configuration, err = json.Marshal(recorded)
+	if err != nil {
+		return groove.Pipeline{}, err
+	}
+	return p, nil
+}
+
+func (e Experiment) statisticsSource() (stats.StatisticsSource, error) {
+	s := e.Statistics
+	options := stats.SearchOptions{
+		Size:    s.Search.Size,
+		RunName: s.Search.RunName,
+	}
+
+	switch s.Source {
+	case "entrez":
+		opts := []func(*stats.EntrezStatisticsSource){
+			stats.EntrezTool(s.Tool),
+			stats.EntrezEmail(s.Email),
+			stats.EntrezOptions(options),
+			stats.EntrezRank(s.Rank),
+		}
+		if len(s.Key) > 0 {
+			opts = append(opts, stats.EntrezAPIKey(s.Key))
+		}
+		if len(s.DB) > 0 {
+			opts = append(opts, stats.EntrezDb(s.DB))
+		}
+		if s.Concurrency > 0 {
+			opts = append(opts, stats.EntrezConcurrency(s.Concurrency))
+		}
+		if s.Retry != nil {
+			opts = append(opts, stats.EntrezRetryPolicy(s.Retry.policy()))
+		}
+		return stats.NewEntrezStatisticsSource(opts...)
+	case "elasticsearch":
+		opts := []func(*stats.ElasticsearchStatisticsSource){
+			stats.ElasticsearchHosts(s.Hosts...),
+			stats.ElasticsearchIndex(s.Index),
+			stats.ElasticsearchSearchOptions(options),
+			stats.ElasticsearchScroll(s.Scroll),
+		}
+		if len(s.DocumentType) > 0 {
+			opts = append(opts, stats.ElasticsearchDocumentType(s.DocumentType))
+		}
+		if len(s.Analyser) > 0 {
+			opts = append(opts, stats.ElasticsearchAnalyser(s.Analyser))
+		}
+		if len(s.AnalysedField) > 0 {
+			opts = append(opts, stats.ElasticsearchAnalysedField(s.AnalysedField))
+		}
+		if s.Concurrency > 0 {
+			opts = append(opts, stats.ElasticsearchConcurrency(s.Concurrency))
+		}
+		if s.Parameters != nil {
+			opts = append(opts, stats.ElasticsearchParameters(s.Parameters))
+		}
+		return stats.NewElasticsearchStatisticsSource(opts...)
+	case "elasticsearch7":
+		opts := []func(*stats.Elasticsearch7StatisticsSource){
+			stats.Elasticsearch7Hosts(s.Hosts...),
+			stats.Elasticsearch7Index(s.Index),
+			stats.Elasticsearch7SearchOptions(options),
+			stats.Elasticsearch7Scroll(s.Scroll),
+		}
+		if len(s.Analyser) > 0 {
+			opts = append(opts, stats.Elasticsearch7Analyser(s.Analyser))
+		}
+		if len(s.AnalysedField) > 0 {
+			opts = append(opts, stats.Elasticsearch7AnalysedField(s.AnalysedField))
+		}
+		if s.Concurrency > 0 {
+			opts = append(opts, stats.Elasticsearch7Concurrency(s.Concurrency))
+		}
+		if s.Parameters != nil {
+			opts = append(opts, stats.Elasticsearch7Parameters(s.Parameters))
+		}
+		return stats.NewElasticsearch7StatisticsSource(opts...)
+	case "solr":
+		opts := []func(*stats.SolrStatisticsSource){
+			stats.SolrCore(s.Core),
+			stats.SolrSearchOptions(options),
+		}
+		if len(s.Hosts) > 0 {
+			opts = append(opts, stats.SolrURL(s.Hosts[0]))
+		}
+		if len(s.Field) > 0 {
+			opts = append(opts, stats.SolrField(s.Field))
+		}
+		if len(s.Analyser) > 0 {
+			opts = append(opts, stats.SolrAnalyser(s.Analyser))
+		}
+		if len(s.AnalysedField) > 0 {
+			opts = append(opts, stats.SolrAnalysedField(s.AnalysedField))
+		}
+		if s.Concurrency > 0 {
+			opts = append(opts, stats.SolrConcurrency(s.Concurrency))
+		}
+		if s.Parameters != nil {
+			opts = append(opts, stats.SolrParameters(s.Parameters))
+		}
+		return stats.NewSolrStatisticsSource(opts...)
+	case "index":
+		docs, err := stats.ReadMedlineFiles(s.Documents...)
+		if err != nil {
+			return nil, err
+		}
+		opts := []func(*stats.IndexStatisticsSource){
+			stats.IndexSearchOptions(options),
+		}
+		if len(s.MeSHTree) > 0 {
+			tree, err := meshexp.New(s.MeSHTree)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, stats.IndexMeSHTree(tree))
+		}
+		if s.Parameters != nil {
+			opts = append(opts, stats.IndexParameters(s.Parameters))
+		}
+		return stats.NewIndexStatisticsSource(docs, opts...), nil
+	case "replay":
+		f, err := stats.ReadFixture(s.Fixture)
+		if err != nil {
+			return nil, err
+		}
+		return stats.NewReplayStatisticsSource(f), nil
+	case "snapshot":
+		snapshot, err := stats.ReadSnapshot(s.Snapshot)
+		if err != nil {
+			return nil, err
+		}
+		return stats.NewSnapshotStatisticsSource(snapshot), nil
+	}
+	return nil, fmt.Errorf("unknown statistics source %q", s.Source)
+}
+
+func (e Experiment) queryCache() combinator.QueryCacher {
+	bounds := []func(*combinator.CacheBounds){
+		combinator.CacheMaxEntries(e.Cache.MaxEntries),
+		combinator.CacheMaxBytes(e.Cache.MaxBytes),
+	}
+	if ttl, err := time.ParseDuration(e.Cache.TTL); err == nil {
+		bounds = append(bounds, combinator.CacheTTL(ttl))
+	}
+
+	var cache combinator.QueryCacher
+	switch e.Cache.Type {
+	case "file":
+		cache = combinator.NewFileQueryCache(e.Cache.Path, bounds...)
+	case "diskv":
+		cache = combinator.NewDiskvQueryCache(diskv.New(diskv.Options{
+			BasePath:     e.Cache.Path,
+			Transform:    combinator.BlockTransform(8),
+			CacheSizeMax: 4096 * 1024,
+			Compression:  diskv.NewGzipCompression(),
+		}), bounds...)
+	default:
+		if e.Cache.MaxEntries == 0 && e.Cache.MaxBytes == 0 && len(e.Cache.TTL) == 0 {
+			return combinator.NewMapQueryCache()
+		}
+		return combinator.NewMemoryQueryCache(bounds...)
+	}
+	if e.Cache.MemoryBytes > 0 {
+		memory := combinator.NewMemoryQueryCache(combinator.CacheMaxBytes(e.Cache.MemoryBytes))
+		return combinator.NewTieredQueryCache(memory, cache)
+	}
+	return cache
+}
+
+func (e Experiment) formulator(ss stats.EntrezStatisticsSource, bindings []registry.Binding) (formulation.Formulator, error) {
+	f := e.Formulator
+	switch f.Method {
+	case "objective":
+		qrels, err := readQrels(f.Qrels)
+		if err != nil {
+			return nil, err
+		}
+		optimisation, err := registry.Evaluator(f.Optimisation, bindings...)
+		if err != nil {
+			return nil, err
+		}
+		client, err := elastic.NewClient(elastic.SetURL(f.Hosts...), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+		if err != nil {
+			return nil, err
+		}
+		var options []formulation.ObjectiveOption
+		if f.MinDocs > 0 {
+			options = append(options, formulation.ObjectiveMinDocs(f.MinDocs))
+		}
+		if f.Seed != 0 {
+			options = append(options, formulation.ObjectiveSeed(f.Seed))
+		}
+		return formulation.NewObjectiveFormulator(ss, client, qrels, formulation.NewPubMedSet(ss), f.Folder, e.PubDatesFile, f.SemTypes, f.MetaMapURL, optimisation, options...), nil
+	case "conceptual":
+		client := metawrap.HTTPClient{URL: f.MetaMapURL}
+		return formulation.NewConceptualFormulator(
+			formulation.NewNLPLogicComposer(f.ClassPath),
+			formulation.NewMetaMapEntityExtractor(client),
+			nil,
+			formulation.NewMetaMapKeywordMapper(client, formulation.Matched()),
+			f.FeedbackDocs,
+			ss,
+		), nil
+	}
+	return nil, fmt.Errorf("unknown formulation method %q", f.Method)
+}
+
+func readQrels(file string) (trecresults.QrelsFile, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return trecresults.QrelsFile{}, err
+	}
+	return trecresults.QrelsFromReader(bytes.NewReader(b))
+}
+
+// Sinks creates the sinks that the results of the experiment are written to. The sinks are closed by executing the
+// pipeline with them.
+func (e Experiment) Sinks() ([]output.ResultSink, error) {
+	var sinks []output.ResultSink
+	fail := func(field string, err error) ([]output.ResultSink, error) {
+		for _, s := range sinks {
+			s.Close()
+		}
+		return nil, fmt.Errorf("%s: %w", field, err)
+	}
+
+	if len(e.Output.Trec) > 0 {
+		sinks = append(sinks, output.NewTrecSink(e.Output.Trec))
+	}
+	if len(e.Output.Directory) > 0 {
+		s, err := output.NewDirectorySink(e.Output.Directory)
+		if err != nil {
+			return fail("output.directory", err)
+		}
+		sinks = append(sinks, s)
+	}
+	if len(e.Output.JSONLines) > 0 {
+		f, err := os.Create(e.Output.JSONLines)
+		if err != nil {
+			return fail("output.jsonl", err)
+		}
+		sinks = append(sinks, output.Closing(output.NewJSONLinesSink(f), f))
+	}
+	if len(e.Output.CSV) > 0 {
+		f, err := os.Create(e.Output.CSV)
+		if err != nil {
+			return fail("output.csv", err)
+		}
+		sinks = append(sinks, output.Closing(output.NewCSVMeasurementSink(f), f))
+	}
+	return sinks, nil
+}
+
+// policy is the retry policy that is configured. The durations must already be validated.
+func (r Retry) policy() stats.RetryPolicy {
+	p := stats.DefaultRetryPolicy
+	if r.MaxAttempts > 0 {
+		p.MaxAttempts = r.MaxAttempts
+	}
+	if len(r.Backoff) > 0 {
+		p.Backoff, _ = time.ParseDuration(r.Backoff)
+	}
+	if len(r.MaxBackoff) > 0 {
+		p.MaxBackoff, _ = time.ParseDuration(r.MaxBackoff)
+	}
+	if r.Jitter != nil {
+		p.Jitter = *r.Jitter
+	}
+	return p
+}
@@ -0,0 +1,617 @@
+// Package config builds groove pipelines from declarative experiment files written in JSON or YAML.
+//
+// An experiment file names the components of a pipeline rather than constructing them, for example:
+//
+//	query_path: queries/
+//	queries:
+//	  format: medline
+//	statistics:
+//	  source: entrez
+//	  email: someone@example.com
+//	  tool: groove
+//	topics:
+//	  exclude: [CD0089*]
+//	  shard: 0
+//	  shards: 4
+//	measurements: [AvgIDF, SumIDF, ClarityScore]
+//	measurement_workers: 8
+//	output:
+//	  measurements: [json]
+//
+// Preprocessors, transformations, measurements, evaluations and formatters are named as they are in the registry
+// package (e.g. strip_numbers, relax_phrases, AvgIDF, mle(f1), ndcg@10). The legacy evaluators wss, wss_res and wss_mle
+// use the size of the collection reported by the statistics source, and the date_restrictions transformation uses the
+// pubdates_file of the experiment.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/hscells/groove"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/query"
+	"github.com/hscells/groove/rank"
+	"github.com/hscells/groove/registry"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Format is the encoding of an experiment file.
+type Format uint8
+
+const (
+	// JSON experiment files.
+	JSON Format = iota
+	// YAML experiment files.
+	YAML
+)
+
+// Experiment is the declarative description of a pipeline.
+type Experiment struct {
+	QueryPath          string          `json:"query_path"`
+	PubDatesFile       string          `json:"pubdates_file"`
+	Queries            Queries         `json:"queries"`
+	Topics             Topics          `json:"topics"`
+	Schedule           *Schedule       `json:"schedule"`
+	Statistics         Statistics      `json:"statistics"`
+	Preprocess         []string        `json:"preprocess"`
+	Transformations    []string        `json:"transformations"`
+	Measurements       []string        `json:"measurements"`
+	MeasurementWorkers int             `json:"measurement_workers"`
+	Evaluations        []string        `json:"evaluations"`
+	Output             Output          `json:"output"`
+	Cache              *Cache          `json:"cache"`
+	CLF                rank.CLFOptions `json:"clf"`
+	Formulator         *Formulator     `json:"formulator"`
+	Failure            *Failure        `json:"failure"`
+	Checkpoint         *Checkpoint     `json:"checkpoint"`
+	// Manifest is the file the manifest of the run is written to.
+	Manifest string `json:"manifest"`
+}
+
+// Queries configures the source that loads queries from the query path.
+type Queries struct {
+	// Format is one of medline, pubmed, cqr, keyword, protocol or tar2.
+	Format string `json:"format"`
+	// Fields are the fields searched by keyword queries.
+	Fields []string `json:"fields"`
+}
+
+// Topics selects the topics of the experiment that are processed.
+type Topics struct {
+	// Include and Exclude list topic IDs or glob patterns such as CD0*.
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+	// Shard is the shard of the topics processed by this run, of Shards shards (e.g. shard 0 of 4).
+	Shard  int `json:"shard"`
+	Shards int `json:"shards"`
+}
+
+// Schedule configures the order the topics are processed in.
+type Schedule struct {
+	// Order is one of complexity_ascending, complexity_descending, cost, file or random.
+	Order string `json:"order"`
+	// Seed is the seed of the random order.
+	Seed int64 `json:"seed"`
+}
+
+// Statistics configures the statistics source.
+type Statistics struct {
+	// Source is one of entrez, elasticsearch, elasticsearch7 (Elasticsearch 7 or later, or OpenSearch), solr, index,
+	// replay or snapshot.
+	Source string `json:"source"`
+
+	Search     Search             `json:"search"`
+	Parameters map[string]float64 `json:"parameters"`
+	// Concurrency is the number of requests that may be made to the source at once.
+	Concurrency int `json:"concurrency"`
+
+	// Entrez options.
+	Tool  string `json:"tool"`
+	Email string `json:"email"`
+	Key   string `json:"api_key"`
+	DB    string `json:"db"`
+	Rank  bool   `json:"rank"`
+	// Retry overrides how requests that fail with a transient error (e.g. rate limiting) are retried.
+	Retry *Retry `json:"retry"`
+
+	// Elasticsearch options. Indices of elasticsearch7 do not have a document type.
+	Hosts         []string `json:"hosts"`
+	Index         string   `json:"index"`
+	DocumentType  string   `json:"document_type"`
+	Analyser      string   `json:"analyser"`
+	AnalysedField string   `json:"analysed_field"`
+	Scroll        bool     `json:"scroll"`
+
+	// Solr options. The URL of Solr is the first of the hosts, and Field is the field keywords without fields are
+	// searched in. The analyser and analysed field options also apply to Solr.
+	Core  string `json:"core"`
+	Field string `json:"field"`
+
+	// Index options. Documents are MEDLINE (NBIB) or PubMed XML files, which are indexed in memory. MeSHTree is a MeSH
+	// tree file (see meshexp) that exploded MeSH headings are expanded with, instead of the default tree.
+	Documents []string `json:"documents"`
+	MeSHTree  string   `json:"mesh_tree"`
+
+	// Replay options. Fixture is a file of requests recorded from another statistics source (see
+	// stats.RecordingStatisticsSource), which are answered without it.
+	Fixture string `json:"fixture"`
+
+	// Snapshot options. Snapshot is a file of collection statistics captured from another statistics source (see
+	// stats.Snapshot, and cmd/snapshot), which are served without it.
+	Snapshot string `json:"snapshot"`
+
+	// Cache caches the results of requests made to the statistics source on disk.
+	Cache *StatisticsCache `json:"cache"`
+}
+
+// StatisticsCache configures the caching of the requests made to the statistics source (see
+// stats.CachingStatisticsSource). Components that search the statistics source directly (formulation, CLF and
+// Elasticsearch transformations) are not cached.
+type StatisticsCache struct {
+	// Dir is the directory results are cached in. By default, the user cache directory is used.
+	Dir string `json:"dir"`
+	// Namespace overrides the namespace derived from the statistics source.
+	Namespace string `json:"namespace"`
+	// Invalidate lists the methods (e.g. DocumentFrequency) whose cached results are removed before the run.
+	Invalidate []string `json:"invalidate"`
+}
+
+// Retry configures the retry policy of the statistics source (see stats.RetryPolicy).
+type Retry struct {
+	MaxAttempts int `json:"max_attempts"`
+	// Backoff and MaxBackoff are durations such as 500ms or 2s.
+	Backoff    string `json:"backoff"`
+	MaxBackoff string `json:"max_backoff"`
+	// Jitter overrides the jitter of the default policy when it is set, even to 0.
+	Jitter *float64 `json:"jitter"`
+}
+
+// Search configures the search options of the statistics source.
+type Search struct {
+	Size    int    `json:"size"`
+	RunName string `json:"run_name"`
+}
+
+// Output configures the formatting of measurements and evaluations, and where results are written.
+type Output struct {
+	Measurements []string          `json:"measurements"`
+	Evaluations  EvaluationsOutput `json:"evaluations"`
+	// Trec is the run file TREC results are appended to.
+	Trec string `json:"trec"`
+	// Directory is a directory that every result of the run is written to (see output.NewDirectorySink).
+	Directory string `json:"directory"`
+	// JSONLines is a file that every result is written to as a line of JSON.
+	JSONLines string `json:"jsonl"`
+	// CSV is a file that the measurements are written to as a CSV table.
+	CSV string `json:"csv"`
+}
+
+// EvaluationsOutput configures how evaluations are formatted and the qrels they are evaluated against.
+type EvaluationsOutput struct {
+	Qrels      string   `json:"qrels"`
+	Formatters []string `json:"formatters"`
+}
+
+// Cache configures the query cache.
+type Cache struct {
+	// Type is one of memory, file or diskv.
+	Type string `json:"type"`
+	// Path is the directory of file and diskv caches.
+	Path string `json:"path"`
+	// MaxEntries and MaxBytes bound the cache, evicting the least recently used queries. TTL (e.g. 720h) expires
+	// queries cached for longer, for statistics sources that change. By default, the cache is unbounded: a memory
+	// cache without bounds keeps the documents of every query until the run ends, and file and diskv caches keep
+	// every file.
+	MaxEntries int    `json:"max_entries"`
+	MaxBytes   int64  `json:"max_bytes"`
+	TTL        string `json:"ttl"`
+	// MemoryBytes adds a bounded memory cache over a file or diskv cache.
+	MemoryBytes int64 `json:"memory_bytes"`
+}
+
+// Formulator configures automatic query formulation.
+type Formulator struct {
+	// Method is either objective or conceptual.
+	Method     string `json:"method"`
+	MetaMapURL string `json:"metamap_url"`
+
+	// Objective formulation options.
+	Qrels        string   `json:"qrels"`
+	Folder       string   `json:"folder"`
+	SemTypes     string   `json:"semtypes"`
+	Optimisation string   `json:"optimisation"`
+	Hosts        []string `json:"hosts"`
+	MinDocs      int      `json:"min_docs"`
+	Seed         int      `json:"seed"`
+
+	// Conceptual formulation options.
+	ClassPath    string `json:"classpath"`
+	FeedbackDocs []int  `json:"feedback_docs"`
+}
+
+// Failure configures the failure policy of the pipeline.
+type Failure struct {
+	// Mode is one of fail_fast, skip_topic or retry_topic.
+	Mode    string `json:"mode"`
+	Retries int    `json:"retries"`
+	// Backoff is a duration such as 500ms or 2s.
+	Backoff string `json:"backoff"`
+}
+
+// queriesSources maps the format of queries to the source that loads them. The keyword source is handled separately
+// as it is configured with fields.
+var queriesSources = map[string]query.QueriesSource{
+	"medline":  query.NewTransmuteQuerySource(query.MedlineTransmutePipeline),
+	"pubmed":   query.NewTransmuteQuerySource(query.PubMedTransmutePipeline),
+	"cqr":      query.NewTransmuteQuerySource(query.CQRTransmutePipeline),
+	"protocol": query.NewProtocolQuerySource(),
+	"tar2":     query.TARTask2QueriesSource{},
+	"keyword":  nil,
+}
+
+// Checkpoint configures where the progress of the run is recorded so that it can be resumed.
+type Checkpoint struct {
+	// Dir is the directory the journal of the run is kept in.
+	Dir string `json:"dir"`
+	// Run identifies the run. Runs resume from the journal of an earlier run with the same ID.
+	Run string `json:"run"`
+	// Recompute lists the stages that are computed again, even if they were completed by an earlier run.
+	Recompute []string `json:"recompute"`
+}
+
+// stages are the stages of the pipeline that can be recomputed.
+var stages = map[string]bool{
+	pipeline.MeasurementStage: true,
+	pipeline.CLFStage:         true,
+	pipeline.RetrievalStage:   true,
+	pipeline.FormulationStage: true,
+	pipeline.GenerateStage:    true,
+	pipeline.TrainStage:       true,
+	pipeline.TestStage:        true,
+}
+
+var schedules = map[string]groove.Scheduler{
+	"complexity_ascending":  groove.ComplexityAscending,
+	"complexity_descending": groove.ComplexityDescending,
+	"cost":                  groove.EstimatedCost,
+	"file":                  groove.FileOrder,
+	"random":                nil,
+}
+
+// analysingSources are the statistics sources that can analyse queries (see stats.AnalysingStatisticsSource), which
+// Elasticsearch transformations require.
+var analysingSources = map[string]bool{
+	"elasticsearch":  true,
+	"elasticsearch7": true,
+	"solr":           true,
+}
+
+var failureModes = map[string]groove.FailureMode{
+	"fail_fast":   groove.FailFast,
+	"skip_topic":  groove.SkipTopic,
+	"retry_topic": groove.RetryTopic,
+}
+
+// Errors are the problems found while validating an experiment.
+type Errors []error
+
+func (e Errors) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// Read decodes an experiment in the given format. Unknown keys are reported as errors.
+func Read(r io.Reader, format Format) (Experiment, error) {
+	var e Experiment
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return e, err
+	}
+
+	// YAML is decoded by first converting it to JSON, so both formats share the same keys and decoding rules.
+	if format == YAML {
+		var v interface{}
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return e, err
+		}
+		b, err = json.Marshal(jsonable(v))
+		if err != nil {
+			return e, err
+		}
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	err = d.Decode(&e)
+	return e, err
+}
+
+// ReadFile reads an experiment from a file. Files ending in .yml or .yaml are read as YAML, all others as JSON.
+func ReadFile(file string) (Experiment, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return Experiment{}, err
+	}
+	format := JSON
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yml", ".yaml":
+		format = YAML
+	}
+	e, err := Read(bytes.NewReader(b), format)
+	if err != nil {
+		return e, fmt.Errorf("%s: %w", file, err)
+	}
+	return e, nil
+}
+
+// Load reads an experiment file and builds the pipeline it describes.
+func Load(file string) (groove.Pipeline, error) {
+	e, err := ReadFile(file)
+	if err != nil {
+		return groove.Pipeline{}, err
+	}
+	return e.Pipeline()
+}
+
+// jsonable converts the maps produced by the YAML decoder, which are keyed by interface{}, into maps keyed by
+// string so that they can be encoded as JSON.
+func jsonable(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, val := range x {
+			m[fmt.Sprint(k)] = jsonable(val)
+		}
+		return m
+	case []interface{}:
+		for i, val := range x {
+			x[i] = jsonable(val)
+		}
+		return x
+	}
+	return v
+}
+
+// selection is the selection of topics of the experiment.
+func (e Experiment) selection() groove.TopicSelection {
+	return groove.TopicSelection{
+		Include: e.Topics.Include,
+		Exclude: e.Topics.Exclude,
+		Shard:   e.Topics.Shard,
+		Shards:  e.Topics.Shards,
+	}
+}
+
+// Validate checks that every component named in the experiment exists and that the fields each component requires
+// are present. All problems are reported together.
+func (e Experiment) Validate() error {
+	var errs Errors
+	add := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	if len(e.Queries.Format) == 0 {
+		add("queries.format: required")
+	} else if _, ok := queriesSources[e.Queries.Format]; !ok {
+		add("queries.format: unknown query format %q", e.Queries.Format)
+	} else if e.Queries.Format == "keyword" && len(e.Queries.Fields) == 0 {
+		add("queries.fields: required for keyword queries")
+	}
+
+	if err := e.selection().Validate(); err != nil {
+		add("topics: %v", err)
+	}
+	if e.Schedule != nil {
+		if _, ok := schedules[e.Schedule.Order]; !ok {
+			add("schedule.order: unknown order %q", e.Schedule.Order)
+		}
+	}
+
+	switch e.Statistics.Source {
+	case "":
+		add("statistics.source: required")
+	case "entrez":
+		if len(e.Statistics.Email) == 0 {
+			add("statistics.email: required for entrez")
+		}
+		if len(e.Statistics.Tool) == 0 {
+			add("statistics.tool: required for entrez")
+		}
+		if r := e.Statistics.Retry; r != nil {
+			for _, d := range []struct{ key, value string }{{"backoff", r.Backoff}, {"max_backoff", r.MaxBackoff}} {
+				if _, err := time.ParseDuration(d.value); len(d.value) > 0 && err != nil {
+					add("statistics.retry.%s: %v", d.key, err)
+				}
+			}
+			if r.Jitter != nil && (*r.Jitter < 0 || *r.Jitter > 1) {
+				add("statistics.retry.jitter: must be between 0 and 1")
+			}
+		}
+	case "elasticsearch":
+		if len(e.Statistics.Index) == 0 {
+			add("statistics.index: required for elasticsearch")
+		}
+	case "elasticsearch7":
+		if len(e.Statistics.Index) == 0 {
+			add("statistics.index: required for elasticsearch7")
+		}
+		if len(e.Statistics.DocumentType) > 0 {
+			add("statistics.document_type: not supported by elasticsearch7")
+		}
+	case "solr":
+		if len(e.Statistics.Core) == 0 {
+			add("statistics.core: required for solr")
+		}
+		if len(e.Statistics.Hosts) > 1 {
+			add("statistics.hosts: solr uses a single host")
+		}
+	case "index":
+		if len(e.Statistics.Documents) == 0 {
+			add("statistics.documents: required for index")
+		}
+	case "replay":
+		if len(e.Statistics.Fixture) == 0 {
+			add("statistics.fixture: required for replay")
+		}
+	case "snapshot":
+		if len(e.Statistics.Snapshot) == 0 {
+			add("statistics.snapshot: required for snapshot")
+		}
+	default:
+		add("statistics.source: unknown statistics source %q", e.Statistics.Source)
+	}
+	if e.Statistics.Cache != nil && e.CLF.CLF {
+		add("statistics.cache: cannot be used with clf")
+	}
+
+	for i, name := range e.Preprocess {
+		if err := registry.Check(registry.QueryProcessors, name); err != nil {
+			add("preprocess[%d]: %v", i, err)
+		}
+	}
+
+	for i, name := range e.Transformations {
+		if registry.Check(registry.BooleanTransformations, name) == nil {
+			if name == "date_restrictions" && len(e.PubDatesFile) == 0 {
+				add("transformations[%d]: %q requires pubdates_file", i, name)
+			}
+			continue
+		}
+		if registry.Check(registry.ElasticsearchTransformations, name) == nil {
+			if !analysingSources[e.Statistics.Source] {
+				add("transformations[%d]: %q requires an elasticsearch, elasticsearch7 or solr statistics source", i, name)
+			} else if e.Statistics.Cache != nil {
+				add("transformations[%d]: %q cannot be used with statistics.cache", i, name)
+			}
+			continue
+		}
+		add("transformations[%d]: unknown transformation %q", i, name)
+	}
+
+	check := func(field string, kind registry.Kind, names []string) {
+		for i, name := range names {
+			if err := registry.Check(kind, name); err != nil {
+				add("%s[%d]: %v", field, i, err)
+			}
+		}
+	}
+	check("measurements", registry.Measurements, e.Measurements)
+	check("evaluations", registry.Evaluators, e.Evaluations)
+	check("output.measurements", registry.MeasurementFormatters, e.Output.Measurements)
+	check("output.evaluations.formatters", registry.EvaluationFormatters, e.Output.Evaluations.Formatters)
+	if len(e.Output.Evaluations.Formatters) > 0 && len(e.Output.Evaluations.Qrels) == 0 {
+		add("output.evaluations.qrels: required to format evaluations")
+	}
+
+	if e.Cache != nil {
+		switch e.Cache.Type {
+		case "memory":
+		case "file", "diskv":
+			if len(e.Cache.Path) == 0 {
+				add("cache.path: required for %s caches", e.Cache.Type)
+			}
+		case "":
+			add("cache.type: required")
+		default:
+			add("cache.type: unknown cache %q", e.Cache.Type)
+		}
+		if e.Cache.MaxEntries < 0 {
+			add("cache.max_entries: must not be negative")
+		}
+		if e.Cache.MaxBytes < 0 {
+			add("cache.max_bytes: must not be negative")
+		}
+		if _, err := time.ParseDuration(e.Cache.TTL); len(e.Cache.TTL) > 0 && err != nil {
+			add("cache.ttl: %v", err)
+		}
+		if e.Cache.MemoryBytes < 0 {
+			add("cache.memory_bytes: must not be negative")
+		} else if e.Cache.MemoryBytes > 0 && e.Cache.Type == "memory" {
+			add("cache.memory_bytes: requires a file or diskv cache")
+		}
+	}
+
+	if e.CLF.CLF && e.Statistics.Source != "entrez" {
+		add("clf: requires the entrez statistics source")
+	}
+
+	if f := e.Formulator; f != nil {
+		if e.Statistics.Source != "entrez" {
+			add("formulator: requires the entrez statistics source")
+		}
+		if len(f.MetaMapURL) == 0 {
+			add("formulator.metamap_url: required")
+		}
+		switch f.Method {
+		case "objective":
+			for _, field := range []struct{ key, value string }{
+				{"qrels", f.Qrels},
+				{"folder", f.Folder},
+				{"semtypes", f.SemTypes},
+				{"optimisation", f.Optimisation},
+			} {
+				if len(field.value) == 0 {
+					add("formulator.%s: required for objective formulation", field.key)
+				}
+			}
+			if len(f.Optimisation) > 0 {
+				if err := registry.Check(registry.Evaluators, f.Optimisation); err != nil {
+					add("formulator.optimisation: %v", err)
+				}
+			}
+			if len(e.PubDatesFile) == 0 {
+				add("pubdates_file: required for objective formulation")
+			}
+			if len(f.Hosts) == 0 {
+				add("formulator.hosts: required for objective formulation")
+			}
+		case "conceptual":
+			if len(f.ClassPath) == 0 {
+				add("formulator.classpath: required for conceptual formulation")
+			}
+		case "":
+			add("formulator.method: required")
+		default:
+			add("formulator.method: unknown formulation method %q", f.Method)
+		}
+	}
+
+	if f := e.Failure; f != nil {
+		if _, ok := failureModes[f.Mode]; !ok {
+			add("failure.mode: unknown failure mode %q", f.Mode)
+		}
+		if len(f.Backoff) > 0 {
+			if _, err := time.ParseDuration(f.Backoff); err != nil {
+				add("failure.backoff: %v", err)
+			}
+		}
+	}
+
+	if c := e.Checkpoint; c != nil {
+		if len(c.Dir) == 0 {
+			add("checkpoint.dir: required")
+		}
+		if len(c.Run) == 0 {
+			add("checkpoint.run: required")
+		}
+		for i, stage := range c.Recompute {
+			if !stages[stage] {
+				add("checkpoint.recompute[%d]: unknown stage %q", i, stage)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,406 @@
+package config
+
+import (
+	"encoding/json"
+	"github.com/hscells/groove"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/stats"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const experimentJSON = `{
+	"query_path": "queries",
+	"queries": {"format": "medline"},
+	"statistics": {"source": "entrez", "tool": "groove", "email": "groove@example.com", "search": {"size": 100}},
+	"preprocess": ["lowercase"],
+	"transformations": ["simplify", "rct_filter"],
+	"measurements": ["AvgIDF", "WIG"],
+	"evaluations": ["f1", "wss_mle"],
+	"output": {"measurements": ["json", "csv"], "trec": "run.results"},
+	"cache": {"type": "memory"},
+	"clf": {"clf": true, "cutoff": 0.5},
+	"failure": {"mode": "retry_topic", "retries": 2, "backoff": "1s"}
+}`
+
+const experimentYAML = `
+query_path: queries
+queries:
+  format: medline
+statistics:
+  source: entrez
+  tool: groove
+  email: groove@example.com
+  search:
+    size: 100
+preprocess: [lowercase]
+transformations: [simplify, rct_filter]
+measurements: [AvgIDF, WIG]
+evaluations: [f1, wss_mle]
+output:
+  measurements: [json, csv]
+  trec: run.results
+cache:
+  type: memory
+clf:
+  clf: true
+  cutoff: 0.5
+failure:
+  mode: retry_topic
+  retries: 2
+  backoff: 1s
+`
+
+func TestRead(t *testing.T) {
+	j, err := Read(strings.NewReader(experimentJSON), JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	y, err := Read(strings.NewReader(experimentYAML), YAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []Experiment{j, y} {
+		if err := e.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if e.Statistics.Search.Size != 100 || !e.CLF.CLF || e.CLF.Cutoff != 0.5 || e.Failure.Retries != 2 {
+			t.Errorf("experiment decoded incorrectly: %+v", e)
+		}
+		if len(e.Measurements) != 2 || e.Measurements[1] != "WIG" {
+			t.Errorf("expected measurements [AvgIDF WIG], got %v", e.Measurements)
+		}
+	}
+}
+
+func TestReadUnknownKey(t *testing.T) {
+	_, err := Read(strings.NewReader("queries:\n  formt: medline\n"), YAML)
+	if err == nil || !strings.Contains(err.Error(), "formt") {
+		t.Errorf("expected an error for the unknown key, got %v", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	read := func(retry string) Retry {
+		t.Helper()
+		e, err := Read(strings.NewReader("queries:\n  format: medline\nstatistics:\n  source: entrez\n  tool: groove\n  email: groove@example.com\n  retry:\n"+retry), YAML)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		return *e.Statistics.Retry
+	}
+	if p := read("    max_attempts: 3\n").policy(); p.Jitter != stats.DefaultRetryPolicy.Jitter || p.MaxAttempts != 3 {
+		t.Errorf("expected the default jitter and 3 attempts, got %+v", p)
+	}
+	if p := read("    jitter: 0\n").policy(); p.Jitter != 0 {
+		t.Errorf("expected no jitter, got %v", p.Jitter)
+	}
+
+	jitter := 1.5
+	e := Experiment{
+		Queries:    Queries{Format: "medline"},
+		Statistics: Statistics{Source: "entrez", Tool: "groove", Email: "groove@example.com", Retry: &Retry{Jitter: &jitter}},
+	}
+	errs, _ := e.Validate().(Errors)
+	if len(errs) != 1 || errs[0].Error() != "statistics.retry.jitter: must be between 0 and 1" {
+		t.Errorf("expected a statistics.retry.jitter error, got %v", errs)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	e := Experiment{
+		Queries:         Queries{Format: "keyword"},
+		Statistics:      Statistics{Source: "entrez", Email: "groove@example.com"},
+		Transformations: []string{"analyse", "date_restrictions", "nope"},
+		Measurements:    []string{"AvgIDF", "avgidf"},
+		Evaluations:     []string{"f2"},
+		Output:          Output{Evaluations: EvaluationsOutput{Formatters: []string{"json"}}},
+		Cache:           &Cache{Type: "diskv"},
+		Formulator:      &Formulator{Method: "conceptual", MetaMapURL: "http://localhost:8080"},
+		Failure:         &Failure{Mode: "skip_topic", Backoff: "soon"},
+	}
+
+	err := e.Validate()
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("expected Errors, got %v", err)
+	}
+
+	expected := []string{
+		"queries.fields: required for keyword queries",
+		"statistics.tool: required for entrez",
+		`transformations[0]: "analyse" requires an elasticsearch, elasticsearch7 or solr statistics source`,
+		`transformations[1]: "date_restrictions" requires pubdates_file`,
+		`transformations[2]: unknown transformation "nope"`,
+		`measurements[1]: unknown measurement "avgidf"`,
+		`evaluations[0]: unknown evaluator "f2"`,
+		"output.evaluations.qrels: required to format evaluations",
+		"cache.path: required for diskv caches",
+		"formulator.classpath: required for conceptual formulation",
+	}
+	if len(errs) != len(expected)+1 {
+		t.Errorf("expected %d errors, got %d: %v", len(expected)+1, len(errs), errs)
+	}
+	for i, msg := range expected {
+		if i < len(errs) && errs[i].Error() != msg {
+			t.Errorf("error %d: expected %q, got %q", i, msg, errs[i])
+		}
+	}
+	if !strings.HasPrefix(errs[len(errs)-1].Error(), "failure.backoff:") {
+		t.Errorf("expected a failure.backoff error, got %q", errs[len(errs)-1])
+	}
+}
+
+func TestFromManifest(t *testing.T) {
+	e, err := Read(strings.NewReader(experimentJSON), JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := FromManifest(groove.Manifest{Config: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r, e) {
+		t.Errorf("expected %+v, got %+v", e, r)
+	}
+
+	if _, err := FromManifest(groove.Manifest{}); err == nil {
+		t.Error("expected an error for a manifest without an experiment")
+	}
+}
+
+func TestTopics(t *testing.T) {
+	e := Experiment{
+		Queries:    Queries{Format: "medline"},
+		Statistics: Statistics{Source: "elasticsearch", Index: "pubmed"},
+		Topics:     Topics{Include: []string{"CD0[12"}, Shard: 4, Shards: 4},
+		Schedule:   &Schedule{Order: "alphabetical"},
+	}
+	errs, _ := e.Validate().(Errors)
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+	if !strings.HasPrefix(errs[0].Error(), `topics: topic pattern "CD0[12"`) {
+		t.Errorf("expected a topic pattern error, got %q", errs[0])
+	}
+	if errs[1].Error() != `schedule.order: unknown order "alphabetical"` {
+		t.Errorf("expected an unknown order error, got %q", errs[1])
+	}
+
+	e.Schedule.Order = "random"
+	e.Topics = Topics{Include: []string{"CD01*", "CD020"}, Exclude: []string{"CD0101"}, Shards: 3}
+	topics := []string{"CD0100", "CD0101", "CD0102", "CD020", "CD021", "CD0103", "CD0104"}
+	selected := make(map[string]int)
+	for shard := 0; shard < e.Topics.Shards; shard++ {
+		e.Topics.Shard = shard
+		if err := e.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		for _, topic := range topics {
+			if e.selection().Selects(topic) {
+				selected[topic]++
+			}
+		}
+	}
+	for _, topic := range []string{"CD0100", "CD0102", "CD020", "CD0103", "CD0104"} {
+		if selected[topic] != 1 {
+			t.Errorf("expected %s to be selected by exactly one shard, selected by %d", topic, selected[topic])
+		}
+	}
+	if selected["CD0101"] != 0 || selected["CD021"] != 0 {
+		t.Errorf("expected CD0101 and CD021 not to be selected, got %v", selected)
+	}
+}
+
+func TestOfflineStatistics(t *testing.T) {
+	e := Experiment{
+		Queries:    Queries{Format: "medline"},
+		Statistics: Statistics{Source: "index"},
+	}
+	errs, _ := e.Validate().(Errors)
+	if len(errs) != 1 || errs[0].Error() != "statistics.documents: required for index" {
+		t.Fatalf("expected a statistics.documents error, got %v", errs)
+	}
+
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	documents := filepath.Join(dir, "pubmed.nbib")
+	err = ioutil.WriteFile(documents, []byte("PMID- 1\nTI  - Metformin for type 2 diabetes.\n\nPMID- 2\nTI  - Insulin pumps.\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.Statistics.Documents = []string{documents}
+	p, err := e.Pipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := p.StatisticsSource.CollectionSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 documents, got %f", n)
+	}
+	if !reflect.DeepEqual(p.InputFiles, []string{documents}) {
+		t.Errorf("expected the documents to be inputs of the pipeline, got %v", p.InputFiles)
+	}
+
+	fixture := stats.NewFixture()
+	if _, err := stats.NewRecordingStatisticsSource(p.StatisticsSource, fixture).CollectionSize(); err != nil {
+		t.Fatal(err)
+	}
+	e.Statistics = Statistics{Source: "replay", Fixture: filepath.Join(dir, "fixture.json")}
+	if err := fixture.Write(e.Statistics.Fixture); err != nil {
+		t.Fatal(err)
+	}
+	p, err = e.Pipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = p.StatisticsSource.CollectionSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 replayed documents, got %f", n)
+	}
+
+	snapshot := stats.NewSnapshot(p.StatisticsSource)
+	if _, err := stats.NewSnapshotRecorder(p.StatisticsSource, snapshot).CollectionSize(); err != nil {
+		t.Fatal(err)
+	}
+	e.Statistics = Statistics{Source: "snapshot", Snapshot: filepath.Join(dir, "snapshot.json")}
+	if err := snapshot.Write(e.Statistics.Snapshot); err != nil {
+		t.Fatal(err)
+	}
+	p, err = e.Pipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = p.StatisticsSource.CollectionSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 documents in the snapshot, got %f", n)
+	}
+}
+
+func TestStatisticsCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	documents := filepath.Join(dir, "pubmed.nbib")
+	if err := ioutil.WriteFile(documents, []byte("PMID- 1\nTI  - Metformin for type 2 diabetes.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := Experiment{
+		Queries: Queries{Format: "medline"},
+		Statistics: Statistics{
+			Source:    "index",
+			Documents: []string{documents},
+			Cache:     &StatisticsCache{Dir: filepath.Join(dir, "cache"), Invalidate: []string{"CollectionSize"}},
+		},
+	}
+	e.CLF.CLF = true
+	errs, _ := e.Validate().(Errors)
+	if len(errs) != 2 || errs[0].Error() != "statistics.cache: cannot be used with clf" ||
+		errs[1].Error() != "clf: requires the entrez statistics source" {
+		t.Fatalf("expected statistics.cache and clf errors, got %v", errs)
+	}
+
+	e.CLF.CLF = false
+	p, err := e.Pipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, ok := p.StatisticsSource.(*stats.CachingStatisticsSource)
+	if !ok {
+		t.Fatalf("expected a caching statistics source, got %T", p.StatisticsSource)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.CollectionSize(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if counter := c.Counters()["CollectionSize"]; counter.Hits != 1 || counter.Misses != 1 {
+		t.Errorf("expected 1 hit and 1 miss, got %+v", counter)
+	}
+
+	e.Statistics.Cache.Invalidate = []string{"Search"}
+	if _, err := e.Pipeline(); err == nil {
+		t.Error("expected an error invalidating a method that is not cached")
+	}
+}
+
+func TestQueryCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := Experiment{
+		Queries:    Queries{Format: "medline"},
+		Statistics: Statistics{Source: "entrez", Email: "groove@example.com", Tool: "groove"},
+		Cache:      &Cache{Type: "memory", MaxBytes: -1, TTL: "a month", MemoryBytes: 1 << 20},
+	}
+	errs, _ := e.Validate().(Errors)
+	expected := []string{
+		"cache.max_bytes: must not be negative",
+		`cache.ttl: time: invalid duration "a month"`,
+		"cache.memory_bytes: requires a file or diskv cache",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for i, msg := range expected {
+		if errs[i].Error() != msg {
+			t.Errorf("error %d: expected %q, got %q", i, msg, errs[i])
+		}
+	}
+
+	e.Cache = &Cache{Type: "memory", MaxEntries: 100}
+	if _, ok := e.queryCache().(*combinator.MemoryQueryCache); !ok {
+		t.Errorf("expected a bounded memory cache, got %T", e.queryCache())
+	}
+	e.Cache = &Cache{Type: "file", Path: dir, MaxBytes: 1 << 30, TTL: "720h", MemoryBytes: 1 << 20}
+	if err := e.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := e.queryCache().(combinator.TieredQueryCache); !ok {
+		t.Errorf("expected a tiered cache, got %T", e.queryCache())
+	}
+}
+
+func TestCLF(t *testing.T) {
+	e := Experiment{
+		Queries:    Queries{Format: "medline"},
+		Statistics: Statistics{Source: "snapshot", Snapshot: "snapshot.json"},
+	}
+	e.CLF.CLF = true
+	errs, _ := e.Validate().(Errors)
+	if len(errs) != 1 || errs[0].Error() != "clf: requires the entrez statistics source" {
+		t.Errorf("expected a clf error, got %v", errs)
+	}
+}
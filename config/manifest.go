@@ -0,0 +1,29 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/hscells/groove"
+)
+
+// FromManifest reads the experiment that a run was built from out of the manifest of the run. API keys are not
+// recorded in manifests, so the key of the statistics source must be set again before the experiment is re-run.
+func FromManifest(m groove.Manifest) (Experiment, error) {
+	if len(m.Config) == 0 {
+		return Experiment{}, errors.New("the manifest does not record the experiment of the run")
+	}
+	return Read(bytes.NewReader(m.Config), JSON)
+}
+
+// Rerun builds the pipeline of the experiment and runs it again, comparing what it produces with the outputs recorded
+// in the manifest (see groove.Rerun). The checkpoint of the experiment is not used, so that every topic is computed
+// again.
+func (e Experiment) Rerun(ctx context.Context, m groove.Manifest) (groove.Manifest, []groove.Difference, error) {
+	e.Checkpoint = nil
+	p, err := e.Pipeline()
+	if err != nil {
+		return groove.Manifest{}, nil, err
+	}
+	return groove.Rerun(ctx, p, m)
+}
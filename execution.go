@@ -0,0 +1,863 @@
+package groove
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/eval"
+	"github.com/hscells/groove/formulation"
+	"github.com/hscells/groove/learning"
+	"github.com/hscells/groove/output"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/preprocess"
+	"github.com/hscells/groove/rank"
+	"github.com/hscells/groove/stats"
+	"github.com/hscells/trecresults"
+	"github.com/peterbourgon/diskv"
+	"log"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// execution is a single run of a pipeline. Each stage of the pipeline is a method of the execution, and any failures
+// of topics are collected by the execution so they can be summarised once the pipeline completes.
+type execution struct {
+	Pipeline
+
+	// parent is the context the pipeline was executed with, and ctx is derived from it so that the pipeline can also be
+	// stopped by a failure.
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	c     chan pipeline.Result
+	sinks []output.ResultSink
+	// out serialises writes to the sinks.
+	out sync.Mutex
+
+	mu       sync.Mutex
+	failures []pipeline.StageError
+
+	// manifest records the run, if a manifest is kept. A rerun ignores the progress of earlier runs.
+	manifest *Manifest
+	rerun    bool
+	// planning is set when the execution only plans the run (see Pipeline.Plan), so components are not modified.
+	planning bool
+	// measurementCache is the directory measurements are cached in, or empty when they are cached in memory.
+	measurementCache string
+
+	loghw  bool
+	hwName string
+}
+
+func newExecution(ctx context.Context, p Pipeline, c chan pipeline.Result) *execution {
+	e := &execution{
+		Pipeline: p,
+		parent:   ctx,
+		c:        c,
+		loghw:    p.Headway != nil,
+		hwName:   fmt.Sprintf("groove (%s)", uuid.New().String()),
+	}
+	e.ctx, e.cancel = context.WithCancel(ctx)
+	e.CLF.Headway = p.Headway
+	e.StatisticsSource = stats.WithContext(e.ctx, p.StatisticsSource)
+	return e
+}
+
+// execute runs the pipeline and closes the channel (if there is one) once it completes.
+func (e *execution) execute() {
+	if e.c != nil {
+		defer close(e.c)
+	}
+	log.Println("starting groove pipeline...")
+	defer e.cancel()
+
+	e.run()
+	e.finish()
+}
+
+// stopped reports whether the pipeline should not continue, either because it was cancelled or because a topic failed
+// and the pipeline fails fast.
+func (e *execution) stopped() bool {
+	return e.ctx.Err() != nil
+}
+
+// fail records the failure of a topic in a stage and reports it. Failures caused by the pipeline being stopped are not
+// failures of the topic, so they are not recorded.
+func (e *execution) fail(topic, stage string, err error) {
+	if e.stopped() {
+		log.Printf("stopped topic %v at %s: %v\n", topic, stage, err)
+		return
+	}
+	se := pipeline.StageError{
+		Topic: topic,
+		Stage: stage,
+		Err:   err,
+	}
+	log.Println(se)
+
+	e.mu.Lock()
+	e.failures = append(e.failures, se)
+	e.mu.Unlock()
+
+	if e.loghw {
+		_ = e.Headway.Message(se.Error())
+	}
+	e.emit(pipeline.Result{
+		Topic: topic,
+		Error: se,
+		Type:  pipeline.Error,
+	})
+	if e.FailurePolicy.Mode == FailFast {
+		e.cancel()
+	}
+}
+
+// err is the error that stopped the pipeline: the first failure, or else the error of the context.
+func (e *execution) err() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.failures) > 0 {
+		return e.failures[0]
+	}
+	return e.ctx.Err()
+}
+
+// do runs a stage for a topic according to the failure policy. It reports whether the stage succeeded.
+func (e *execution) do(topic, stage string, fn func() error) bool {
+	if e.stopped() {
+		return false
+	}
+	err := e.FailurePolicy.try(e.ctx, fn)
+	if err != nil {
+		e.fail(topic, stage, err)
+		return false
+	}
+	return true
+}
+
+// resume returns the results a topic produced in a stage of an earlier run, unless the pipeline is not checkpointed or
+// the stage is recomputed.
+func (e *execution) resume(topic, stage string) ([]pipeline.Result, bool) {
+	results, ok := e.checkpointed(topic, stage)
+	if ok {
+		log.Printf("resuming topic %v from the %s checkpoint\n", topic, stage)
+	}
+	return results, ok
+}
+
+// replay drops the TREC results of a topic resumed from a checkpoint when the topic is already in the TREC output
+// (r) of the pipeline, since the output was written by the run that checkpointed the topic.
+func replay(results []pipeline.Result, r trecresults.ResultFile, topic string) []pipeline.Result {
+	if _, ok := r.Results[topic]; !ok {
+		return results
+	}
+	replayed := make([]pipeline.Result, 0, len(results))
+	for _, result := range results {
+		if result.Type != pipeline.TrecResult {
+			replayed = append(replayed, result)
+		}
+	}
+	return replayed
+}
+
+// checkpointed returns the results a topic produced in a stage of an earlier run, and whether the stage is resumed.
+func (e *execution) checkpointed(topic, stage string) ([]pipeline.Result, bool) {
+	if e.Checkpoint == nil || e.rerun {
+		return nil, false
+	}
+	for _, s := range e.Recompute {
+		if s == stage {
+			return nil, false
+		}
+	}
+	return e.Checkpoint.Load(topic, stage)
+}
+
+// record checkpoints that a topic completed a stage. A checkpoint that cannot be written only means the topic will be
+// computed again on resumption, so it does not fail the topic.
+func (e *execution) record(topic, stage string, results ...pipeline.Result) {
+	if e.Checkpoint == nil || e.rerun {
+		return
+	}
+	if err := e.Checkpoint.Save(topic, stage, results); err != nil {
+		log.Printf("could not checkpoint topic %v at %s: %v\n", topic, stage, err)
+	}
+}
+
+// send outputs results of the pipeline, recording them in the manifest.
+func (e *execution) send(results ...pipeline.Result) {
+	for _, r := range results {
+		if e.manifest != nil {
+			e.mu.Lock()
+			e.manifest.record(r)
+			e.mu.Unlock()
+		}
+		e.emit(r)
+	}
+}
+
+// emit writes a result to the sinks of the pipeline and sends it through the channel, if there is one. An output that
+// cannot be written to a sink fails its topic in the output stage.
+func (e *execution) emit(r pipeline.Result) {
+	var err error
+	e.out.Lock()
+	for _, s := range e.sinks {
+		if werr := s.Write(r); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	e.out.Unlock()
+
+	if e.c != nil {
+		e.c <- r
+	}
+
+	if err != nil {
+		if outputTypes[r.Type] {
+			e.fail(r.Topic, pipeline.OutputStage, err)
+		} else {
+			log.Printf("could not write the %s result to a sink: %v\n", r.Type, err)
+		}
+	}
+}
+
+// finish reports why the pipeline was cancelled (if it was), summarises the failures, and completes the pipeline.
+func (e *execution) finish() {
+	if err := e.parent.Err(); err != nil {
+		log.Printf("stopping groove pipeline: %v\n", err)
+		e.emit(pipeline.Result{
+			Error: err,
+			Type:  pipeline.Error,
+		})
+	}
+
+	e.mu.Lock()
+	failures := make([]pipeline.StageError, len(e.failures))
+	copy(failures, e.failures)
+	e.mu.Unlock()
+	if len(failures) > 0 {
+		log.Printf("%d failures occurred\n", len(failures))
+	}
+	if c, ok := e.QueryCache.(combinator.ObservableQueryCacher); ok {
+		log.Printf("query cache: %s\n", c.Stats())
+	}
+	e.emit(pipeline.Result{
+		Failures: failures,
+		Type:     pipeline.Summary,
+	})
+
+	if e.manifest != nil {
+		e.manifest.caches(e.measurementCache, e.StatisticsSource, e.QueryCache)
+		e.manifest.complete(failures)
+		if len(e.ManifestFile) > 0 && !e.rerun {
+			if err := e.manifest.Write(e.ManifestFile); err != nil {
+				log.Printf("could not write the manifest: %v\n", err)
+				e.emit(pipeline.Result{
+					Error: err,
+					Type:  pipeline.Error,
+				})
+			}
+		}
+	}
+
+	// The sinks are closed rather than sent Done.
+	for _, s := range e.sinks {
+		if err := s.Close(); err != nil {
+			log.Printf("could not close a sink: %v\n", err)
+			if e.c != nil {
+				e.c <- pipeline.Result{
+					Error: err,
+					Type:  pipeline.Error,
+				}
+			}
+		}
+	}
+
+	// Return the formatted results.
+	if e.c != nil {
+		e.c <- pipeline.Result{
+			Type: pipeline.Done,
+		}
+	}
+}
+
+// run executes each stage of the pipeline in turn.
+func (e *execution) run() {
+	if !e.setup() {
+		return
+	}
+
+	if e.manifest != nil {
+		e.manifest.describe(e.Pipeline)
+	}
+
+	// Only perform this section if there are some queries.
+	if len(e.QueryPath) > 0 {
+		queries, ok := e.load()
+		if !ok {
+			return
+		}
+
+		e.measure(queries)
+		if e.stopped() {
+			return
+		}
+
+		if e.retrieves() && e.CLF.CLF {
+			e.clf(queries)
+		} else if e.retrieves() {
+			e.retrieve(queries)
+		}
+		if e.stopped() {
+			return
+		}
+
+		e.formulate(queries)
+		if e.stopped() {
+			return
+		}
+	}
+
+	e.model()
+}
+
+// setup configures the caches used by the pipeline. It reports whether the caches could be configured.
+func (e *execution) setup() bool {
+	// A rerun computes every topic again, so it does not read the measurements and documents cached by earlier runs.
+	if e.rerun {
+		e.QueryCache = combinator.NewMapQueryCache()
+		e.MeasurementExecutor = analysis.NewMemoryMeasurementExecutor()
+		return true
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		e.fail("", pipeline.SetupStage, err)
+		return false
+	}
+
+	// Configure caches.
+	e.measurementCache = path.Join(cacheDir, "groove", "statistics_cache")
+	statisticsCache := diskv.New(diskv.Options{
+		BasePath:     e.measurementCache,
+		Transform:    combinator.BlockTransform(8),
+		CacheSizeMax: 4096 * 1024,
+		Compression:  diskv.NewGzipCompression(),
+	})
+
+	if e.QueryCache == nil {
+		e.QueryCache = combinator.NewFileQueryCache(path.Join(cacheDir, "groove", "file_cache"))
+	}
+
+	e.MeasurementExecutor = analysis.NewDiskMeasurementExecutor(statisticsCache)
+	return true
+}
+
+// retrieves reports whether the queries are retrieved, i.e. whether there is TREC output or evaluation.
+func (e *execution) retrieves() bool {
+	return len(e.OutputTrec.Path) > 0 || len(e.EvaluationFormatters.EvaluationFormatters) > 0
+}
+
+// completed reads the TREC output of the pipeline, which contains the results of topics retrieved by an earlier run.
+func (e *execution) completed() (trecresults.ResultFile, error) {
+	f, err := os.OpenFile(e.OutputTrec.Path, os.O_RDONLY, 0664)
+	if err != nil {
+		return trecresults.ResultFile{}, err
+	}
+	defer f.Close()
+	return trecresults.ResultsFromReader(f)
+}
+
+// LoadQueries loads the queries of the pipeline and selects its topics. The queries are not yet preprocessed or
+// transformed (see ProcessQuery).
+func (p Pipeline) LoadQueries() ([]pipeline.Query, error) {
+	if err := p.Topics.Validate(); err != nil {
+		return nil, err
+	}
+	queries, err := p.QueriesSource.Load(p.QueryPath)
+	if err != nil {
+		return nil, err
+	}
+	selected := p.Topics.Select(queries)
+	log.Printf("selected %d of %d topics\n", len(selected), len(queries))
+	return selected, nil
+}
+
+// ProcessQuery preprocesses and then transforms a query with the preprocessing and transformations of the pipeline.
+func (p Pipeline) ProcessQuery(q pipeline.Query) (pipeline.Query, error) {
+	for _, processor := range p.Preprocess {
+		q = pipeline.NewQuery(q.Name, q.Topic, preprocess.ProcessQuery(q.Query, processor))
+	}
+	for _, t := range p.Transformations.BooleanTransformations {
+		q = pipeline.NewQuery(q.Name, q.Topic, t(q.Query, q.Topic)())
+	}
+	if len(p.Transformations.ElasticsearchTransformations) > 0 {
+		s, ok := p.StatisticsSource.(stats.AnalysingStatisticsSource)
+		if !ok {
+			return q, fmt.Errorf("Elasticsearch transformations require an analysing statistics source, got %T", p.StatisticsSource)
+		}
+		for _, t := range p.Transformations.ElasticsearchTransformations {
+			q = pipeline.NewQuery(q.Name, q.Topic, t(q.Query, s)())
+		}
+	}
+	return q, nil
+}
+
+// load loads, selects, preprocesses, transforms, and schedules the queries.
+func (e *execution) load() ([]pipeline.Query, bool) {
+	log.Println("loading queries...")
+	var queries []pipeline.Query
+	ok := e.do("", pipeline.LoadStage, func() error {
+		var err error
+		queries, err = e.LoadQueries()
+		return err
+	})
+	if !ok {
+		return nil, false
+	}
+
+	// Here we need to configure how the queries are loaded into each learning model.
+	if e.Model != nil && !e.planning {
+		switch m := e.Model.(type) {
+		case *learning.QueryChain:
+			m.Queries = queries
+			// Candidate queries share most of their subtrees, which are memoised rather than constructed and
+			// evaluated for each candidate.
+			m.QueryCacher = combinator.NewSubtreeQueryCache(e.QueryCache, 0)
+			m.MeasurementExecutor = e.MeasurementExecutor
+		}
+	}
+
+	//if len(p.PubDatesFile) > 0 {
+	//	log.Println("adding date restrictions to queries...")
+	//	for i, cq := range queries {
+	//		log.Println(cq.Topic)
+	//		q := preprocess.DateRestrictions(p.PubDatesFile)(cq.Query, cq.Topic)()
+	//		queries[i].Query = q
+	//	}
+	//}
+
+	// This means preprocessing and transforming the query.
+	measurementQueries := make([]pipeline.Query, 0, len(queries))
+	for _, q := range queries {
+		q, err := e.ProcessQuery(q)
+		if err != nil {
+			e.fail(q.Topic, pipeline.LoadStage, err)
+			continue
+		}
+		measurementQueries = append(measurementQueries, q)
+	}
+
+	log.Println("scheduling queries...")
+
+	// Order the transformed queries, by default by size.
+	schedule := e.Schedule
+	if schedule == nil {
+		schedule = ComplexityAscending
+	}
+	schedule(e.Pipeline, measurementQueries)
+
+	return measurementQueries, true
+}
+
+// measures reports whether the queries are measured. Measurements are only performed if there are some measurement
+// formatters to output them to.
+func (e *execution) measures() bool {
+	return len(e.MeasurementFormatters) > 0
+}
+
+// measure computes measurements for each of the queries using a pool of workers.
+func (e *execution) measure(queries []pipeline.Query) {
+	if !e.measures() {
+		return
+	}
+
+	workers := e.MeasurementWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	workers = stats.Concurrency(e.StatisticsSource, workers)
+	log.Printf("starting to measure queries with %d workers\n", workers)
+
+	// measured is a measured topic, where result is nil if the topic failed.
+	type measured struct {
+		idx    int
+		result *pipeline.Result
+	}
+
+	jobs := make(chan int)
+	results := make(chan measured)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				q := queries[idx]
+				if prev, ok := e.resume(q.Topic, pipeline.MeasurementStage); ok {
+					m := measured{idx: idx}
+					if len(prev) > 0 {
+						m.result = &prev[0]
+					}
+					results <- m
+					continue
+				}
+
+				var measurements []float64
+				ok := e.do(q.Topic, pipeline.MeasurementStage, func() error {
+					var err error
+					measurements, err = e.MeasurementExecutor.ExecuteContext(e.ctx, q, e.StatisticsSource, e.Measurements...)
+					return err
+				})
+				m := measured{idx: idx}
+				if ok {
+					data := make(map[string]float64)
+					for i, measurement := range measurements {
+						data[e.Measurements[i].Name()] = measurement
+					}
+					m.result = &pipeline.Result{
+						Topic:        q.Topic,
+						Measurements: data,
+						Type:         pipeline.Measurement,
+					}
+					e.record(q.Topic, pipeline.MeasurementStage, *m.result)
+				}
+				results <- m
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for idx := range queries {
+			if e.stopped() {
+				return
+			}
+			jobs <- idx
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Output the measurements in the order of the queries, as soon as every query before them has been measured.
+	pending := make(map[int]measured)
+	next := 0
+	for m := range results {
+		pending[m.idx] = m
+		for {
+			p, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if p.result != nil {
+				e.send(*p.result)
+			}
+		}
+	}
+}
+
+// clf ranks the documents for each query using coordination level fusion.
+func (e *execution) clf(queries []pipeline.Query) {
+	entrez, ok := e.StatisticsSource.(stats.EntrezStatisticsSource)
+	if !ok {
+		e.fail("", pipeline.CLFStage, fmt.Errorf("requires an Entrez statistics source, got %T", e.StatisticsSource))
+		return
+	}
+
+	// Store the measurements to be output later.
+	var r trecresults.ResultFile
+	ok = e.rerun || e.do("", pipeline.CLFStage, func() error {
+		var err error
+		r, err = e.completed()
+		return err
+	})
+	if !ok {
+		return
+	}
+
+	measurements := make(map[string]map[string]float64)
+	for i, q := range queries {
+		if e.stopped() {
+			break
+		}
+		if prev, ok := e.resume(q.Topic, pipeline.CLFStage); ok {
+			for _, result := range replay(prev, r, q.Topic) {
+				if result.Type == pipeline.Evaluation {
+					measurements[q.Topic] = result.Evaluations
+				} else {
+					e.send(result)
+				}
+			}
+			continue
+		}
+		if _, ok := r.Results[q.Topic]; ok {
+			log.Printf("already completed topic %v, so skipping it\n", q.Topic)
+			continue
+		}
+		log.Printf("starting topic %v\n", q.Topic)
+		var results trecresults.ResultList
+		ok := e.do(q.Topic, pipeline.CLFStage, func() error {
+			var err error
+			results, err = rank.CLF(q, entrez, e.CLF)
+			return err
+		})
+		if !ok {
+			if e.loghw {
+				_ = e.Headway.Send(float64(i), float64(len(queries)), e.hwName, fmt.Sprintf("[measurement] topic %s failed", q.Topic))
+			}
+			continue
+		}
+		if e.loghw {
+			_ = e.Headway.Send(float64(i), float64(len(queries)), e.hwName, fmt.Sprintf("[measurement] topic %s", q.Topic))
+		}
+		// Set the evaluation results. These are sent once all of the topics have been ranked.
+		var out []pipeline.Result
+		if len(e.Evaluations) > 0 {
+			measurements[q.Topic] = eval.Evaluate(e.Evaluations, &results, e.EvaluationFormatters.EvaluationQrels, q.Topic)
+			out = append(out, pipeline.Result{
+				Topic:       q.Topic,
+				Evaluations: measurements[q.Topic],
+				Type:        pipeline.Evaluation,
+			})
+		}
+
+		// MeasurementOutput the trec results.
+		if len(e.OutputTrec.Path) > 0 {
+			trec := pipeline.Result{
+				Topic:       q.Topic,
+				TrecResults: &results,
+				Type:        pipeline.TrecResult,
+			}
+			e.send(trec)
+			out = append(out, trec)
+		}
+
+		// Send the transformation through the channel.
+		transformation := pipeline.Result{
+			Topic:          q.Topic,
+			Transformation: pipeline.QueryResult{Name: q.Name, Topic: q.Topic, Transformation: q.Query},
+			Type:           pipeline.Transformation,
+		}
+		e.send(transformation)
+		e.record(q.Topic, pipeline.CLFStage, append(out, transformation)...)
+
+		log.Printf("completed topic %v\n", q.Topic)
+	}
+	if e.loghw {
+		_ = e.Headway.Send(float64(len(queries)), float64(len(queries)), e.hwName, "[measurement] done!")
+	}
+
+	// Flush the evaluations of the topics that were completed, in the order of their topics.
+	topics := make([]string, 0, len(measurements))
+	for topic := range measurements {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		e.send(pipeline.Result{
+			Topic:       topic,
+			Evaluations: measurements[topic],
+			Type:        pipeline.Evaluation,
+		})
+	}
+}
+
+// retrieve executes each of the queries using the statistics source. This section is run concurrently, since the
+// results can sometimes get quite large and we don't want to eat ram.
+func (e *execution) retrieve(queries []pipeline.Query) {
+	// Set the limit to how many goroutines can be run.
+	// http://jmoiron.net/blog/limiting-concurrency-in-go/
+	concurrency := runtime.NumCPU()
+
+	log.Println(e.OutputTrec)
+
+	log.Printf("starting to execute queries with %d goroutines\n", concurrency)
+
+	var r trecresults.ResultFile
+	if _, err := os.Stat(e.OutputTrec.Path); err == nil && !e.rerun {
+		ok := e.do("", pipeline.RetrievalStage, func() error {
+			var err error
+			r, err = e.completed()
+			return err
+		})
+		if !ok {
+			return
+		}
+	} else {
+		r = *trecresults.NewResultFile()
+	}
+
+	sem := make(chan bool, concurrency)
+	for i, q := range queries {
+		if e.stopped() {
+			break
+		}
+		sem <- true
+		go func(idx int, query pipeline.Query) {
+			defer func() { <-sem }()
+			if prev, ok := e.resume(query.Topic, pipeline.RetrievalStage); ok {
+				e.send(replay(prev, r, query.Topic)...)
+				return
+			}
+			if _, ok := r.Results[query.Topic]; ok {
+				log.Printf("already completed topic %v, so skipping it\n", query.Topic)
+				return
+			}
+			if e.loghw {
+				_ = e.Headway.Send(float64(idx)+1, float64(len(queries)), "EV."+e.hwName, fmt.Sprintf("%s", query.Topic))
+			}
+			log.Printf("starting topic %v\n", query.Topic)
+
+			var trecResults trecresults.ResultList
+			ok := e.do(query.Topic, pipeline.RetrievalStage, func() error {
+				var err error
+				trecResults, err = e.StatisticsSource.Execute(query, e.StatisticsSource.SearchOptions())
+				return err
+			})
+			if !ok {
+				return
+			}
+
+			var out []pipeline.Result
+
+			// Set the evaluation results.
+			if len(e.Evaluations) > 0 {
+				out = append(out, pipeline.Result{
+					Topic:       query.Topic,
+					Evaluations: eval.Evaluate(e.Evaluations, &trecResults, e.EvaluationFormatters.EvaluationQrels, query.Topic),
+					Type:        pipeline.Evaluation,
+				})
+			}
+
+			// MeasurementOutput the trec results.
+			if len(e.OutputTrec.Path) > 0 {
+				out = append(out, pipeline.Result{
+					Topic:       query.Topic,
+					TrecResults: &trecResults,
+					Type:        pipeline.TrecResult,
+				})
+			}
+
+			// Send the transformation through the channel.
+			out = append(out, pipeline.Result{
+				Topic:          query.Topic,
+				Transformation: pipeline.QueryResult{Name: query.Name, Topic: query.Topic, Transformation: query.Query},
+				Type:           pipeline.Transformation,
+			})
+
+			e.record(query.Topic, pipeline.RetrievalStage, out...)
+			e.send(out...)
+
+			log.Printf("completed topic %v\n", query.Topic)
+		}(i, q)
+	}
+
+	// Wait until the last goroutine has read from the semaphore.
+	for i := 0; i < cap(sem); i++ {
+		sem <- true
+	}
+}
+
+// formulate performs query formulation for each of the queries.
+func (e *execution) formulate(queries []pipeline.Query) {
+	if e.QueryFormulator == nil {
+		return
+	}
+	for i, q := range queries {
+		if e.stopped() {
+			return
+		}
+		if prev, ok := e.resume(q.Topic, pipeline.FormulationStage); ok {
+			e.send(prev...)
+			continue
+		}
+		if e.loghw {
+			e.Headway.Send(float64(i)+1, float64(len(queries)), "QF."+e.hwName, fmt.Sprintf("%s - %s", e.QueryFormulator.Method(), q.Topic))
+		}
+		// Perform the query formulation.
+		var (
+			formulations []cqr.CommonQueryRepresentation
+			sup          []pipeline.SupplementalData
+		)
+		ok := e.do(q.Topic, pipeline.FormulationStage, func() error {
+			var err error
+			formulations, sup, err = formulation.FormulateContext(e.ctx, e.QueryFormulator, q)
+			return err
+		})
+		if !ok {
+			continue
+		}
+
+		result := pipeline.Result{
+			Topic: q.Topic,
+			Formulation: pipeline.FormulationResut{
+				Queries: formulations,
+				Sup:     sup,
+			},
+			Type: pipeline.Formulation,
+		}
+		e.record(q.Topic, pipeline.FormulationStage, result)
+		e.send(result)
+	}
+	if e.loghw {
+		e.Headway.Message("completed query formulation")
+	}
+}
+
+// model generates features for, trains, and tests the model of the pipeline.
+func (e *execution) model() {
+	if e.Model == nil {
+		return
+	}
+
+	// Models that support cancellation are bound to the context, otherwise the context is only checked between tasks.
+	generate, train, test := e.Model.Generate, e.Model.Train, e.Model.Test
+	if m, ok := e.Model.(learning.ContextModel); ok {
+		generate = func() error { return m.GenerateContext(e.ctx) }
+		train = func() error { return m.TrainContext(e.ctx) }
+		test = func() error { return m.TestContext(e.ctx) }
+	}
+	if e.ModelConfiguration.Generate {
+		log.Println("generating features for model")
+		if !e.modelStage(pipeline.GenerateStage, generate) {
+			return
+		}
+	}
+	if e.ModelConfiguration.Train {
+		log.Println("training model")
+		if !e.modelStage(pipeline.TrainStage, train) {
+			return
+		}
+	}
+	if e.ModelConfiguration.Test {
+		log.Println("testing model")
+		if !e.modelStage(pipeline.TestStage, test) {
+			return
+		}
+	}
+}
+
+// modelStage runs a stage of the model, unless it was completed by an earlier run. It reports whether the stage
+// succeeded.
+func (e *execution) modelStage(stage string, fn func() error) bool {
+	if _, ok := e.resume("", stage); ok {
+		return true
+	}
+	if !e.do("", stage, fn) {
+		return false
+	}
+	e.record("", stage)
+	return true
+}
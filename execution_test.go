@@ -0,0 +1,282 @@
+package groove
+
+import (
+	"context"
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/output"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"github.com/hscells/trecresults"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Pipelines cache measurements and query results in the user cache directory, which is isolated for the tests.
+	dir, err := ioutil.TempDir("", "groove")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("XDG_CACHE_HOME", dir)
+	os.Setenv("HOME", dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// queriesSource loads the same queries from any path.
+type queriesSource []pipeline.Query
+
+func (q queriesSource) Load(string) ([]pipeline.Query, error) {
+	return append([]pipeline.Query{}, q...), nil
+}
+
+// topics are keyword queries with the topics.
+func topics(topics ...string) queriesSource {
+	var q queriesSource
+	for _, topic := range topics {
+		q = append(q, pipeline.NewQuery(topic, topic, cqr.NewKeyword("topic "+topic, "title")))
+	}
+	return q
+}
+
+// statisticsSource is a statistics source whose retrieval and retrieval size are stubbed by tests. Its requests are
+// bound to a context, and it limits its concurrency when limit is positive.
+type statisticsSource struct {
+	execute       func(ctx context.Context, q pipeline.Query) (trecresults.ResultList, error)
+	retrievalSize func(ctx context.Context, q cqr.CommonQueryRepresentation) (float64, error)
+	limit         int
+	ctx           context.Context
+}
+
+func (s statisticsSource) WithContext(ctx context.Context) stats.StatisticsSource {
+	s.ctx = ctx
+	return s
+}
+
+func (s statisticsSource) Concurrency() int {
+	return s.limit
+}
+
+func (s statisticsSource) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+func (s statisticsSource) SearchOptions() stats.SearchOptions {
+	return stats.SearchOptions{Size: 10, RunName: "test"}
+}
+
+func (s statisticsSource) Parameters() map[string]float64 {
+	return nil
+}
+
+func (s statisticsSource) TermFrequency(term, field, document string) (float64, error) {
+	return 0, nil
+}
+
+func (s statisticsSource) TermVector(document string) (stats.TermVector, error) {
+	return nil, nil
+}
+
+func (s statisticsSource) DocumentFrequency(term, field string) (float64, error) {
+	return 0, nil
+}
+
+func (s statisticsSource) TotalTermFrequency(term, field string) (float64, error) {
+	return 0, nil
+}
+
+func (s statisticsSource) InverseDocumentFrequency(term, field string) (float64, error) {
+	return 0, nil
+}
+
+func (s statisticsSource) RetrievalSize(query cqr.CommonQueryRepresentation) (float64, error) {
+	if s.retrievalSize == nil {
+		return 0, nil
+	}
+	return s.retrievalSize(s.context(), query)
+}
+
+func (s statisticsSource) VocabularySize(field string) (float64, error) {
+	return 0, nil
+}
+
+func (s statisticsSource) Execute(query pipeline.Query, options stats.SearchOptions) (trecresults.ResultList, error) {
+	if s.execute == nil {
+		return trecresults.ResultList{{Topic: query.Topic, DocId: "1", Rank: 1, Score: 1, RunName: options.RunName}}, nil
+	}
+	return s.execute(s.context(), query)
+}
+
+func (s statisticsSource) CollectionSize() (float64, error) {
+	return 0, nil
+}
+
+// measurementFunc is a measurement computed by a function.
+type measurementFunc struct {
+	name string
+	fn   func(q pipeline.Query, ss stats.StatisticsSource) (float64, error)
+}
+
+func (m measurementFunc) Name() string {
+	return m.name
+}
+
+func (m measurementFunc) Execute(q pipeline.Query, ss stats.StatisticsSource) (float64, error) {
+	return m.fn(q, ss)
+}
+
+// measuring adds measurements to a pipeline, like Measurement.
+func measuring(measurements ...measurementFunc) func() interface{} {
+	return func() interface{} {
+		var m []analysis.Measurement
+		for _, measurement := range measurements {
+			m = append(m, measurement)
+		}
+		return m
+	}
+}
+
+// execute executes the pipeline until ctx is done, and returns the results it sent. The measurements and query results
+// cached by earlier pipelines are removed first.
+func execute(t *testing.T, ctx context.Context, p Pipeline, sinks ...output.ResultSink) []pipeline.Result {
+	if err := os.RemoveAll(filepath.Join(os.Getenv("XDG_CACHE_HOME"), "groove")); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.QueryPath) == 0 {
+		p.QueryPath = "queries"
+	}
+	c := make(chan pipeline.Result)
+	go p.ExecuteContext(ctx, c, sinks...)
+	var results []pipeline.Result
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case r, ok := <-c:
+			if !ok {
+				return results
+			}
+			results = append(results, r)
+		case <-timeout:
+			t.Fatalf("the pipeline did not complete; results so far: %v", results)
+		}
+	}
+}
+
+// resultsOf are the results of a type.
+func resultsOf(results []pipeline.Result, t pipeline.ResultType) []pipeline.Result {
+	var of []pipeline.Result
+	for _, r := range results {
+		if r.Type == t {
+			of = append(of, r)
+		}
+	}
+	return of
+}
+
+// summary is the summary of the results.
+func summary(t *testing.T, results []pipeline.Result) pipeline.Result {
+	s := resultsOf(results, pipeline.Summary)
+	if len(s) != 1 {
+		t.Fatalf("expected a summary, got %v", results)
+	}
+	return s[0]
+}
+
+func TestExecuteContextCancelled(t *testing.T) {
+	// Retrieval blocks until the pipeline is cancelled.
+	started := make(chan string, 3)
+	ss := statisticsSource{execute: func(ctx context.Context, q pipeline.Query) (trecresults.ResultList, error) {
+		started <- q.Topic
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+	dir, err := ioutil.TempDir("", "cancel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := NewGroovePipeline(topics("1", "2", "3"), ss, TrecOutput(filepath.Join(dir, "run.trec")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	results := execute(t, ctx, p)
+
+	// Topics stopped by the cancellation are not failures, and no stage continues after it.
+	if len(results) != 3 {
+		t.Fatalf("expected an error, summary and done, got %v", results)
+	}
+	if results[0].Type != pipeline.Error || results[0].Error != context.Canceled {
+		t.Errorf("expected the error of the context, got %v", results[0])
+	}
+	if results[1].Type != pipeline.Summary || len(results[1].Failures) != 0 {
+		t.Errorf("expected a summary without failures, got %v", results[1])
+	}
+	if results[2].Type != pipeline.Done {
+		t.Errorf("expected done, got %v", results[2])
+	}
+}
+
+func TestMeasureWorkers(t *testing.T) {
+	// Later topics are measured faster, so they complete before the topics ahead of them. The statistics source allows
+	// two requests at once, which limits the four workers.
+	var queries queriesSource
+	for i := 0; i < 12; i++ {
+		queries = append(queries, topics(fmt.Sprint(i))...)
+	}
+	var (
+		mu                  sync.Mutex
+		inFlight, maxFlight int
+	)
+	ss := statisticsSource{limit: 2, retrievalSize: func(ctx context.Context, q cqr.CommonQueryRepresentation) (float64, error) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxFlight {
+			maxFlight = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		var topic int
+		fmt.Sscanf(q.(cqr.Keyword).QueryString, "topic %d", &topic)
+		time.Sleep(time.Duration(len(queries)-topic) * 2 * time.Millisecond)
+		return float64(topic), nil
+	}}
+	m := measurementFunc{name: "RetrievalSize", fn: func(q pipeline.Query, ss stats.StatisticsSource) (float64, error) {
+		return ss.RetrievalSize(q.Query)
+	}}
+	p := NewGroovePipeline(queries, ss,
+		measuring(m),
+		MeasurementOutput(output.JsonMeasurementFormatter),
+		MeasurementWorkers(4),
+		ScheduleTopics(FileOrder))
+	results := execute(t, context.Background(), p)
+
+	measured := resultsOf(results, pipeline.Measurement)
+	if len(measured) != len(queries) {
+		t.Fatalf("expected %d measurements, got %d", len(queries), len(measured))
+	}
+	for i, r := range measured {
+		if r.Topic != queries[i].Topic || r.Measurements["RetrievalSize"] != float64(i) {
+			t.Errorf("measurement %d: expected topic %s, got %s (%v)", i, queries[i].Topic, r.Topic, r.Measurements)
+		}
+	}
+	if maxFlight != 2 {
+		t.Errorf("expected 2 requests at once, got %d", maxFlight)
+	}
+}
@@ -0,0 +1,88 @@
+package groove
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// FailureMode determines how a pipeline reacts to a topic failing in one of its stages.
+type FailureMode uint8
+
+const (
+	// FailFast stops the pipeline at the first failure.
+	FailFast FailureMode = iota
+	// SkipTopic reports the failure and continues with the remaining topics.
+	SkipTopic
+	// RetryTopic retries the stage for the topic before reporting the failure and continuing with the remaining topics.
+	RetryTopic
+)
+
+// FailurePolicy configures how failures of individual topics are handled by a pipeline. The zero value fails fast.
+type FailurePolicy struct {
+	Mode FailureMode
+	// Retries is the number of times a stage is retried for a topic when the mode is RetryTopic.
+	Retries int
+	// Backoff is the delay before the first retry. The delay doubles with every subsequent retry.
+	Backoff time.Duration
+}
+
+// FailFastPolicy stops the pipeline at the first failure.
+func FailFastPolicy() FailurePolicy {
+	return FailurePolicy{Mode: FailFast}
+}
+
+// SkipTopicPolicy skips topics that fail and continues with the rest.
+func SkipTopicPolicy() FailurePolicy {
+	return FailurePolicy{Mode: SkipTopic}
+}
+
+// RetryTopicPolicy retries a failed topic n times, waiting backoff before the first retry and doubling the wait after
+// each subsequent one. Topics that still fail are skipped.
+func RetryTopicPolicy(n int, backoff time.Duration) FailurePolicy {
+	return FailurePolicy{Mode: RetryTopic, Retries: n, Backoff: backoff}
+}
+
+// OnFailure configures the failure policy of the pipeline.
+func OnFailure(policy FailurePolicy) func() interface{} {
+	return func() interface{} {
+		return policy
+	}
+}
+
+// attempts is the total number of times a stage is attempted for a topic.
+func (f FailurePolicy) attempts() int {
+	if f.Mode == RetryTopic && f.Retries > 0 {
+		return f.Retries + 1
+	}
+	return 1
+}
+
+// try runs fn according to the policy, retrying it while attempts remain. Panics raised by fn are recovered and
+// returned as errors so that a single topic cannot bring down the whole pipeline.
+func (f FailurePolicy) try(ctx context.Context, fn func() error) (err error) {
+	backoff := f.Backoff
+	for attempt := 1; ; attempt++ {
+		err = recovered(fn)
+		if err == nil || ctx.Err() != nil || attempt >= f.attempts() {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// recovered calls fn, turning a panic into an error.
+func recovered(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn()
+}
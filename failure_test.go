@@ -0,0 +1,216 @@
+package groove
+
+import (
+	"context"
+	"errors"
+	"github.com/hscells/groove/output"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/preprocess"
+	"github.com/hscells/groove/stats"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFailurePolicyTry(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name     string
+		policy   FailurePolicy
+		failures int
+		attempts int
+		err      string
+	}{
+		{"fail fast", FailFastPolicy(), 1, 1, "failed"},
+		{"skip topic", SkipTopicPolicy(), 1, 1, "failed"},
+		{"retry topic", RetryTopicPolicy(2, time.Millisecond), 2, 3, ""},
+		{"retries exhausted", RetryTopicPolicy(2, time.Millisecond), 5, 3, "failed"},
+		{"no retries", RetryTopicPolicy(0, time.Millisecond), 5, 1, "failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := tt.policy.try(context.Background(), func() error {
+				attempts++
+				if attempts <= tt.failures {
+					return errFailed
+				}
+				return nil
+			})
+			if attempts != tt.attempts {
+				t.Errorf("expected %d attempts, got %d", tt.attempts, attempts)
+			}
+			if (err == nil && len(tt.err) > 0) || (err != nil && err.Error() != tt.err) {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestFailurePolicyTryBackoff(t *testing.T) {
+	var attempts []time.Time
+	RetryTopicPolicy(2, 10*time.Millisecond).try(context.Background(), func() error {
+		attempts = append(attempts, time.Now())
+		return errors.New("failed")
+	})
+	if len(attempts) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(attempts))
+	}
+	// The delay doubles after every retry.
+	if d := attempts[1].Sub(attempts[0]); d < 10*time.Millisecond {
+		t.Errorf("expected a backoff of at least 10ms, got %v", d)
+	}
+	if d := attempts[2].Sub(attempts[1]); d < 20*time.Millisecond {
+		t.Errorf("expected a backoff of at least 20ms, got %v", d)
+	}
+}
+
+func TestFailurePolicyTryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	start := time.Now()
+	err := RetryTopicPolicy(5, time.Hour).try(ctx, func() error {
+		attempts++
+		cancel()
+		return errors.New("failed")
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("expected one failed attempt, got %d attempts and %v", attempts, err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("expected the backoff to stop once the context was cancelled")
+	}
+}
+
+func TestFailurePolicyTryPanic(t *testing.T) {
+	err := SkipTopicPolicy().try(context.Background(), func() error {
+		panic("out of bounds")
+	})
+	if err == nil || err.Error() != "panic: out of bounds" {
+		t.Errorf("expected the panic to be recovered, got %v", err)
+	}
+}
+
+func TestFailureModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   FailurePolicy
+		failures int
+		// measured are the topics measured, and attempts the number of times each topic was measured.
+		measured []string
+		attempts map[string]int
+		failed   []string
+	}{
+		{
+			name:     "fail_fast",
+			policy:   FailFastPolicy(),
+			failures: 1,
+			measured: []string{"1"},
+			attempts: map[string]int{"1": 1, "2": 1},
+			failed:   []string{"topic 2, measurement: failed"},
+		},
+		{
+			name:     "skip_topic",
+			policy:   SkipTopicPolicy(),
+			failures: 1,
+			measured: []string{"1", "3"},
+			attempts: map[string]int{"1": 1, "2": 1, "3": 1},
+			failed:   []string{"topic 2, measurement: failed"},
+		},
+		{
+			name:     "retry_topic",
+			policy:   RetryTopicPolicy(2, time.Millisecond),
+			failures: 2,
+			measured: []string{"1", "2", "3"},
+			attempts: map[string]int{"1": 1, "2": 3, "3": 1},
+		},
+		{
+			name:     "retries_exhausted",
+			policy:   RetryTopicPolicy(1, time.Millisecond),
+			failures: 2,
+			measured: []string{"1", "3"},
+			attempts: map[string]int{"1": 1, "2": 2, "3": 1},
+			failed:   []string{"topic 2, measurement: failed"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Topic 2 fails the first failures times it is measured. Topics are measured one at a time, so a pipeline
+			// that fails fast never measures topic 3.
+			attempts := make(map[string]int)
+			m := measurementFunc{name: tt.name, fn: func(q pipeline.Query, ss stats.StatisticsSource) (float64, error) {
+				attempts[q.Topic]++
+				if q.Topic == "2" && attempts[q.Topic] <= tt.failures {
+					return 0, errors.New("failed")
+				}
+				return 1, nil
+			}}
+			p := NewGroovePipeline(topics("1", "2", "3"), statisticsSource{},
+				measuring(m),
+				MeasurementOutput(output.JsonMeasurementFormatter),
+				MeasurementWorkers(1),
+				ScheduleTopics(FileOrder),
+				OnFailure(tt.policy))
+			results := execute(t, context.Background(), p)
+
+			var measured []string
+			for _, r := range resultsOf(results, pipeline.Measurement) {
+				measured = append(measured, r.Topic)
+			}
+			if strings.Join(measured, " ") != strings.Join(tt.measured, " ") {
+				t.Errorf("expected topics %v to be measured, got %v", tt.measured, measured)
+			}
+			for topic, n := range tt.attempts {
+				if attempts[topic] != n {
+					t.Errorf("expected topic %s to be measured %d times, got %d", topic, n, attempts[topic])
+				}
+			}
+			if len(attempts) != len(tt.attempts) {
+				t.Errorf("expected %d topics to be measured, got %v", len(tt.attempts), attempts)
+			}
+
+			// The failures are reported as they happen, and summarised before Done.
+			s := summary(t, results)
+			if len(s.Failures) != len(tt.failed) || len(resultsOf(results, pipeline.Error)) != len(tt.failed) {
+				t.Fatalf("expected failures %v, got %v", tt.failed, s.Failures)
+			}
+			for i, msg := range tt.failed {
+				if s.Failures[i].Error() != msg || s.Failures[i].Topic != "2" {
+					t.Errorf("expected failure %q, got %q", msg, s.Failures[i])
+				}
+			}
+			if n := len(results); results[n-2].Type != pipeline.Summary || results[n-1].Type != pipeline.Done {
+				t.Errorf("expected the summary and then done, got %v and %v", results[n-2].Type, results[n-1].Type)
+			}
+		})
+	}
+}
+
+func TestFailureLoad(t *testing.T) {
+	// Elasticsearch transformations require an analysing statistics source, so without one every topic fails to load.
+	transformations := func() interface{} {
+		return preprocess.QueryTransformations{
+			ElasticsearchTransformations: []preprocess.ElasticsearchTransformation{preprocess.Analyse},
+		}
+	}
+	m := measurementFunc{name: "measured", fn: func(q pipeline.Query, ss stats.StatisticsSource) (float64, error) {
+		t.Errorf("expected topic %s not to be measured", q.Topic)
+		return 0, nil
+	}}
+	p := NewGroovePipeline(topics("1", "2"), statisticsSource{},
+		measuring(m),
+		MeasurementOutput(output.JsonMeasurementFormatter),
+		transformations,
+		OnFailure(SkipTopicPolicy()))
+	results := execute(t, context.Background(), p)
+
+	s := summary(t, results)
+	if len(s.Failures) != 2 {
+		t.Fatalf("expected both topics to fail, got %v", s.Failures)
+	}
+	for i, topic := range []string{"1", "2"} {
+		if f := s.Failures[i]; f.Topic != topic || f.Stage != pipeline.LoadStage {
+			t.Errorf("expected topic %s to fail to load, got %q", topic, f)
+		}
+	}
+}
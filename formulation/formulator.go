@@ -2,6 +2,7 @@
 package formulation
 
 import (
+	"context"
 	"fmt"
 	"github.com/hscells/cqr"
 	"github.com/hscells/groove/eval"
@@ -19,6 +20,24 @@ type Formulator interface {
 	Method() string
 }
 
+// ContextFormulator is a formulator that can be cancelled part way through formulating a query.
+type ContextFormulator interface {
+	Formulator
+	FormulateContext(ctx context.Context, query pipeline.Query) ([]cqr.CommonQueryRepresentation, []pipeline.SupplementalData, error)
+}
+
+// FormulateContext formulates a query using f, cancelling the formulation when ctx is done. Formulators that do not
+// implement ContextFormulator can only be cancelled before the formulation starts.
+func FormulateContext(ctx context.Context, f Formulator, query pipeline.Query) ([]cqr.CommonQueryRepresentation, []pipeline.SupplementalData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if cf, ok := f.(ContextFormulator); ok {
+		return cf.FormulateContext(ctx, query)
+	}
+	return f.Formulate(query)
+}
+
 // ConceptualFormulator formulates queries using the title or string of a systematic review.
 type ConceptualFormulator struct {
 	LogicComposer
@@ -258,6 +277,12 @@ func (o ObjectiveFormulator) Formulate(query pipeline.Query) ([]cqr.CommonQueryR
 	return []cqr.CommonQueryRepresentation{q1, q2}, []pipeline.SupplementalData{sup}, nil
 }
 
+// FormulateContext is Formulate, with the requests made to PubMed bound to ctx.
+func (o ObjectiveFormulator) FormulateContext(ctx context.Context, query pipeline.Query) ([]cqr.CommonQueryRepresentation, []pipeline.SupplementalData, error) {
+	o.s = o.s.WithContext(ctx).(stats.EntrezStatisticsSource)
+	return o.Formulate(query)
+}
+
 func (o ObjectiveFormulator) Method() string {
 	return "objective" + o.optimisation.Name()
 }
@@ -331,6 +356,12 @@ func (t ConceptualFormulator) Formulate(query pipeline.Query) ([]cqr.CommonQuery
 	return []cqr.CommonQueryRepresentation{q}, nil, nil
 }
 
+// FormulateContext is Formulate, with the requests made to PubMed bound to ctx.
+func (t ConceptualFormulator) FormulateContext(ctx context.Context, query pipeline.Query) ([]cqr.CommonQueryRepresentation, []pipeline.SupplementalData, error) {
+	t.s = t.s.WithContext(ctx).(stats.EntrezStatisticsSource)
+	return t.Formulate(query)
+}
+
 func (t ConceptualFormulator) Method() string {
 	return "conceptual"
 }
@@ -3,6 +3,7 @@ module github.com/hscells/groove
 go 1.13
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/afjoseph/RAKE.Go v0.0.0-20191109090147-068a9e43b194
 	github.com/alexflint/go-arg v1.0.0
 	github.com/bbalet/stopwords v1.0.0
@@ -40,4 +41,5 @@ require (
 	gonum.org/v1/gonum v0.8.2
 	gopkg.in/cheggaaa/pb.v1 v1.0.28
 	gopkg.in/olivere/elastic.v5 v5.0.86
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -4,6 +4,7 @@ package learning
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/hscells/groove/analysis"
 	"github.com/hscells/groove/combinator"
@@ -44,6 +45,12 @@ type QueryChain struct {
 
 // Generate will create test data sampling using random stratified sampling.
 func (qc *QueryChain) Generate() error {
+	return qc.GenerateContext(context.Background())
+}
+
+// GenerateContext is Generate, stopping between candidates once ctx is done.
+func (qc *QueryChain) GenerateContext(ctx context.Context) error {
+	ss := stats.WithContext(ctx, qc.StatisticsSource)
 	w, err := os.OpenFile(qc.GenerationFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
 	if err != nil {
 		return err
@@ -70,11 +77,23 @@ func (qc *QueryChain) Generate() error {
 	}
 
 	for _, cq := range qc.Queries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		c := make(chan GenerationResult)
 
 		go qc.GenerationExplorer.Traverse(NewCandidateQuery(cq.Query, cq.Topic, nil), c)
 
 		for result := range c {
+			if err := ctx.Err(); err != nil {
+				// Let the explorer finish without anyone waiting on it.
+				go func() {
+					for range c {
+					}
+				}()
+				return err
+			}
 
 			if result.error != nil {
 				return result.error
@@ -97,7 +116,7 @@ func (qc *QueryChain) Generate() error {
 
 			gq := pipeline.NewQuery(cq.Name, cq.Topic, candidate.Query)
 
-			tree, _, err := combinator.NewLogicalTree(gq, qc.StatisticsSource, qc.QueryCacher)
+			tree, _, err := combinator.NewLogicalTree(gq, ss, qc.QueryCacher)
 			if err != nil {
 				return err
 			}
@@ -140,6 +159,11 @@ func (qc *QueryChain) Generate() error {
 }
 
 func (qc *QueryChain) Test() error {
+	return qc.TestContext(context.Background())
+}
+
+// TestContext is Test, stopping between queries and transformations once ctx is done.
+func (qc *QueryChain) TestContext(ctx context.Context) error {
 	// Create directory if not exists.
 	err := os.MkdirAll(qc.TransformedOutput, 0777)
 	if err != nil {
@@ -147,6 +171,9 @@ func (qc *QueryChain) Test() error {
 	}
 
 	for _, q := range qc.Queries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		p := path.Join(qc.TransformedOutput, q.Topic)
 
 		// Do not process if the file already exists.
@@ -158,7 +185,7 @@ func (qc *QueryChain) Test() error {
 		log.Println(fmt.Sprintf("starting topic %s", q.Topic))
 
 		// Perform the query chain process on the query.
-		tq, err := qc.Execute(q)
+		tq, err := qc.ExecuteContext(ctx, q)
 		if err != nil {
 			return err
 		}
@@ -184,6 +211,14 @@ func (qc *QueryChain) Train() error {
 	return err
 }
 
+// TrainContext is Train, provided ctx is not already done.
+func (qc *QueryChain) TrainContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return qc.Train()
+}
+
 func (qc *QueryChain) Validate() error {
 	log.Println("WARN: validation of query chain happens inside candidate selector")
 	return nil
@@ -220,16 +255,25 @@ func NewQueryChain(selector QueryChainCandidateSelector, ss stats.StatisticsSour
 // in order to see if the chain should continue or not. At the end of the chain, the selector is cleaned using the
 // finalise method.
 func (qc *QueryChain) Execute(q pipeline.Query) (CandidateQuery, error) {
+	return qc.ExecuteContext(context.Background(), q)
+}
+
+// ExecuteContext is Execute, stopping at the next transition point once ctx is done.
+func (qc *QueryChain) ExecuteContext(ctx context.Context, q pipeline.Query) (CandidateQuery, error) {
 	var (
 		stop bool
 	)
+	ss := stats.WithContext(ctx, qc.StatisticsSource)
 	cq := NewCandidateQuery(q.Query, q.Topic, nil)
 	sel := qc.CandidateSelector
 	stop = sel.StoppingCriteria()
 	d := 0
 	for !stop {
+		if err := ctx.Err(); err != nil {
+			return CandidateQuery{}, err
+		}
 		log.Println("generating candidates...")
-		candidates, err := Variations(cq, qc.StatisticsSource, qc.MeasurementExecutor, qc.Measurements, qc.Transformations...)
+		candidates, err := Variations(cq, ss, qc.MeasurementExecutor, qc.Measurements, qc.Transformations...)
 		if err != nil {
 			return CandidateQuery{}, err
 		}
@@ -1,5 +1,7 @@
 package learning
 
+import "context"
+
 // Model is an abstract representation of a machine learning model that can perform a training
 // and a testing task. Optionally, the model may also have a validation task.
 // Additionally, a model must implement how Features for training are generated.
@@ -14,6 +16,18 @@ type Model interface {
 	Generate() error
 }
 
+// ContextModel is a model whose tasks can be cancelled using a context. Each task returns the error of the context
+// once it is done.
+type ContextModel interface {
+	Model
+	// TrainContext is Train, bound to ctx.
+	TrainContext(ctx context.Context) error
+	// TestContext is Test, bound to ctx.
+	TestContext(ctx context.Context) error
+	// GenerateContext is Generate, bound to ctx.
+	GenerateContext(ctx context.Context) error
+}
+
 // FeatureGenerator models a way for Features to be generated for a machine learning task that
 // may be used by a Model.
 type FeatureGenerator interface {
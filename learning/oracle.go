@@ -86,7 +86,7 @@ func (oc OracleQueryChainCandidateSelector) Select(query CandidateQuery, candida
 	oc.prevRet = oc.bestRet
 
 	if oc.seen == nil {
-		oc.seen = combinator.NewMapQueryCache()
+		oc.seen = combinator.NewSubtreeQueryCache(nil, 0)
 	}
 
 	if oc.minResults == 0 {
@@ -30,6 +30,25 @@ type TransformationSampler struct {
 	TransformationStrategy
 }
 
+// uniqueCandidates removes candidates whose queries are equivalent (see combinator.Canonicalise) to an earlier
+// candidate of the same topic, so that the same query is not sampled (or evaluated) twice.
+func uniqueCandidates(candidates []CandidateQuery) []CandidateQuery {
+	type key struct {
+		topic string
+		hash  uint64
+	}
+	seen := make(map[key]bool, len(candidates))
+	unique := make([]CandidateQuery, 0, len(candidates))
+	for _, candidate := range candidates {
+		k := key{topic: candidate.Topic, hash: combinator.CanonicalHash(candidate.Query)}
+		if !seen[k] {
+			seen[k] = true
+			unique = append(unique, candidate)
+		}
+	}
+	return unique
+}
+
 func BalancedTransformationStrategy(candidates []CandidateQuery, N int) []CandidateQuery {
 	// Sort the candidates by transformation ID.
 	sort.Slice(candidates, func(i, j int) bool {
@@ -120,6 +139,8 @@ func StratifiedTransformationStrategy(candidates []CandidateQuery, N int) []Cand
 }
 
 func (s TransformationSampler) Sample(candidates []CandidateQuery) ([]CandidateQuery, error) {
+	candidates = uniqueCandidates(candidates)
+
 	// Compute the number of candidates to sample.
 	N := int(s.delta*float64(len(candidates))) + s.n
 
@@ -150,6 +171,8 @@ type RandomSampler struct {
 }
 
 func (s RandomSampler) Sample(candidates []CandidateQuery) ([]CandidateQuery, error) {
+	candidates = uniqueCandidates(candidates)
+
 	// Compute the number of candidates to sample.
 	N := int(s.delta*float64(len(candidates))) + s.n
 
@@ -376,6 +399,8 @@ func MaximalMarginalRelevanceScoredStrategy(lambda float64, similarity func(x, y
 }
 
 func (s EvaluationSampler) Sample(candidates []CandidateQuery) ([]CandidateQuery, error) {
+	candidates = uniqueCandidates(candidates)
+
 	// Compute the number of candidates to sample.
 	N := int(s.delta*float64(len(candidates))) + s.n
 
@@ -505,6 +530,8 @@ func MaximalMarginalRelevanceGreedyStrategy(scores map[string]map[string]float64
 }
 
 func (s GreedySampler) Sample(candidates []CandidateQuery) ([]CandidateQuery, error) {
+	candidates = uniqueCandidates(candidates)
+
 	// Compute the number of candidates to sample.
 	N := int(s.delta*float64(len(candidates))) + s.n
 
@@ -587,6 +614,8 @@ type ClusterSampler struct {
 }
 
 func (s ClusterSampler) Sample(candidates []CandidateQuery) ([]CandidateQuery, error) {
+	candidates = uniqueCandidates(candidates)
+
 	// Compute the number of candidates to sample.
 	N := int(s.delta*float64(len(candidates))) + s.n
 
@@ -0,0 +1,593 @@
+package groove
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const modulePath = "github.com/hscells/groove"
+
+// Manifest describes a run of a pipeline: how the pipeline was configured, which inputs it read, and what it produced.
+// Pipelines with a manifest file (see WriteManifest) write a manifest once they complete, and the run can be
+// reproduced from it (see Rerun).
+type Manifest struct {
+	Version string    `json:"version"`
+	Build   Build     `json:"build"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	// Config is the configuration the pipeline was built from, if it was built from one (see the config package).
+	Config     json.RawMessage    `json:"config,omitempty"`
+	Components Components         `json:"components"`
+	Statistics StatisticsSettings `json:"statistics"`
+	Caches     Caches             `json:"caches"`
+	Inputs     []Input            `json:"inputs"`
+	Outputs    []Output           `json:"outputs"`
+	Failures   []string           `json:"failures,omitempty"`
+}
+
+// Caches describe the caches a pipeline read from and wrote to, so that results read from the caches of earlier runs
+// can be told apart.
+type Caches struct {
+	// MeasurementCache is the directory measurements are cached in, or empty when they are cached in memory.
+	MeasurementCache string `json:"measurement_cache,omitempty"`
+	// StatisticsNamespace is the namespace the results of the statistics source are cached in, when they are cached
+	// (see stats.CachingStatisticsSource).
+	StatisticsNamespace string `json:"statistics_namespace,omitempty"`
+	// QueryCache are the statistics of the query cache when the pipeline completed, if it reports them.
+	QueryCache combinator.CacheStats `json:"query_cache"`
+}
+
+// Build identifies the build of groove a pipeline was run with. The revision is only known when groove is the main
+// module of the binary and it was built from a git checkout.
+type Build struct {
+	Module   string `json:"module"`
+	Revision string `json:"revision,omitempty"`
+	Modified bool   `json:"modified,omitempty"`
+	Go       string `json:"go"`
+}
+
+// Components names the components of a pipeline. Types are named as they are printed by %T, and transformations by
+// the name of their function.
+type Components struct {
+	QueriesSource                string             `json:"queries_source"`
+	Topics                       TopicSelection     `json:"topics"`
+	Schedule                     string             `json:"schedule,omitempty"`
+	Preprocess                   []string           `json:"preprocess,omitempty"`
+	BooleanTransformations       []string           `json:"boolean_transformations,omitempty"`
+	ElasticsearchTransformations []string           `json:"elasticsearch_transformations,omitempty"`
+	Measurements                 []string           `json:"measurements,omitempty"`
+	MeasurementFormatters        []string           `json:"measurement_formatters,omitempty"`
+	MeasurementWorkers           int                `json:"measurement_workers,omitempty"`
+	Evaluations                  []string           `json:"evaluations,omitempty"`
+	EvaluationFormatters         []string           `json:"evaluation_formatters,omitempty"`
+	TrecOutput                   string             `json:"trec_output,omitempty"`
+	QueryCache                   string             `json:"query_cache,omitempty"`
+	QueryFormulator              string             `json:"query_formulator,omitempty"`
+	Model                        string             `json:"model,omitempty"`
+	ModelConfiguration           ModelConfiguration `json:"model_configuration"`
+	FailurePolicy                FailurePolicy      `json:"failure_policy"`
+	Checkpoint                   string             `json:"checkpoint,omitempty"`
+	Recompute                    []string           `json:"recompute,omitempty"`
+	CLF                          bool               `json:"clf"`
+}
+
+// StatisticsSettings are the settings of the statistics source, as resolved by the source.
+type StatisticsSettings struct {
+	Source        string              `json:"source"`
+	Parameters    map[string]float64  `json:"parameters"`
+	SearchOptions stats.SearchOptions `json:"search_options"`
+	Concurrency   int                 `json:"concurrency,omitempty"`
+}
+
+// Input is a file read by a pipeline.
+type Input struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// Output is a result produced for a topic. Measurements and evaluations are recorded in full, while the larger results
+// (TREC results, transformations and formulations) are recorded as a digest of their content.
+type Output struct {
+	Topic  string            `json:"topic"`
+	Type   string            `json:"type"`
+	Values map[string]Number `json:"values,omitempty"`
+	Digest string            `json:"digest,omitempty"`
+}
+
+// Number is a float that can also be NaN or infinite in JSON, which some measurements are.
+type Number float64
+
+// MarshalJSON encodes NaN and infinities as the strings "NaN", "+Inf" and "-Inf".
+func (n Number) MarshalJSON() ([]byte, error) {
+	f := float64(n)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return json.Marshal(strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	return json.Marshal(f)
+}
+
+// UnmarshalJSON decodes numbers encoded by MarshalJSON.
+func (n *Number) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		f, err := strconv.ParseFloat(s, 64)
+		*n = Number(f)
+		return err
+	}
+	var f float64
+	err := json.Unmarshal(b, &f)
+	*n = Number(f)
+	return err
+}
+
+// outputTypes are the types of results that are recorded as outputs.
+var outputTypes = map[pipeline.ResultType]bool{
+	pipeline.Measurement:    true,
+	pipeline.Evaluation:     true,
+	pipeline.Transformation: true,
+	pipeline.TrecResult:     true,
+	pipeline.Formulation:    true,
+}
+
+// manifestFile is the file the manifest of a run is written to.
+type manifestFile string
+
+// WriteManifest writes the manifest of each run of the pipeline to file, once the run completes.
+func WriteManifest(file string) func() interface{} {
+	return func() interface{} {
+		return manifestFile(file)
+	}
+}
+
+// inputFiles are files read by components of the pipeline.
+type inputFiles []string
+
+// Inputs records files that components of the pipeline read (e.g. the qrels of a query formulator) in the manifest of
+// the pipeline. The query files, the publication dates file and the qrels of the evaluations are always recorded. Files
+// are added to those of earlier Inputs components.
+func Inputs(files ...string) func() interface{} {
+	return func() interface{} {
+		return inputFiles(files)
+	}
+}
+
+// ReadManifest reads a manifest written by a pipeline.
+func ReadManifest(file string) (Manifest, error) {
+	var m Manifest
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
+
+// Write writes the manifest to file.
+func (m Manifest) Write(file string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, b, 0664)
+}
+
+// Rerun runs the pipeline again and compares what it produces with the recorded manifest. Every topic is computed
+// again: checkpoints and existing TREC results are ignored, and nothing is checkpointed. The manifest of the re-run is
+// returned rather than written. The pipeline is usually rebuilt from the configuration in the manifest (see the config
+// package).
+func Rerun(ctx context.Context, p Pipeline, recorded Manifest) (Manifest, []Difference, error) {
+	c := make(chan pipeline.Result)
+	e := newExecution(ctx, p, c)
+	e.manifest = new(Manifest)
+	e.rerun = true
+	go e.execute()
+	for range c {
+	}
+	if err := ctx.Err(); err != nil {
+		return *e.manifest, nil, err
+	}
+	return *e.manifest, recorded.Diff(*e.manifest), nil
+}
+
+// describe records the version of groove, the components and statistics source of the pipeline, and its inputs.
+func (m *Manifest) describe(p Pipeline) {
+	m.Version = Version
+	m.Build = build()
+	m.Start = time.Now()
+	m.Config = p.Configuration
+
+	c := Components{
+		QueriesSource:      typeName(p.QueriesSource),
+		Topics:             p.Topics,
+		MeasurementWorkers: p.MeasurementWorkers,
+		TrecOutput:         p.OutputTrec.Path,
+		QueryCache:         typeName(p.QueryCache),
+		Model:              typeName(p.Model),
+		ModelConfiguration: p.ModelConfiguration,
+		FailurePolicy:      p.FailurePolicy,
+		Checkpoint:         typeName(p.Checkpoint),
+		Recompute:          p.Recompute,
+		CLF:                p.CLF.CLF,
+	}
+	if p.Schedule != nil {
+		c.Schedule = funcName(p.Schedule)
+	}
+	for _, f := range p.Preprocess {
+		c.Preprocess = append(c.Preprocess, funcName(f))
+	}
+	for _, t := range p.Transformations.BooleanTransformations {
+		c.BooleanTransformations = append(c.BooleanTransformations, funcName(t))
+	}
+	for _, t := range p.Transformations.ElasticsearchTransformations {
+		c.ElasticsearchTransformations = append(c.ElasticsearchTransformations, funcName(t))
+	}
+	for _, measurement := range p.Measurements {
+		c.Measurements = append(c.Measurements, measurement.Name())
+	}
+	for _, f := range p.MeasurementFormatters {
+		c.MeasurementFormatters = append(c.MeasurementFormatters, funcName(f))
+	}
+	for _, evaluator := range p.Evaluations {
+		c.Evaluations = append(c.Evaluations, evaluator.Name())
+	}
+	for _, f := range p.EvaluationFormatters.EvaluationFormatters {
+		c.EvaluationFormatters = append(c.EvaluationFormatters, funcName(f))
+	}
+	if p.QueryFormulator != nil {
+		c.QueryFormulator = p.QueryFormulator.Method()
+	}
+	m.Components = c
+
+	if p.StatisticsSource != nil {
+		m.Statistics = StatisticsSettings{
+			Source:        typeName(p.StatisticsSource),
+			Parameters:    p.StatisticsSource.Parameters(),
+			SearchOptions: p.StatisticsSource.SearchOptions(),
+		}
+		if l, ok := p.StatisticsSource.(stats.ConcurrencyLimiter); ok {
+			m.Statistics.Concurrency = l.Concurrency()
+		}
+	}
+
+	var files []string
+	if len(p.QueryPath) > 0 {
+		queryFiles, err := listFiles(p.QueryPath)
+		if err != nil {
+			log.Printf("could not list the query files for the manifest: %v\n", err)
+		}
+		files = append(files, queryFiles...)
+	}
+	for _, file := range append([]string{p.PubDatesFile, p.EvaluationFormatters.QrelsFile}, p.InputFiles...) {
+		if len(file) > 0 {
+			files = append(files, file)
+		}
+	}
+	for _, file := range files {
+		input, err := hashFile(file)
+		if err != nil {
+			log.Printf("could not hash %s for the manifest: %v\n", file, err)
+			continue
+		}
+		m.Inputs = append(m.Inputs, input)
+	}
+}
+
+// record records a result as an output of the run.
+func (m *Manifest) record(r pipeline.Result) {
+	if !outputTypes[r.Type] {
+		return
+	}
+	o := Output{
+		Topic: r.Topic,
+		Type:  r.Type.String(),
+	}
+	switch r.Type {
+	case pipeline.Measurement:
+		o.Values = numbers(r.Measurements)
+	case pipeline.Evaluation:
+		o.Values = numbers(r.Evaluations)
+	default:
+		var err error
+		o.Digest, err = digest(r)
+		if err != nil {
+			log.Printf("could not digest the %s of topic %v for the manifest: %v\n", r.Type, r.Topic, err)
+		}
+	}
+	m.Outputs = append(m.Outputs, o)
+}
+
+// caches records the caches of the pipeline.
+func (m *Manifest) caches(measurementCache string, ss stats.StatisticsSource, queryCache combinator.QueryCacher) {
+	m.Caches.MeasurementCache = measurementCache
+	if c, ok := ss.(*stats.CachingStatisticsSource); ok {
+		m.Caches.StatisticsNamespace = c.Namespace()
+	}
+	if c, ok := queryCache.(combinator.ObservableQueryCacher); ok {
+		m.Caches.QueryCache = c.Stats()
+	}
+}
+
+// complete records the end of the run and its failures.
+func (m *Manifest) complete(failures []pipeline.StageError) {
+	m.End = time.Now()
+	for _, f := range failures {
+		m.Failures = append(m.Failures, f.Error())
+	}
+	// Results arrive in whichever order the topics complete.
+	sort.SliceStable(m.Outputs, func(i, j int) bool {
+		if m.Outputs[i].Topic != m.Outputs[j].Topic {
+			return m.Outputs[i].Topic < m.Outputs[j].Topic
+		}
+		return m.Outputs[i].Type < m.Outputs[j].Type
+	})
+}
+
+// Kinds of differences between runs.
+const (
+	VersionDifference    = "version"
+	InputDifference      = "input"
+	StatisticsDifference = "statistics"
+	OutputDifference     = "output"
+)
+
+// Difference is something that differs between a recorded run and a re-run of a pipeline. An empty value means the
+// run did not have or produce it.
+type Difference struct {
+	Kind     string `json:"kind"`
+	Topic    string `json:"topic,omitempty"`
+	Name     string `json:"name"`
+	Recorded string `json:"recorded"`
+	Current  string `json:"current"`
+}
+
+func (d Difference) String() string {
+	if len(d.Topic) == 0 {
+		return fmt.Sprintf("%s %s: %q != %q", d.Kind, d.Name, d.Recorded, d.Current)
+	}
+	return fmt.Sprintf("%s %s of topic %s: %q != %q", d.Kind, d.Name, d.Topic, d.Recorded, d.Current)
+}
+
+// Diff lists what differs between the run of the manifest and the run of another manifest: the version of groove,
+// the content of the inputs, the settings of the statistics source, and the outputs.
+func (m Manifest) Diff(other Manifest) []Difference {
+	var diffs []Difference
+	differ := func(kind, topic, name, recorded, current string) {
+		if recorded != current {
+			diffs = append(diffs, Difference{Kind: kind, Topic: topic, Name: name, Recorded: recorded, Current: current})
+		}
+	}
+
+	differ(VersionDifference, "", "version", m.Version, other.Version)
+	differ(VersionDifference, "", "module", m.Build.Module, other.Build.Module)
+	differ(VersionDifference, "", "revision", m.Build.Revision, other.Build.Revision)
+	differ(VersionDifference, "", "modified", strconv.FormatBool(m.Build.Modified), strconv.FormatBool(other.Build.Modified))
+	differ(VersionDifference, "", "go", m.Build.Go, other.Build.Go)
+
+	inputs := make(map[string]string)
+	for _, input := range other.Inputs {
+		inputs[input.Path] = input.SHA256
+	}
+	for _, input := range m.Inputs {
+		differ(InputDifference, "", input.Path, input.SHA256, inputs[input.Path])
+		delete(inputs, input.Path)
+	}
+	for _, file := range sortedKeys(inputs) {
+		differ(InputDifference, "", file, "", inputs[file])
+	}
+
+	s, t := m.Statistics, other.Statistics
+	differ(StatisticsDifference, "", "source", s.Source, t.Source)
+	differ(StatisticsDifference, "", "size", strconv.Itoa(s.SearchOptions.Size), strconv.Itoa(t.SearchOptions.Size))
+	differ(StatisticsDifference, "", "run_name", s.SearchOptions.RunName, t.SearchOptions.RunName)
+	differ(StatisticsDifference, "", "concurrency", strconv.Itoa(s.Concurrency), strconv.Itoa(t.Concurrency))
+	params := make(map[string]string)
+	for k, v := range s.Parameters {
+		params[k] = formatFloat(v)
+	}
+	for k, v := range t.Parameters {
+		differ(StatisticsDifference, "", k, params[k], formatFloat(v))
+		delete(params, k)
+	}
+	for _, k := range sortedKeys(params) {
+		differ(StatisticsDifference, "", k, params[k], "")
+	}
+
+	type key struct{ topic, kind string }
+	outputs := make(map[key]Output)
+	for _, o := range other.Outputs {
+		outputs[key{o.Topic, o.Type}] = o
+	}
+	for _, o := range m.Outputs {
+		k := key{o.Topic, o.Type}
+		current, ok := outputs[k]
+		delete(outputs, k)
+		if !ok {
+			differ(OutputDifference, o.Topic, o.Type, o.Type, "")
+			continue
+		}
+		differ(OutputDifference, o.Topic, o.Type, o.Digest, current.Digest)
+		values := make(map[string]string)
+		for name, v := range current.Values {
+			values[name] = formatFloat(float64(v))
+		}
+		for _, name := range sortedNumberKeys(o.Values) {
+			differ(OutputDifference, o.Topic, name, formatFloat(float64(o.Values[name])), values[name])
+			delete(values, name)
+		}
+		for _, name := range sortedKeys(values) {
+			differ(OutputDifference, o.Topic, name, "", values[name])
+		}
+	}
+	for _, o := range other.Outputs {
+		if _, ok := outputs[key{o.Topic, o.Type}]; ok {
+			differ(OutputDifference, o.Topic, o.Type, "", o.Type)
+		}
+	}
+	return diffs
+}
+
+// build identifies the build of groove that is running.
+func build() Build {
+	v := Build{Go: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v
+	}
+	if info.Main.Path == modulePath {
+		v.Module = info.Main.Version
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				v.Revision = s.Value
+			case "vcs.modified":
+				v.Modified = s.Value == "true"
+			}
+		}
+		return v
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			v.Module = dep.Version
+			if dep.Replace != nil {
+				v.Module = dep.Replace.Path + "@" + dep.Replace.Version
+			}
+		}
+	}
+	return v
+}
+
+// listFiles lists the files in a directory of queries (or the file itself, if it is not a directory), as they are
+// read by the query sources.
+func listFiles(queryPath string) ([]string, error) {
+	info, err := os.Stat(queryPath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{queryPath}, nil
+	}
+	infos, err := ioutil.ReadDir(queryPath)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, f := range infos {
+		if !f.IsDir() {
+			files = append(files, path.Join(queryPath, f.Name()))
+		}
+	}
+	return files, nil
+}
+
+func hashFile(file string) (Input, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return Input{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return Input{}, err
+	}
+	return Input{Path: file, SHA256: hex.EncodeToString(h.Sum(nil)), Size: n}, nil
+}
+
+// digest hashes the content of a TREC result, transformation or formulation.
+func digest(r pipeline.Result) (string, error) {
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	switch r.Type {
+	case pipeline.TrecResult:
+		if err := enc.Encode(r.TrecResults); err != nil {
+			return "", err
+		}
+	case pipeline.Transformation:
+		if err := enc.Encode(r.Transformation); err != nil {
+			return "", err
+		}
+	case pipeline.Formulation:
+		if err := enc.Encode(r.Formulation.Queries); err != nil {
+			return "", err
+		}
+		for _, s := range r.Formulation.Sup {
+			for _, d := range s.Data {
+				b, err := d.Value.Marshal()
+				if err != nil {
+					return "", err
+				}
+				h.Write([]byte(s.Name + "/" + d.Name))
+				h.Write(b)
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func numbers(values map[string]float64) map[string]Number {
+	n := make(map[string]Number, len(values))
+	for k, v := range values {
+		n[k] = Number(v)
+	}
+	return n
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedNumberKeys(m map[string]Number) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func typeName(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// funcName is the name of a function, or the type of a value that is not a function.
+func funcName(v interface{}) string {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Func {
+		return typeName(v)
+	}
+	if f := runtime.FuncForPC(rv.Pointer()); f != nil {
+		return f.Name()
+	}
+	return typeName(v)
+}
@@ -0,0 +1,128 @@
+package groove
+
+import (
+	"context"
+	"github.com/hscells/groove/output"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestManifestDiffStatistics(t *testing.T) {
+	recorded := Manifest{
+		Version: Version,
+		Build:   Build{Module: "v1.0.0", Go: "go1.13"},
+		Inputs:  []Input{{Path: "queries/1", SHA256: "abc", Size: 3}},
+		Statistics: StatisticsSettings{
+			Source:        "*stats.EntrezStatisticsSource",
+			Parameters:    map[string]float64{"k": 1, "b": 0.75},
+			SearchOptions: stats.SearchOptions{Size: 100, RunName: "run"},
+		},
+		Outputs: []Output{{Topic: "1", Type: "measurement", Values: map[string]Number{"AvgIDF": 2}}},
+	}
+	current := recorded
+	current.Statistics = StatisticsSettings{
+		Source:        "*stats.EntrezStatisticsSource",
+		Parameters:    map[string]float64{"k": 1.2, "mu": 2000},
+		SearchOptions: stats.SearchOptions{Size: 1000, RunName: "run"},
+		Concurrency:   3,
+	}
+
+	expected := []Difference{
+		{Kind: StatisticsDifference, Name: "size", Recorded: "100", Current: "1000"},
+		{Kind: StatisticsDifference, Name: "concurrency", Recorded: "0", Current: "3"},
+		{Kind: StatisticsDifference, Name: "k", Recorded: "1", Current: "1.2"},
+		{Kind: StatisticsDifference, Name: "mu", Recorded: "", Current: "2000"},
+		{Kind: StatisticsDifference, Name: "b", Recorded: "0.75", Current: ""},
+	}
+	diffs := recorded.Diff(current)
+	// The parameters of the current run are compared in the order of its map.
+	if len(diffs) != len(expected) {
+		t.Fatalf("expected %d differences, got %v", len(expected), diffs)
+	}
+	for _, e := range expected {
+		found := false
+		for _, d := range diffs {
+			found = found || d == e
+		}
+		if !found {
+			t.Errorf("expected difference %v, got %v", e, diffs)
+		}
+	}
+	if diffs := recorded.Diff(recorded); len(diffs) != 0 {
+		t.Errorf("expected no differences, got %v", diffs)
+	}
+}
+
+func TestRerun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "manifest.json")
+
+	value := 1.0
+	m := measurementFunc{name: "Value", fn: func(q pipeline.Query, ss stats.StatisticsSource) (float64, error) {
+		if q.Topic == "2" {
+			return math.NaN(), nil
+		}
+		return value, nil
+	}}
+	p := NewGroovePipeline(topics("1", "2"), statisticsSource{},
+		measuring(m),
+		MeasurementOutput(output.JsonMeasurementFormatter),
+		WriteManifest(file))
+	p.QueryPath = "queries"
+	execute(t, context.Background(), p)
+
+	recorded, err := ReadManifest(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded.Outputs) != 2 || recorded.Components.Measurements[0] != "Value" {
+		t.Fatalf("unexpected manifest %+v", recorded)
+	}
+	if c := recorded.Caches; len(c.MeasurementCache) == 0 {
+		t.Errorf("expected the caches to be recorded, got %+v", c)
+	}
+
+	// A re-run that produces the same outputs (including NaN measurements) does not differ, and is not written.
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	current, diffs, err := Rerun(context.Background(), p, recorded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("expected no differences, got %v", diffs)
+	}
+	// The re-run caches measurements and documents in memory, rather than reading them from earlier runs.
+	if current.Caches.MeasurementCache != "" || current.Components.QueryCache != "combinator.MapQueryCache" {
+		t.Errorf("expected the re-run to cache in memory, got %+v and %s", current.Caches, current.Components.QueryCache)
+	}
+	current.Components.QueryCache = recorded.Components.QueryCache
+	if !reflect.DeepEqual(current.Components, recorded.Components) {
+		t.Errorf("expected components %+v, got %+v", recorded.Components, current.Components)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Error("expected the manifest of the re-run not to be written")
+	}
+
+	// A measurement that changes is reported, although the earlier measurement is still cached.
+	value = 2
+	_, diffs, err = Rerun(context.Background(), p, recorded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Difference{{Kind: OutputDifference, Topic: "1", Name: "Value", Recorded: "1", Current: "2"}}
+	if !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("expected differences %v, got %v", expected, diffs)
+	}
+}
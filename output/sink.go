@@ -0,0 +1,344 @@
+package output
+
+import (
+	"encoding/json"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/trecresults"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+)
+
+// ResultSink consumes the results of a groove pipeline. A sink receives every result of the pipeline except Done;
+// once the pipeline completes, the sink is closed instead. Sinks ignore the types of results they do not handle.
+//
+// A pipeline only writes to its sinks from one goroutine at a time.
+type ResultSink interface {
+	// Write consumes a result.
+	Write(result pipeline.Result) error
+	// Close flushes anything buffered by the sink and releases its resources.
+	Close() error
+}
+
+type fanOut []ResultSink
+
+// FanOut writes results to each of the sinks. The result is written to every sink even when one of them fails, and
+// the first error is returned.
+func FanOut(sinks ...ResultSink) ResultSink {
+	return fanOut(sinks)
+}
+
+func (f fanOut) Write(result pipeline.Result) error {
+	var err error
+	for _, s := range f {
+		if e := s.Write(result); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (f fanOut) Close() error {
+	var err error
+	for _, s := range f {
+		if e := s.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// JSONLinesSink writes every result as a line of JSON. Measurements and evaluations that are NaN or infinite are
+// written as the strings "NaN", "+Inf" and "-Inf".
+type JSONLinesSink struct {
+	enc *json.Encoder
+}
+
+type jsonLine struct {
+	Topic          string                          `json:"topic,omitempty"`
+	Type           string                          `json:"type"`
+	Measurements   map[string]interface{}          `json:"measurements,omitempty"`
+	Evaluations    map[string]interface{}          `json:"evaluations,omitempty"`
+	Transformation *pipeline.QueryResult           `json:"transformation,omitempty"`
+	Formulations   []cqr.CommonQueryRepresentation `json:"formulations,omitempty"`
+	Supplemental   map[string]map[string][]byte    `json:"supplemental,omitempty"`
+	TrecResults    trecresults.ResultList          `json:"trec_results,omitempty"`
+	Failures       []string                        `json:"failures,omitempty"`
+	Error          string                          `json:"error,omitempty"`
+}
+
+// NewJSONLinesSink creates a sink that writes JSON lines to w. Closing the sink does not close w.
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{enc: json.NewEncoder(w)}
+}
+
+// Write writes the result as a line of JSON.
+func (s *JSONLinesSink) Write(result pipeline.Result) error {
+	l := jsonLine{
+		Topic:        result.Topic,
+		Type:         result.Type.String(),
+		Measurements: jsonFloats(result.Measurements),
+		Evaluations:  jsonFloats(result.Evaluations),
+	}
+	switch result.Type {
+	case pipeline.Transformation:
+		l.Transformation = &result.Transformation
+	case pipeline.Formulation:
+		l.Formulations = result.Formulation.Queries
+		for _, sup := range result.Formulation.Sup {
+			if l.Supplemental == nil {
+				l.Supplemental = make(map[string]map[string][]byte)
+			}
+			data := make(map[string][]byte)
+			for _, d := range sup.Data {
+				b, err := d.Value.Marshal()
+				if err != nil {
+					return err
+				}
+				data[d.Name] = b
+			}
+			l.Supplemental[sup.Name] = data
+		}
+	case pipeline.TrecResult:
+		if result.TrecResults != nil {
+			l.TrecResults = *result.TrecResults
+		}
+	}
+	for _, f := range result.Failures {
+		l.Failures = append(l.Failures, f.Error())
+	}
+	if result.Error != nil {
+		l.Error = result.Error.Error()
+	}
+	return s.enc.Encode(l)
+}
+
+// Close does nothing, as every result has already been written.
+func (s *JSONLinesSink) Close() error {
+	return nil
+}
+
+// jsonFloats converts values that cannot be encoded as JSON numbers into strings.
+func jsonFloats(values map[string]float64) map[string]interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	m := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			m[k] = strconv.FormatFloat(v, 'g', -1, 64)
+		} else {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// TrecSink appends TREC results to a run file. Since a pipeline skips the topics already in its TREC output, a run
+// that is stopped can be continued by running the pipeline again with the same file.
+type TrecSink struct {
+	f *lazyFile
+}
+
+// NewTrecSink creates a sink that appends to the run file. The file is created when the first results are written.
+func NewTrecSink(file string) *TrecSink {
+	return &TrecSink{f: &lazyFile{name: file, flag: os.O_WRONLY | os.O_CREATE | os.O_APPEND}}
+}
+
+// Write appends the TREC results of a topic to the run file.
+func (s *TrecSink) Write(result pipeline.Result) error {
+	if result.Type != pipeline.TrecResult || result.TrecResults == nil {
+		return nil
+	}
+	b, err := result.TrecResults.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = s.f.Write(b)
+	return err
+}
+
+// Close closes the run file.
+func (s *TrecSink) Close() error {
+	return s.f.Close()
+}
+
+// MeasurementSink collects the measurements of every topic and formats them as a single table once the pipeline
+// completes. Measurements are in the order of the topics; a measurement missing for a topic is NaN.
+type MeasurementSink struct {
+	w         io.Writer
+	formatter MeasurementFormatter
+	topics    []string
+	values    []map[string]float64
+}
+
+// NewMeasurementSink creates a sink that writes measurements to w, formatted by the formatter. Closing the sink does
+// not close w.
+func NewMeasurementSink(w io.Writer, formatter MeasurementFormatter) *MeasurementSink {
+	return &MeasurementSink{w: w, formatter: formatter}
+}
+
+// NewCSVMeasurementSink creates a sink that writes measurements to w as a CSV table with a row for each topic.
+func NewCSVMeasurementSink(w io.Writer) *MeasurementSink {
+	return NewMeasurementSink(w, CsvMeasurementFormatter)
+}
+
+// Write collects the measurements of a topic.
+func (s *MeasurementSink) Write(result pipeline.Result) error {
+	if result.Type != pipeline.Measurement {
+		return nil
+	}
+	s.topics = append(s.topics, result.Topic)
+	s.values = append(s.values, result.Measurements)
+	return nil
+}
+
+// Close formats and writes the measurements, if there are any.
+func (s *MeasurementSink) Close() error {
+	seen := make(map[string]bool)
+	var headers []string
+	for _, values := range s.values {
+		for name := range values {
+			if !seen[name] {
+				seen[name] = true
+				headers = append(headers, name)
+			}
+		}
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+	sort.Strings(headers)
+
+	data := make([][]float64, len(headers))
+	for i, name := range headers {
+		data[i] = make([]float64, len(s.topics))
+		for j, values := range s.values {
+			v, ok := values[name]
+			if !ok {
+				v = math.NaN()
+			}
+			data[i][j] = v
+		}
+	}
+	out, err := s.formatter(s.topics, headers, data)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(s.w, out)
+	return err
+}
+
+// EvaluationSink collects the evaluations of every topic and formats them once the pipeline completes.
+type EvaluationSink struct {
+	w           io.Writer
+	formatter   EvaluationFormatter
+	evaluations map[string]map[string]float64
+}
+
+// NewEvaluationSink creates a sink that writes evaluations to w, formatted by the formatter. Closing the sink does not
+// close w.
+func NewEvaluationSink(w io.Writer, formatter EvaluationFormatter) *EvaluationSink {
+	return &EvaluationSink{w: w, formatter: formatter, evaluations: make(map[string]map[string]float64)}
+}
+
+// Write collects the evaluations of a topic.
+func (s *EvaluationSink) Write(result pipeline.Result) error {
+	if result.Type != pipeline.Evaluation {
+		return nil
+	}
+	s.evaluations[result.Topic] = result.Evaluations
+	return nil
+}
+
+// Close formats and writes the evaluations, if there are any.
+func (s *EvaluationSink) Close() error {
+	if len(s.evaluations) == 0 {
+		return nil
+	}
+	out, err := s.formatter(s.evaluations)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(s.w, out)
+	return err
+}
+
+// NewDirectorySink creates a sink that writes the results of a run to files in a directory, creating the directory if
+// it does not exist. The files are only created for results the run produces:
+//
+//	measurements.json  measurements of each topic (JsonMeasurementFormatter)
+//	measurements.csv   measurements of each topic (CsvMeasurementFormatter)
+//	evaluations.json   evaluations of each topic (JsonEvaluationFormatter)
+//	run.trec           TREC results, appended to an existing run
+//	results.jsonl      every result, including transformations, formulations and errors (see JSONLinesSink)
+func NewDirectorySink(dir string) (ResultSink, error) {
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		return nil, err
+	}
+	create := func(name string) *lazyFile {
+		return &lazyFile{name: path.Join(dir, name), flag: os.O_WRONLY | os.O_CREATE | os.O_TRUNC}
+	}
+	var (
+		measurementsJSON = create("measurements.json")
+		measurementsCSV  = create("measurements.csv")
+		evaluations      = create("evaluations.json")
+		results          = create("results.jsonl")
+	)
+	return FanOut(
+		Closing(NewMeasurementSink(measurementsJSON, JsonMeasurementFormatter), measurementsJSON),
+		Closing(NewCSVMeasurementSink(measurementsCSV), measurementsCSV),
+		Closing(NewEvaluationSink(evaluations, JsonEvaluationFormatter), evaluations),
+		NewTrecSink(path.Join(dir, "run.trec")),
+		Closing(NewJSONLinesSink(results), results),
+	), nil
+}
+
+type closing struct {
+	ResultSink
+	c io.Closer
+}
+
+// Closing closes c (e.g. the file the sink writes to) once the sink is closed.
+func Closing(sink ResultSink, c io.Closer) ResultSink {
+	return closing{sink, c}
+}
+
+func (c closing) Close() error {
+	err := c.ResultSink.Close()
+	if e := c.c.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// lazyFile is a file that is only opened once it is written to.
+type lazyFile struct {
+	name string
+	flag int
+	f    *os.File
+}
+
+func (l *lazyFile) Write(p []byte) (int, error) {
+	if l.f == nil {
+		f, err := os.OpenFile(l.name, l.flag, 0664)
+		if err != nil {
+			return 0, err
+		}
+		l.f = f
+	}
+	return l.f.Write(p)
+}
+
+func (l *lazyFile) Close() error {
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
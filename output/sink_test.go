@@ -0,0 +1,146 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/trecresults"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func results() []pipeline.Result {
+	return []pipeline.Result{
+		{Topic: "1", Measurements: map[string]float64{"AvgIDF": 1.5, "SumIDF": 3}, Type: pipeline.Measurement},
+		{Topic: "2", Measurements: map[string]float64{"AvgIDF": 2, "SumIDF": 4}, Type: pipeline.Measurement},
+		{Topic: "1", Evaluations: map[string]float64{"F1Measure": 0.5}, Type: pipeline.Evaluation},
+		{Topic: "1", TrecResults: &trecresults.ResultList{{Topic: "1", DocId: "123", Rank: 1, Score: 2, RunName: "run"}}, Type: pipeline.TrecResult},
+		{Topic: "1", Transformation: pipeline.QueryResult{Topic: "1", Name: "q", Transformation: cqr.NewKeyword("heart", "title")}, Type: pipeline.Transformation},
+		{Topic: "2", Error: errors.New("failed"), Type: pipeline.Error},
+		{Failures: []pipeline.StageError{{Topic: "2", Stage: pipeline.MeasurementStage, Err: errors.New("failed")}}, Type: pipeline.Summary},
+	}
+}
+
+func write(t *testing.T, s ResultSink) {
+	for _, r := range results() {
+		if err := s.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirectorySink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The TREC results of an earlier run are kept.
+	if err := ioutil.WriteFile(path.Join(dir, "run.trec"), []byte("0 0 1 1 1 run\n"), 0664); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewDirectorySink(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, s)
+
+	read := func(name string) string {
+		b, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	if csv := read("measurements.csv"); csv != "Topic,AvgIDF,SumIDF\n1,1.5,3\n2,2,4\n" {
+		t.Errorf("unexpected measurements.csv:\n%s", csv)
+	}
+	if trec := read("run.trec"); trec != "0 0 1 1 1 run\n1 0 123 1 2 run\n" {
+		t.Errorf("unexpected run.trec:\n%s", trec)
+	}
+	var evaluations map[string]map[string]float64
+	if err := json.Unmarshal([]byte(read("evaluations.json")), &evaluations); err != nil {
+		t.Fatal(err)
+	}
+	if evaluations["1"]["F1Measure"] != 0.5 {
+		t.Errorf("unexpected evaluations %v", evaluations)
+	}
+
+	lines := strings.Split(strings.TrimSpace(read("results.jsonl")), "\n")
+	if len(lines) != len(results()) {
+		t.Fatalf("expected %d lines, got %d", len(results()), len(lines))
+	}
+	var summary struct {
+		Type     string
+		Failures []string
+	}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Type != "summary" || len(summary.Failures) != 1 || summary.Failures[0] != "topic 2, measurement: failed" {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+
+	// Files are only created for the results that were produced.
+	s, err = NewDirectorySink(path.Join(dir, "empty"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := ioutil.ReadDir(path.Join(dir, "empty")); len(files) != 0 {
+		t.Errorf("expected no files, got %d", len(files))
+	}
+}
+
+func TestJSONLinesSink(t *testing.T) {
+	var b bytes.Buffer
+	s := NewJSONLinesSink(&b)
+	if err := s.Write(pipeline.Result{Topic: "1", Measurements: map[string]float64{"a": math.NaN()}, Type: pipeline.Measurement}); err != nil {
+		t.Fatal(err)
+	}
+	if line := strings.TrimSpace(b.String()); line != `{"topic":"1","type":"measurement","measurements":{"a":"NaN"}}` {
+		t.Errorf("unexpected line %s", line)
+	}
+}
+
+type failingSink struct {
+	closed bool
+}
+
+func (f *failingSink) Write(pipeline.Result) error {
+	return errors.New("write")
+}
+
+func (f *failingSink) Close() error {
+	f.closed = true
+	return errors.New("close")
+}
+
+func TestFanOut(t *testing.T) {
+	var b bytes.Buffer
+	f := &failingSink{}
+	s := FanOut(f, NewCSVMeasurementSink(&b))
+	if err := s.Write(results()[0]); err == nil || err.Error() != "write" {
+		t.Errorf("expected the write error, got %v", err)
+	}
+	if err := s.Close(); err == nil || err.Error() != "close" {
+		t.Errorf("expected the close error, got %v", err)
+	}
+	if !f.closed || b.String() != "Topic,AvgIDF,SumIDF\n1,1.5,3\n" {
+		t.Errorf("expected every sink to be written and closed, got %q", b.String())
+	}
+}
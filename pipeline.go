@@ -3,9 +3,10 @@ package groove
 
 import (
 	"bytes"
-	"fmt"
-	"github.com/google/uuid"
+	"context"
+	"encoding/json"
 	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/checkpoint"
 	"github.com/hscells/groove/combinator"
 	"github.com/hscells/groove/eval"
 	"github.com/hscells/groove/formulation"
@@ -18,13 +19,7 @@ import (
 	"github.com/hscells/groove/stats"
 	"github.com/hscells/headway"
 	"github.com/hscells/trecresults"
-	"github.com/peterbourgon/diskv"
 	"io/ioutil"
-	"log"
-	"os"
-	"path"
-	"runtime"
-	"sort"
 )
 
 // Pipeline contains all the information for executing a pipeline for query analysis.
@@ -32,12 +27,15 @@ type Pipeline struct {
 	QueryPath             string
 	PubDatesFile          string
 	QueriesSource         query.QueriesSource
+	Topics                TopicSelection
+	Schedule              Scheduler
 	StatisticsSource      stats.StatisticsSource
 	Preprocess            []preprocess.QueryProcessor
 	Transformations       preprocess.QueryTransformations
 	Measurements          []analysis.Measurement
 	MeasurementFormatters []output.MeasurementFormatter
 	MeasurementExecutor   analysis.MeasurementExecutor
+	MeasurementWorkers    int
 	Evaluations           []eval.Evaluator
 	EvaluationFormatters  EvaluationOutputFormat
 	OutputTrec            output.TrecResults
@@ -46,6 +44,14 @@ type Pipeline struct {
 	ModelConfiguration    ModelConfiguration
 	QueryFormulator       formulation.Formulator
 	Headway               *headway.Client
+	FailurePolicy         FailurePolicy
+	Checkpoint            checkpoint.Store
+	Recompute             []string
+	ManifestFile          string
+	InputFiles            []string
+
+	// Configuration is the configuration the pipeline was built from, which is recorded in its manifest.
+	Configuration json.RawMessage
 
 	CLF rank.CLFOptions
 }
@@ -61,6 +67,7 @@ type ModelConfiguration struct {
 type EvaluationOutputFormat struct {
 	EvaluationFormatters []output.EvaluationFormatter
 	EvaluationQrels      trecresults.QrelsFile
+	QrelsFile            string
 }
 
 // Preprocess adds preprocessors to the pipeline.
@@ -70,19 +77,31 @@ func Preprocess(processor ...preprocess.QueryProcessor) func() interface{} {
 	}
 }
 
-//// Measurement adds measurements to the pipeline.
-//func Measurement(measurements ...analysis.Measurement) func() interface{} {
-//	return func() interface{} {
-//		return measurements
-//	}
-//}
-//
-//// Evaluation adds evaluation measures to the pipeline.
-//func Evaluation(measures ...eval.Evaluator) func() interface{} {
-//	return func() interface{} {
-//		return measures
-//	}
-//}
+// Measurement adds measurements to the pipeline.
+func Measurement(measurements ...analysis.Measurement) func() interface{} {
+	return func() interface{} {
+		return measurements
+	}
+}
+
+// Evaluation adds evaluation measures to the pipeline.
+func Evaluation(measures ...eval.Evaluator) func() interface{} {
+	return func() interface{} {
+		return measures
+	}
+}
+
+// measurementWorkers is the number of topics measured at once.
+type measurementWorkers int
+
+// MeasurementWorkers sets the number of topics that are measured at once. By default, runtime.NumCPU() topics are
+// measured at once. The number of workers is further limited by the concurrency of the statistics source, if it has a
+// limit (see stats.ConcurrencyLimiter).
+func MeasurementWorkers(n int) func() interface{} {
+	return func() interface{} {
+		return measurementWorkers(n)
+	}
+}
 
 // MeasurementOutput adds outputs to the pipeline.
 func MeasurementOutput(formatter ...output.MeasurementFormatter) func() interface{} {
@@ -114,10 +133,51 @@ func EvaluationOutput(qrels string, formatters ...output.EvaluationFormatter) fu
 		return EvaluationOutputFormat{
 			EvaluationQrels:      f,
 			EvaluationFormatters: formatters,
+			QrelsFile:            qrels,
 		}
 	}
 }
 
+// QueryCache configures the cache used to store the results of queries.
+func QueryCache(cache combinator.QueryCacher) func() interface{} {
+	return func() interface{} {
+		return cache
+	}
+}
+
+// QueryFormulator configures the formulator used to automatically formulate queries.
+func QueryFormulator(formulator formulation.Formulator) func() interface{} {
+	return func() interface{} {
+		return formulator
+	}
+}
+
+// CLF configures the options for screening (CLF).
+func CLF(options rank.CLFOptions) func() interface{} {
+	return func() interface{} {
+		return options
+	}
+}
+
+// recompute lists the stages that are recomputed.
+type recompute []string
+
+// Checkpoint records the progress of the pipeline in a checkpoint store. Topics that completed a stage in an earlier
+// run with the same store are not computed again; the results they produced are sent through the channel instead.
+func Checkpoint(store checkpoint.Store) func() interface{} {
+	return func() interface{} {
+		return store
+	}
+}
+
+// Recompute forces the stages (e.g. pipeline.MeasurementStage) to be computed again for every topic, even when they
+// were checkpointed by an earlier run.
+func Recompute(stages ...string) func() interface{} {
+	return func() interface{} {
+		return recompute(stages)
+	}
+}
+
 // NewGroovePipeline creates a new groove pipeline. The query source and statistics source are required. Additional
 // components are provided via the optional functional arguments.
 func NewGroovePipeline(qs query.QueriesSource, ss stats.StatisticsSource, components ...func() interface{}) Pipeline {
@@ -135,402 +195,60 @@ func NewGroovePipeline(qs query.QueriesSource, ss stats.StatisticsSource, compon
 			gp.Measurements = v
 		case []output.MeasurementFormatter:
 			gp.MeasurementFormatters = v
+		case measurementWorkers:
+			gp.MeasurementWorkers = int(v)
 		case preprocess.QueryTransformations:
 			gp.Transformations = v
+		case []eval.Evaluator:
+			gp.Evaluations = v
+		case EvaluationOutputFormat:
+			gp.EvaluationFormatters = v
+		case output.TrecResults:
+			gp.OutputTrec = v
+		case rank.CLFOptions:
+			gp.CLF = v
+		case FailurePolicy:
+			gp.FailurePolicy = v
+		case recompute:
+			gp.Recompute = v
+		case checkpoint.Store:
+			gp.Checkpoint = v
+		case combinator.QueryCacher:
+			gp.QueryCache = v
+		case formulation.Formulator:
+			gp.QueryFormulator = v
+		case manifestFile:
+			gp.ManifestFile = string(v)
+		case inputFiles:
+			gp.InputFiles = append(gp.InputFiles, v...)
+		case TopicSelection:
+			gp.Topics = v
+		case Scheduler:
+			gp.Schedule = v
 		}
 	}
 
 	return gp
 }
 
-// Execute runs a groove pipeline for a particular directory of queries.
-//noinspection GoNilness
-func (p Pipeline) Execute(c chan pipeline.Result) {
-	defer close(c)
-	log.Println("starting groove pipeline...")
-
-	p.CLF.Headway = p.Headway
-
-	// TODO this method needs some serious refactoring done to it.
-
-	cacheDir, err := os.UserCacheDir()
-	if err != nil {
-		c <- pipeline.Result{
-			Error: err,
-			Type:  pipeline.Error,
-		}
-		return
-	}
-
-	// Configure caches.
-	statisticsCache := diskv.New(diskv.Options{
-		BasePath:     path.Join(cacheDir, "groove", "statistics_cache"),
-		Transform:    combinator.BlockTransform(8),
-		CacheSizeMax: 4096 * 1024,
-		Compression:  diskv.NewGzipCompression(),
-	})
-
-	if p.QueryCache == nil {
-		p.QueryCache = combinator.NewFileQueryCache(path.Join(cacheDir, "groove", "file_cache"))
-	}
-
-	p.MeasurementExecutor = analysis.NewDiskMeasurementExecutor(statisticsCache)
-
-	// Only perform this section if there are some queries.
-	if len(p.QueryPath) > 0 {
-		log.Println("loading queries...")
-		// Load and process the queries.
-		queries, err := p.QueriesSource.Load(p.QueryPath)
-		if err != nil {
-			c <- pipeline.Result{
-				Error: err,
-				Type:  pipeline.Error,
-			}
-			return
-		}
-
-		// Here we need to configure how the queries are loaded into each learning model.
-		if p.Model != nil {
-			switch m := p.Model.(type) {
-			case *learning.QueryChain:
-				m.Queries = queries
-				m.QueryCacher = p.QueryCache
-				m.MeasurementExecutor = p.MeasurementExecutor
-			}
-		}
-
-		//if len(p.PubDatesFile) > 0 {
-		//	log.Println("adding date restrictions to queries...")
-		//	for i, cq := range queries {
-		//		log.Println(cq.Topic)
-		//		q := preprocess.DateRestrictions(p.PubDatesFile)(cq.Query, cq.Topic)()
-		//		queries[i].Query = q
-		//	}
-		//}
-
-		log.Println("sorting queries by complexity...")
-
-		// Sort the transformed queries by size.
-		sort.Slice(queries, func(i, j int) bool {
-			return len(analysis.QueryBooleanQueries(queries[i].Query)) < len(analysis.QueryBooleanQueries(queries[j].Query))
-		})
-
-		for _, q := range queries {
-			fmt.Printf("%s ", q.Topic)
-		}
-		fmt.Println()
-
-		// This means preprocessing the query.
-		measurementQueries := make([]pipeline.Query, len(queries))
-		topics := make([]string, len(queries))
-		for i, q := range queries {
-			topics[i] = q.Topic
-			// Ensure there is a processed query.
-
-			// And apply the processing if there is any.
-			for _, p := range p.Preprocess {
-				q = pipeline.NewQuery(q.Name, q.Topic, preprocess.ProcessQuery(q.Query, p))
-			}
-
-			// Apply any transformations.
-			for i, t := range p.Transformations.BooleanTransformations {
-				fmt.Println(q.Topic, i)
-				q = pipeline.NewQuery(q.Name, q.Topic, t(q.Query, q.Topic)())
-			}
-			for _, t := range p.Transformations.ElasticsearchTransformations {
-				if s, ok := p.StatisticsSource.(*stats.ElasticsearchStatisticsSource); ok {
-					q = pipeline.NewQuery(q.Name, q.Topic, t(q.Query, s)())
-				} else {
-					log.Fatal("Elasticsearch transformations only work with an Elasticsearch statistics source.")
-				}
-			}
-			measurementQueries[i] = q
-		}
-
-		// Compute measurements for each of the queries.
-		// The measurements are computed in parallel.
-		// Only perform the measurements if there are some measurement formatters to output them to.
-		if len(p.MeasurementFormatters) > 0 {
-			for _, m := range measurementQueries {
-				data := make(map[string]float64)
-				measurements, err := p.MeasurementExecutor.Execute(m, p.StatisticsSource, p.Measurements...)
-				if err != nil {
-					c <- pipeline.Result{
-						Error: err,
-						Type:  pipeline.Error,
-					}
-					return
-				}
-				for i, measurement := range measurements {
-					data[p.Measurements[i].Name()] = measurement
-				}
-				c <- pipeline.Result{
-					Topic:        m.Topic,
-					Measurements: data,
-					Type:         pipeline.Measurement,
-				}
-			}
-		}
-
-		loghw := !(p.Headway == nil)
-		hwName := fmt.Sprintf("groove (%s)", uuid.New().String())
-
-		if (len(p.OutputTrec.Path) > 0 || len(p.EvaluationFormatters.EvaluationFormatters) > 0) && p.CLF.CLF {
-			// Store the measurements to be output later.
-
-			f, err := os.OpenFile(p.OutputTrec.Path, os.O_RDONLY, 0664)
-			if err != nil {
-				c <- pipeline.Result{
-					Error: err,
-					Type:  pipeline.Error,
-				}
-				return
-			}
-
-			r, err := trecresults.ResultsFromReader(f)
-			if err != nil {
-				c <- pipeline.Result{
-					Error: err,
-					Type:  pipeline.Error,
-				}
-				return
-			}
-			f.Close()
-
-			measurements := make(map[string]map[string]float64)
-			for i, q := range measurementQueries {
-				if _, ok := r.Results[q.Topic]; ok {
-					log.Printf("already completed topic %v, so skipping it\n", q.Topic)
-					continue
-				}
-				log.Printf("starting topic %v\n", q.Topic)
-				results, err := rank.CLF(q, p.StatisticsSource.(stats.EntrezStatisticsSource), p.CLF)
-				if err != nil {
-					if loghw {
-						_ = p.Headway.Message(err.Error())
-						_ = p.Headway.Send(float64(i), float64(len(measurementQueries)), hwName, err.Error())
-					}
-					c <- pipeline.Result{
-						Error: err,
-						Type:  pipeline.Error,
-					}
-					return
-				}
-				if loghw {
-					_ = p.Headway.Send(float64(i), float64(len(measurementQueries)), hwName, fmt.Sprintf("[measurement] topic %s", q.Topic))
-				}
-				// Set the evaluation results.
-				if len(p.Evaluations) > 0 {
-					measurements[q.Topic] = eval.Evaluate(p.Evaluations, &results, p.EvaluationFormatters.EvaluationQrels, q.Topic)
-				}
-
-				// MeasurementOutput the trec results.
-				if len(p.OutputTrec.Path) > 0 {
-					c <- pipeline.Result{
-						Topic:       q.Topic,
-						TrecResults: &results,
-						Type:        pipeline.TrecResult,
-					}
-				}
-
-				// Send the transformation through the channel.
-				c <- pipeline.Result{
-					Topic:          q.Topic,
-					Transformation: pipeline.QueryResult{Name: q.Name, Topic: q.Topic, Transformation: q.Query},
-					Type:           pipeline.Transformation,
-				}
-
-				log.Printf("completed topic %v\n", q.Topic)
-			}
-			if loghw {
-				_ = p.Headway.Send(float64(len(measurementQueries)), float64(len(measurementQueries)), hwName, "[measurement] done!")
-			}
-
-		} else if len(p.OutputTrec.Path) > 0 || len(p.EvaluationFormatters.EvaluationFormatters) > 0 {
-			// This section is run concurrently, since the results can sometimes get quite large and we don't want to eat ram.
-
-			// Set the limit to how many goroutines can be run.
-			// http://jmoiron.net/blog/limiting-concurrency-in-go/
-			concurrency := runtime.NumCPU()
-
-			log.Println(p.OutputTrec)
-
-			log.Printf("starting to execute queries with %d goroutines\n", concurrency)
-
-			var r trecresults.ResultFile
-			if _, err := os.Stat(p.OutputTrec.Path); os.IsExist(err) {
-				f, err := os.OpenFile(p.OutputTrec.Path, os.O_RDONLY, 0664)
-				if err != nil {
-					log.Println(err)
-					c <- pipeline.Result{
-						Error: err,
-						Type:  pipeline.Error,
-					}
-					return
-				}
-
-				r, err = trecresults.ResultsFromReader(f)
-				if err != nil {
-					log.Println(err)
-					c <- pipeline.Result{
-						Error: err,
-						Type:  pipeline.Error,
-					}
-					return
-				}
-				f.Close()
-			} else {
-				r = *trecresults.NewResultFile()
-			}
-
-			sem := make(chan bool, concurrency)
-			for i, q := range measurementQueries {
-				sem <- true
-				go func(idx int, query pipeline.Query) {
-					defer func() { <-sem }()
-					if _, ok := r.Results[q.Topic]; ok {
-						log.Printf("already completed topic %v, so skipping it\n", q.Topic)
-						return
-					}
-					if loghw {
-						_ = p.Headway.Send(float64(i)+1, float64(len(measurementQueries)), "EV."+hwName, fmt.Sprintf("%s", query.Topic))
-					}
-					log.Printf("starting topic %v\n", query.Topic)
-
-					//tree, cache, err := combinator.NewLogicalTree(query, p.StatisticsSource, p.QueryCache)
-					//if err != nil {
-					//	c <- pipeline.Result{
-					//		Topic: query.Topic,
-					//		Error: err,
-					//		Type:  pipeline.Error,
-					//	}
-					//	return
-					//}
-					//docIds := tree.Documents(cache)
-					//if err != nil {
-					//	c <- pipeline.Result{
-					//		Topic: query.Topic,
-					//		Error: err,
-					//		Type:  pipeline.Error,
-					//	}
-					//	return
-					//}
-					//trecResults := docIds.Results(query, query.Name)
-					trecResults, err := p.StatisticsSource.Execute(query, p.StatisticsSource.SearchOptions())
-					if err != nil {
-						if loghw {
-							_ = p.Headway.Message(err.Error())
-							_ = p.Headway.Send(float64(i), float64(len(measurementQueries)), hwName, err.Error())
-						}
-						panic(err)
-					}
-
-					// Set the evaluation results.
-					if len(p.Evaluations) > 0 {
-						c <- pipeline.Result{
-							Topic:       query.Topic,
-							Evaluations: eval.Evaluate(p.Evaluations, &trecResults, p.EvaluationFormatters.EvaluationQrels, query.Topic),
-							Type:        pipeline.Evaluation,
-						}
-					}
-
-					// MeasurementOutput the trec results.
-					if len(p.OutputTrec.Path) > 0 {
-						c <- pipeline.Result{
-							Topic:       query.Topic,
-							TrecResults: &trecResults,
-							Type:        pipeline.TrecResult,
-						}
-					}
-
-					// Send the transformation through the channel.
-					c <- pipeline.Result{
-						Topic:          q.Topic,
-						Transformation: pipeline.QueryResult{Name: query.Name, Topic: query.Topic, Transformation: query.Query},
-						Type:           pipeline.Transformation,
-					}
-
-					log.Printf("completed topic %v\n", query.Topic)
-				}(i, q)
-			}
-
-			// Wait until the last goroutine has read from the semaphore.
-			for i := 0; i < cap(sem); i++ {
-				sem <- true
-			}
-		}
-
-		// This part of the pipeline handles query formulation.
-		if p.QueryFormulator != nil {
-			for i, measurementQuery := range measurementQueries {
-				if loghw {
-					p.Headway.Send(float64(i)+1, float64(len(measurementQueries)), "QF."+hwName, fmt.Sprintf("%s - %s", p.QueryFormulator.Method(), measurementQuery.Topic))
-				}
-				// Perform the query formulation.
-				queries, sup, err := p.QueryFormulator.Formulate(measurementQuery)
-				if err != nil {
-					c <- pipeline.Result{
-						Error: err,
-						Type:  pipeline.Error,
-					}
-					return
-				}
-
-				c <- pipeline.Result{
-					Topic: measurementQuery.Topic,
-					Formulation: pipeline.FormulationResut{
-						Queries: queries,
-						Sup:     sup,
-					},
-					Type: pipeline.Formulation,
-				}
-			}
-			if loghw {
-				p.Headway.Message("completed query formulation")
-			}
-		}
-	}
-
-	if p.Model != nil {
-		if p.ModelConfiguration.Generate {
-			log.Println("generating features for model")
-			err := p.Model.Generate()
-			if err != nil {
-				c <- pipeline.Result{
-					Error: err,
-					Type:  pipeline.Error,
-				}
-				return
-			}
-		}
-		if p.ModelConfiguration.Train {
-			log.Println("training model")
-			err := p.Model.Train()
-			if err != nil {
-				c <- pipeline.Result{
-					Error: err,
-					Type:  pipeline.Error,
-				}
-				return
-			}
-		}
-		if p.ModelConfiguration.Test {
-			log.Println("testing model")
-			err := p.Model.Test()
-			if err != nil {
-				c <- pipeline.Result{
-					Error: err,
-					Type:  pipeline.Error,
-				}
-				return
-			}
-
-		}
-	}
+// Execute runs a groove pipeline for a particular directory of queries. The results are written to each of the sinks
+// and sent through c. Either may be omitted: a pipeline that only writes to sinks is executed with a nil channel, and
+// Execute returns once the pipeline completes.
+func (p Pipeline) Execute(c chan pipeline.Result, sinks ...output.ResultSink) {
+	p.ExecuteContext(context.Background(), c, sinks...)
+}
 
-	// Return the formatted results.
-	c <- pipeline.Result{
-		Type: pipeline.Done,
+// ExecuteContext runs a groove pipeline for a particular directory of queries until ctx is done. The context is passed
+// on to every stage of the pipeline and to the requests made by the statistics source. When the pipeline is stopped
+// early, the results gathered so far are sent first, then an error result with the error of ctx. Topics that fail are
+// handled according to the failure policy of the pipeline. The last results sent are always a summary of the failures
+// and then Done. If the pipeline has a manifest file, the manifest of the run is written before Done is sent. Sinks
+// are closed instead of being written Done.
+func (p Pipeline) ExecuteContext(ctx context.Context, c chan pipeline.Result, sinks ...output.ResultSink) {
+	e := newExecution(ctx, p, c)
+	e.sinks = sinks
+	if len(p.ManifestFile) > 0 {
+		e.manifest = new(Manifest)
 	}
-	return
+	e.execute()
 }
@@ -1,6 +1,7 @@
 package pipeline
 
 import (
+	"fmt"
 	"github.com/hscells/cqr"
 	"github.com/hscells/trecresults"
 )
@@ -52,8 +53,64 @@ const (
 	Error
 	// Done indicates the pipeline has completed.
 	Done
+	// Summary lists the topics that failed, and is sent before Done.
+	Summary
 )
 
+var resultTypes = map[ResultType]string{
+	Measurement:    "measurement",
+	Evaluation:     "evaluation",
+	Transformation: "transformation",
+	TrecResult:     "trec",
+	Formulation:    "formulation",
+	Error:          "error",
+	Done:           "done",
+	Summary:        "summary",
+}
+
+// String is the name of the result type.
+func (t ResultType) String() string {
+	if s, ok := resultTypes[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("ResultType(%d)", t)
+}
+
+// Stages of the pipeline that a topic may fail in.
+const (
+	SetupStage       = "setup"
+	LoadStage        = "load"
+	MeasurementStage = "measurement"
+	CLFStage         = "clf"
+	RetrievalStage   = "retrieval"
+	FormulationStage = "formulation"
+	GenerateStage    = "generate"
+	TrainStage       = "train"
+	TestStage        = "test"
+	OutputStage      = "output"
+)
+
+// StageError is an error raised while processing a topic in a stage of the pipeline. Errors that are not specific to a
+// topic (e.g., loading the queries) have an empty topic.
+type StageError struct {
+	Topic string
+	Stage string
+	Err   error
+}
+
+// Error describes where the error occurred.
+func (e StageError) Error() string {
+	if len(e.Topic) == 0 {
+		return fmt.Sprintf("%s: %v", e.Stage, e.Err)
+	}
+	return fmt.Sprintf("topic %s, %s: %v", e.Topic, e.Stage, e.Err)
+}
+
+// Unwrap returns the cause of the error.
+func (e StageError) Unwrap() error {
+	return e.Err
+}
+
 // Result is the output of a groove pipeline.
 type Result struct {
 	Topic          string
@@ -62,6 +119,7 @@ type Result struct {
 	Transformation QueryResult
 	Formulation    FormulationResut
 	TrecResults    *trecresults.ResultList
+	Failures       []StageError
 	Type           ResultType
 	Error          error
 }
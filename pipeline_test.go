@@ -1,7 +1,6 @@
 package groove_test
 
 import (
-	"bytes"
 	"github.com/hscells/groove"
 	"github.com/hscells/groove/analysis/postqpp"
 	"github.com/hscells/groove/analysis/preqpp"
@@ -10,52 +9,51 @@ import (
 	"github.com/hscells/groove/pipeline"
 	"github.com/hscells/groove/query"
 	"github.com/hscells/groove/stats"
-	"io/ioutil"
-	"log"
+	"os"
 	"testing"
 )
 
 func TestName(t *testing.T) {
+	// The queries are measured and retrieved using an Elasticsearch index of MEDLINE.
+	host := os.Getenv("GROOVE_ELASTICSEARCH")
+	if len(host) == 0 {
+		t.Skip("GROOVE_ELASTICSEARCH is not set")
+	}
 
 	// Construct the pipeline.
-	ss, _ := stats.NewElasticsearchStatisticsSource(stats.ElasticsearchHosts("http://localhost:9200"),
+	ss, err := stats.NewElasticsearchStatisticsSource(stats.ElasticsearchHosts(host),
 		stats.ElasticsearchIndex("medline"),
 		stats.ElasticsearchScroll(true),
 		stats.ElasticsearchSearchOptions(stats.SearchOptions{
 			Size:    10000,
 			RunName: "qpp",
 		}))
-	pipelineChannel := make(chan groove.Result)
-	p := pipeline.NewGroovePipeline(
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := groove.NewGroovePipeline(
 		query.NewTransmuteQuerySource(query.MedlineTransmutePipeline), ss,
-		pipeline.Measurement(preqpp.AvgICTF, preqpp.SumIDF, preqpp.AvgIDF, preqpp.StdDevIDF, preqpp.MaxIDF, postqpp.ClarityScore),
-		pipeline.Evaluation(eval.Precision, eval.Recall),
-		pipeline.MeasurementOutput(output.JsonMeasurementFormatter),
-		pipeline.EvaluationOutput("medline.qrels", output.JsonEvaluationFormatter),
-		pipeline.TrecOutput("medline_qpp.results"))
+		groove.Measurement(preqpp.AvgICTF, preqpp.SumIDF, preqpp.AvgIDF, preqpp.StdDevIDF, preqpp.MaxIDF, postqpp.ClarityScore),
+		groove.Evaluation(eval.Precision, eval.Recall),
+		groove.MeasurementOutput(output.JsonMeasurementFormatter),
+		groove.EvaluationOutput("medline.qrels", output.JsonEvaluationFormatter),
+		groove.TrecOutput("medline_qpp.results"))
+	p.QueryPath = "./medline"
+
+	// The measurements, evaluations and TREC results are written to a directory.
+	sink, err := output.NewDirectorySink("medline_qpp")
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	// Execute it on a directory of queries. A pipeline executes queries in parallel.
-	go p.Execute("./medline", pipelineChannel)
+	pipelineChannel := make(chan pipeline.Result)
+	go p.Execute(pipelineChannel, sink)
 
-	for {
-		// Continue until completed.
-		result := <-pipelineChannel
-		if result.Type == groove.Done {
-			break
-		}
-		switch result.Type {
-		case groove.Measurement:
-			// Process the measurement outputs.
-			err := ioutil.WriteFile("medline_qpp.json", bytes.NewBufferString(result.Measurements[0]).Bytes(), 0644)
-			if err != nil {
-				log.Fatal(err)
-			}
-		case groove.Evaluation:
-			// Process the evaluation outputs.
-			err := ioutil.WriteFile("medline_qpp_eval.json", bytes.NewBufferString(result.Evaluations[0]).Bytes(), 0644)
-			if err != nil {
-				log.Fatal(err)
-			}
+	// Continue until completed.
+	for result := range pipelineChannel {
+		if result.Type == pipeline.Error {
+			t.Error(result.Error)
 		}
 	}
 }
@@ -0,0 +1,309 @@
+package groove
+
+import (
+	"context"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"github.com/hscells/trecresults"
+	"os"
+)
+
+// What measurements require of the statistics source.
+const (
+	// RequiresQuery measurements only use the structure of the query.
+	RequiresQuery = "query"
+	// RequiresStatistics measurements use statistics of the collection (e.g. pre-retrieval QPP).
+	RequiresStatistics = "statistics"
+	// RequiresRetrieval measurements retrieve the query (e.g. post-retrieval QPP).
+	RequiresRetrieval = "retrieval"
+)
+
+// Plan is a report of what executing a pipeline would do (see Pipeline.Plan).
+type Plan struct {
+	// Topics is the number of topics loaded.
+	Topics int `json:"topics"`
+	// Stages are the stages the pipeline would run.
+	Stages       []string          `json:"stages"`
+	Measurements []MeasurementPlan `json:"measurements,omitempty"`
+	Queries      []QueryPlan       `json:"queries"`
+	// Calls is the number of requests that would be made to the statistics source, by method.
+	Calls map[string]int `json:"calls"`
+}
+
+// MeasurementPlan describes what a measurement requires of the statistics source.
+type MeasurementPlan struct {
+	Name string `json:"name"`
+	// Requires is one of RequiresQuery, RequiresStatistics or RequiresRetrieval.
+	Requires string `json:"requires"`
+	// Error is the first error the measurement raised while being planned, in which case Requires may be incomplete.
+	Error string `json:"error,omitempty"`
+}
+
+// QueryPlan describes what executing a pipeline would do for a topic.
+type QueryPlan struct {
+	Topic string `json:"topic"`
+	Name  string `json:"name"`
+	// Query is the query after it has been preprocessed and transformed.
+	Query cqr.CommonQueryRepresentation `json:"query"`
+	// Cached are the measurements of the query that are already cached.
+	Cached []string `json:"cached,omitempty"`
+	// Checkpointed are the stages the topic completed in an earlier run, which would not be computed again.
+	Checkpointed []string `json:"checkpointed,omitempty"`
+	// Retrieved reports whether the topic is already in the TREC output, so that it would not be retrieved again.
+	Retrieved bool `json:"retrieved,omitempty"`
+	// QueryCached reports whether the documents the query retrieves are already in the query cache (which is used by
+	// the model of the pipeline).
+	QueryCached bool `json:"query_cached,omitempty"`
+	// Calls is the number of requests that would be made to the statistics source for the topic, by method.
+	Calls map[string]int `json:"calls,omitempty"`
+}
+
+// Plan loads, preprocesses and transforms the queries of the pipeline as Execute would, and reports what executing the
+// pipeline would do without measuring or retrieving anything. Transformations that use the statistics source (e.g.
+// analyse) still make requests to it.
+//
+// Measurements are planned by executing them with a statistics source that records the requests made to it and
+// answers each of them with nothing. Statistics requested in a batch from a stats.BatchStatisticsSource count as one
+// request (e.g. DocumentFrequencies). Requests that depend on what a query retrieves (e.g. the term vectors of the
+// retrieved documents) are not counted, and neither are the requests made by CLF and query formulation. The model of
+// the pipeline is not given the queries.
+func (p Pipeline) Plan(ctx context.Context) (Plan, error) {
+	e := newExecution(ctx, p, nil)
+	e.planning = true
+	defer e.cancel()
+
+	plan := Plan{
+		Calls: make(map[string]int),
+	}
+	if !e.setup() {
+		return plan, e.err()
+	}
+
+	if len(e.QueryPath) > 0 {
+		if e.measures() {
+			plan.Stages = append(plan.Stages, pipeline.MeasurementStage)
+		}
+		if e.retrieves() && e.CLF.CLF {
+			plan.Stages = append(plan.Stages, pipeline.CLFStage)
+		} else if e.retrieves() {
+			plan.Stages = append(plan.Stages, pipeline.RetrievalStage)
+		}
+		if e.QueryFormulator != nil {
+			plan.Stages = append(plan.Stages, pipeline.FormulationStage)
+		}
+	}
+	if e.Model != nil {
+		for _, stage := range []struct {
+			name string
+			run  bool
+		}{
+			{pipeline.GenerateStage, e.ModelConfiguration.Generate},
+			{pipeline.TrainStage, e.ModelConfiguration.Train},
+			{pipeline.TestStage, e.ModelConfiguration.Test},
+		} {
+			if _, ok := e.checkpointed("", stage.name); stage.run && !ok {
+				plan.Stages = append(plan.Stages, stage.name)
+			}
+		}
+	}
+	if len(e.QueryPath) == 0 {
+		return plan, nil
+	}
+
+	queries, ok := e.load()
+	if !ok {
+		return plan, e.err()
+	}
+	plan.Topics = len(queries)
+
+	var r trecresults.ResultFile
+	if _, err := os.Stat(e.OutputTrec.Path); err == nil && e.retrieves() {
+		r, err = e.completed()
+		if err != nil {
+			return plan, err
+		}
+	}
+
+	measurements := make([]MeasurementPlan, len(e.Measurements))
+	for i, m := range e.Measurements {
+		measurements[i] = MeasurementPlan{Name: m.Name(), Requires: RequiresQuery}
+	}
+
+	for _, q := range queries {
+		if err := ctx.Err(); err != nil {
+			return plan, err
+		}
+		qp := QueryPlan{
+			Topic: q.Topic,
+			Name:  q.Name,
+			Query: q.Query,
+			Calls: make(map[string]int),
+		}
+		for _, stage := range plan.Stages {
+			if _, ok := e.checkpointed(q.Topic, stage); ok {
+				qp.Checkpointed = append(qp.Checkpointed, stage)
+			}
+		}
+		checkpointed := func(stage string) bool {
+			for _, s := range qp.Checkpointed {
+				if s == stage {
+					return true
+				}
+			}
+			return false
+		}
+
+		if e.measures() {
+			for i, m := range e.Measurements {
+				calls, err := countRequests(m, q, e.StatisticsSource)
+				measurements[i].require(calls, err)
+				if e.MeasurementExecutor.Cached(q, m) {
+					qp.Cached = append(qp.Cached, m.Name())
+					continue
+				}
+				if checkpointed(pipeline.MeasurementStage) {
+					continue
+				}
+				for method, n := range calls {
+					qp.Calls[method] += n
+				}
+			}
+		}
+
+		if e.QueryCache != nil {
+			_, err := e.QueryCache.Get(q.Query)
+			qp.QueryCached = err == nil
+		}
+
+		if e.retrieves() && !e.CLF.CLF && !checkpointed(pipeline.RetrievalStage) {
+			if _, ok := r.Results[q.Topic]; ok {
+				qp.Retrieved = true
+			} else {
+				qp.Calls["Execute"]++
+			}
+		}
+
+		for method, n := range qp.Calls {
+			plan.Calls[method] += n
+		}
+		plan.Queries = append(plan.Queries, qp)
+	}
+	if e.measures() {
+		plan.Measurements = measurements
+	}
+	return plan, nil
+}
+
+// require records what a measurement required of the statistics source for a query.
+func (m *MeasurementPlan) require(calls map[string]int, err error) {
+	if err != nil && len(m.Error) == 0 {
+		m.Error = err.Error()
+	}
+	switch {
+	case calls["Execute"] > 0 || calls["RetrievalSize"] > 0 || calls["RetrievalSizes"] > 0:
+		m.Requires = RequiresRetrieval
+	case len(calls) > 0 && m.Requires == RequiresQuery:
+		m.Requires = RequiresStatistics
+	}
+}
+
+// countRequests executes a measurement with a statistics source that counts the requests made to it. Requests are
+// counted in batches if the statistics source is a stats.BatchStatisticsSource.
+func countRequests(m analysis.Measurement, q pipeline.Query, ss stats.StatisticsSource) (map[string]int, error) {
+	c := requestCounter{
+		StatisticsSource: ss,
+		calls:            make(map[string]int),
+	}
+	var counter stats.StatisticsSource = c
+	if _, ok := ss.(stats.BatchStatisticsSource); ok {
+		counter = batchRequestCounter{c}
+	}
+	err := recovered(func() error {
+		_, err := m.Execute(q, counter)
+		return err
+	})
+	return c.calls, err
+}
+
+// requestCounter is a statistics source that counts the requests made to it instead of making them. Every statistic
+// is zero and every query retrieves nothing. The search options and parameters are those of the wrapped source.
+type requestCounter struct {
+	stats.StatisticsSource
+	calls map[string]int
+}
+
+func (c requestCounter) TermFrequency(term, field, document string) (float64, error) {
+	c.calls["TermFrequency"]++
+	return 0, nil
+}
+
+func (c requestCounter) TermVector(document string) (stats.TermVector, error) {
+	c.calls["TermVector"]++
+	return nil, nil
+}
+
+func (c requestCounter) DocumentFrequency(term, field string) (float64, error) {
+	c.calls["DocumentFrequency"]++
+	return 0, nil
+}
+
+func (c requestCounter) TotalTermFrequency(term, field string) (float64, error) {
+	c.calls["TotalTermFrequency"]++
+	return 0, nil
+}
+
+func (c requestCounter) InverseDocumentFrequency(term, field string) (float64, error) {
+	c.calls["InverseDocumentFrequency"]++
+	return 0, nil
+}
+
+func (c requestCounter) RetrievalSize(query cqr.CommonQueryRepresentation) (float64, error) {
+	c.calls["RetrievalSize"]++
+	return 0, nil
+}
+
+func (c requestCounter) VocabularySize(field string) (float64, error) {
+	c.calls["VocabularySize"]++
+	return 0, nil
+}
+
+func (c requestCounter) Execute(query pipeline.Query, options stats.SearchOptions) (trecresults.ResultList, error) {
+	c.calls["Execute"]++
+	return nil, nil
+}
+
+func (c requestCounter) CollectionSize() (float64, error) {
+	c.calls["CollectionSize"]++
+	return 0, nil
+}
+
+// batchRequestCounter is a request counter for a batch statistics source, which counts each non-empty batch as one
+// request.
+type batchRequestCounter struct {
+	requestCounter
+}
+
+func (c batchRequestCounter) batch(method string, n int) []float64 {
+	if n > 0 {
+		c.calls[method]++
+	}
+	return make([]float64, n)
+}
+
+func (c batchRequestCounter) DocumentFrequencies(terms []stats.TermField) ([]float64, error) {
+	return c.batch("DocumentFrequencies", len(terms)), nil
+}
+
+func (c batchRequestCounter) TotalTermFrequencies(terms []stats.TermField) ([]float64, error) {
+	return c.batch("TotalTermFrequencies", len(terms)), nil
+}
+
+func (c batchRequestCounter) InverseDocumentFrequencies(terms []stats.TermField) ([]float64, error) {
+	return c.batch("InverseDocumentFrequencies", len(terms)), nil
+}
+
+func (c batchRequestCounter) RetrievalSizes(queries []cqr.CommonQueryRepresentation) ([]float64, error) {
+	return c.batch("RetrievalSizes", len(queries)), nil
+}
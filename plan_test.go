@@ -0,0 +1,131 @@
+package groove
+
+import (
+	"context"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/learning"
+	"github.com/hscells/groove/output"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// batchStatisticsSource is a statistics source that requests statistics in batches.
+type batchStatisticsSource struct {
+	statisticsSource
+}
+
+func (s batchStatisticsSource) WithContext(ctx context.Context) stats.StatisticsSource {
+	s.ctx = ctx
+	return s
+}
+
+func (s batchStatisticsSource) DocumentFrequencies(terms []stats.TermField) ([]float64, error) {
+	return make([]float64, len(terms)), nil
+}
+
+func (s batchStatisticsSource) TotalTermFrequencies(terms []stats.TermField) ([]float64, error) {
+	return make([]float64, len(terms)), nil
+}
+
+func (s batchStatisticsSource) InverseDocumentFrequencies(terms []stats.TermField) ([]float64, error) {
+	return make([]float64, len(terms)), nil
+}
+
+func (s batchStatisticsSource) RetrievalSizes(queries []cqr.CommonQueryRepresentation) ([]float64, error) {
+	return make([]float64, len(queries)), nil
+}
+
+// termStatistics is a measurement of the document frequencies of three terms, and the retrieval size of the query.
+var termStatistics = measurementFunc{name: "TermStatistics", fn: func(q pipeline.Query, ss stats.StatisticsSource) (float64, error) {
+	_, err := stats.DocumentFrequencies(ss, []stats.TermField{{Term: "a", Field: "title"}, {Term: "b", Field: "title"}, {Term: "c", Field: "title"}})
+	if err != nil {
+		return 0, err
+	}
+	return ss.RetrievalSize(q.Query)
+}}
+
+func TestPlanBatches(t *testing.T) {
+	tests := []struct {
+		name  string
+		ss    stats.StatisticsSource
+		calls map[string]int
+	}{
+		{"requests", statisticsSource{}, map[string]int{"DocumentFrequency": 6, "RetrievalSize": 2}},
+		{"batches", batchStatisticsSource{}, map[string]int{"DocumentFrequencies": 2, "RetrievalSize": 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.RemoveAll(filepath.Join(os.Getenv("XDG_CACHE_HOME"), "groove"))
+			p := NewGroovePipeline(topics("1", "2"), tt.ss,
+				measuring(termStatistics),
+				MeasurementOutput(output.JsonMeasurementFormatter))
+			p.QueryPath = "queries"
+			plan, err := p.Plan(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(plan.Calls, tt.calls) {
+				t.Errorf("expected calls %v, got %v", tt.calls, plan.Calls)
+			}
+			if len(plan.Measurements) != 1 || plan.Measurements[0].Requires != RequiresRetrieval {
+				t.Errorf("expected the measurement to require retrieval, got %+v", plan.Measurements)
+			}
+		})
+	}
+}
+
+func TestPlan(t *testing.T) {
+	queries := topics("1", "2")
+	cache := combinator.NewMapQueryCache()
+	if err := cache.Set(queries[0].Query, combinator.Documents{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	model := &learning.QueryChain{}
+	p := NewGroovePipeline(queries, statisticsSource{},
+		measuring(termStatistics),
+		MeasurementOutput(output.JsonMeasurementFormatter),
+		TrecOutput(filepath.Join(os.Getenv("XDG_CACHE_HOME"), "run.trec")),
+		QueryCache(cache),
+		ScheduleTopics(FileOrder))
+	p.Model = model
+	p.ModelConfiguration = ModelConfiguration{Train: true}
+	p.QueryPath = "queries"
+
+	// The first topic is measured, so its measurement is cached.
+	p1 := p
+	p1.QueriesSource = queries[:1]
+	p1.Model = nil
+	execute(t, context.Background(), p1)
+
+	plan, err := p.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stages := []string{pipeline.MeasurementStage, pipeline.RetrievalStage, pipeline.TrainStage}
+	if plan.Topics != 2 || !reflect.DeepEqual(plan.Stages, stages) {
+		t.Errorf("expected 2 topics and stages %v, got %d and %v", stages, plan.Topics, plan.Stages)
+	}
+	if len(plan.Queries) != 2 {
+		t.Fatalf("expected 2 queries, got %+v", plan.Queries)
+	}
+	first, second := plan.Queries[0], plan.Queries[1]
+	if !reflect.DeepEqual(first.Cached, []string{"TermStatistics"}) || !first.QueryCached {
+		t.Errorf("expected the measurement and documents of topic 1 to be cached, got %+v", first)
+	}
+	if len(second.Cached) != 0 || second.QueryCached {
+		t.Errorf("expected nothing of topic 2 to be cached, got %+v", second)
+	}
+	if calls := map[string]int{"Execute": 1}; !reflect.DeepEqual(first.Calls, calls) {
+		t.Errorf("expected calls %v for topic 1, got %v", calls, first.Calls)
+	}
+
+	// Planning does not give the model the queries.
+	if model.Queries != nil || model.QueryCacher != nil {
+		t.Errorf("expected the model not to be modified, got %+v", model)
+	}
+}
@@ -8,15 +8,16 @@ import (
 	"strings"
 )
 
-// ElasticsearchTransformation is a specific transformation that uses an Elasticsearch statistics source.
-type ElasticsearchTransformation func(query cqr.CommonQueryRepresentation, source *stats.ElasticsearchStatisticsSource) Transformation
+// ElasticsearchTransformation is a specific transformation that uses an Elasticsearch (or OpenSearch) statistics source.
+type ElasticsearchTransformation func(query cqr.CommonQueryRepresentation, source stats.AnalysingStatisticsSource) Transformation
 
 // Analyse runs the specified Elasticsearch analyser on a query and returns a new, analysed query.
-func Analyse(query cqr.CommonQueryRepresentation, source *stats.ElasticsearchStatisticsSource) Transformation {
+func Analyse(query cqr.CommonQueryRepresentation, source stats.AnalysingStatisticsSource) Transformation {
 	return func() cqr.CommonQueryRepresentation {
 		switch q := query.(type) {
 		case cqr.Keyword:
-			tokens, err := source.Analyse(q.QueryString, source.Analyser)
+			analyser, _ := source.Analysis()
+			tokens, err := source.Analyse(q.QueryString, analyser)
 			if err != nil {
 				panic(err)
 			}
@@ -33,16 +34,17 @@ func Analyse(query cqr.CommonQueryRepresentation, source *stats.ElasticsearchSta
 }
 
 // SetAnalyseField sets the text and title fields to be analysed by the specified analyser.
-func SetAnalyseField(query cqr.CommonQueryRepresentation, source *stats.ElasticsearchStatisticsSource) Transformation {
+func SetAnalyseField(query cqr.CommonQueryRepresentation, source stats.AnalysingStatisticsSource) Transformation {
 	return func() cqr.CommonQueryRepresentation {
 		switch q := query.(type) {
 		case cqr.Keyword:
+			_, analyseField := source.Analysis()
 			fields := make([]string, len(q.Fields))
 			copy(fields, q.Fields)
 			if truncated, ok := q.Options["truncated"].(bool); ok && truncated {
 				for i, field := range q.Fields {
 					if field == "text" || field == "title" {
-						fields[i] = fmt.Sprintf("%s.%s", field, source.AnalyseField)
+						fields[i] = fmt.Sprintf("%s.%s", field, analyseField)
 					}
 				}
 			} else if !truncated {
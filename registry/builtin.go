@@ -0,0 +1,172 @@
+package registry
+
+import (
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/analysis/postqpp"
+	"github.com/hscells/groove/analysis/preqpp"
+	"github.com/hscells/groove/eval"
+	"github.com/hscells/groove/learning"
+	"github.com/hscells/groove/output"
+	"github.com/hscells/groove/preprocess"
+)
+
+func init() {
+	// Measurements are registered under the names they report for themselves.
+	for _, m := range []analysis.Measurement{
+		analysis.BooleanFields,
+		analysis.BooleanKeywords,
+		analysis.BooleanClauses,
+		analysis.BooleanNonAtomicClauses,
+		analysis.BooleanTruncated,
+		analysis.BooleanFieldsTitle,
+		analysis.BooleanFieldsAbstract,
+		analysis.BooleanFieldsMeSH,
+		analysis.BooleanFieldsOther,
+		analysis.BooleanAndCount,
+		analysis.BooleanOrCount,
+		analysis.BooleanNotCount,
+		analysis.MeshKeywordCount,
+		analysis.MeshExplodedCount,
+		analysis.MeshNonExplodedCount,
+		analysis.MeshAvgDepth,
+		analysis.MeshMaxDepth,
+		analysis.TermCount,
+		preqpp.AvgIDF,
+		preqpp.SumIDF,
+		preqpp.MaxIDF,
+		preqpp.StdDevIDF,
+		preqpp.AvgICTF,
+		preqpp.QueryScope,
+		preqpp.RetrievalSize,
+		preqpp.SimplifiedClarityScore,
+		preqpp.SummedCollectionQuerySimilarity,
+		preqpp.MaxCollectionQuerySimilarity,
+		preqpp.AverageCollectionQuerySimilarity,
+		postqpp.ClarityScore,
+		postqpp.WeightedInformationGain,
+		postqpp.WeightedExpansionGain,
+		postqpp.NormalisedQueryCommitment,
+	} {
+		Register(Measurements, m.Name(), Value(m))
+	}
+
+	// Evaluation measures.
+	for name, e := range map[string]eval.Evaluator{
+		"precision":   eval.Precision,
+		"recall":      eval.Recall,
+		"f1":          eval.F1Measure,
+		"f0.5":        eval.F05Measure,
+		"f3":          eval.F3Measure,
+		"nnr":         eval.NNR,
+		"num_ret":     eval.NumRet,
+		"num_rel":     eval.NumRel,
+		"num_rel_ret": eval.NumRelRet,
+		"ap":          eval.AP,
+		"ndcg":        eval.NDCG{},
+	} {
+		Register(Evaluators, name, Value(e))
+	}
+	Register(Evaluators, "p@K", func(args Args) (interface{}, error) {
+		k, err := args.Int(0)
+		return eval.PrecisionAtK{K: k}, err
+	})
+	Register(Evaluators, "r@K", func(args Args) (interface{}, error) {
+		k, err := args.Int(0)
+		return eval.RecallAtK{K: k}, err
+	})
+	Register(Evaluators, "ndcg@K", func(args Args) (interface{}, error) {
+		k, err := args.Int(0)
+		return eval.NDCG{K: k}, err
+	})
+	Register(Evaluators, "wss(N)", func(args Args) (interface{}, error) {
+		n, err := args.Float(0)
+		if err != nil {
+			return nil, err
+		}
+		return eval.NewWSSEvaluator(n), nil
+	})
+	Register(Evaluators, "residual(x)", func(args Args) (interface{}, error) {
+		e, err := evaluatorArg(args)
+		if err != nil {
+			return nil, err
+		}
+		return eval.NewResidualEvaluator(e), nil
+	})
+	Register(Evaluators, "mle(x)", func(args Args) (interface{}, error) {
+		e, err := evaluatorArg(args)
+		if err != nil {
+			return nil, err
+		}
+		return eval.NewMaximumLikelihoodEvaluator(e), nil
+	})
+
+	// The names previously used by entrez_eval. The collection size N of wss must be bound at lookup.
+	for _, name := range []string{"precision", "recall", "f1", "f0.5", "f3"} {
+		Alias(Evaluators, name+"_res", "residual("+name+")")
+		Alias(Evaluators, name+"_mle", "mle("+name+")")
+	}
+	Alias(Evaluators, "wss", "wss(N)")
+	Alias(Evaluators, "wss_res", "residual(wss(N))")
+	Alias(Evaluators, "wss_mle", "mle(wss(N))")
+
+	// Query preprocessors.
+	Register(QueryProcessors, "alphanum", Value(preprocess.QueryProcessor(preprocess.AlphaNum)))
+	Register(QueryProcessors, "strip_numbers", Value(preprocess.QueryProcessor(preprocess.StripNumbers)))
+	Register(QueryProcessors, "lowercase", Value(preprocess.QueryProcessor(preprocess.Lowercase)))
+
+	// Preprocessing transformations.
+	for name, t := range map[string]preprocess.BooleanTransformation{
+		"simplify":              preprocess.Simplify,
+		"relax_phrases":         preprocess.RelaxPhrases,
+		"remove_explosion_mesh": preprocess.RemoveExplosionMeSH,
+		"and_simplify":          preprocess.AndSimplify,
+		"or_simplify":           preprocess.OrSimplify,
+		"rct_filter":            preprocess.RCTFilter,
+	} {
+		Register(BooleanTransformations, name, Value(t))
+	}
+	Register(BooleanTransformations, "date_restrictions(file)", func(args Args) (interface{}, error) {
+		file, err := args.String(0)
+		if err != nil {
+			return nil, err
+		}
+		return preprocess.DateRestrictions(file), nil
+	})
+	Alias(BooleanTransformations, "date_restrictions", "date_restrictions(pubdates)")
+	Register(ElasticsearchTransformations, "analyse", Value(preprocess.ElasticsearchTransformation(preprocess.Analyse)))
+	Register(ElasticsearchTransformations, "set_analyse_field", Value(preprocess.ElasticsearchTransformation(preprocess.SetAnalyseField)))
+
+	// Query chain transformations. Some transformations keep state, so each lookup creates a new one.
+	for name, t := range map[string]func() learning.Transformation{
+		"logical_operator":      learning.NewLogicalOperatorTransformer,
+		"adjacency_range":       learning.NewAdjacencyRangeTransformer,
+		"mesh_explosion":        learning.NewMeSHExplosionTransformer,
+		"field_restrictions":    learning.NewFieldRestrictionsTransformer,
+		"adjacency_replacement": learning.NewAdjacencyReplacementTransformer,
+		"clause_removal":        learning.NewClauseRemovalTransformer,
+		"mesh_parent":           learning.NewMeshParentTransformer,
+	} {
+		t := t
+		Register(Transformations, name, func(Args) (interface{}, error) {
+			return t(), nil
+		})
+	}
+
+	// Formatters.
+	Register(MeasurementFormatters, "json", Value(output.MeasurementFormatter(output.JsonMeasurementFormatter)))
+	Register(MeasurementFormatters, "csv", Value(output.MeasurementFormatter(output.CsvMeasurementFormatter)))
+	Register(EvaluationFormatters, "json", Value(output.EvaluationFormatter(output.JsonEvaluationFormatter)))
+}
+
+// evaluatorArg looks up the evaluator named by the argument of an evaluator that wraps another, e.g. residual(x).
+func evaluatorArg(args Args) (eval.Evaluator, error) {
+	v, err := args.Lookup(Evaluators, 0)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := v.(eval.Evaluator)
+	if !ok {
+		return nil, mismatch(Evaluators, args.name.Args[0], v)
+	}
+	return e, nil
+}
@@ -0,0 +1,118 @@
+package registry
+
+import (
+	"fmt"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/eval"
+	"github.com/hscells/groove/learning"
+	"github.com/hscells/groove/output"
+	"github.com/hscells/groove/preprocess"
+)
+
+func mismatch(kind Kind, name string, v interface{}) error {
+	return fmt.Errorf("%s %s: registered component is a %T", kind, name, v)
+}
+
+// Measurement looks up a measurement by name.
+func Measurement(name string, bindings ...Binding) (analysis.Measurement, error) {
+	v, err := Lookup(Measurements, name, bindings...)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(analysis.Measurement)
+	if !ok {
+		return nil, mismatch(Measurements, name, v)
+	}
+	return m, nil
+}
+
+// Evaluator looks up an evaluation measure by name.
+func Evaluator(name string, bindings ...Binding) (eval.Evaluator, error) {
+	v, err := Lookup(Evaluators, name, bindings...)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := v.(eval.Evaluator)
+	if !ok {
+		return nil, mismatch(Evaluators, name, v)
+	}
+	return e, nil
+}
+
+// QueryProcessor looks up a query preprocessor by name.
+func QueryProcessor(name string, bindings ...Binding) (preprocess.QueryProcessor, error) {
+	v, err := Lookup(QueryProcessors, name, bindings...)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := v.(preprocess.QueryProcessor)
+	if !ok {
+		return nil, mismatch(QueryProcessors, name, v)
+	}
+	return p, nil
+}
+
+// BooleanTransformation looks up a preprocessing transformation of Boolean queries by name.
+func BooleanTransformation(name string, bindings ...Binding) (preprocess.BooleanTransformation, error) {
+	v, err := Lookup(BooleanTransformations, name, bindings...)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := v.(preprocess.BooleanTransformation)
+	if !ok {
+		return nil, mismatch(BooleanTransformations, name, v)
+	}
+	return t, nil
+}
+
+// ElasticsearchTransformation looks up a preprocessing transformation that uses Elasticsearch by name.
+func ElasticsearchTransformation(name string, bindings ...Binding) (preprocess.ElasticsearchTransformation, error) {
+	v, err := Lookup(ElasticsearchTransformations, name, bindings...)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := v.(preprocess.ElasticsearchTransformation)
+	if !ok {
+		return nil, mismatch(ElasticsearchTransformations, name, v)
+	}
+	return t, nil
+}
+
+// Transformation looks up a query chain transformation by name.
+func Transformation(name string, bindings ...Binding) (learning.Transformation, error) {
+	v, err := Lookup(Transformations, name, bindings...)
+	if err != nil {
+		return learning.Transformation{}, err
+	}
+	t, ok := v.(learning.Transformation)
+	if !ok {
+		return learning.Transformation{}, mismatch(Transformations, name, v)
+	}
+	return t, nil
+}
+
+// MeasurementFormatter looks up a measurement formatter by name.
+func MeasurementFormatter(name string, bindings ...Binding) (output.MeasurementFormatter, error) {
+	v, err := Lookup(MeasurementFormatters, name, bindings...)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := v.(output.MeasurementFormatter)
+	if !ok {
+		return nil, mismatch(MeasurementFormatters, name, v)
+	}
+	return f, nil
+}
+
+// EvaluationFormatter looks up an evaluation formatter by name.
+func EvaluationFormatter(name string, bindings ...Binding) (output.EvaluationFormatter, error) {
+	v, err := Lookup(EvaluationFormatters, name, bindings...)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := v.(output.EvaluationFormatter)
+	if !ok {
+		return nil, mismatch(EvaluationFormatters, name, v)
+	}
+	return f, nil
+}
@@ -0,0 +1,326 @@
+// Package registry provides a single vocabulary of names for the components of groove.
+//
+// Each component registers under a stable name. Names may take parameters, either a single parameter after an @
+// (e.g. ndcg@K) or a list of parameters in parentheses (e.g. wss(N) or residual(x)). When looking up a component, the
+// parameters are given as values (e.g. ndcg@10, wss(30000000) or residual(f1)), and a parameter may itself be the name
+// of another component. Parameters that cannot be known in advance can be bound when looking up a component, so that
+// wss(N) may be constructed once the size of the collection is known.
+//
+// Packages outside of groove can register their own components, typically from an init function:
+//
+//	func init() {
+//		registry.Register(registry.Measurements, "MyMeasurement", registry.Value(myMeasurement{}))
+//	}
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Kind is the kind of component that is registered.
+type Kind string
+
+const (
+	Measurements                 Kind = "measurement"
+	Evaluators                   Kind = "evaluator"
+	QueryProcessors              Kind = "preprocessor"
+	BooleanTransformations       Kind = "boolean transformation"
+	ElasticsearchTransformations Kind = "elasticsearch transformation"
+	Transformations              Kind = "transformation"
+	MeasurementFormatters        Kind = "measurement formatter"
+	EvaluationFormatters         Kind = "evaluation formatter"
+)
+
+// Constructor creates a component from the arguments of its name.
+type Constructor func(args Args) (interface{}, error)
+
+// Value is a constructor for components that take no arguments.
+func Value(v interface{}) Constructor {
+	return func(Args) (interface{}, error) {
+		return v, nil
+	}
+}
+
+type entry struct {
+	pattern     string
+	constructor Constructor
+}
+
+var registry = struct {
+	sync.RWMutex
+	entries map[Kind]map[string]entry
+	aliases map[Kind]map[string]string
+}{
+	entries: make(map[Kind]map[string]entry),
+	aliases: make(map[Kind]map[string]string),
+}
+
+// Register adds a component to the registry. The pattern is the name of the component, with the names of any
+// parameters in place of their values (e.g. ndcg@K). Register panics if the pattern cannot be parsed or if a component
+// of the same kind is already registered with the same name and parameters.
+func Register(kind Kind, pattern string, constructor Constructor) {
+	n, err := Parse(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("registry: %v", err))
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.entries[kind] == nil {
+		registry.entries[kind] = make(map[string]entry)
+	}
+	if e, ok := registry.entries[kind][n.key()]; ok {
+		panic(fmt.Sprintf("registry: %s %s conflicts with %s", kind, pattern, e.pattern))
+	}
+	registry.entries[kind][n.key()] = entry{pattern: pattern, constructor: constructor}
+}
+
+// Alias registers an alternative name for a component, e.g. f1_mle for mle(f1). Aliases may refer to parameters that
+// must be bound at lookup, e.g. wss for wss(N). Alias panics if the alias is already in use.
+func Alias(kind Kind, alias, name string) {
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.aliases[kind] == nil {
+		registry.aliases[kind] = make(map[string]string)
+	}
+	if _, ok := registry.aliases[kind][alias]; ok {
+		panic(fmt.Sprintf("registry: %s alias %s already registered", kind, alias))
+	}
+	registry.aliases[kind][alias] = name
+}
+
+// Names lists the patterns and aliases registered for a kind of component, in sorted order.
+func Names(kind Kind) []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	var names []string
+	for _, e := range registry.entries[kind] {
+		names = append(names, e.pattern)
+	}
+	for alias := range registry.aliases[kind] {
+		names = append(names, alias)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check reports whether name refers to a registered component without constructing it. Values of parameters are not
+// checked.
+func Check(kind Kind, name string) error {
+	_, err := find(kind, name)
+	return err
+}
+
+// Lookup constructs the component of the given kind and name.
+func Lookup(kind Kind, name string, bindings ...Binding) (interface{}, error) {
+	n, err := find(kind, name)
+	if err != nil {
+		return nil, err
+	}
+
+	registry.RLock()
+	e := registry.entries[kind][n.key()]
+	registry.RUnlock()
+
+	v, err := e.constructor(Args{name: n, bindings: bindings})
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", kind, name, err)
+	}
+	return v, nil
+}
+
+// find resolves aliases and parses a name, checking that a component is registered under it.
+func find(kind Kind, name string) (Name, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+	if alias, ok := registry.aliases[kind][name]; ok {
+		name = alias
+	}
+	n, err := Parse(name)
+	if err != nil {
+		return n, err
+	}
+	if _, ok := registry.entries[kind][n.key()]; !ok {
+		return n, fmt.Errorf("unknown %s %q", kind, name)
+	}
+	return n, nil
+}
+
+// Name is a parsed component name.
+type Name struct {
+	Base string
+	// At is true when the single argument follows an @ (e.g. ndcg@10) rather than parentheses (e.g. wss(100)).
+	At   bool
+	Args []string
+}
+
+// Parse parses a component name such as ndcg@10, wss(100) or residual(wss(N)). Arguments are not parsed
+// themselves; they are kept as written so they can be looked up as names of their own.
+func Parse(name string) (Name, error) {
+	name = strings.TrimSpace(name)
+	if i := strings.IndexAny(name, "@("); i >= 0 {
+		n := Name{Base: name[:i]}
+		if len(n.Base) == 0 {
+			return n, fmt.Errorf("invalid name %q: missing name before %c", name, name[i])
+		}
+		if name[i] == '@' {
+			n.At = true
+			arg := name[i+1:]
+			if len(arg) == 0 || strings.ContainsAny(arg, "@(),") {
+				return n, fmt.Errorf("invalid name %q: expected a single argument after @", name)
+			}
+			n.Args = []string{arg}
+			return n, nil
+		}
+		if name[len(name)-1] != ')' {
+			return n, fmt.Errorf("invalid name %q: expected name to end with )", name)
+		}
+		args, err := split(name[i+1 : len(name)-1])
+		if err != nil {
+			return n, fmt.Errorf("invalid name %q: %v", name, err)
+		}
+		n.Args = args
+		return n, nil
+	}
+	if len(name) == 0 || strings.ContainsAny(name, "),") {
+		return Name{}, fmt.Errorf("invalid name %q", name)
+	}
+	return Name{Base: name}, nil
+}
+
+// split splits arguments on the commas that are not nested inside parentheses.
+func split(s string) ([]string, error) {
+	var (
+		args  []string
+		depth int
+		start int
+	)
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, errors.New("unbalanced parentheses")
+			}
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, errors.New("unbalanced parentheses")
+	}
+	args = append(args, strings.TrimSpace(s[start:]))
+	for _, arg := range args {
+		if len(arg) == 0 {
+			return nil, errors.New("empty argument")
+		}
+	}
+	return args, nil
+}
+
+// String formats the name in the form it was parsed from.
+func (n Name) String() string {
+	if n.At {
+		return n.Base + "@" + n.Args[0]
+	}
+	if len(n.Args) > 0 {
+		return n.Base + "(" + strings.Join(n.Args, ",") + ")"
+	}
+	return n.Base
+}
+
+// key identifies a registered component by its name and the shape of its parameters.
+func (n Name) key() string {
+	if n.At {
+		return n.Base + "@"
+	}
+	if len(n.Args) > 0 {
+		return n.Base + "(" + strconv.Itoa(len(n.Args)) + ")"
+	}
+	return n.Base
+}
+
+// Binding supplies the value of a named parameter when looking up a component.
+type Binding struct {
+	Param string
+	value func() (interface{}, error)
+}
+
+// Bind binds param to value.
+func Bind(param string, value interface{}) Binding {
+	return Binding{Param: param, value: func() (interface{}, error) {
+		return value, nil
+	}}
+}
+
+// BindFunc binds param to the value returned by fn. fn is only called if a component uses the parameter.
+func BindFunc(param string, fn func() (interface{}, error)) Binding {
+	return Binding{Param: param, value: fn}
+}
+
+// Args are the arguments of a component name.
+type Args struct {
+	name     Name
+	bindings []Binding
+}
+
+// Len is the number of arguments.
+func (a Args) Len() int {
+	return len(a.name.Args)
+}
+
+// String is the i-th argument, with its bound value if the argument is a parameter.
+func (a Args) String(i int) (string, error) {
+	arg := a.name.Args[i]
+	for _, b := range a.bindings {
+		if b.Param == arg {
+			v, err := b.value()
+			if err != nil {
+				return "", fmt.Errorf("binding %s: %w", arg, err)
+			}
+			return fmt.Sprint(v), nil
+		}
+	}
+	return arg, nil
+}
+
+// Int is the i-th argument as an integer.
+func (a Args) Int(i int) (int, error) {
+	s, err := a.String(i)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("argument %s is not an integer", a.name.Args[i])
+	}
+	return v, nil
+}
+
+// Float is the i-th argument as a number.
+func (a Args) Float(i int) (float64, error) {
+	s, err := a.String(i)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("argument %s is not a number", a.name.Args[i])
+	}
+	return v, nil
+}
+
+// Lookup constructs the component named by the i-th argument, passing on the bindings of the outer name.
+func (a Args) Lookup(kind Kind, i int) (interface{}, error) {
+	return Lookup(kind, a.name.Args[i], a.bindings...)
+}
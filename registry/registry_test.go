@@ -0,0 +1,159 @@
+package registry
+
+import (
+	"github.com/hscells/groove/eval"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for name, expected := range map[string]Name{
+		"precision":             {Base: "precision"},
+		"f0.5":                  {Base: "f0.5"},
+		"ndcg@10":               {Base: "ndcg", At: true, Args: []string{"10"}},
+		"wss(100)":              {Base: "wss", Args: []string{"100"}},
+		"residual(wss(N))":      {Base: "residual", Args: []string{"wss(N)"}},
+		"f(mle(p@10), 3, x(y))": {Base: "f", Args: []string{"mle(p@10)", "3", "x(y)"}},
+	} {
+		n, err := Parse(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(n, expected) {
+			t.Errorf("%s: expected %+v, got %+v", name, expected, n)
+		}
+	}
+
+	for _, name := range []string{"", "@10", "ndcg@", "ndcg@1@2", "wss(", "wss(1", "wss(1))", "f(a,,b)", "a,b"} {
+		if _, err := Parse(name); err == nil {
+			t.Errorf("%q: expected an error", name)
+		}
+	}
+}
+
+func TestEvaluator(t *testing.T) {
+	for name, expected := range map[string]string{
+		"f1":                    "F1Measure",
+		"p@10":                  "Precision@10",
+		"ndcg@5":                "nDCG@5",
+		"mle(f1)":               "MLEF1Measure",
+		"f1_mle":                "MLEF1Measure",
+		"residual(mle(recall))": "ResidualMLERecall",
+		"wss_res":               "ResidualWSS",
+	} {
+		e, err := Evaluator(name, Bind("N", 1000))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if e.Name() != expected {
+			t.Errorf("%s: expected %s, got %s", name, expected, e.Name())
+		}
+	}
+
+	e, err := Evaluator("mle(wss(N))", Bind("N", 30000000.0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := e.(eval.MaximumLikelihoodEvaluator).Evaluator.(eval.WorkSavedOverSampling); w.N != 30000000 {
+		t.Errorf("expected N to be bound to 30000000, got %v", w.N)
+	}
+
+	if _, err := Evaluator("wss"); err == nil || !strings.Contains(err.Error(), "argument N is not a number") {
+		t.Errorf("expected an error for the unbound N, got %v", err)
+	}
+	if _, err := Evaluator("p@ten"); err == nil {
+		t.Error("expected an error for a non-integer K")
+	}
+	if err := Check(Evaluators, "f2"); err == nil || err.Error() != `unknown evaluator "f2"` {
+		t.Errorf("expected an unknown evaluator error, got %v", err)
+	}
+	if err := Check(Evaluators, "ndcg(10)"); err == nil {
+		t.Error("expected ndcg(10) to be unknown as only ndcg@K is registered")
+	}
+}
+
+func TestBindFunc(t *testing.T) {
+	called := 0
+	n := BindFunc("N", func() (interface{}, error) {
+		called++
+		return 100, nil
+	})
+	if _, err := Evaluator("f1", n); err != nil {
+		t.Fatal(err)
+	}
+	if called != 0 {
+		t.Errorf("expected the binding not to be used by f1")
+	}
+	if _, err := Evaluator("wss", n); err != nil {
+		t.Fatal(err)
+	}
+	if called != 1 {
+		t.Errorf("expected the binding to be used once by wss, used %d times", called)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	const kind Kind = "test component"
+	Register(kind, "scaled(x,k)", func(args Args) (interface{}, error) {
+		k, err := args.Float(1)
+		if err != nil {
+			return nil, err
+		}
+		s, err := args.String(0)
+		return strings.Repeat(s, int(k)), err
+	})
+	Register(kind, "plain", Value("plain"))
+	Alias(kind, "twice", "scaled(a,2)")
+
+	if names := Names(kind); !reflect.DeepEqual(names, []string{"plain", "scaled(x,k)", "twice"}) {
+		t.Errorf("unexpected names %v", names)
+	}
+
+	v, err := Lookup(kind, "twice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "aa" {
+		t.Errorf("expected aa, got %v", v)
+	}
+
+	// The same name with a different number of parameters is a different component.
+	Register(kind, "plain(x)", Value("x"))
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a duplicate to panic")
+		}
+	}()
+	Register(kind, "scaled(y,z)", Value(nil))
+}
+
+func TestComponents(t *testing.T) {
+	if _, err := Measurement("AvgIDF"); err != nil {
+		t.Error(err)
+	}
+	if _, err := QueryProcessor("lowercase"); err != nil {
+		t.Error(err)
+	}
+	if _, err := BooleanTransformation("simplify"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ElasticsearchTransformation("analyse"); err != nil {
+		t.Error(err)
+	}
+	if _, err := Transformation("mesh_parent"); err != nil {
+		t.Error(err)
+	}
+	if _, err := MeasurementFormatter("csv"); err != nil {
+		t.Error(err)
+	}
+	if _, err := EvaluationFormatter("json"); err != nil {
+		t.Error(err)
+	}
+	if _, err := Measurement("f1"); err == nil {
+		t.Error("expected f1 not to be a measurement")
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"github.com/hscells/trecresults"
 	"log"
 	"strconv"
-	"time"
 )
 
 // Deduplicator removes duplicate documents from a result list.
@@ -34,13 +33,11 @@ func (d Deduplicator) Handle(list *trecresults.ResultList) error {
 
 	log.Println("fetching documents")
 
+	// Transient failures are retried by the statistics source.
 	var docs []guru.MedlineDocument
-reset:
 	fetched, err := d.e.Fetch(pmids)
 	if err != nil {
-		log.Println(err)
-		time.Sleep(5 * time.Second)
-		goto reset
+		return err
 	}
 	docs = append(docs, fetched...)
 	log.Println("begin de-duplication")
@@ -0,0 +1,124 @@
+package stats
+
+import (
+	"github.com/hscells/cqr"
+	"sync"
+)
+
+// TermField is a term in a field, whose statistics are requested in a batch.
+type TermField struct {
+	Term  string
+	Field string
+}
+
+// BatchStatisticsSource is a statistics source that computes the statistics of many terms (or queries) at once, in
+// fewer round trips than requesting them one at a time. The statistics of a batch are in the order of its terms.
+type BatchStatisticsSource interface {
+	StatisticsSource
+	DocumentFrequencies(terms []TermField) ([]float64, error)
+	TotalTermFrequencies(terms []TermField) ([]float64, error)
+	InverseDocumentFrequencies(terms []TermField) ([]float64, error)
+	RetrievalSizes(queries []cqr.CommonQueryRepresentation) ([]float64, error)
+}
+
+// DocumentFrequencies are the document frequencies of the terms, requested in a batch if the statistics source is a
+// BatchStatisticsSource.
+func DocumentFrequencies(ss StatisticsSource, terms []TermField) ([]float64, error) {
+	if b, ok := ss.(BatchStatisticsSource); ok {
+		return b.DocumentFrequencies(terms)
+	}
+	return eachTerm(terms, ss.DocumentFrequency)
+}
+
+// TotalTermFrequencies are the total term frequencies of the terms, requested in a batch if the statistics source is
+// a BatchStatisticsSource.
+func TotalTermFrequencies(ss StatisticsSource, terms []TermField) ([]float64, error) {
+	if b, ok := ss.(BatchStatisticsSource); ok {
+		return b.TotalTermFrequencies(terms)
+	}
+	return eachTerm(terms, ss.TotalTermFrequency)
+}
+
+// InverseDocumentFrequencies are the inverse document frequencies of the terms, requested in a batch if the statistics
+// source is a BatchStatisticsSource.
+func InverseDocumentFrequencies(ss StatisticsSource, terms []TermField) ([]float64, error) {
+	if b, ok := ss.(BatchStatisticsSource); ok {
+		return b.InverseDocumentFrequencies(terms)
+	}
+	return eachTerm(terms, ss.InverseDocumentFrequency)
+}
+
+// RetrievalSizes are the number of documents each query retrieves, requested in a batch if the statistics source is a
+// BatchStatisticsSource.
+func RetrievalSizes(ss StatisticsSource, queries []cqr.CommonQueryRepresentation) ([]float64, error) {
+	if b, ok := ss.(BatchStatisticsSource); ok {
+		return b.RetrievalSizes(queries)
+	}
+	v := make([]float64, len(queries))
+	for i, query := range queries {
+		var err error
+		v[i], err = ss.RetrievalSize(query)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// eachTerm requests the statistic of each term one at a time.
+func eachTerm(terms []TermField, statistic func(term, field string) (float64, error)) ([]float64, error) {
+	v := make([]float64, len(terms))
+	for i, t := range terms {
+		var err error
+		v[i], err = statistic(t.Term, t.Field)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// concurrently requests the statistic of each of n items with at most concurrency requests at once. The first error
+// is returned, and no more requests are made once a request fails.
+func concurrently(n, concurrency int, statistic func(i int) (float64, error)) ([]float64, error) {
+	if concurrency <= 0 || concurrency > n {
+		concurrency = n
+	}
+	v := make([]float64, n)
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		err  error
+		next = make(chan int)
+	)
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				s, e := statistic(i)
+				mu.Lock()
+				if e != nil && err == nil {
+					err = e
+				}
+				v[i] = s
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		mu.Lock()
+		failed := err != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
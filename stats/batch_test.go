@@ -0,0 +1,125 @@
+package stats
+
+import (
+	"errors"
+	"github.com/hscells/cqr"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// countingBatchSource counts the batches requested from a statistics source, and the statistics requested in them.
+type countingBatchSource struct {
+	*IndexStatisticsSource
+	batches, requested int
+}
+
+func (c *countingBatchSource) DocumentFrequencies(terms []TermField) ([]float64, error) {
+	c.batches++
+	c.requested += len(terms)
+	return eachTerm(terms, c.DocumentFrequency)
+}
+
+func (c *countingBatchSource) TotalTermFrequencies(terms []TermField) ([]float64, error) {
+	c.batches++
+	c.requested += len(terms)
+	return eachTerm(terms, c.TotalTermFrequency)
+}
+
+func (c *countingBatchSource) InverseDocumentFrequencies(terms []TermField) ([]float64, error) {
+	c.batches++
+	c.requested += len(terms)
+	return eachTerm(terms, c.InverseDocumentFrequency)
+}
+
+func (c *countingBatchSource) RetrievalSizes(queries []cqr.CommonQueryRepresentation) ([]float64, error) {
+	c.batches++
+	c.requested += len(queries)
+	return RetrievalSizes(c.IndexStatisticsSource, queries)
+}
+
+func TestBatchStatistics(t *testing.T) {
+	s := testIndex(t)
+	terms := []TermField{{"retinopathy", "text"}, {"diabet*", "title"}, {"unindexed", "title"}}
+
+	df, err := DocumentFrequencies(s, terms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(df, []float64{1, 3, 0}) {
+		t.Errorf("unexpected document frequencies %v", df)
+	}
+	sizes, err := RetrievalSizes(s, []cqr.CommonQueryRepresentation{
+		cqr.NewKeyword("metformin", "title"),
+		cqr.NewKeyword("humans", "mesh_headings"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sizes, []float64{1, 2}) {
+		t.Errorf("unexpected retrieval sizes %v", sizes)
+	}
+
+	b := &countingBatchSource{IndexStatisticsSource: s}
+	ttf, err := TotalTermFrequencies(b, terms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ttf, []float64{2, 3, 0}) {
+		t.Errorf("unexpected total term frequencies %v", ttf)
+	}
+	if _, err := InverseDocumentFrequencies(b, terms); err != nil {
+		t.Fatal(err)
+	}
+	if b.batches != 2 {
+		t.Errorf("expected the statistics to be requested in 2 batches, got %d", b.batches)
+	}
+}
+
+func TestEntrezStatisticsSource_DocumentFrequencies(t *testing.T) {
+	var inFlight, maxInFlight int64
+	e, requests, done := testEntrez(t, func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		for {
+			max := atomic.LoadInt64(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt64(&maxInFlight, max, n) {
+				break
+			}
+		}
+		if r.URL.Query().Get("term") == "invalid" {
+			w.Write([]byte("<eSearchResult><ERROR>Invalid query</ERROR></eSearchResult>"))
+			return
+		}
+		w.Write([]byte("<eSearchResult><Count>" + r.URL.Query().Get("term") + "</Count></eSearchResult>"))
+	})
+	defer done()
+	e.concurrency = 2
+
+	terms := make([]TermField, 20)
+	want := make([]float64, len(terms))
+	for i := range terms {
+		terms[i] = TermField{Term: strconv.Itoa(i), Field: "tiab"}
+		want[i] = float64(i)
+	}
+	df, err := DocumentFrequencies(e, terms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(df, want) {
+		t.Errorf("expected the document frequencies %v, got %v", want, df)
+	}
+	if *requests != int64(len(terms)) {
+		t.Errorf("expected %d requests, got %d", len(terms), *requests)
+	}
+	if maxInFlight > 2 {
+		t.Errorf("expected at most 2 requests at once, got %d", maxInFlight)
+	}
+
+	_, err = e.DocumentFrequencies(append(terms, TermField{Term: "invalid", Field: "tiab"}))
+	if !errors.Is(err, ErrQuerySyntax) {
+		t.Errorf("expected a query syntax error, got %v", err)
+	}
+}
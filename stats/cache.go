@@ -0,0 +1,425 @@
+package stats
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/trecresults"
+	"github.com/peterbourgon/diskv"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// IdentifiedStatisticsSource is a statistics source that can identify the collection it computes statistics for (e.g.
+// the Elasticsearch index), so that statistics cached for one collection are not used for another.
+type IdentifiedStatisticsSource interface {
+	StatisticsSource
+	// Identity identifies the collection of the statistics source.
+	Identity() string
+}
+
+// sourceMethods are the methods of a statistics source that are cached (and that can be routed, see
+// CompositeStatisticsSource).
+var sourceMethods = []string{
+	"TermFrequency",
+	"TermVector",
+	"DocumentFrequency",
+	"TotalTermFrequency",
+	"InverseDocumentFrequency",
+	"RetrievalSize",
+	"VocabularySize",
+	"Execute",
+	"CollectionSize",
+}
+
+// CacheCounter is the number of requests of a method that a caching statistics source answered from its cache (hits),
+// and that it made to the statistics source (misses).
+type CacheCounter struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+type cacheCounter struct {
+	hits, misses int64
+}
+
+// CachingStatisticsSource is a statistics source that caches the results of the requests made to another statistics
+// source on disk, so that they are not made again by other measurements, topics or runs. Requests that fail are not
+// cached.
+//
+// The results are cached in a namespace derived from the type of the statistics source, its identity (see
+// IdentifiedStatisticsSource), parameters and search options, so that sources configured differently do not share
+// results.
+type CachingStatisticsSource struct {
+	source    StatisticsSource
+	dir       string
+	namespace string
+	cache     *diskv.Diskv
+	counters  map[string]*cacheCounter
+}
+
+// CacheDirectory sets the directory results are cached in. By default, this is groove/statistics_source in the user
+// cache directory.
+func CacheDirectory(dir string) func(*CachingStatisticsSource) {
+	return func(c *CachingStatisticsSource) {
+		c.dir = dir
+	}
+}
+
+// CacheNamespace sets the namespace results are cached in, instead of the namespace derived from the statistics
+// source. Statistics sources with the same namespace share results.
+func CacheNamespace(namespace string) func(*CachingStatisticsSource) {
+	return func(c *CachingStatisticsSource) {
+		c.namespace = namespace
+	}
+}
+
+// NewCachingStatisticsSource creates a statistics source that caches the results of requests made to ss.
+func NewCachingStatisticsSource(ss StatisticsSource, options ...func(*CachingStatisticsSource)) (*CachingStatisticsSource, error) {
+	c := &CachingStatisticsSource{
+		source:   ss,
+		counters: make(map[string]*cacheCounter, len(sourceMethods)),
+	}
+	for _, method := range sourceMethods {
+		c.counters[method] = &cacheCounter{}
+	}
+	for _, option := range options {
+		option(c)
+	}
+
+	if len(c.dir) == 0 {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		c.dir = path.Join(cacheDir, "groove", "statistics_source")
+	}
+	if len(c.namespace) == 0 {
+		var err error
+		c.namespace, err = Namespace(ss)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if strings.ContainsAny(c.namespace, `./\`) {
+		return nil, fmt.Errorf("invalid cache namespace %q", c.namespace)
+	}
+
+	c.cache = diskv.New(diskv.Options{
+		BasePath: c.dir,
+		// Keys are namespace.method.hash, which are cached in namespace/method.
+		Transform: func(key string) []string {
+			parts := strings.Split(key, ".")
+			return parts[:len(parts)-1]
+		},
+		// Results are read from disk rather than held in memory, so that results invalidated by another caching
+		// statistics source (or process) are not used.
+		Compression: diskv.NewGzipCompression(),
+	})
+	return c, nil
+}
+
+// Namespace is the namespace that the results of a statistics source are cached in: a hash of its type, identity,
+// parameters and search options.
+func Namespace(ss StatisticsSource) (string, error) {
+	var identity string
+	if s, ok := ss.(IdentifiedStatisticsSource); ok {
+		identity = s.Identity()
+	}
+	b, err := json.Marshal(struct {
+		Type       string
+		Identity   string
+		Parameters map[string]float64
+		Options    SearchOptions
+	}{fmt.Sprintf("%T", ss), identity, ss.Parameters(), ss.SearchOptions()})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b))[:16], nil
+}
+
+// Namespace is the namespace the results are cached in.
+func (c *CachingStatisticsSource) Namespace() string {
+	return c.namespace
+}
+
+// Counters are the number of cache hits and misses of each method since the statistics source was created.
+func (c *CachingStatisticsSource) Counters() map[string]CacheCounter {
+	counters := make(map[string]CacheCounter, len(c.counters))
+	for method, counter := range c.counters {
+		counters[method] = CacheCounter{
+			Hits:   atomic.LoadInt64(&counter.hits),
+			Misses: atomic.LoadInt64(&counter.misses),
+		}
+	}
+	return counters
+}
+
+// Invalidate removes the cached results of the methods (e.g. "DocumentFrequency"), or of every method if none are
+// given. Only the results in the namespace of the statistics source are removed.
+func (c *CachingStatisticsSource) Invalidate(methods ...string) error {
+	if len(methods) == 0 {
+		methods = sourceMethods
+	}
+	for _, method := range methods {
+		if _, ok := c.counters[method]; !ok {
+			return fmt.Errorf("%s is not a cached method", method)
+		}
+		var keys []string
+		for key := range c.cache.KeysPrefix(c.namespace+"."+method+".", nil) {
+			keys = append(keys, key)
+		}
+		for _, key := range keys {
+			if err := c.cache.Erase(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WithContext binds the cached statistics source to ctx, if it supports cancellation.
+func (c *CachingStatisticsSource) WithContext(ctx context.Context) StatisticsSource {
+	s := *c
+	s.source = WithContext(ctx, c.source)
+	return &s
+}
+
+// Concurrency is the number of requests that may be made to the cached statistics source at once.
+func (c *CachingStatisticsSource) Concurrency() int {
+	if s, ok := c.source.(ConcurrencyLimiter); ok {
+		return s.Concurrency()
+	}
+	return 0
+}
+
+// Identity is the identity of the cached statistics source.
+func (c *CachingStatisticsSource) Identity() string {
+	if s, ok := c.source.(IdentifiedStatisticsSource); ok {
+		return s.Identity()
+	}
+	return ""
+}
+
+func (c *CachingStatisticsSource) SearchOptions() SearchOptions {
+	return c.source.SearchOptions()
+}
+
+func (c *CachingStatisticsSource) Parameters() map[string]float64 {
+	return c.source.Parameters()
+}
+
+// key is the key the result of a request is cached with.
+func (c *CachingStatisticsSource) key(method string, args ...interface{}) (string, error) {
+	b, err := json.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s.%s.%x", c.namespace, method, sha256.Sum256(b)), nil
+}
+
+// cached reads the result of a request from the cache into v, reporting whether it was cached.
+func (c *CachingStatisticsSource) cached(key, method string, v interface{}) bool {
+	b, err := c.cache.Read(key)
+	if err == nil && json.Unmarshal(b, v) == nil {
+		atomic.AddInt64(&c.counters[method].hits, 1)
+		return true
+	}
+	atomic.AddInt64(&c.counters[method].misses, 1)
+	return false
+}
+
+// float answers a request with a float result from the cache, or from the statistics source if it is not cached.
+func (c *CachingStatisticsSource) float(method string, request func() (float64, error), args ...interface{}) (float64, error) {
+	key, err := c.key(method, args...)
+	if err != nil {
+		return 0, err
+	}
+	var s string
+	if c.cached(key, method, &s) {
+		return strconv.ParseFloat(s, 64)
+	}
+	v, err := request()
+	if err != nil {
+		return v, err
+	}
+	b, err := json.Marshal(strconv.FormatFloat(v, 'g', -1, 64))
+	if err != nil {
+		return v, err
+	}
+	return v, c.cache.Write(key, b)
+}
+
+// floats answers a batch of requests with float results from the cache, making the requests that are not cached to
+// the statistics source in a single batch. The arguments of the i-th request are args(i), and request answers the
+// requests at the indices of the misses.
+func (c *CachingStatisticsSource) floats(method string, n int, args func(i int) []interface{}, request func(misses []int) ([]float64, error)) ([]float64, error) {
+	v := make([]float64, n)
+	keys := make([]string, n)
+	var misses []int
+	for i := range keys {
+		var err error
+		keys[i], err = c.key(method, args(i)...)
+		if err != nil {
+			return nil, err
+		}
+		var s string
+		if !c.cached(keys[i], method, &s) {
+			misses = append(misses, i)
+			continue
+		}
+		v[i], err = strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(misses) == 0 {
+		return v, nil
+	}
+	r, err := request(misses)
+	if err != nil {
+		return nil, err
+	}
+	for k, i := range misses {
+		v[i] = r[k]
+		b, err := json.Marshal(strconv.FormatFloat(v[i], 'g', -1, 64))
+		if err != nil {
+			return nil, err
+		}
+		if err := c.cache.Write(keys[i], b); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// terms answers a batch of requests for a statistic of terms, making the requests that are not cached to the
+// statistics source in a single batch.
+func (c *CachingStatisticsSource) terms(method string, terms []TermField, statistics func(ss StatisticsSource, terms []TermField) ([]float64, error)) ([]float64, error) {
+	return c.floats(method, len(terms), func(i int) []interface{} {
+		return []interface{}{terms[i].Term, terms[i].Field}
+	}, func(misses []int) ([]float64, error) {
+		batch := make([]TermField, len(misses))
+		for k, i := range misses {
+			batch[k] = terms[i]
+		}
+		return statistics(c.source, batch)
+	})
+}
+
+func (c *CachingStatisticsSource) TermFrequency(term, field, document string) (float64, error) {
+	return c.float("TermFrequency", func() (float64, error) {
+		return c.source.TermFrequency(term, field, document)
+	}, term, field, document)
+}
+
+func (c *CachingStatisticsSource) TermVector(document string) (TermVector, error) {
+	key, err := c.key("TermVector", document)
+	if err != nil {
+		return nil, err
+	}
+	var tv TermVector
+	if c.cached(key, "TermVector", &tv) {
+		return tv, nil
+	}
+	tv, err = c.source.TermVector(document)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(tv)
+	if err != nil {
+		return nil, err
+	}
+	return tv, c.cache.Write(key, b)
+}
+
+func (c *CachingStatisticsSource) DocumentFrequency(term, field string) (float64, error) {
+	return c.float("DocumentFrequency", func() (float64, error) {
+		return c.source.DocumentFrequency(term, field)
+	}, term, field)
+}
+
+func (c *CachingStatisticsSource) TotalTermFrequency(term, field string) (float64, error) {
+	return c.float("TotalTermFrequency", func() (float64, error) {
+		return c.source.TotalTermFrequency(term, field)
+	}, term, field)
+}
+
+func (c *CachingStatisticsSource) InverseDocumentFrequency(term, field string) (float64, error) {
+	return c.float("InverseDocumentFrequency", func() (float64, error) {
+		return c.source.InverseDocumentFrequency(term, field)
+	}, term, field)
+}
+
+func (c *CachingStatisticsSource) RetrievalSize(query cqr.CommonQueryRepresentation) (float64, error) {
+	return c.float("RetrievalSize", func() (float64, error) {
+		return c.source.RetrievalSize(query)
+	}, query)
+}
+
+// DocumentFrequencies are the document frequencies of the terms. Those that are not cached are requested in a batch.
+func (c *CachingStatisticsSource) DocumentFrequencies(terms []TermField) ([]float64, error) {
+	return c.terms("DocumentFrequency", terms, DocumentFrequencies)
+}
+
+// TotalTermFrequencies are the total term frequencies of the terms. Those that are not cached are requested in a
+// batch.
+func (c *CachingStatisticsSource) TotalTermFrequencies(terms []TermField) ([]float64, error) {
+	return c.terms("TotalTermFrequency", terms, TotalTermFrequencies)
+}
+
+// InverseDocumentFrequencies are the inverse document frequencies of the terms. Those that are not cached are
+// requested in a batch.
+func (c *CachingStatisticsSource) InverseDocumentFrequencies(terms []TermField) ([]float64, error) {
+	return c.terms("InverseDocumentFrequency", terms, InverseDocumentFrequencies)
+}
+
+// RetrievalSizes are the number of documents each query retrieves. Those that are not cached are requested in a
+// batch.
+func (c *CachingStatisticsSource) RetrievalSizes(queries []cqr.CommonQueryRepresentation) ([]float64, error) {
+	return c.floats("RetrievalSize", len(queries), func(i int) []interface{} {
+		return []interface{}{queries[i]}
+	}, func(misses []int) ([]float64, error) {
+		batch := make([]cqr.CommonQueryRepresentation, len(misses))
+		for k, i := range misses {
+			batch[k] = queries[i]
+		}
+		return RetrievalSizes(c.source, batch)
+	})
+}
+
+func (c *CachingStatisticsSource) VocabularySize(field string) (float64, error) {
+	return c.float("VocabularySize", func() (float64, error) {
+		return c.source.VocabularySize(field)
+	}, field)
+}
+
+func (c *CachingStatisticsSource) Execute(query pipeline.Query, options SearchOptions) (trecresults.ResultList, error) {
+	key, err := c.key("Execute", query.Topic, query.Query, options)
+	if err != nil {
+		return nil, err
+	}
+	var results trecresults.ResultList
+	if c.cached(key, "Execute", &results) {
+		return results, nil
+	}
+	results, err = c.source.Execute(query, options)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(results)
+	if err != nil {
+		return nil, err
+	}
+	return results, c.cache.Write(key, b)
+}
+
+func (c *CachingStatisticsSource) CollectionSize() (float64, error) {
+	return c.float("CollectionSize", c.source.CollectionSize)
+}
@@ -0,0 +1,144 @@
+package stats
+
+import (
+	"github.com/hscells/cqr"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestCachingStatisticsSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	index := testIndex(t)
+	c, err := NewCachingStatisticsSource(index, CacheDirectory(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := func(c *CachingStatisticsSource, method string, hits, misses int64) {
+		t.Helper()
+		if got := c.Counters()[method]; got.Hits != hits || got.Misses != misses {
+			t.Errorf("expected %d hits and %d misses of %s, got %+v", hits, misses, method, got)
+		}
+	}
+	df := func(c *CachingStatisticsSource) {
+		t.Helper()
+		v, err := c.DocumentFrequency("retinopathy", "text")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != 1 {
+			t.Errorf("expected a document frequency of 1, got %f", v)
+		}
+	}
+
+	df(c)
+	df(c)
+	counter(c, "DocumentFrequency", 1, 1)
+
+	tv, err := c.TermVector("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := c.TermVector("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cached) != len(tv) {
+		t.Errorf("expected the cached term vector %v to be %v", cached, tv)
+	}
+	counter(c, "TermVector", 1, 1)
+
+	// Results are cached on disk, so another statistics source of the same collection uses them.
+	other, err := NewCachingStatisticsSource(testIndex(t), CacheDirectory(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Namespace() != c.Namespace() {
+		t.Errorf("expected the namespace %s, got %s", c.Namespace(), other.Namespace())
+	}
+	df(other)
+	counter(other, "DocumentFrequency", 1, 0)
+
+	if err := other.Invalidate("DocumentFrequency"); err != nil {
+		t.Fatal(err)
+	}
+	df(c)
+	counter(c, "DocumentFrequency", 1, 2)
+	if _, err := c.TermVector("2"); err != nil {
+		t.Fatal(err)
+	}
+	counter(c, "TermVector", 2, 1)
+
+	if err := c.Invalidate("Search"); err == nil {
+		t.Error("expected an error invalidating a method that is not cached")
+	}
+
+	parameterised := NewIndexStatisticsSource(nil, IndexParameters(map[string]float64{"k1": 1.2}))
+	a, err := Namespace(parameterised)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Namespace(NewIndexStatisticsSource(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Error("expected statistics sources with different parameters to have different namespaces")
+	}
+}
+
+func TestCachingStatisticsSource_Batch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b := &countingBatchSource{IndexStatisticsSource: testIndex(t)}
+	c, err := NewCachingStatisticsSource(b, CacheDirectory(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.DocumentFrequency("retinopathy", "text"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the statistics that are not cached are requested, in a single batch.
+	terms := []TermField{{"retinopathy", "text"}, {"diabet*", "title"}, {"unindexed", "title"}}
+	for i := 0; i < 2; i++ {
+		df, err := DocumentFrequencies(c, terms)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(df, []float64{1, 3, 0}) {
+			t.Errorf("unexpected document frequencies %v", df)
+		}
+	}
+	if b.batches != 1 || b.requested != 2 {
+		t.Errorf("expected 2 document frequencies in 1 batch, got %d in %d", b.requested, b.batches)
+	}
+	if got := c.Counters()["DocumentFrequency"]; got.Hits != 4 || got.Misses != 3 {
+		t.Errorf("expected 4 hits and 3 misses, got %+v", got)
+	}
+
+	queries := []cqr.CommonQueryRepresentation{cqr.NewKeyword("metformin", "title"), cqr.NewKeyword("humans", "mesh_headings")}
+	if _, err := c.RetrievalSize(queries[1]); err != nil {
+		t.Fatal(err)
+	}
+	sizes, err := RetrievalSizes(c, queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sizes, []float64{1, 2}) {
+		t.Errorf("unexpected retrieval sizes %v", sizes)
+	}
+	if b.batches != 2 || b.requested != 3 {
+		t.Errorf("expected 1 retrieval size in 1 batch, got %d in %d", b.requested-2, b.batches-1)
+	}
+}
@@ -0,0 +1,496 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/trecresults"
+	"sort"
+	"strings"
+)
+
+// fieldMethods are the methods of a statistics source with a field, which can be routed by field.
+var fieldMethods = []string{
+	"TermFrequency",
+	"DocumentFrequency",
+	"TotalTermFrequency",
+	"InverseDocumentFrequency",
+	"VocabularySize",
+}
+
+// CompositeStatisticsSource is a statistics source that routes each method, and optionally each field, to one of
+// several statistics sources. For instance, retrieval can be routed to PubMed, and term statistics to a local index of
+// abstracts. A method with a field is routed to the source of the field, if the method is routed by field, otherwise
+// to the source of the method, otherwise to the default source.
+//
+// The documents of the sources may be identified differently; sources can be wrapped in a TranslatingStatisticsSource
+// so that the IDs the composite source retrieves can be used with all of them.
+type CompositeStatisticsSource struct {
+	fallback StatisticsSource
+	methods  map[string]StatisticsSource
+	// fields maps a field, then a method, to a statistics source.
+	fields map[string]map[string]StatisticsSource
+
+	options    *SearchOptions
+	parameters map[string]float64
+	err        error
+}
+
+// CompositeDefault sets the statistics source of the methods that are not routed to another source.
+func CompositeDefault(ss StatisticsSource) func(*CompositeStatisticsSource) {
+	return func(c *CompositeStatisticsSource) {
+		c.fallback = ss
+	}
+}
+
+// CompositeRoute routes methods of the statistics source interface (e.g. "RetrievalSize" and "Execute") to a
+// statistics source.
+func CompositeRoute(ss StatisticsSource, methods ...string) func(*CompositeStatisticsSource) {
+	return func(c *CompositeStatisticsSource) {
+		for _, method := range methods {
+			if !contains(sourceMethods, method) {
+				c.err = fmt.Errorf("composite: unknown method %q", method)
+				return
+			}
+			c.methods[method] = ss
+		}
+	}
+}
+
+// CompositeFieldRoute routes the methods with a field (e.g. "DocumentFrequency") to a statistics source, for a field.
+// If no methods are given, every method with a field is routed.
+func CompositeFieldRoute(ss StatisticsSource, field string, methods ...string) func(*CompositeStatisticsSource) {
+	return func(c *CompositeStatisticsSource) {
+		if len(methods) == 0 {
+			methods = fieldMethods
+		}
+		if c.fields[field] == nil {
+			c.fields[field] = make(map[string]StatisticsSource)
+		}
+		for _, method := range methods {
+			if !contains(fieldMethods, method) {
+				c.err = fmt.Errorf("composite: %q cannot be routed by field", method)
+				return
+			}
+			c.fields[field][method] = ss
+		}
+	}
+}
+
+// CompositeSearchOptions sets the search options of the composite statistics source. By default, these are the
+// search options of the source that Execute is routed to.
+func CompositeSearchOptions(options SearchOptions) func(*CompositeStatisticsSource) {
+	return func(c *CompositeStatisticsSource) {
+		c.options = &options
+	}
+}
+
+// CompositeParameters sets parameters of the composite statistics source, which take precedence over the
+// parameters of its sources.
+func CompositeParameters(params map[string]float64) func(*CompositeStatisticsSource) {
+	return func(c *CompositeStatisticsSource) {
+		for k, v := range params {
+			c.parameters[k] = v
+		}
+	}
+}
+
+// NewCompositeStatisticsSource creates a statistics source that routes methods to other statistics sources. A
+// default source must be given.
+//
+// The parameters of the composite source are those of all of its sources, merged in order: the default source, then
+// the sources of methods and then of fields, in alphabetical order. Parameters given with CompositeParameters take
+// precedence.
+func NewCompositeStatisticsSource(options ...func(*CompositeStatisticsSource)) (*CompositeStatisticsSource, error) {
+	c := &CompositeStatisticsSource{
+		methods:    make(map[string]StatisticsSource),
+		fields:     make(map[string]map[string]StatisticsSource),
+		parameters: make(map[string]float64),
+	}
+	explicit := make(map[string]float64)
+	for _, option := range options {
+		option(c)
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	if c.fallback == nil {
+		return nil, errors.New("composite: a default statistics source is required")
+	}
+
+	for k, v := range c.parameters {
+		explicit[k] = v
+	}
+	for _, ss := range c.sources() {
+		for k, v := range ss.Parameters() {
+			c.parameters[k] = v
+		}
+	}
+	for k, v := range explicit {
+		c.parameters[k] = v
+	}
+	return c, nil
+}
+
+// sources are the statistics sources of the composite source, in the order their parameters are merged. A source
+// that methods or fields are routed to more than once appears more than once.
+func (c *CompositeStatisticsSource) sources() []StatisticsSource {
+	sources := []StatisticsSource{c.fallback}
+	for _, method := range sortedKeys(c.methods) {
+		sources = append(sources, c.methods[method])
+	}
+	fields := make([]string, 0, len(c.fields))
+	for field := range c.fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		for _, method := range sortedKeys(c.fields[field]) {
+			sources = append(sources, c.fields[field][method])
+		}
+	}
+	return sources
+}
+
+func sortedKeys(m map[string]StatisticsSource) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// route is the statistics source a method is routed to, for a field (which may be empty), and the key of the route.
+func (c *CompositeStatisticsSource) route(method, field string) (StatisticsSource, string) {
+	if ss, ok := c.fields[field][method]; ok {
+		return ss, field + "." + method
+	}
+	if ss, ok := c.methods[method]; ok {
+		return ss, method
+	}
+	return c.fallback, ""
+}
+
+// source is the statistics source a method is routed to, for a field (which may be empty).
+func (c *CompositeStatisticsSource) source(method, field string) StatisticsSource {
+	ss, _ := c.route(method, field)
+	return ss
+}
+
+// WithContext binds each of the statistics sources to ctx, if they support cancellation.
+func (c *CompositeStatisticsSource) WithContext(ctx context.Context) StatisticsSource {
+	s := *c
+	s.fallback = WithContext(ctx, c.fallback)
+	s.methods = make(map[string]StatisticsSource, len(c.methods))
+	for method, ss := range c.methods {
+		s.methods[method] = WithContext(ctx, ss)
+	}
+	s.fields = make(map[string]map[string]StatisticsSource, len(c.fields))
+	for field, methods := range c.fields {
+		s.fields[field] = make(map[string]StatisticsSource, len(methods))
+		for method, ss := range methods {
+			s.fields[field][method] = WithContext(ctx, ss)
+		}
+	}
+	return &s
+}
+
+// Concurrency is the smallest number of requests that may be made at once to any of the statistics sources.
+func (c *CompositeStatisticsSource) Concurrency() int {
+	var n int
+	for _, ss := range c.sources() {
+		if s, ok := ss.(ConcurrencyLimiter); ok {
+			if limit := s.Concurrency(); limit > 0 && (n == 0 || limit < n) {
+				n = limit
+			}
+		}
+	}
+	return n
+}
+
+// Identity is the identity of each statistics source, and the methods and fields routed to it.
+func (c *CompositeStatisticsSource) Identity() string {
+	identity := func(ss StatisticsSource) string {
+		if s, ok := ss.(IdentifiedStatisticsSource); ok {
+			return fmt.Sprintf("%T(%s)", ss, s.Identity())
+		}
+		return fmt.Sprintf("%T", ss)
+	}
+	parts := []string{"default=" + identity(c.fallback)}
+	for _, method := range sortedKeys(c.methods) {
+		parts = append(parts, method+"="+identity(c.methods[method]))
+	}
+	for field, methods := range c.fields {
+		for _, method := range sortedKeys(methods) {
+			parts = append(parts, field+"."+method+"="+identity(methods[method]))
+		}
+	}
+	sort.Strings(parts[1:])
+	return strings.Join(parts, ";")
+}
+
+// SearchOptions are the search options given to the composite source, or those of the source Execute is routed to.
+func (c *CompositeStatisticsSource) SearchOptions() SearchOptions {
+	if c.options != nil {
+		return *c.options
+	}
+	return c.source("Execute", "").SearchOptions()
+}
+
+// Parameters are the merged parameters of the statistics sources.
+func (c *CompositeStatisticsSource) Parameters() map[string]float64 {
+	return c.parameters
+}
+
+func (c *CompositeStatisticsSource) TermFrequency(term, field, document string) (float64, error) {
+	return c.source("TermFrequency", field).TermFrequency(term, field, document)
+}
+
+func (c *CompositeStatisticsSource) TermVector(document string) (TermVector, error) {
+	return c.source("TermVector", "").TermVector(document)
+}
+
+func (c *CompositeStatisticsSource) DocumentFrequency(term, field string) (float64, error) {
+	return c.source("DocumentFrequency", field).DocumentFrequency(term, field)
+}
+
+func (c *CompositeStatisticsSource) TotalTermFrequency(term, field string) (float64, error) {
+	return c.source("TotalTermFrequency", field).TotalTermFrequency(term, field)
+}
+
+func (c *CompositeStatisticsSource) InverseDocumentFrequency(term, field string) (float64, error) {
+	return c.source("InverseDocumentFrequency", field).InverseDocumentFrequency(term, field)
+}
+
+func (c *CompositeStatisticsSource) RetrievalSize(query cqr.CommonQueryRepresentation) (float64, error) {
+	return c.source("RetrievalSize", "").RetrievalSize(query)
+}
+
+func (c *CompositeStatisticsSource) VocabularySize(field string) (float64, error) {
+	return c.source("VocabularySize", field).VocabularySize(field)
+}
+
+func (c *CompositeStatisticsSource) Execute(query pipeline.Query, options SearchOptions) (trecresults.ResultList, error) {
+	return c.source("Execute", "").Execute(query, options)
+}
+
+func (c *CompositeStatisticsSource) CollectionSize() (float64, error) {
+	return c.source("CollectionSize", "").CollectionSize()
+}
+
+// batch requests the statistics of terms in a batch from each of the statistics sources the terms are routed to.
+func (c *CompositeStatisticsSource) batch(method string, terms []TermField, statistics func(ss StatisticsSource, terms []TermField) ([]float64, error)) ([]float64, error) {
+	var (
+		routes  []string
+		sources = make(map[string]StatisticsSource)
+		batches = make(map[string][]int)
+	)
+	for i, t := range terms {
+		ss, key := c.route(method, t.Field)
+		if _, ok := sources[key]; !ok {
+			routes = append(routes, key)
+			sources[key] = ss
+		}
+		batches[key] = append(batches[key], i)
+	}
+
+	v := make([]float64, len(terms))
+	for _, key := range routes {
+		batch := make([]TermField, len(batches[key]))
+		for k, i := range batches[key] {
+			batch[k] = terms[i]
+		}
+		s, err := statistics(sources[key], batch)
+		if err != nil {
+			return nil, err
+		}
+		for k, i := range batches[key] {
+			v[i] = s[k]
+		}
+	}
+	return v, nil
+}
+
+// DocumentFrequencies are the document frequencies of the terms, requested in a batch from each source.
+func (c *CompositeStatisticsSource) DocumentFrequencies(terms []TermField) ([]float64, error) {
+	return c.batch("DocumentFrequency", terms, DocumentFrequencies)
+}
+
+// TotalTermFrequencies are the total term frequencies of the terms, requested in a batch from each source.
+func (c *CompositeStatisticsSource) TotalTermFrequencies(terms []TermField) ([]float64, error) {
+	return c.batch("TotalTermFrequency", terms, TotalTermFrequencies)
+}
+
+// InverseDocumentFrequencies are the inverse document frequencies of the terms, requested in a batch from each
+// source.
+func (c *CompositeStatisticsSource) InverseDocumentFrequencies(terms []TermField) ([]float64, error) {
+	return c.batch("InverseDocumentFrequency", terms, InverseDocumentFrequencies)
+}
+
+// RetrievalSizes are the number of documents each query retrieves, requested in a batch.
+func (c *CompositeStatisticsSource) RetrievalSizes(queries []cqr.CommonQueryRepresentation) ([]float64, error) {
+	return RetrievalSizes(c.source("RetrievalSize", ""), queries)
+}
+
+// ErrUnknownDocument is the error of a document ID that cannot be translated.
+var ErrUnknownDocument = errors.New("unknown document")
+
+// DocumentIDs translates the IDs of documents between the IDs of a pipeline (e.g. PMIDs) and the IDs of a statistics
+// source.
+type DocumentIDs struct {
+	// To translates an ID of the pipeline to an ID of the statistics source.
+	To func(id string) (string, error)
+	// From translates an ID of the statistics source to an ID of the pipeline.
+	From func(id string) (string, error)
+}
+
+// DocumentIDMap translates document IDs with a map from the IDs of the pipeline to the IDs of a statistics source.
+// IDs that are not in the map are unknown documents.
+func DocumentIDMap(m map[string]string) DocumentIDs {
+	inverse := make(map[string]string, len(m))
+	for k, v := range m {
+		inverse[v] = k
+	}
+	lookup := func(m map[string]string) func(string) (string, error) {
+		return func(id string) (string, error) {
+			if v, ok := m[id]; ok {
+				return v, nil
+			}
+			return "", fmt.Errorf("%w %q", ErrUnknownDocument, id)
+		}
+	}
+	return DocumentIDs{To: lookup(m), From: lookup(inverse)}
+}
+
+// TranslatingStatisticsSource is a statistics source that translates the IDs of documents for another statistics
+// source. The documents given to TermFrequency and TermVector are translated to the IDs of the source, and the
+// documents it retrieves are translated back. Retrieved documents that are unknown (see ErrUnknownDocument) are left
+// out of the results.
+type TranslatingStatisticsSource struct {
+	source StatisticsSource
+	ids    DocumentIDs
+}
+
+// NewTranslatingStatisticsSource creates a statistics source that translates the IDs of documents for ss.
+func NewTranslatingStatisticsSource(ss StatisticsSource, ids DocumentIDs) *TranslatingStatisticsSource {
+	return &TranslatingStatisticsSource{source: ss, ids: ids}
+}
+
+// WithContext binds the translated statistics source to ctx, if it supports cancellation.
+func (t *TranslatingStatisticsSource) WithContext(ctx context.Context) StatisticsSource {
+	s := *t
+	s.source = WithContext(ctx, t.source)
+	return &s
+}
+
+// Concurrency is the number of requests that may be made to the translated statistics source at once.
+func (t *TranslatingStatisticsSource) Concurrency() int {
+	if s, ok := t.source.(ConcurrencyLimiter); ok {
+		return s.Concurrency()
+	}
+	return 0
+}
+
+// Identity is the identity of the translated statistics source.
+func (t *TranslatingStatisticsSource) Identity() string {
+	if s, ok := t.source.(IdentifiedStatisticsSource); ok {
+		return s.Identity()
+	}
+	return ""
+}
+
+func (t *TranslatingStatisticsSource) SearchOptions() SearchOptions {
+	return t.source.SearchOptions()
+}
+
+func (t *TranslatingStatisticsSource) Parameters() map[string]float64 {
+	return t.source.Parameters()
+}
+
+func (t *TranslatingStatisticsSource) TermFrequency(term, field, document string) (float64, error) {
+	id, err := t.ids.To(document)
+	if err != nil {
+		return 0, err
+	}
+	return t.source.TermFrequency(term, field, id)
+}
+
+func (t *TranslatingStatisticsSource) TermVector(document string) (TermVector, error) {
+	id, err := t.ids.To(document)
+	if err != nil {
+		return nil, err
+	}
+	return t.source.TermVector(id)
+}
+
+func (t *TranslatingStatisticsSource) DocumentFrequency(term, field string) (float64, error) {
+	return t.source.DocumentFrequency(term, field)
+}
+
+func (t *TranslatingStatisticsSource) TotalTermFrequency(term, field string) (float64, error) {
+	return t.source.TotalTermFrequency(term, field)
+}
+
+func (t *TranslatingStatisticsSource) InverseDocumentFrequency(term, field string) (float64, error) {
+	return t.source.InverseDocumentFrequency(term, field)
+}
+
+func (t *TranslatingStatisticsSource) RetrievalSize(query cqr.CommonQueryRepresentation) (float64, error) {
+	return t.source.RetrievalSize(query)
+}
+
+func (t *TranslatingStatisticsSource) VocabularySize(field string) (float64, error) {
+	return t.source.VocabularySize(field)
+}
+
+func (t *TranslatingStatisticsSource) Execute(query pipeline.Query, options SearchOptions) (trecresults.ResultList, error) {
+	results, err := t.source.Execute(query, options)
+	if err != nil {
+		return nil, err
+	}
+	translated := make(trecresults.ResultList, 0, len(results))
+	for _, result := range results {
+		id, err := t.ids.From(result.DocId)
+		if errors.Is(err, ErrUnknownDocument) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		r := *result
+		r.DocId = id
+		translated = append(translated, &r)
+	}
+	return translated, nil
+}
+
+func (t *TranslatingStatisticsSource) CollectionSize() (float64, error) {
+	return t.source.CollectionSize()
+}
+
+func (t *TranslatingStatisticsSource) DocumentFrequencies(terms []TermField) ([]float64, error) {
+	return DocumentFrequencies(t.source, terms)
+}
+
+func (t *TranslatingStatisticsSource) TotalTermFrequencies(terms []TermField) ([]float64, error) {
+	return TotalTermFrequencies(t.source, terms)
+}
+
+func (t *TranslatingStatisticsSource) InverseDocumentFrequencies(terms []TermField) ([]float64, error) {
+	return InverseDocumentFrequencies(t.source, terms)
+}
+
+func (t *TranslatingStatisticsSource) RetrievalSizes(queries []cqr.CommonQueryRepresentation) ([]float64, error) {
+	return RetrievalSizes(t.source, queries)
+}
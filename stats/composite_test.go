@@ -0,0 +1,125 @@
+package stats
+
+import (
+	"errors"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/pipeline"
+	"reflect"
+	"testing"
+)
+
+// constantSource is a statistics source whose document frequencies and retrieval sizes are a constant.
+type constantSource struct {
+	*IndexStatisticsSource
+	v      float64
+	params map[string]float64
+}
+
+func (c constantSource) DocumentFrequency(term, field string) (float64, error) {
+	return c.v, nil
+}
+
+func (c constantSource) RetrievalSize(query cqr.CommonQueryRepresentation) (float64, error) {
+	return c.v, nil
+}
+
+func (c constantSource) Parameters() map[string]float64 {
+	return c.params
+}
+
+func TestCompositeStatisticsSource(t *testing.T) {
+	s := testIndex(t)
+	c, err := NewCompositeStatisticsSource(
+		CompositeDefault(s),
+		CompositeRoute(constantSource{s, 100, map[string]float64{"mu": 2, "k": 1}}, "RetrievalSize"),
+		CompositeFieldRoute(constantSource{s, 7, nil}, "mesh_headings", "DocumentFrequency"),
+		CompositeParameters(map[string]float64{"k": 3}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []struct {
+		name      string
+		statistic func() (float64, error)
+		want      float64
+	}{
+		{"routed by field", func() (float64, error) { return c.DocumentFrequency("humans", "mesh_headings") }, 7},
+		{"default", func() (float64, error) { return c.DocumentFrequency("retinopathy", "text") }, 1},
+		{"method not routed by field", func() (float64, error) { return c.TotalTermFrequency("retinopathy", "text") }, 2},
+		{"routed by method", func() (float64, error) { return c.RetrievalSize(cqr.NewKeyword("metformin", "title")) }, 100},
+	} {
+		got, err := r.statistic()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != r.want {
+			t.Errorf("%s: expected %f, got %f", r.name, r.want, got)
+		}
+	}
+
+	df, err := DocumentFrequencies(c, []TermField{{"retinopathy", "text"}, {"humans", "mesh_headings"}, {"diabet*", "title"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(df, []float64{1, 7, 3}) {
+		t.Errorf("unexpected document frequencies %v", df)
+	}
+	if p := c.Parameters(); !reflect.DeepEqual(p, map[string]float64{"mu": 2, "k": 3}) {
+		t.Errorf("unexpected parameters %v", p)
+	}
+
+	for _, options := range [][]func(*CompositeStatisticsSource){
+		{CompositeRoute(s, "RetrievalSize")},
+		{CompositeDefault(s), CompositeRoute(s, "Retrieve")},
+		{CompositeDefault(s), CompositeFieldRoute(s, "title", "RetrievalSize")},
+	} {
+		if _, err := NewCompositeStatisticsSource(options...); err == nil {
+			t.Error("expected an error for an invalid composite statistics source")
+		}
+	}
+}
+
+func TestTranslatingStatisticsSource(t *testing.T) {
+	s := testIndex(t)
+	tr := NewTranslatingStatisticsSource(s, DocumentIDMap(map[string]string{"a": "1", "b": "2", "c": "3"}))
+
+	query := pipeline.NewQuery("q", "1", cqr.NewKeyword("diabet*", "title"))
+	results, err := s.Execute(query, SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	translated, err := tr.Execute(query, SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, r := range results {
+		switch r.DocId {
+		case "1", "2", "3":
+			want = append(want, string('a'+r.DocId[0]-'1'))
+		}
+	}
+	var got []string
+	for _, r := range translated {
+		got = append(got, r.DocId)
+	}
+	if len(want) == len(results) || !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the documents %v (of %d), got %v", want, len(results), got)
+	}
+
+	tv, err := tr.TermVector("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := s.TermVector("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tv, expected) {
+		t.Errorf("expected the term vector of document 1, got %v", tv)
+	}
+	if _, err := tr.TermVector("4"); !errors.Is(err, ErrUnknownDocument) {
+		t.Errorf("expected an unknown document error, got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/hscells/cqr"
 	gpipeline "github.com/hscells/groove/pipeline"
 	"github.com/hscells/transmute/backend"
@@ -17,7 +18,6 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -34,7 +34,23 @@ type ElasticsearchStatisticsSource struct {
 	Analyser     string
 	AnalyseField string
 
-	wg sync.WaitGroup
+	ctx         context.Context
+	concurrency int
+}
+
+// WithContext returns a copy of the statistics source whose requests to Elasticsearch are bound to ctx.
+func (es *ElasticsearchStatisticsSource) WithContext(ctx context.Context) StatisticsSource {
+	c := *es
+	c.ctx = ctx
+	return &c
+}
+
+// context is the context requests to Elasticsearch are made with.
+func (es *ElasticsearchStatisticsSource) context() context.Context {
+	if es.ctx == nil {
+		return context.Background()
+	}
+	return es.ctx
 }
 
 // SearchOptions gets the immutable execute options for the statistics source.
@@ -49,7 +65,7 @@ func (es *ElasticsearchStatisticsSource) Parameters() map[string]float64 {
 
 // TermFrequency is the term frequency in the field.
 func (es *ElasticsearchStatisticsSource) TermFrequency(term, field, document string) (float64, error) {
-	resp, err := es.client.TermVectors(es.index, es.documentType).Id(document).Do(context.Background())
+	resp, err := es.client.TermVectors(es.index, es.documentType).Id(document).Do(es.context())
 	if err != nil {
 		return 0, err
 	}
@@ -72,7 +88,7 @@ func (es *ElasticsearchStatisticsSource) DocumentFrequency(term string, field st
 		Payloads(false).
 		Fields(field).
 		PerFieldAnalyzer(map[string]string{field: ""}).
-		Do(context.Background())
+		Do(es.context())
 	if err != nil {
 		return 0, err
 	}
@@ -99,7 +115,7 @@ func (es *ElasticsearchStatisticsSource) TotalTermFrequency(term, field string)
 		req = req.PerFieldAnalyzer(map[string]string{docField: "medline_analyser"})
 	}
 
-	resp, err := req.Do(context.Background())
+	resp, err := req.Do(es.context())
 	if err != nil {
 		return 0.0, err
 	}
@@ -115,7 +131,7 @@ func (es *ElasticsearchStatisticsSource) TotalTermFrequency(term, field string)
 // InverseDocumentFrequency is the ratio of of documents in the collection to the number of documents the term appears
 // in, logarithmically smoothed.
 func (es *ElasticsearchStatisticsSource) InverseDocumentFrequency(term, field string) (float64, error) {
-	resp1, err := es.client.IndexStats(es.index).Do(context.Background())
+	resp1, err := es.client.IndexStats(es.index).Do(es.context())
 	if err != nil {
 		return 0.0, err
 	}
@@ -137,7 +153,7 @@ func (es *ElasticsearchStatisticsSource) InverseDocumentFrequency(term, field st
 		req = req.PerFieldAnalyzer(map[string]string{docField: "medline_analyser"})
 	}
 
-	resp2, err := req.Do(context.Background())
+	resp2, err := req.Do(es.context())
 	if err != nil {
 		return 0.0, err
 	}
@@ -164,7 +180,7 @@ func (es *ElasticsearchStatisticsSource) VocabularySize(field string) (float64,
 		Payloads(false).
 		Fields(field).
 		PerFieldAnalyzer(map[string]string{field: ""}).
-		Do(context.Background())
+		Do(es.context())
 	if err != nil {
 		return 0.0, err
 	}
@@ -183,7 +199,7 @@ func (es *ElasticsearchStatisticsSource) RetrievalSize(query cqr.CommonQueryRepr
 	// Only then can we issue it to Elasticsearch using our API.
 	result, err := es.client.Count(es.index).
 		Query(elastic.NewRawStringQuery(q)).
-		Do(context.Background())
+		Do(es.context())
 	if err != nil {
 		return 0.0, err
 	}
@@ -205,7 +221,7 @@ func (es *ElasticsearchStatisticsSource) TermVector(document string) (TermVector
 		Payloads(false).
 		Fields("*")
 
-	resp, err := req.Do(context.Background())
+	resp, err := req.Do(es.context())
 	if err != nil {
 		return tv, err
 	}
@@ -266,7 +282,7 @@ func (es *ElasticsearchStatisticsSource) ExecuteFast(query gpipeline.Query, opti
 						Query(elastic.NewRawStringQuery(q)))
 
 			for {
-				result, err := svc.Do(context.Background())
+				result, err := svc.Do(es.context())
 				if err == io.EOF {
 					break
 				}
@@ -291,7 +307,7 @@ func (es *ElasticsearchStatisticsSource) ExecuteFast(query gpipeline.Query, opti
 				log.Printf("%v: %v/%v\n", n, len(hits[n]), result.Hits.TotalHits)
 			}
 
-			err = svc.Clear(context.Background())
+			err = svc.Clear(es.context())
 			if err != nil {
 				log.Println(err)
 				//panic(err)
@@ -306,6 +322,11 @@ func (es *ElasticsearchStatisticsSource) ExecuteFast(query gpipeline.Query, opti
 		sem <- true
 	}
 
+	// Partial results are not returned for cancelled requests.
+	if err := es.context().Err(); err != nil {
+		return nil, err
+	}
+
 	var results []uint32
 	for _, hit := range hits {
 		results = append(results, hit...)
@@ -344,7 +365,7 @@ func (es *ElasticsearchStatisticsSource) Execute(query gpipeline.Query, options
 					Query(elastic.NewRawStringQuery(q)))
 
 		for {
-			result, err := svc.Do(context.Background())
+			result, err := svc.Do(es.context())
 			if err == io.EOF {
 				break
 			}
@@ -355,7 +376,7 @@ func (es *ElasticsearchStatisticsSource) Execute(query gpipeline.Query, options
 			hits = append(hits, result.Hits.Hits...)
 		}
 
-		err = svc.Clear(context.Background())
+		err = svc.Clear(es.context())
 		if err != nil {
 			return nil, err
 		}
@@ -385,7 +406,7 @@ func (es *ElasticsearchStatisticsSource) Execute(query gpipeline.Query, options
 		Query(elastic.NewRawStringQuery(q)).
 		Size(options.Size).
 		NoStoredFields().
-		Do(context.Background())
+		Do(es.context())
 	if err != nil {
 		return nil, err
 	}
@@ -413,7 +434,7 @@ func (es *ElasticsearchStatisticsSource) CollectionSize() (float64, error) {
 
 // Analyse is a specific Elasticsearch method used in the analyse transformation.
 func (es *ElasticsearchStatisticsSource) Analyse(text, analyser string) (tokens []string, err error) {
-	res, err := es.client.IndexAnalyze().Index(es.index).Analyzer(analyser).Text(text).Do(context.Background())
+	res, err := es.client.IndexAnalyze().Index(es.index).Analyzer(analyser).Text(text).Do(es.context())
 	if err != nil {
 		return
 	}
@@ -423,6 +444,152 @@ func (es *ElasticsearchStatisticsSource) Analyse(text, analyser string) (tokens
 	return
 }
 
+// Analysis is the analyser queries are analysed with, and the field that analysed text is searched in.
+func (es *ElasticsearchStatisticsSource) Analysis() (analyser, field string) {
+	return es.Analyser, es.AnalyseField
+}
+
+// termVectors requests the term vectors of an artificial document for each term, in a single multi term vectors
+// request. The term vectors are in the order of the terms.
+func (es *ElasticsearchStatisticsSource) termVectors(terms []TermField, item func(t TermField) *elastic.MultiTermvectorItem) ([]*elastic.TermvectorsResponse, error) {
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	req := es.client.MultiTermVectors().Index(es.index).Type(es.documentType)
+	for _, t := range terms {
+		req = req.Add(item(t).Index(es.index).Type(es.documentType))
+	}
+	resp, err := req.Do(es.context())
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Docs) != len(terms) {
+		return nil, fmt.Errorf("requested %d term vectors, got %d", len(terms), len(resp.Docs))
+	}
+	return resp.Docs, nil
+}
+
+// DocumentFrequencies are the document frequencies of the terms, requested in a single multi term vectors request.
+func (es *ElasticsearchStatisticsSource) DocumentFrequencies(terms []TermField) ([]float64, error) {
+	docs, err := es.termVectors(terms, func(t TermField) *elastic.MultiTermvectorItem {
+		return elastic.NewMultiTermvectorItem().
+			Doc(map[string]string{t.Field: t.Term}).
+			FieldStatistics(false).
+			TermStatistics(true).
+			Offsets(false).
+			Positions(false).
+			Payloads(false).
+			Fields(t.Field).
+			PerFieldAnalyzer(map[string]string{t.Field: ""})
+	})
+	if err != nil {
+		return nil, err
+	}
+	v := make([]float64, len(terms))
+	for i, t := range terms {
+		if tv, ok := docs[i].TermVectors[t.Field]; ok {
+			v[i] = float64(tv.Terms[t.Term].DocFreq)
+		}
+	}
+	return v, nil
+}
+
+// TotalTermFrequencies are the total term frequencies of the terms, requested in a single multi term vectors request.
+func (es *ElasticsearchStatisticsSource) TotalTermFrequencies(terms []TermField) ([]float64, error) {
+	docs, err := es.termVectors(terms, func(t TermField) *elastic.MultiTermvectorItem {
+		item := elastic.NewMultiTermvectorItem().
+			Doc(map[string]string{t.Field: t.Term}).
+			TermStatistics(true).
+			Offsets(false).
+			Positions(false).
+			Payloads(false)
+		if strings.ContainsRune(t.Term, '*') {
+			docField := strings.Replace(t.Field, es.AnalyseField, "", -1)
+			item = item.PerFieldAnalyzer(map[string]string{docField: "medline_analyser"})
+		}
+		return item
+	})
+	if err != nil {
+		return nil, err
+	}
+	v := make([]float64, len(terms))
+	for i, t := range terms {
+		if tv, ok := docs[i].TermVectors[t.Field]; ok {
+			term := strings.ToLower(strings.Replace(strings.Replace(strings.Replace(t.Term, "\"", "", -1), "*", "", -1), "~", "", -1))
+			v[i] = float64(tv.Terms[term].Ttf)
+		}
+	}
+	return v, nil
+}
+
+// InverseDocumentFrequencies are the inverse document frequencies of the terms, requested in a single multi term
+// vectors request.
+func (es *ElasticsearchStatisticsSource) InverseDocumentFrequencies(terms []TermField) ([]float64, error) {
+	indexStats, err := es.client.IndexStats(es.index).Do(es.context())
+	if err != nil {
+		return nil, err
+	}
+	N := indexStats.All.Total.Docs.Count
+
+	docs, err := es.termVectors(terms, func(t TermField) *elastic.MultiTermvectorItem {
+		docField := strings.Replace(t.Field, es.AnalyseField, "", -1)
+		item := elastic.NewMultiTermvectorItem().
+			Doc(map[string]string{docField: t.Term}).
+			FieldStatistics(false).
+			TermStatistics(true).
+			Offsets(false).
+			Positions(false).
+			Payloads(false)
+		if strings.ContainsRune(t.Term, '*') {
+			item = item.PerFieldAnalyzer(map[string]string{docField: "medline_analyser"})
+		}
+		return item
+	})
+	if err != nil {
+		return nil, err
+	}
+	v := make([]float64, len(terms))
+	for i, t := range terms {
+		if tv, ok := docs[i].TermVectors[t.Field]; ok {
+			if nt := tv.Terms[t.Term].DocFreq; nt > 0 {
+				v[i] = idf(float64(N), float64(nt))
+			}
+		}
+	}
+	return v, nil
+}
+
+// RetrievalSizes are the number of documents each query retrieves, requested in a single multi search request.
+func (es *ElasticsearchStatisticsSource) RetrievalSizes(queries []cqr.CommonQueryRepresentation) ([]float64, error) {
+	if len(queries) == 0 {
+		return nil, nil
+	}
+	req := es.client.MultiSearch()
+	for _, query := range queries {
+		q, err := toElasticsearch(query)
+		if err != nil {
+			return nil, err
+		}
+		source := elastic.NewSearchSource().Query(elastic.NewRawStringQuery(q)).Size(0)
+		req = req.Add(elastic.NewSearchRequest().Index(es.index).Source(source))
+	}
+	resp, err := req.Do(es.context())
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Responses) != len(queries) {
+		return nil, fmt.Errorf("requested %d searches, got %d", len(queries), len(resp.Responses))
+	}
+	v := make([]float64, len(queries))
+	for i, r := range resp.Responses {
+		if r.Error != nil {
+			return nil, fmt.Errorf("%s: %s", r.Error.Type, r.Error.Reason)
+		}
+		v[i] = float64(r.TotalHits())
+	}
+	return v, nil
+}
+
 // toElasticsearch transforms a cqr query into an Elasticsearch query.
 func toElasticsearch(query cqr.CommonQueryRepresentation) (string, error) {
 	var result map[string]interface{}
@@ -553,6 +720,25 @@ func ElasticsearchScroll(scroll bool) func(*ElasticsearchStatisticsSource) {
 	}
 }
 
+// ElasticsearchConcurrency sets the number of requests that may be made to Elasticsearch at once. By default there is
+// no limit.
+func ElasticsearchConcurrency(n int) func(*ElasticsearchStatisticsSource) {
+	return func(es *ElasticsearchStatisticsSource) {
+		es.concurrency = n
+		return
+	}
+}
+
+// Concurrency is the number of requests that may be made to Elasticsearch at once, or zero for no limit.
+func (es *ElasticsearchStatisticsSource) Concurrency() int {
+	return es.concurrency
+}
+
+// Identity is the Elasticsearch index and document type that are searched, and how they are analysed.
+func (es *ElasticsearchStatisticsSource) Identity() string {
+	return strings.Join([]string{es.index, es.documentType, es.Analyser, es.AnalyseField}, "/")
+}
+
 // NewElasticsearchStatisticsSource creates a new ElasticsearchStatisticsSource using functional options.
 func NewElasticsearchStatisticsSource(options ...func(*ElasticsearchStatisticsSource)) (*ElasticsearchStatisticsSource, error) {
 	es := &ElasticsearchStatisticsSource{}
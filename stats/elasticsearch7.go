@@ -0,0 +1,622 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hscells/cqr"
+	gpipeline "github.com/hscells/groove/pipeline"
+	"github.com/hscells/trecresults"
+	"github.com/olivere/elastic/v7"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// pointInTimeKeepAlive is how long a point in time is kept between the pages of a search.
+const pointInTimeKeepAlive = "5m"
+
+// Elasticsearch7StatisticsSource is a way of gathering statistics for a collection using Elasticsearch 7 (or later)
+// or OpenSearch. Unlike ElasticsearchStatisticsSource, indices do not have document types, and searches that retrieve
+// every document (see Elasticsearch7Scroll) page through a point in time with search_after instead of scrolling.
+type Elasticsearch7StatisticsSource struct {
+	client *elastic.Client
+	hosts  []string
+	index  string
+	// openSearch is whether the cluster is OpenSearch, whose point in time API differs from Elasticsearch.
+	openSearch bool
+
+	options    SearchOptions
+	parameters map[string]float64
+
+	Scroll       bool
+	Analyser     string
+	AnalyseField string
+
+	ctx         context.Context
+	concurrency int
+}
+
+// WithContext returns a copy of the statistics source whose requests to Elasticsearch are bound to ctx.
+func (es *Elasticsearch7StatisticsSource) WithContext(ctx context.Context) StatisticsSource {
+	c := *es
+	c.ctx = ctx
+	return &c
+}
+
+// context is the context requests to Elasticsearch are made with.
+func (es *Elasticsearch7StatisticsSource) context() context.Context {
+	if es.ctx == nil {
+		return context.Background()
+	}
+	return es.ctx
+}
+
+// SearchOptions gets the immutable execute options for the statistics source.
+func (es *Elasticsearch7StatisticsSource) SearchOptions() SearchOptions {
+	return es.options
+}
+
+// Parameters gets the immutable parameters for the statistics source.
+func (es *Elasticsearch7StatisticsSource) Parameters() map[string]float64 {
+	return es.parameters
+}
+
+// TermFrequency is the term frequency in the field.
+func (es *Elasticsearch7StatisticsSource) TermFrequency(term, field, document string) (float64, error) {
+	resp, err := es.client.TermVectors(es.index).Id(document).Do(es.context())
+	if err != nil {
+		return 0, err
+	}
+
+	if tv, ok := resp.TermVectors[field]; ok {
+		return float64(tv.Terms[term].TermFreq), nil
+	}
+
+	return 0.0, nil
+}
+
+// DocumentFrequency is the document frequency (the number of documents containing the current term).
+func (es *Elasticsearch7StatisticsSource) DocumentFrequency(term string, field string) (float64, error) {
+	v, err := es.DocumentFrequencies([]TermField{{Term: term, Field: field}})
+	if err != nil {
+		return 0, err
+	}
+	return v[0], nil
+}
+
+// TotalTermFrequency is a sum of total term frequencies (the sum of total term frequencies of each term in this field).
+func (es *Elasticsearch7StatisticsSource) TotalTermFrequency(term, field string) (float64, error) {
+	v, err := es.TotalTermFrequencies([]TermField{{Term: term, Field: field}})
+	if err != nil {
+		return 0, err
+	}
+	return v[0], nil
+}
+
+// InverseDocumentFrequency is the ratio of of documents in the collection to the number of documents the term appears
+// in, logarithmically smoothed.
+func (es *Elasticsearch7StatisticsSource) InverseDocumentFrequency(term, field string) (float64, error) {
+	v, err := es.InverseDocumentFrequencies([]TermField{{Term: term, Field: field}})
+	if err != nil {
+		return 0, err
+	}
+	return v[0], nil
+}
+
+// VocabularySize is the total number of terms in the vocabulary.
+func (es *Elasticsearch7StatisticsSource) VocabularySize(field string) (float64, error) {
+	resp, err := es.client.TermVectors(es.index).
+		Doc(map[string]string{field: ""}).
+		Offsets(false).
+		Positions(false).
+		Realtime(false).
+		Pretty(false).
+		Payloads(false).
+		Fields(field).
+		PerFieldAnalyzer(map[string]string{field: ""}).
+		Do(es.context())
+	if err != nil {
+		return 0.0, err
+	}
+
+	return float64(resp.TermVectors[field].FieldStatistics.SumTtf), nil
+}
+
+// RetrievalSize is the minimum number of documents that contains at least one of the query terms.
+func (es *Elasticsearch7StatisticsSource) RetrievalSize(query cqr.CommonQueryRepresentation) (float64, error) {
+	q, err := toElasticsearch(query)
+	if err != nil {
+		return 0.0, err
+	}
+
+	result, err := es.client.Count(es.index).
+		Query(elastic.NewRawStringQuery(q)).
+		Do(es.context())
+	if err != nil {
+		return 0.0, err
+	}
+
+	return float64(result), nil
+}
+
+// TermVector retrieves the term vector for a document.
+func (es *Elasticsearch7StatisticsSource) TermVector(document string) (TermVector, error) {
+	tv := TermVector{}
+
+	resp, err := es.client.TermVectors(es.index).
+		Id(document).
+		FieldStatistics(true).
+		TermStatistics(true).
+		Offsets(false).
+		Pretty(false).
+		Positions(false).
+		Payloads(false).
+		Fields("*").
+		Do(es.context())
+	if err != nil {
+		return tv, err
+	}
+
+	for field, vector := range resp.TermVectors {
+		for term, vec := range vector.Terms {
+			tv = append(tv, TermVectorTerm{
+				Term:               term,
+				Field:              field,
+				DocumentFrequency:  float64(vec.DocFreq),
+				TermFrequency:      float64(vec.TermFreq),
+				TotalTermFrequency: float64(vec.Ttf),
+			})
+		}
+	}
+
+	return tv, nil
+}
+
+// Execute runs the query on Elasticsearch and returns results in trec format. When scrolling, every document the
+// query retrieves is returned, in pages of the size of the search options.
+func (es *Elasticsearch7StatisticsSource) Execute(query gpipeline.Query, options SearchOptions) (trecresults.ResultList, error) {
+	q, err := toElasticsearch(query.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	if es.Scroll {
+		ids, err := es.page(q, options.Size)
+		if err != nil {
+			return nil, err
+		}
+		results := make(trecresults.ResultList, len(ids))
+		for i, id := range ids {
+			results[i] = &trecresults.Result{
+				Topic:     query.Topic,
+				Iteration: "Q0",
+				DocId:     id,
+				Rank:      int64(i),
+				RunName:   options.RunName,
+			}
+		}
+		return results, nil
+	}
+
+	// Regular execute. The total number of hits is not needed, so it is not counted.
+	result, err := es.client.Search(es.index).
+		Query(elastic.NewRawStringQuery(q)).
+		Size(options.Size).
+		TrackTotalHits(false).
+		FetchSource(false).
+		Do(es.context())
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(trecresults.ResultList, len(result.Hits.Hits))
+	for i, hit := range result.Hits.Hits {
+		results[i] = &trecresults.Result{
+			Topic:     query.Topic,
+			Iteration: "Q0",
+			DocId:     hit.Id,
+			Rank:      int64(i),
+			RunName:   options.RunName,
+		}
+		if hit.Score != nil {
+			results[i].Score = *hit.Score
+		}
+	}
+	return results, nil
+}
+
+// pitSearchResult is a page of the results of a search through a point in time.
+type pitSearchResult struct {
+	PitID string `json:"pit_id"`
+	Hits  struct {
+		Hits []struct {
+			ID   string        `json:"_id"`
+			Sort []interface{} `json:"sort"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// page retrieves the IDs of every document a query retrieves, by searching through a point in time with search_after
+// in pages of size documents.
+func (es *Elasticsearch7StatisticsSource) page(query string, size int) ([]string, error) {
+	if size <= 0 {
+		size = 10000
+	}
+	ctx := es.context()
+	pit, err := es.openPointInTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sort := []interface{}{map[string]string{"_shard_doc": "asc"}}
+	if es.openSearch {
+		sort = []interface{}{map[string]string{"_doc": "asc"}, map[string]string{"_id": "asc"}}
+	}
+
+	var (
+		ids   []string
+		after []interface{}
+	)
+	for {
+		body := map[string]interface{}{
+			"query":            json.RawMessage(query),
+			"size":             size,
+			"_source":          false,
+			"track_total_hits": false,
+			"pit":              map[string]string{"id": pit, "keep_alive": pointInTimeKeepAlive},
+			"sort":             sort,
+		}
+		if after != nil {
+			body["search_after"] = after
+		}
+		resp, err := es.client.PerformRequest(ctx, elastic.PerformRequestOptions{
+			Method: http.MethodPost,
+			Path:   "/_search",
+			Body:   body,
+		})
+		if err != nil {
+			es.closePointInTime(pit)
+			return nil, err
+		}
+		var result pitSearchResult
+		if err := json.Unmarshal(resp.Body, &result); err != nil {
+			es.closePointInTime(pit)
+			return nil, err
+		}
+		// The ID of a point in time may change between searches.
+		if len(result.PitID) > 0 {
+			pit = result.PitID
+		}
+		hits := result.Hits.Hits
+		for _, hit := range hits {
+			ids = append(ids, hit.ID)
+		}
+		if len(hits) < size {
+			break
+		}
+		after = hits[len(hits)-1].Sort
+	}
+	return ids, es.closePointInTime(pit)
+}
+
+// openPointInTime opens a point in time over the index, returning its ID.
+func (es *Elasticsearch7StatisticsSource) openPointInTime(ctx context.Context) (string, error) {
+	path := fmt.Sprintf("/%s/_pit", url.PathEscape(es.index))
+	if es.openSearch {
+		path = fmt.Sprintf("/%s/_search/point_in_time", url.PathEscape(es.index))
+	}
+	resp, err := es.client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: http.MethodPost,
+		Path:   path,
+		Params: url.Values{"keep_alive": {pointInTimeKeepAlive}},
+	})
+	if err != nil {
+		return "", err
+	}
+	var pit struct {
+		ID    string `json:"id"`
+		PitID string `json:"pit_id"`
+	}
+	if err := json.Unmarshal(resp.Body, &pit); err != nil {
+		return "", err
+	}
+	if len(pit.PitID) > 0 {
+		return pit.PitID, nil
+	}
+	return pit.ID, nil
+}
+
+// closePointInTime closes a point in time. It is closed even if the context of the statistics source is done, as it
+// would otherwise be kept open until it expires.
+func (es *Elasticsearch7StatisticsSource) closePointInTime(pit string) error {
+	opts := elastic.PerformRequestOptions{
+		Method: http.MethodDelete,
+		Path:   "/_pit",
+		Body:   map[string]string{"id": pit},
+	}
+	if es.openSearch {
+		opts.Path = "/_search/point_in_time"
+		opts.Body = map[string][]string{"pit_id": {pit}}
+	}
+	_, err := es.client.PerformRequest(context.Background(), opts)
+	return err
+}
+
+// CollectionSize is the number of documents in the index.
+func (es *Elasticsearch7StatisticsSource) CollectionSize() (float64, error) {
+	n, err := es.client.Count(es.index).Do(es.context())
+	return float64(n), err
+}
+
+// Analyse is a specific Elasticsearch method used in the analyse transformation.
+func (es *Elasticsearch7StatisticsSource) Analyse(text, analyser string) (tokens []string, err error) {
+	res, err := es.client.IndexAnalyze().Index(es.index).Analyzer(analyser).Text(text).Do(es.context())
+	if err != nil {
+		return
+	}
+	for _, token := range res.Tokens {
+		tokens = append(tokens, token.Token)
+	}
+	return
+}
+
+// Analysis is the analyser queries are analysed with, and the field that analysed text is searched in.
+func (es *Elasticsearch7StatisticsSource) Analysis() (analyser, field string) {
+	return es.Analyser, es.AnalyseField
+}
+
+// termVectors requests the term vectors of an artificial document for each term, in a single multi term vectors
+// request. The term vectors are in the order of the terms.
+func (es *Elasticsearch7StatisticsSource) termVectors(terms []TermField, item func(t TermField) *elastic.MultiTermvectorItem) ([]*elastic.TermvectorsResponse, error) {
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	req := es.client.MultiTermVectors().Index(es.index)
+	for _, t := range terms {
+		req = req.Add(item(t).Index(es.index))
+	}
+	resp, err := req.Do(es.context())
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Docs) != len(terms) {
+		return nil, fmt.Errorf("requested %d term vectors, got %d", len(terms), len(resp.Docs))
+	}
+	return resp.Docs, nil
+}
+
+// DocumentFrequencies are the document frequencies of the terms, requested in a single multi term vectors request.
+func (es *Elasticsearch7StatisticsSource) DocumentFrequencies(terms []TermField) ([]float64, error) {
+	docs, err := es.termVectors(terms, func(t TermField) *elastic.MultiTermvectorItem {
+		return elastic.NewMultiTermvectorItem().
+			Doc(map[string]string{t.Field: t.Term}).
+			FieldStatistics(false).
+			TermStatistics(true).
+			Offsets(false).
+			Positions(false).
+			Payloads(false).
+			Fields(t.Field).
+			PerFieldAnalyzer(map[string]string{t.Field: ""})
+	})
+	if err != nil {
+		return nil, err
+	}
+	v := make([]float64, len(terms))
+	for i, t := range terms {
+		if tv, ok := docs[i].TermVectors[t.Field]; ok {
+			v[i] = float64(tv.Terms[t.Term].DocFreq)
+		}
+	}
+	return v, nil
+}
+
+// TotalTermFrequencies are the total term frequencies of the terms, requested in a single multi term vectors request.
+func (es *Elasticsearch7StatisticsSource) TotalTermFrequencies(terms []TermField) ([]float64, error) {
+	docs, err := es.termVectors(terms, func(t TermField) *elastic.MultiTermvectorItem {
+		item := elastic.NewMultiTermvectorItem().
+			Doc(map[string]string{t.Field: t.Term}).
+			TermStatistics(true).
+			Offsets(false).
+			Positions(false).
+			Payloads(false)
+		if strings.ContainsRune(t.Term, '*') {
+			docField := strings.Replace(t.Field, es.AnalyseField, "", -1)
+			item = item.PerFieldAnalyzer(map[string]string{docField: "medline_analyser"})
+		}
+		return item
+	})
+	if err != nil {
+		return nil, err
+	}
+	v := make([]float64, len(terms))
+	for i, t := range terms {
+		if tv, ok := docs[i].TermVectors[t.Field]; ok {
+			term := strings.ToLower(strings.Replace(strings.Replace(strings.Replace(t.Term, "\"", "", -1), "*", "", -1), "~", "", -1))
+			v[i] = float64(tv.Terms[term].Ttf)
+		}
+	}
+	return v, nil
+}
+
+// InverseDocumentFrequencies are the inverse document frequencies of the terms, requested in a single multi term
+// vectors request.
+func (es *Elasticsearch7StatisticsSource) InverseDocumentFrequencies(terms []TermField) ([]float64, error) {
+	N, err := es.CollectionSize()
+	if err != nil {
+		return nil, err
+	}
+
+	docs, err := es.termVectors(terms, func(t TermField) *elastic.MultiTermvectorItem {
+		docField := strings.Replace(t.Field, es.AnalyseField, "", -1)
+		item := elastic.NewMultiTermvectorItem().
+			Doc(map[string]string{docField: t.Term}).
+			FieldStatistics(false).
+			TermStatistics(true).
+			Offsets(false).
+			Positions(false).
+			Payloads(false)
+		if strings.ContainsRune(t.Term, '*') {
+			item = item.PerFieldAnalyzer(map[string]string{docField: "medline_analyser"})
+		}
+		return item
+	})
+	if err != nil {
+		return nil, err
+	}
+	v := make([]float64, len(terms))
+	for i, t := range terms {
+		if tv, ok := docs[i].TermVectors[t.Field]; ok {
+			if nt := tv.Terms[t.Term].DocFreq; nt > 0 {
+				v[i] = idf(N, float64(nt))
+			}
+		}
+	}
+	return v, nil
+}
+
+// RetrievalSizes are the number of documents each query retrieves, requested in a single multi search request.
+// Searches count every hit (track_total_hits), as Elasticsearch 7 otherwise stops counting at 10,000.
+func (es *Elasticsearch7StatisticsSource) RetrievalSizes(queries []cqr.CommonQueryRepresentation) ([]float64, error) {
+	if len(queries) == 0 {
+		return nil, nil
+	}
+	req := es.client.MultiSearch()
+	for _, query := range queries {
+		q, err := toElasticsearch(query)
+		if err != nil {
+			return nil, err
+		}
+		source := elastic.NewSearchSource().Query(elastic.NewRawStringQuery(q)).Size(0).TrackTotalHits(true)
+		req = req.Add(elastic.NewSearchRequest().Index(es.index).Source(source))
+	}
+	resp, err := req.Do(es.context())
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Responses) != len(queries) {
+		return nil, fmt.Errorf("requested %d searches, got %d", len(queries), len(resp.Responses))
+	}
+	v := make([]float64, len(queries))
+	for i, r := range resp.Responses {
+		if r.Error != nil {
+			return nil, fmt.Errorf("%s: %s", r.Error.Type, r.Error.Reason)
+		}
+		v[i] = float64(r.TotalHits())
+	}
+	return v, nil
+}
+
+// Elasticsearch7Hosts sets the hosts for the Elasticsearch client. By default, this is http://localhost:9200.
+func Elasticsearch7Hosts(hosts ...string) func(*Elasticsearch7StatisticsSource) {
+	return func(es *Elasticsearch7StatisticsSource) {
+		es.hosts = hosts
+	}
+}
+
+// Elasticsearch7Client sets the Elasticsearch client, instead of creating one for the hosts.
+func Elasticsearch7Client(client *elastic.Client) func(*Elasticsearch7StatisticsSource) {
+	return func(es *Elasticsearch7StatisticsSource) {
+		es.client = client
+	}
+}
+
+// Elasticsearch7Index sets the index for the Elasticsearch client.
+func Elasticsearch7Index(index string) func(*Elasticsearch7StatisticsSource) {
+	return func(es *Elasticsearch7StatisticsSource) {
+		es.index = index
+	}
+}
+
+// Elasticsearch7SearchOptions sets the execute options for the statistic source.
+func Elasticsearch7SearchOptions(options SearchOptions) func(*Elasticsearch7StatisticsSource) {
+	return func(es *Elasticsearch7StatisticsSource) {
+		es.options = options
+	}
+}
+
+// Elasticsearch7Parameters sets the parameters for the statistic source.
+func Elasticsearch7Parameters(params map[string]float64) func(*Elasticsearch7StatisticsSource) {
+	return func(es *Elasticsearch7StatisticsSource) {
+		es.parameters = params
+	}
+}
+
+// Elasticsearch7Analyser sets the analyser for the statistic source.
+func Elasticsearch7Analyser(analyser string) func(*Elasticsearch7StatisticsSource) {
+	return func(es *Elasticsearch7StatisticsSource) {
+		es.Analyser = analyser
+	}
+}
+
+// Elasticsearch7AnalysedField sets the analysed field for the statistic source.
+func Elasticsearch7AnalysedField(field string) func(*Elasticsearch7StatisticsSource) {
+	return func(es *Elasticsearch7StatisticsSource) {
+		es.AnalyseField = field
+	}
+}
+
+// Elasticsearch7Scroll sets whether executing a query retrieves every document, rather than the size of the search
+// options.
+func Elasticsearch7Scroll(scroll bool) func(*Elasticsearch7StatisticsSource) {
+	return func(es *Elasticsearch7StatisticsSource) {
+		es.Scroll = scroll
+	}
+}
+
+// Elasticsearch7Concurrency sets the number of requests that may be made to Elasticsearch at once. By default there is
+// no limit.
+func Elasticsearch7Concurrency(n int) func(*Elasticsearch7StatisticsSource) {
+	return func(es *Elasticsearch7StatisticsSource) {
+		es.concurrency = n
+	}
+}
+
+// Concurrency is the number of requests that may be made to Elasticsearch at once, or zero for no limit.
+func (es *Elasticsearch7StatisticsSource) Concurrency() int {
+	return es.concurrency
+}
+
+// Identity is the Elasticsearch index that is searched, and how it is analysed.
+func (es *Elasticsearch7StatisticsSource) Identity() string {
+	return strings.Join([]string{es.index, es.Analyser, es.AnalyseField}, "/")
+}
+
+// NewElasticsearch7StatisticsSource creates a new Elasticsearch7StatisticsSource using functional options. Whether the
+// cluster is Elasticsearch or OpenSearch is determined from the cluster itself.
+func NewElasticsearch7StatisticsSource(options ...func(*Elasticsearch7StatisticsSource)) (*Elasticsearch7StatisticsSource, error) {
+	es := &Elasticsearch7StatisticsSource{}
+	for _, option := range options {
+		option(es)
+	}
+
+	if es.client == nil {
+		hosts := es.hosts
+		if len(hosts) == 0 {
+			hosts = []string{"http://localhost:9200"}
+		}
+		// Nodes are not sniffed, as the addresses they advertise often cannot be reached (e.g. from outside a
+		// container), and OpenSearch does not answer sniffing requests the way the client expects.
+		var err error
+		es.client, err = elastic.NewClient(elastic.SetURL(hosts...), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := es.client.PerformRequest(es.context(), elastic.PerformRequestOptions{Method: http.MethodGet, Path: "/"})
+	if err != nil {
+		return nil, err
+	}
+	var info struct {
+		Version struct {
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	if err := json.Unmarshal(resp.Body, &info); err != nil {
+		return nil, err
+	}
+	es.openSearch = info.Version.Distribution == "opensearch"
+
+	return es, nil
+}
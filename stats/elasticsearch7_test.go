@@ -0,0 +1,244 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hscells/cqr"
+	gpipeline "github.com/hscells/groove/pipeline"
+	"github.com/olivere/elastic/v7"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// es7Stub is an Elasticsearch 7 (or OpenSearch) cluster with an index of ten documents, whose term statistics are
+// given by the length of the terms.
+type es7Stub struct {
+	distribution string
+	mu           sync.Mutex
+	pits         map[string]bool
+	pages        int
+}
+
+func (s *es7Stub) termVector(doc map[string]string) map[string]interface{} {
+	tv := make(map[string]interface{})
+	for field, term := range doc {
+		tv[field] = map[string]interface{}{
+			"terms": map[string]interface{}{
+				term: map[string]int{"doc_freq": len(term), "ttf": 2 * len(term), "term_freq": 1},
+			},
+		}
+	}
+	return map[string]interface{}{"found": true, "term_vectors": tv}
+}
+
+func (s *es7Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	if !strings.HasSuffix(r.URL.Path, "_msearch") {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+	reply := func(v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.URL.Path {
+	case "/":
+		reply(map[string]interface{}{"version": map[string]string{"number": "7.10.2", "distribution": s.distribution}})
+	case "/idx/_count":
+		count := 10
+		if body["query"] != nil {
+			count = 3
+		}
+		reply(map[string]int{"count": count})
+	case "/idx/_mtermvectors":
+		var docs []interface{}
+		for _, d := range body["docs"].([]interface{}) {
+			doc := make(map[string]string)
+			for field, term := range d.(map[string]interface{})["doc"].(map[string]interface{}) {
+				doc[field] = term.(string)
+			}
+			docs = append(docs, s.termVector(doc))
+		}
+		reply(map[string]interface{}{"docs": docs})
+	case "/_msearch":
+		var responses []interface{}
+		dec := json.NewDecoder(r.Body)
+		for {
+			var header, search map[string]interface{}
+			if dec.Decode(&header) != nil || dec.Decode(&search) != nil {
+				break
+			}
+			if search["track_total_hits"] != true || header["index"] == nil {
+				http.Error(w, "expected an index and track_total_hits", http.StatusBadRequest)
+				return
+			}
+			q, _ := json.Marshal(search["query"])
+			responses = append(responses, map[string]interface{}{
+				"hits": map[string]interface{}{"total": map[string]interface{}{"value": len(q), "relation": "eq"}},
+			})
+		}
+		reply(map[string]interface{}{"responses": responses})
+	case "/idx/_pit", "/idx/_search/point_in_time":
+		id := fmt.Sprintf("pit%d", len(s.pits))
+		s.pits[id] = true
+		if s.distribution == "opensearch" {
+			reply(map[string]string{"pit_id": id})
+			return
+		}
+		reply(map[string]string{"id": id})
+	case "/_pit":
+		delete(s.pits, body["id"].(string))
+		reply(map[string]bool{"succeeded": true})
+	case "/_search/point_in_time":
+		for _, id := range body["pit_id"].([]interface{}) {
+			delete(s.pits, id.(string))
+		}
+		reply(map[string]bool{"succeeded": true})
+	case "/_search":
+		pit := body["pit"].(map[string]interface{})
+		if !s.pits[pit["id"].(string)] {
+			http.Error(w, "no such point in time", http.StatusNotFound)
+			return
+		}
+		s.pages++
+		from := 0
+		if after, ok := body["search_after"].([]interface{}); ok {
+			from = int(after[0].(float64)) + 1
+		}
+		var hits []interface{}
+		for i := from; i < from+int(body["size"].(float64)) && i < 10; i++ {
+			hits = append(hits, map[string]interface{}{"_id": fmt.Sprintf("doc%d", i), "sort": []int{i}})
+		}
+		reply(map[string]interface{}{"pit_id": pit["id"], "hits": map[string]interface{}{"hits": hits}})
+	case "/idx/_analyze":
+		var tokens []interface{}
+		for _, text := range body["text"].([]interface{}) {
+			for _, token := range strings.Fields(strings.ToLower(text.(string))) {
+				tokens = append(tokens, map[string]string{"token": token})
+			}
+		}
+		reply(map[string]interface{}{"tokens": tokens})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func testElasticsearch7(t *testing.T, distribution string) (*Elasticsearch7StatisticsSource, *es7Stub, func()) {
+	stub := &es7Stub{distribution: distribution, pits: make(map[string]bool)}
+	ts := httptest.NewServer(stub)
+	es, err := NewElasticsearch7StatisticsSource(
+		Elasticsearch7Hosts(ts.URL),
+		Elasticsearch7Index("idx"),
+		Elasticsearch7Analyser("standard"),
+		Elasticsearch7Scroll(true),
+	)
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return es, stub, ts.Close
+}
+
+func TestElasticsearch7StatisticsSource(t *testing.T) {
+	es, _, done := testElasticsearch7(t, "")
+	defer done()
+
+	df, err := es.DocumentFrequency("diabetes", "title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if df != 8 {
+		t.Errorf("expected a document frequency of 8, got %f", df)
+	}
+	ttf, err := TotalTermFrequencies(es, []TermField{{"cat", "title"}, {"diabetes", "text"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ttf, []float64{6, 16}) {
+		t.Errorf("unexpected total term frequencies %v", ttf)
+	}
+
+	n, err := es.CollectionSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.Errorf("expected a collection size of 10, got %f", n)
+	}
+	size, err := es.RetrievalSize(cqr.NewKeyword("diabetes", "title"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 3 {
+		t.Errorf("expected a retrieval size of 3, got %f", size)
+	}
+	sizes, err := RetrievalSizes(es, []cqr.CommonQueryRepresentation{cqr.NewKeyword("a", "title"), cqr.NewKeyword("diabetes", "title")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != 2 || sizes[0] >= sizes[1] {
+		t.Errorf("unexpected retrieval sizes %v", sizes)
+	}
+
+	tokens, err := es.Analyse("Diabetes Mellitus", "standard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tokens, []string{"diabetes", "mellitus"}) {
+		t.Errorf("unexpected tokens %v", tokens)
+	}
+	var _ AnalysingStatisticsSource = es
+}
+
+func TestElasticsearch7StatisticsSource_Execute(t *testing.T) {
+	for _, distribution := range []string{"", "opensearch"} {
+		t.Run(distribution, func(t *testing.T) {
+			es, stub, done := testElasticsearch7(t, distribution)
+			defer done()
+			if es.openSearch != (distribution == "opensearch") {
+				t.Fatalf("expected the distribution to be detected")
+			}
+
+			results, err := es.Execute(gpipeline.NewQuery("q", "1", cqr.NewKeyword("diabetes", "title")), SearchOptions{Size: 4, RunName: "test"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 10 || results[0].DocId != "doc0" || results[9].DocId != "doc9" || results[9].Rank != 9 {
+				t.Errorf("expected every document to be retrieved in order, got %d results", len(results))
+			}
+			if stub.pages != 3 {
+				t.Errorf("expected 3 pages, got %d", stub.pages)
+			}
+			if len(stub.pits) != 0 {
+				t.Errorf("expected the point in time to be closed, got %v", stub.pits)
+			}
+		})
+	}
+}
+
+func TestElasticsearch7StatisticsSource_Error(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Write([]byte(`{"version": {"number": "7.10.2"}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": {"type": "parsing_exception", "reason": "unknown query"}, "status": 400}`))
+	}))
+	defer ts.Close()
+	es, err := NewElasticsearch7StatisticsSource(Elasticsearch7Hosts(ts.URL), Elasticsearch7Index("idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = es.RetrievalSize(cqr.NewKeyword("diabetes", "title"))
+	if e, ok := err.(*elastic.Error); !ok || e.Details == nil || e.Details.Type != "parsing_exception" {
+		t.Errorf("expected a parsing exception, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package stats
+
+import (
+	"encoding/json"
+	"github.com/hscells/cqr"
+	"gopkg.in/olivere/elastic.v5"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// es5Stub is an Elasticsearch 5 cluster with an index of ten documents, whose term statistics are given by the length
+// of the terms. It counts the requests made to each path.
+type es5Stub struct {
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func (s *es5Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	if !strings.HasSuffix(r.URL.Path, "_msearch") {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+	reply := func(v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[r.URL.Path]++
+	switch r.URL.Path {
+	case "/idx/_stats":
+		reply(map[string]interface{}{"_all": map[string]interface{}{"total": map[string]interface{}{"docs": map[string]int{"count": 10}}}})
+	case "/idx/doc/_mtermvectors":
+		var docs []interface{}
+		for _, d := range body["docs"].([]interface{}) {
+			tv := make(map[string]interface{})
+			for field, term := range d.(map[string]interface{})["doc"].(map[string]interface{}) {
+				t := term.(string)
+				tv[field] = map[string]interface{}{
+					"terms": map[string]interface{}{
+						t: map[string]int{"doc_freq": len(t), "ttf": 2 * len(t), "term_freq": 1},
+					},
+				}
+			}
+			docs = append(docs, map[string]interface{}{"found": true, "term_vectors": tv})
+		}
+		reply(map[string]interface{}{"docs": docs})
+	case "/_msearch":
+		var responses []interface{}
+		dec := json.NewDecoder(r.Body)
+		for {
+			var header, search map[string]interface{}
+			if dec.Decode(&header) != nil || dec.Decode(&search) != nil {
+				break
+			}
+			q, _ := json.Marshal(search["query"])
+			responses = append(responses, map[string]interface{}{"hits": map[string]interface{}{"total": len(q)}})
+		}
+		reply(map[string]interface{}{"responses": responses})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func testElasticsearch(t *testing.T) (*ElasticsearchStatisticsSource, *es5Stub, func()) {
+	stub := &es5Stub{requests: make(map[string]int)}
+	ts := httptest.NewServer(stub)
+	client, err := elastic.NewClient(elastic.SetURL(ts.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	es, err := NewElasticsearchStatisticsSource(
+		func(es *ElasticsearchStatisticsSource) { es.client = client },
+		ElasticsearchIndex("idx"),
+		ElasticsearchDocumentType("doc"),
+	)
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return es, stub, ts.Close
+}
+
+func TestElasticsearchStatisticsSource_Batch(t *testing.T) {
+	es, stub, done := testElasticsearch(t)
+	defer done()
+	terms := []TermField{{"cat", "title"}, {"diabetes", "text"}}
+
+	df, err := DocumentFrequencies(es, terms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(df, []float64{3, 8}) {
+		t.Errorf("unexpected document frequencies %v", df)
+	}
+	ttf, err := TotalTermFrequencies(es, terms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ttf, []float64{6, 16}) {
+		t.Errorf("unexpected total term frequencies %v", ttf)
+	}
+	idfs, err := InverseDocumentFrequencies(es, terms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{math.Log(11.0 / 4), math.Log(11.0 / 9)}; !reflect.DeepEqual(idfs, want) {
+		t.Errorf("expected the inverse document frequencies %v, got %v", want, idfs)
+	}
+	if n := stub.requests["/idx/doc/_mtermvectors"]; n != 3 {
+		t.Errorf("expected each statistic of the terms in a single request, got %d requests", n)
+	}
+
+	sizes, err := RetrievalSizes(es, []cqr.CommonQueryRepresentation{cqr.NewKeyword("a", "title"), cqr.NewKeyword("diabetes", "title")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != 2 || sizes[0] >= sizes[1] {
+		t.Errorf("unexpected retrieval sizes %v", sizes)
+	}
+	if n := stub.requests["/_msearch"]; n != 1 {
+		t.Errorf("expected the retrieval sizes in a single request, got %d requests", n)
+	}
+
+	if v, err := es.DocumentFrequencies(nil); err != nil || len(v) != 0 {
+		t.Errorf("expected no document frequencies of no terms, got %v, %v", v, err)
+	}
+}
+
+func TestElasticsearchStatisticsSource_BatchError(t *testing.T) {
+	es, _, done := testElasticsearch(t)
+	defer done()
+	es.index = "missing"
+	if _, err := DocumentFrequencies(es, []TermField{{"cat", "title"}}); err == nil {
+		t.Error("expected an error requesting term vectors of a missing index")
+	}
+}
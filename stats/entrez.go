@@ -1,7 +1,12 @@
 package stats
 
+//go:generate easyjson entrez.go
+
 import (
 	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"github.com/biogo/ncbi"
 	"github.com/biogo/ncbi/entrez"
@@ -19,6 +24,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"path"
 	"reflect"
 	"regexp"
 	"strconv"
@@ -36,10 +42,18 @@ type EntrezStatisticsSource struct {
 	parameters map[string]float64
 	rank       bool
 	options    SearchOptions
+	ctx        context.Context
+	// concurrency is the number of requests that may be made to the E-utilities at once.
+	concurrency int
+	retry       RetryPolicy
 	// The size of PubMed.
 	N float64
 }
 
+// entrezClient is the http client used for requests to the E-utilities.
+var entrezClient = &http.Client{Timeout: 10 * time.Minute}
+
+//easyjson:json
 type term struct {
 	count int
 	token string
@@ -85,6 +99,7 @@ func mapTerms(terms []term) map[string]float64 {
 	return m
 }
 
+//easyjson:json
 type Search struct {
 	Count int `xml:"Count"`
 }
@@ -94,15 +109,177 @@ func (e EntrezStatisticsSource) SetDB(db string) EntrezStatisticsSource {
 	return e
 }
 
-func (e EntrezStatisticsSource) Count(term, field string) float64 {
+// WithContext returns a copy of the statistics source whose requests to the E-utilities are bound to ctx.
+func (e EntrezStatisticsSource) WithContext(ctx context.Context) StatisticsSource {
+	e.ctx = ctx
+	return e
+}
+
+// context is the context requests to the E-utilities are made with.
+func (e EntrezStatisticsSource) context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// get issues a request to an E-utility using the context of the statistics source. As with ncbi.Util, requests that
+// are too long for a GET are sent as a POST instead. Requests that fail, or are not answered with 200 OK, return an
+// EntrezError. It is the caller's responsibility to close the response body.
+func (e EntrezStatisticsSource) get(u ncbi.Util, v url.Values) (*http.Response, error) {
+	ctx := e.context()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p, err := u.Prepare(v, e.tool, e.email)
+	if err != nil {
+		return nil, err
+	}
+	entrez.Limit.Wait()
+
+	var req *http.Request
+	if len(u)+len(p.RawQuery) < ncbi.GetMethodLimit {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, p.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		body := p.RawQuery
+		p.RawQuery = ""
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, p.String(), strings.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := entrezClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &EntrezError{Kind: ErrNetwork, Util: utilName(u), Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, statusError(u, resp)
+	}
+	return resp, nil
+}
+
+// utilName is the name of an E-utility (e.g. esearch).
+func utilName(u ncbi.Util) string {
+	return strings.TrimSuffix(path.Base(string(u)), ".fcgi")
+}
+
+// statusError is the error of a response from an E-utility that is not 200 OK.
+func statusError(u ncbi.Util, resp *http.Response) error {
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	err := &EntrezError{
+		Util:       utilName(u),
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(b)),
+		RetryAfter: retryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		err.Kind = ErrRateLimited
+	case resp.StatusCode >= 500:
+		err.Kind = ErrNetwork
+	case resp.StatusCode == http.StatusBadRequest && !strings.Contains(err.Message, "API key"),
+		resp.StatusCode == http.StatusRequestURITooLong:
+		err.Kind = ErrQuerySyntax
+	default:
+		err.Kind = ErrRejected
+	}
+	return err
+}
+
+// request issues a request to an E-utility and reads the response, retrying it according to the retry policy of the
+// statistics source.
+func (e EntrezStatisticsSource) request(u ncbi.Util, v url.Values) ([]byte, error) {
+	var b []byte
+	err := e.retry.do(e.context(), func() error {
+		resp, err := e.get(u, v)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		b, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return &EntrezError{Kind: ErrNetwork, Util: utilName(u), Err: err}
+		}
+		if len(bytes.TrimSpace(b)) == 0 {
+			return &EntrezError{Kind: ErrEmptyResult, Util: utilName(u)}
+		}
+		return nil
+	})
+	return b, err
+}
+
+// getXML issues a request to an E-utility and decodes the XML response into d.
+func (e EntrezStatisticsSource) getXML(u ncbi.Util, v url.Values, d interface{}) error {
+	b, err := e.request(u, v)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(b, d)
+}
+
+// search performs an ESearch request, as entrez.DoSearch does.
+func (e EntrezStatisticsSource) search(db, term string, p *entrez.Parameters) (*entrez.Search, error) {
+	v := url.Values{}
+	v["db"] = []string{db}
+	if term != "" {
+		v["term"] = []string{term}
+	}
+	fillParams(p, v)
+	s := entrez.Search{Database: db}
+	err := e.getXML(entrez.SearchURL, v, &s)
+	if err != nil {
+		return nil, err
+	}
+	if s.Err != nil {
+		return &s, &EntrezError{Kind: ErrQuerySyntax, Util: "esearch", Message: *s.Err}
+	}
+	return &s, nil
+}
+
+// fetch performs an EFetch request, as entrez.Fetch does.
+func (e EntrezStatisticsSource) fetch(p *entrez.Parameters, pmids ...int) ([]byte, error) {
+	if len(pmids) == 0 {
+		return nil, entrez.ErrNoIdProvided
+	}
+	ids := make([]string, len(pmids))
+	for i, pmid := range pmids {
+		ids[i] = strconv.Itoa(pmid)
+	}
+	v := url.Values{"id": ids}
+	v["db"] = []string{e.db}
+	fillParams(p, v)
+	return e.request(entrez.FetchURL, v)
+}
+
+// info performs an EInfo request for the database of the statistics source, as entrez.DoInfo does.
+func (e EntrezStatisticsSource) info() (*entrez.Info, error) {
+	i := entrez.Info{}
+	err := e.getXML(entrez.InfoURL, url.Values{"db": {e.db}}, &i)
+	if err != nil {
+		return nil, err
+	}
+	if i.Err != "" {
+		return &i, &EntrezError{Kind: ErrRejected, Util: "einfo", Message: i.Err}
+	}
+	return &i, nil
+}
+
+// Count is the number of documents that contain a term in a field.
+func (e EntrezStatisticsSource) Count(term, field string) (float64, error) {
 	var s Search
-count:
-	err := entrez.SearchURL.GetXML(map[string][]string{"field": {field}, "api_key": {e.key}, "term": {term}}, e.tool, e.email, entrez.Limit, &s)
+	err := e.getXML(entrez.SearchURL, map[string][]string{"field": {field}, "api_key": {e.key}, "term": {term}}, &s)
 	if err != nil {
-		log.Println(err)
-		goto count
+		return 0, err
 	}
-	return float64(s.Count)
+	return float64(s.Count), nil
 }
 
 func (e EntrezStatisticsSource) SearchStart(n int) func(p *entrez.Parameters) {
@@ -150,20 +327,21 @@ func fillParams(p *entrez.Parameters, v url.Values) {
 	}
 }
 
+//easyjson:json
 type esearch struct {
 	EsearchResult esearchresult `json:"esearchresult"`
 }
 
+//easyjson:json
 type esearchresult struct {
 	RetStart string   `json:"retstart"`
 	Count    string   `json:"count"`
 	Idlist   []string `json:"idlist"`
+	Error    string   `json:"ERROR,omitempty"`
 }
 
 // Search uses the entrez eutils to get the pmids for a given query.
 func (e EntrezStatisticsSource) Search(query string, options ...func(p *entrez.Parameters)) ([]int, error) {
-	ncbi.SetTimeout(10 * time.Minute)
-
 	//fmt.Printf("%s", query)
 	p := &entrez.Parameters{}
 	p.RetMax = e.options.Size
@@ -186,21 +364,7 @@ func (e EntrezStatisticsSource) Search(query string, options ...func(p *entrez.P
 	v["term"] = []string{query}
 	fillParams(p, v)
 	fmt.Print(".")
-	fails, nfails := 20, 20
-search:
-	r, err := entrez.SearchURL.Get(v, e.tool, e.email, entrez.Limit)
-	if err != nil {
-		if fails > 0 {
-			log.Printf("search error: %v, retrying %d more times for %s", err, fails, time.Duration(((nfails-fails)*5)*int(time.Second)))
-			fails--
-			time.Sleep(time.Duration((nfails-fails)*5) * time.Second)
-			goto search
-		}
-		goto search
-	}
-	defer r.Close()
-	fmt.Print(".")
-	b, err := ioutil.ReadAll(r)
+	b, err := e.request(entrez.SearchURL, v)
 	if err != nil {
 		return nil, err
 	}
@@ -211,6 +375,9 @@ search:
 		fmt.Println(string(b))
 		return nil, err
 	}
+	if len(s.EsearchResult.Error) > 0 {
+		return nil, &EntrezError{Kind: ErrQuerySyntax, Util: "esearch", Message: s.EsearchResult.Error}
+	}
 	fmt.Print(".")
 
 	pmids := make([]int, len(s.EsearchResult.Idlist))
@@ -233,16 +400,8 @@ search:
 	if e.rank || (e.Limit > 0 && len(pmids) >= e.Limit) {
 		return pmids, nil
 	} else if len(pmids) == e.options.Size {
-		fails, nfails := 20, 20
-	retry:
 		l, err := e.Search(query, e.SearchStart(p.RetStart+len(pmids)), e.SearchSize(e.SearchOptions().Size))
 		if err != nil {
-			if fails > 0 {
-				log.Printf("recursive search error: %v, retrying %d more times for %f seconds", err, fails, time.Duration(((nfails-fails)*5)*int(time.Second)).Seconds())
-				fails--
-				time.Sleep(time.Duration((nfails-fails)*5) * time.Second)
-				goto retry
-			}
 			return nil, err
 		}
 		pmids = append(pmids, l...)
@@ -263,7 +422,7 @@ func (e EntrezStatisticsSource) Summary(ids []string, value interface{}, options
 	p.RetMax = e.options.Size
 	p.RetMode = "xml"
 	p.APIKey = e.key
-	return entrez.SummaryURL.GetXML(v, e.tool, e.email, entrez.Limit, value)
+	return e.getXML(entrez.SummaryURL, v, value)
 }
 
 // Fetch uses the entrez eutils to fetch the pubmed Article given a set of pubmed identifiers.
@@ -280,23 +439,8 @@ func (e EntrezStatisticsSource) Fetch(pmids []int, options ...func(p *entrez.Par
 	for _, option := range options {
 		option(p)
 	}
-	fails, nfails := 20, 20
 
-retry:
-	r, err := entrez.Fetch(e.db, p, e.tool, e.email, nil, pmids...)
-	if err != nil {
-		if fails > 0 {
-			log.Printf("fetch error: %v, retrying %d more times for %f seconds", err, fails, time.Duration(((nfails-fails)*5)*int(time.Second)).Seconds())
-			fails--
-			time.Sleep(time.Duration((nfails-fails)*5) * time.Second)
-			goto retry
-		}
-		return nil, err
-	}
-
-	//log.Println("reading")
-	//ncbi.SetTimeout(1 * time.Hour
-	b, err := ioutil.ReadAll(r)
+	b, err := e.fetch(p, pmids...)
 	if err != nil {
 		return nil, err
 	}
@@ -311,13 +455,24 @@ retry:
 	//s := guru.UnmarshalAbstract(bytes.NewReader(b))
 	s := guru.UnmarshalMedline(bytes.NewReader(b))
 	//log.Println("done")
-	return s, r.Close()
+	return s, nil
 }
 
 func (e EntrezStatisticsSource) Link(pmids []int, linkname string) ([]int, error) {
-	link, err := entrez.DoLink(e.db, "pubmed", "neighbor", "", &entrez.Parameters{
-		LinkName: linkname,
-	}, e.tool, e.email, nil, pmids)
+	ids := make([]string, len(pmids))
+	for i, pmid := range pmids {
+		ids[i] = strconv.Itoa(pmid)
+	}
+	v := url.Values{
+		"db":     {"pubmed"},
+		"dbfrom": {e.db},
+		"cmd":    {"neighbor"},
+		"id":     {strings.Join(ids, ",")},
+	}
+	fillParams(&entrez.Parameters{LinkName: linkname}, v)
+
+	var link entrez.Link
+	err := e.getXML(entrez.LinkURL, v, &link)
 	if err != nil {
 		return nil, err
 	}
@@ -347,13 +502,14 @@ func (e EntrezStatisticsSource) TermFrequency(term, field, document string) (flo
 	if err != nil {
 		return 0, err
 	}
-	r, err := entrez.Fetch(e.db, &entrez.Parameters{RetMode: "xml", APIKey: e.key}, e.tool, e.email, nil, int(d))
-	if err != nil {
+	b, err := e.fetch(&entrez.Parameters{RetMode: "xml", APIKey: e.key}, int(d))
+	if errors.Is(err, ErrEmptyResult) {
+		return 0, nil
+	} else if err != nil {
 		return 0, err
 	}
-	defer r.Close()
 
-	docs := guru.UnmarshalMedline(r)
+	docs := guru.UnmarshalMedline(bytes.NewReader(b))
 
 	if len(docs) == 0 {
 		return 0, nil
@@ -375,7 +531,7 @@ func (e EntrezStatisticsSource) TermVector(document string) (TermVector, error)
 	}
 
 	docs, err := e.Fetch([]int{int(d)})
-	if err != nil {
+	if err != nil && !errors.Is(err, ErrEmptyResult) {
 		return nil, err
 	}
 
@@ -422,7 +578,12 @@ func (e EntrezStatisticsSource) TermVector(document string) (TermVector, error)
 		wg.Add(1)
 		//go func(x string, y float64) {
 		log.Println(term)
-		s := e.Count(term, "tiab")
+		s, err := e.Count(term, "tiab")
+		if err != nil {
+			wg.Done()
+			close(ch)
+			return nil, err
+		}
 		ch <- TermVectorTerm{
 			DocumentFrequency:  s,
 			TotalTermFrequency: s,
@@ -442,7 +603,7 @@ func (e EntrezStatisticsSource) TermVector(document string) (TermVector, error)
 }
 
 func (e EntrezStatisticsSource) DocumentFrequency(term, field string) (float64, error) {
-	s, err := entrez.DoSearch(e.db, term, &entrez.Parameters{APIKey: e.key}, nil, e.tool, e.email)
+	s, err := e.search(e.db, term, &entrez.Parameters{APIKey: e.key})
 	if err != nil {
 		return 0, err
 	}
@@ -475,7 +636,10 @@ func (e EntrezStatisticsSource) TotalTermFrequency(term, _ string) (float64, err
 }
 
 func (e EntrezStatisticsSource) InverseDocumentFrequency(term, field string) (float64, error) {
-	nt := e.Count(term, field)
+	nt, err := e.Count(term, field)
+	if err != nil {
+		return 0, err
+	}
 	return idf(e.N, nt), nil
 }
 
@@ -493,36 +657,29 @@ func (e EntrezStatisticsSource) RetrievalSize(query cqr.CommonQueryRepresentatio
 	if err != nil {
 		return 0, err
 	}
-retry:
-	entrez.Limit.Wait()
-	c := &http.Client{}
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://eutils.ncbi.nlm.nih.gov/entrez/eutils/esearch.fcgi?db=pubmed&rettype=count&term=%s&api_key=%s&tool=%s&email=%s", url.QueryEscape(q), e.key, e.tool, e.email), nil)
-	if err != nil {
-		return 0, err
-	}
-	resp, err := c.Do(req)
-	if err != nil {
-		return 0, err
-	}
-	if resp.StatusCode != http.StatusOK {
-		goto retry
+	v := url.Values{
+		"db":      {"pubmed"},
+		"rettype": {"count"},
+		"term":    {q},
+		"api_key": {e.key},
 	}
-
-	var buff bytes.Buffer
-	_, err = buff.ReadFrom(resp.Body)
+	b, err := e.request(entrez.SearchURL, v)
 	if err != nil {
 		return 0, err
 	}
 	re := regexp.MustCompile("<Count>(?P<count>[0-9]+)</Count>")
-	matches := re.FindSubmatch(buff.Bytes())
+	matches := re.FindSubmatch(b)
 	if len(matches) >= 2 {
 		return strconv.ParseFloat(string(bytes.TrimSpace(matches[1])), 32)
 	}
+	if matches := regexp.MustCompile("<ERROR>(.*)</ERROR>").FindSubmatch(b); len(matches) >= 2 {
+		return 0, &EntrezError{Kind: ErrQuerySyntax, Util: "esearch", Message: string(matches[1])}
+	}
 	return 0, nil
 }
 
 func (e EntrezStatisticsSource) VocabularySize(field string) (float64, error) {
-	i, err := entrez.DoInfo(e.db, e.tool, e.email)
+	i, err := e.info()
 	if err != nil {
 		return 0, err
 	}
@@ -549,16 +706,8 @@ func (e EntrezStatisticsSource) Execute(query pipeline.Query, options SearchOpti
 		return nil, err
 	}
 
-	fails := 20
-execute:
 	pmids, err := e.Search(q)
 	if err != nil {
-		if fails > 0 {
-			log.Printf("search execution error: %v, retrying %d more times for query: %s", err, fails, q)
-			fails--
-			time.Sleep(5 * time.Second)
-			goto execute
-		}
 		return nil, err
 	}
 
@@ -580,7 +729,7 @@ func (e EntrezStatisticsSource) CollectionSize() (float64, error) {
 	if e.N > 0 {
 		return e.N, nil
 	}
-	info, err := entrez.DoInfo(e.db, e.tool, e.email)
+	info, err := e.info()
 	if err != nil {
 		return 0, err
 	}
@@ -591,8 +740,8 @@ func (e EntrezStatisticsSource) CollectionSize() (float64, error) {
 }
 
 func (e EntrezStatisticsSource) Translation(term string) ([]string, error) {
-	s, err := entrez.DoSearch("pubmed", term, nil, nil, e.tool, e.email)
-	if err != io.EOF && err != nil {
+	s, err := e.search("pubmed", term, nil)
+	if err != nil && !errors.Is(err, ErrEmptyResult) {
 		return nil, err
 	}
 	if s == nil || len(s.TranslationStack) == 0 {
@@ -608,6 +757,38 @@ func (e EntrezStatisticsSource) Translation(term string) ([]string, error) {
 	return translations, nil
 }
 
+// DocumentFrequencies are the document frequencies of the terms, requested concurrently within the rate limits of
+// entrez.
+func (e EntrezStatisticsSource) DocumentFrequencies(terms []TermField) ([]float64, error) {
+	return concurrently(len(terms), e.Concurrency(), func(i int) (float64, error) {
+		return e.DocumentFrequency(terms[i].Term, terms[i].Field)
+	})
+}
+
+// TotalTermFrequencies are the total term frequencies of the terms, requested concurrently within the rate limits of
+// entrez.
+func (e EntrezStatisticsSource) TotalTermFrequencies(terms []TermField) ([]float64, error) {
+	return concurrently(len(terms), e.Concurrency(), func(i int) (float64, error) {
+		return e.TotalTermFrequency(terms[i].Term, terms[i].Field)
+	})
+}
+
+// InverseDocumentFrequencies are the inverse document frequencies of the terms, requested concurrently within the rate
+// limits of entrez.
+func (e EntrezStatisticsSource) InverseDocumentFrequencies(terms []TermField) ([]float64, error) {
+	return concurrently(len(terms), e.Concurrency(), func(i int) (float64, error) {
+		return e.InverseDocumentFrequency(terms[i].Term, terms[i].Field)
+	})
+}
+
+// RetrievalSizes are the number of documents each query retrieves, requested concurrently within the rate limits of
+// entrez.
+func (e EntrezStatisticsSource) RetrievalSizes(queries []cqr.CommonQueryRepresentation) ([]float64, error) {
+	return concurrently(len(queries), e.Concurrency(), func(i int) (float64, error) {
+		return e.RetrievalSize(queries[i])
+	})
+}
+
 // EntrezTool sets the tool name for entrez.
 func EntrezTool(tool string) func(source *EntrezStatisticsSource) {
 	return func(source *EntrezStatisticsSource) {
@@ -656,12 +837,45 @@ func EntrezRank(rank bool) func(source *EntrezStatisticsSource) {
 	}
 }
 
+// EntrezConcurrency sets the number of requests that may be made to entrez at once.
+func EntrezConcurrency(n int) func(source *EntrezStatisticsSource) {
+	return func(source *EntrezStatisticsSource) {
+		source.concurrency = n
+	}
+}
+
+// EntrezRetryPolicy sets how requests to entrez that fail with a transient error are retried. By default, this is
+// DefaultRetryPolicy.
+func EntrezRetryPolicy(policy RetryPolicy) func(source *EntrezStatisticsSource) {
+	return func(source *EntrezStatisticsSource) {
+		source.retry = policy
+	}
+}
+
+// Concurrency is the number of requests that may be made to entrez at once. Unless set otherwise, this is the number
+// of requests per second the E-utilities allow: 10 with an API key and 3 without.
+func (e EntrezStatisticsSource) Concurrency() int {
+	if e.concurrency > 0 {
+		return e.concurrency
+	}
+	if len(e.key) > 0 {
+		return 10
+	}
+	return 3
+}
+
+// Identity is the Entrez database that is searched.
+func (e EntrezStatisticsSource) Identity() string {
+	return e.db
+}
+
 // NewEntrezStatisticsSource creates a new entrez statistics source for searching pubmed.
 // When an API key is specified, the entrez request Limit is raised to 10 per second instead of the default 3.
 func NewEntrezStatisticsSource(options ...func(source *EntrezStatisticsSource)) (EntrezStatisticsSource, error) {
 	e := &EntrezStatisticsSource{
-		db:   "pubmed",
-		rank: false,
+		db:    "pubmed",
+		rank:  false,
+		retry: DefaultRetryPolicy,
 	}
 
 	//if len(e.key) > 0 {
@@ -28,7 +28,7 @@ func easyjson93cb6946DecodeGithubComHscellsGrooveStats(in *jlexer.Lexer, out *te
 	}
 	in.Delim('{')
 	for !in.IsDelim('}') {
-		key := in.UnsafeString()
+		key := in.UnsafeFieldName(false)
 		in.WantColon()
 		if in.IsNull() {
 			in.Skip()
@@ -87,7 +87,7 @@ func easyjson93cb6946DecodeGithubComHscellsGrooveStats1(in *jlexer.Lexer, out *e
 	}
 	in.Delim('{')
 	for !in.IsDelim('}') {
-		key := in.UnsafeString()
+		key := in.UnsafeFieldName(false)
 		in.WantColon()
 		if in.IsNull() {
 			in.Skip()
@@ -122,6 +122,8 @@ func easyjson93cb6946DecodeGithubComHscellsGrooveStats1(in *jlexer.Lexer, out *e
 				}
 				in.Delim(']')
 			}
+		case "ERROR":
+			out.Error = string(in.String())
 		default:
 			in.SkipRecursive()
 		}
@@ -138,32 +140,17 @@ func easyjson93cb6946EncodeGithubComHscellsGrooveStats1(out *jwriter.Writer, in
 	_ = first
 	{
 		const prefix string = ",\"retstart\":"
-		if first {
-			first = false
-			out.RawString(prefix[1:])
-		} else {
-			out.RawString(prefix)
-		}
+		out.RawString(prefix[1:])
 		out.String(string(in.RetStart))
 	}
 	{
 		const prefix string = ",\"count\":"
-		if first {
-			first = false
-			out.RawString(prefix[1:])
-		} else {
-			out.RawString(prefix)
-		}
+		out.RawString(prefix)
 		out.String(string(in.Count))
 	}
 	{
 		const prefix string = ",\"idlist\":"
-		if first {
-			first = false
-			out.RawString(prefix[1:])
-		} else {
-			out.RawString(prefix)
-		}
+		out.RawString(prefix)
 		if in.Idlist == nil && (out.Flags&jwriter.NilSliceAsEmpty) == 0 {
 			out.RawString("null")
 		} else {
@@ -177,6 +164,11 @@ func easyjson93cb6946EncodeGithubComHscellsGrooveStats1(out *jwriter.Writer, in
 			out.RawByte(']')
 		}
 	}
+	if in.Error != "" {
+		const prefix string = ",\"ERROR\":"
+		out.RawString(prefix)
+		out.String(string(in.Error))
+	}
 	out.RawByte('}')
 }
 
@@ -214,7 +206,7 @@ func easyjson93cb6946DecodeGithubComHscellsGrooveStats2(in *jlexer.Lexer, out *e
 	}
 	in.Delim('{')
 	for !in.IsDelim('}') {
-		key := in.UnsafeString()
+		key := in.UnsafeFieldName(false)
 		in.WantColon()
 		if in.IsNull() {
 			in.Skip()
@@ -240,12 +232,7 @@ func easyjson93cb6946EncodeGithubComHscellsGrooveStats2(out *jwriter.Writer, in
 	_ = first
 	{
 		const prefix string = ",\"esearchresult\":"
-		if first {
-			first = false
-			out.RawString(prefix[1:])
-		} else {
-			out.RawString(prefix)
-		}
+		out.RawString(prefix[1:])
 		(in.EsearchResult).MarshalEasyJSON(out)
 	}
 	out.RawByte('}')
@@ -285,7 +272,7 @@ func easyjson93cb6946DecodeGithubComHscellsGrooveStats3(in *jlexer.Lexer, out *S
 	}
 	in.Delim('{')
 	for !in.IsDelim('}') {
-		key := in.UnsafeString()
+		key := in.UnsafeFieldName(false)
 		in.WantColon()
 		if in.IsNull() {
 			in.Skip()
@@ -311,12 +298,7 @@ func easyjson93cb6946EncodeGithubComHscellsGrooveStats3(out *jwriter.Writer, in
 	_ = first
 	{
 		const prefix string = ",\"Count\":"
-		if first {
-			first = false
-			out.RawString(prefix[1:])
-		} else {
-			out.RawString(prefix)
-		}
+		out.RawString(prefix[1:])
 		out.Int(int(in.Count))
 	}
 	out.RawByte('}')
@@ -345,62 +327,3 @@ func (v *Search) UnmarshalJSON(data []byte) error {
 func (v *Search) UnmarshalEasyJSON(l *jlexer.Lexer) {
 	easyjson93cb6946DecodeGithubComHscellsGrooveStats3(l, v)
 }
-func easyjson93cb6946DecodeGithubComHscellsGrooveStats4(in *jlexer.Lexer, out *EntrezStatisticsSource) {
-	isTopLevel := in.IsStart()
-	if in.IsNull() {
-		if isTopLevel {
-			in.Consumed()
-		}
-		in.Skip()
-		return
-	}
-	in.Delim('{')
-	for !in.IsDelim('}') {
-		key := in.UnsafeString()
-		in.WantColon()
-		if in.IsNull() {
-			in.Skip()
-			in.WantComma()
-			continue
-		}
-		switch key {
-		default:
-			in.SkipRecursive()
-		}
-		in.WantComma()
-	}
-	in.Delim('}')
-	if isTopLevel {
-		in.Consumed()
-	}
-}
-func easyjson93cb6946EncodeGithubComHscellsGrooveStats4(out *jwriter.Writer, in EntrezStatisticsSource) {
-	out.RawByte('{')
-	first := true
-	_ = first
-	out.RawByte('}')
-}
-
-// MarshalJSON supports json.Marshaler interface
-func (e EntrezStatisticsSource) MarshalJSON() ([]byte, error) {
-	w := jwriter.Writer{}
-	easyjson93cb6946EncodeGithubComHscellsGrooveStats4(&w, e)
-	return w.Buffer.BuildBytes(), w.Error
-}
-
-// MarshalEasyJSON supports easyjson.Marshaler interface
-func (e EntrezStatisticsSource) MarshalEasyJSON(w *jwriter.Writer) {
-	easyjson93cb6946EncodeGithubComHscellsGrooveStats4(w, e)
-}
-
-// UnmarshalJSON supports json.Unmarshaler interface
-func (e *EntrezStatisticsSource) UnmarshalJSON(data []byte) error {
-	r := jlexer.Lexer{Data: data}
-	easyjson93cb6946DecodeGithubComHscellsGrooveStats4(&r, e)
-	return r.Error()
-}
-
-// UnmarshalEasyJSON supports easyjson.Unmarshaler interface
-func (e *EntrezStatisticsSource) UnmarshalEasyJSON(l *jlexer.Lexer) {
-	easyjson93cb6946DecodeGithubComHscellsGrooveStats4(l, e)
-}
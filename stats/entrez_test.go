@@ -0,0 +1,157 @@
+package stats
+
+import (
+	"errors"
+	"github.com/biogo/ncbi"
+	"github.com/biogo/ncbi/entrez"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// redirect sends the requests of a client to a test server instead of the E-utilities.
+type redirect struct {
+	url *url.URL
+}
+
+func (r redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = r.url.Scheme
+	req.URL.Host = r.url.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// testEntrez creates an Entrez statistics source whose requests are answered by handler, and counts the requests.
+func testEntrez(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) (EntrezStatisticsSource, *int64, func()) {
+	var requests int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		handler(w, r)
+	}))
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, limit := entrezClient, entrez.Limit
+	entrezClient = &http.Client{Transport: redirect{url: u}}
+	entrez.Limit = ncbi.NewLimiter(time.Millisecond)
+	e := EntrezStatisticsSource{
+		db:    "pubmed",
+		retry: RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond},
+	}
+	return e, &requests, func() {
+		ts.Close()
+		entrezClient, entrez.Limit = client, limit
+	}
+}
+
+func TestEntrezStatisticsSource_Retry(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		handler  func(w http.ResponseWriter, r *http.Request, requests int)
+		kind     error
+		requests int
+	}{
+		{"rate limited", func(w http.ResponseWriter, r *http.Request, requests int) {
+			if requests < 3 {
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, `{"error":"API rate limit exceeded"}`, http.StatusTooManyRequests)
+				return
+			}
+			w.Write([]byte("<eSearchResult><Count>5</Count></eSearchResult>"))
+		}, nil, 3},
+		{"rate limited too often", func(w http.ResponseWriter, r *http.Request, requests int) {
+			http.Error(w, `{"error":"API rate limit exceeded"}`, http.StatusTooManyRequests)
+		}, ErrRateLimited, 3},
+		{"server failure", func(w http.ResponseWriter, r *http.Request, requests int) {
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		}, ErrNetwork, 3},
+		{"query syntax", func(w http.ResponseWriter, r *http.Request, requests int) {
+			w.Write([]byte("<eSearchResult><ERROR>Invalid query</ERROR></eSearchResult>"))
+		}, ErrQuerySyntax, 1},
+		{"bad request", func(w http.ResponseWriter, r *http.Request, requests int) {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+		}, ErrQuerySyntax, 1},
+		{"invalid API key", func(w http.ResponseWriter, r *http.Request, requests int) {
+			http.Error(w, `{"error":"API key invalid"}`, http.StatusBadRequest)
+		}, ErrRejected, 1},
+		{"empty result", func(w http.ResponseWriter, r *http.Request, requests int) {}, ErrEmptyResult, 1},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var n int
+			e, requests, done := testEntrez(t, func(w http.ResponseWriter, r *http.Request) {
+				n++
+				c.handler(w, r, n)
+			})
+			defer done()
+
+			df, err := e.DocumentFrequency("diabetes", "tiab")
+			if int(*requests) != c.requests {
+				t.Errorf("expected %d requests, got %d", c.requests, *requests)
+			}
+			if c.kind == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if df != 5 {
+					t.Errorf("expected a document frequency of 5, got %f", df)
+				}
+				return
+			}
+			if !errors.Is(err, c.kind) {
+				t.Fatalf("expected a %v error, got %v", c.kind, err)
+			}
+			var ee *EntrezError
+			if !errors.As(err, &ee) || (c.requests > 1 && ee.Attempts != c.requests) || ee.Util != "esearch" {
+				t.Errorf("unexpected error %#v", err)
+			}
+		})
+	}
+}
+
+func TestEntrezStatisticsSource_Search(t *testing.T) {
+	e, requests, done := testEntrez(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"esearchresult": {"count": "0", "retstart": "0", "idlist": [], "ERROR": "Invalid query"}}`))
+	})
+	defer done()
+	if _, err := e.Search("diabetes[tiab"); !errors.Is(err, ErrQuerySyntax) {
+		t.Errorf("expected a query syntax error, got %v", err)
+	}
+	if *requests != 1 {
+		t.Errorf("expected a query syntax error not to be retried, got %d requests", *requests)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p := RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempts, want := range []time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if want == 0 {
+			continue
+		}
+		if got := p.delay(attempts); got != want {
+			t.Errorf("expected a delay of %v after %d attempts, got %v", want, attempts, got)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.delay(2); d < time.Second || d > 2*time.Second {
+			t.Fatalf("expected a delay between 1s and 2s, got %v", d)
+		}
+	}
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for header, want := range map[string]time.Duration{
+		"":                              0,
+		"120":                           2 * time.Minute,
+		"Wed, 01 Jan 2020 00:00:30 GMT": 30 * time.Second,
+		"Tue, 31 Dec 2019 23:59:00 GMT": 0,
+		"soon":                          0,
+	} {
+		if got := retryAfter(header, now); got != want {
+			t.Errorf("expected Retry-After %q to be %v, got %v", header, want, got)
+		}
+	}
+}
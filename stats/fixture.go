@@ -0,0 +1,391 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/trecresults"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// ErrNotRecorded is returned by a replay statistics source for a request that is not in its fixture.
+var ErrNotRecorded = errors.New("request not recorded")
+
+// Fixture is the requests made to a statistics source and their results, so that they can be replayed without the
+// source (e.g. in tests that would otherwise need PubMed or Elasticsearch). A fixture is safe for concurrent use.
+type Fixture struct {
+	mu         sync.Mutex
+	options    SearchOptions
+	parameters map[string]float64
+	calls      map[string]fixtureCall
+}
+
+type fixtureCall struct {
+	Method    string          `json:"method"`
+	Arguments json.RawMessage `json:"arguments"`
+	Result    json.RawMessage `json:"result"`
+}
+
+type fixtureFile struct {
+	SearchOptions SearchOptions      `json:"search_options"`
+	Parameters    map[string]float64 `json:"parameters,omitempty"`
+	Calls         []fixtureCall      `json:"calls"`
+}
+
+// NewFixture creates an empty fixture.
+func NewFixture() *Fixture {
+	return &Fixture{calls: make(map[string]fixtureCall)}
+}
+
+// ReadFixture reads a fixture from a file written by Fixture.Write.
+func ReadFixture(file string) (*Fixture, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var ff fixtureFile
+	if err := json.Unmarshal(b, &ff); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	f := NewFixture()
+	f.options = ff.SearchOptions
+	f.parameters = ff.Parameters
+	for _, call := range ff.Calls {
+		var args bytes.Buffer
+		if err := json.Compact(&args, call.Arguments); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		call.Arguments = args.Bytes()
+		f.calls[fixtureKey(call.Method, call.Arguments)] = call
+	}
+	return f, nil
+}
+
+// Write writes the fixture to a file. The requests are sorted, so that fixtures of the same requests are identical.
+func (f *Fixture) Write(file string) error {
+	f.mu.Lock()
+	ff := fixtureFile{
+		SearchOptions: f.options,
+		Parameters:    f.parameters,
+		Calls:         make([]fixtureCall, 0, len(f.calls)),
+	}
+	keys := make([]string, 0, len(f.calls))
+	for key := range f.calls {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		ff.Calls = append(ff.Calls, f.calls[key])
+	}
+	f.mu.Unlock()
+
+	b, err := json.MarshalIndent(ff, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, b, 0644)
+}
+
+// Len is the number of requests in the fixture.
+func (f *Fixture) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.calls)
+}
+
+func fixtureKey(method string, args []byte) string {
+	return method + string(args)
+}
+
+// record adds the result of a request to the fixture.
+func (f *Fixture) record(method string, result interface{}, args ...interface{}) error {
+	a, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	r, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[fixtureKey(method, a)] = fixtureCall{Method: method, Arguments: a, Result: r}
+	return nil
+}
+
+// replay decodes the recorded result of a request into result.
+func (f *Fixture) replay(method string, result interface{}, args ...interface{}) error {
+	a, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	call, ok := f.calls[fixtureKey(method, a)]
+	f.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s%s", ErrNotRecorded, method, a)
+	}
+	return json.Unmarshal(call.Result, result)
+}
+
+// fixtureFloat is a float64 that may be NaN or infinite, which are recorded as strings.
+type fixtureFloat float64
+
+func (v fixtureFloat) MarshalJSON() ([]byte, error) {
+	f := float64(v)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return json.Marshal(strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	return json.Marshal(f)
+}
+
+func (v *fixtureFloat) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		f, err := strconv.ParseFloat(s, 64)
+		*v = fixtureFloat(f)
+		return err
+	}
+	var f float64
+	err := json.Unmarshal(b, &f)
+	*v = fixtureFloat(f)
+	return err
+}
+
+// RecordingStatisticsSource is a statistics source that records the requests made to another statistics source, and
+// their results, in a fixture. Requests that fail are not recorded. Only the methods of StatisticsSource are recorded,
+// so components that use the methods of a particular source (e.g. Entrez searches) cannot be replayed.
+type RecordingStatisticsSource struct {
+	source  StatisticsSource
+	fixture *Fixture
+}
+
+// NewRecordingStatisticsSource creates a statistics source that records the requests made to ss in the fixture.
+func NewRecordingStatisticsSource(ss StatisticsSource, fixture *Fixture) RecordingStatisticsSource {
+	fixture.mu.Lock()
+	fixture.options = ss.SearchOptions()
+	fixture.parameters = ss.Parameters()
+	fixture.mu.Unlock()
+	return RecordingStatisticsSource{source: ss, fixture: fixture}
+}
+
+// Fixture is the fixture the requests are recorded in.
+func (r RecordingStatisticsSource) Fixture() *Fixture {
+	return r.fixture
+}
+
+// WithContext binds the recorded statistics source to ctx, if it supports cancellation.
+func (r RecordingStatisticsSource) WithContext(ctx context.Context) StatisticsSource {
+	r.source = WithContext(ctx, r.source)
+	return r
+}
+
+// Concurrency is the number of requests that may be made to the recorded statistics source at once.
+func (r RecordingStatisticsSource) Concurrency() int {
+	if s, ok := r.source.(ConcurrencyLimiter); ok {
+		return s.Concurrency()
+	}
+	return 0
+}
+
+func (r RecordingStatisticsSource) SearchOptions() SearchOptions {
+	return r.source.SearchOptions()
+}
+
+func (r RecordingStatisticsSource) Parameters() map[string]float64 {
+	return r.source.Parameters()
+}
+
+// float records a request with a float result.
+func (r RecordingStatisticsSource) float(method string, v float64, err error, args ...interface{}) (float64, error) {
+	if err != nil {
+		return v, err
+	}
+	return v, r.fixture.record(method, fixtureFloat(v), args...)
+}
+
+func (r RecordingStatisticsSource) TermFrequency(term, field, document string) (float64, error) {
+	v, err := r.source.TermFrequency(term, field, document)
+	return r.float("TermFrequency", v, err, term, field, document)
+}
+
+func (r RecordingStatisticsSource) TermVector(document string) (TermVector, error) {
+	tv, err := r.source.TermVector(document)
+	if err != nil {
+		return nil, err
+	}
+	return tv, r.fixture.record("TermVector", tv, document)
+}
+
+func (r RecordingStatisticsSource) DocumentFrequency(term, field string) (float64, error) {
+	v, err := r.source.DocumentFrequency(term, field)
+	return r.float("DocumentFrequency", v, err, term, field)
+}
+
+func (r RecordingStatisticsSource) TotalTermFrequency(term, field string) (float64, error) {
+	v, err := r.source.TotalTermFrequency(term, field)
+	return r.float("TotalTermFrequency", v, err, term, field)
+}
+
+func (r RecordingStatisticsSource) InverseDocumentFrequency(term, field string) (float64, error) {
+	v, err := r.source.InverseDocumentFrequency(term, field)
+	return r.float("InverseDocumentFrequency", v, err, term, field)
+}
+
+func (r RecordingStatisticsSource) RetrievalSize(query cqr.CommonQueryRepresentation) (float64, error) {
+	v, err := r.source.RetrievalSize(query)
+	return r.float("RetrievalSize", v, err, query)
+}
+
+// batch records a statistic of each term, requested in a batch from the recorded statistics source. The statistics
+// are recorded as requests for the statistic of each term, so that they can be replayed one at a time.
+func (r RecordingStatisticsSource) batch(method string, terms []TermField, v []float64, err error) ([]float64, error) {
+	if err != nil {
+		return nil, err
+	}
+	for i, t := range terms {
+		if err := r.fixture.record(method, fixtureFloat(v[i]), t.Term, t.Field); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func (r RecordingStatisticsSource) DocumentFrequencies(terms []TermField) ([]float64, error) {
+	v, err := DocumentFrequencies(r.source, terms)
+	return r.batch("DocumentFrequency", terms, v, err)
+}
+
+func (r RecordingStatisticsSource) TotalTermFrequencies(terms []TermField) ([]float64, error) {
+	v, err := TotalTermFrequencies(r.source, terms)
+	return r.batch("TotalTermFrequency", terms, v, err)
+}
+
+func (r RecordingStatisticsSource) InverseDocumentFrequencies(terms []TermField) ([]float64, error) {
+	v, err := InverseDocumentFrequencies(r.source, terms)
+	return r.batch("InverseDocumentFrequency", terms, v, err)
+}
+
+func (r RecordingStatisticsSource) RetrievalSizes(queries []cqr.CommonQueryRepresentation) ([]float64, error) {
+	v, err := RetrievalSizes(r.source, queries)
+	if err != nil {
+		return nil, err
+	}
+	for i, query := range queries {
+		if err := r.fixture.record("RetrievalSize", fixtureFloat(v[i]), query); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func (r RecordingStatisticsSource) VocabularySize(field string) (float64, error) {
+	v, err := r.source.VocabularySize(field)
+	return r.float("VocabularySize", v, err, field)
+}
+
+func (r RecordingStatisticsSource) Execute(query pipeline.Query, options SearchOptions) (trecresults.ResultList, error) {
+	results, err := r.source.Execute(query, options)
+	if err != nil {
+		return nil, err
+	}
+	return results, r.fixture.record("Execute", results, query.Topic, query.Query, options)
+}
+
+func (r RecordingStatisticsSource) CollectionSize() (float64, error) {
+	v, err := r.source.CollectionSize()
+	return r.float("CollectionSize", v, err)
+}
+
+// ReplayStatisticsSource is a statistics source that answers requests with the results recorded in a fixture, without
+// the source they were recorded from. A request that was not recorded fails with ErrNotRecorded.
+type ReplayStatisticsSource struct {
+	fixture *Fixture
+	ctx     context.Context
+}
+
+// NewReplayStatisticsSource creates a statistics source that replays the requests recorded in the fixture.
+func NewReplayStatisticsSource(fixture *Fixture) ReplayStatisticsSource {
+	return ReplayStatisticsSource{fixture: fixture}
+}
+
+// WithContext returns a copy of the statistics source whose requests fail once ctx is done.
+func (r ReplayStatisticsSource) WithContext(ctx context.Context) StatisticsSource {
+	r.ctx = ctx
+	return r
+}
+
+func (r ReplayStatisticsSource) SearchOptions() SearchOptions {
+	r.fixture.mu.Lock()
+	defer r.fixture.mu.Unlock()
+	return r.fixture.options
+}
+
+func (r ReplayStatisticsSource) Parameters() map[string]float64 {
+	r.fixture.mu.Lock()
+	defer r.fixture.mu.Unlock()
+	return r.fixture.parameters
+}
+
+// replay decodes the recorded result of a request into result.
+func (r ReplayStatisticsSource) replay(method string, result interface{}, args ...interface{}) error {
+	if r.ctx != nil && r.ctx.Err() != nil {
+		return r.ctx.Err()
+	}
+	return r.fixture.replay(method, result, args...)
+}
+
+// float replays a request with a float result.
+func (r ReplayStatisticsSource) float(method string, args ...interface{}) (float64, error) {
+	var v fixtureFloat
+	err := r.replay(method, &v, args...)
+	return float64(v), err
+}
+
+func (r ReplayStatisticsSource) TermFrequency(term, field, document string) (float64, error) {
+	return r.float("TermFrequency", term, field, document)
+}
+
+func (r ReplayStatisticsSource) TermVector(document string) (TermVector, error) {
+	var tv TermVector
+	err := r.replay("TermVector", &tv, document)
+	return tv, err
+}
+
+func (r ReplayStatisticsSource) DocumentFrequency(term, field string) (float64, error) {
+	return r.float("DocumentFrequency", term, field)
+}
+
+func (r ReplayStatisticsSource) TotalTermFrequency(term, field string) (float64, error) {
+	return r.float("TotalTermFrequency", term, field)
+}
+
+func (r ReplayStatisticsSource) InverseDocumentFrequency(term, field string) (float64, error) {
+	return r.float("InverseDocumentFrequency", term, field)
+}
+
+func (r ReplayStatisticsSource) RetrievalSize(query cqr.CommonQueryRepresentation) (float64, error) {
+	return r.float("RetrievalSize", query)
+}
+
+func (r ReplayStatisticsSource) VocabularySize(field string) (float64, error) {
+	return r.float("VocabularySize", field)
+}
+
+func (r ReplayStatisticsSource) Execute(query pipeline.Query, options SearchOptions) (trecresults.ResultList, error) {
+	var results trecresults.ResultList
+	err := r.replay("Execute", &results, query.Topic, query.Query, options)
+	return results, err
+}
+
+func (r ReplayStatisticsSource) CollectionSize() (float64, error) {
+	return r.float("CollectionSize")
+}
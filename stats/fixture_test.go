@@ -0,0 +1,87 @@
+package stats
+
+import (
+	"errors"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/pipeline"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFixture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	query := pipeline.NewQuery("test", "1", cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{
+		cqr.NewKeyword("diabet*", "title", "text"),
+		cqr.NewKeyword("Diabetes Mellitus", "mesh_headings").SetOption(cqr.ExplodedString, true),
+	}))
+	requests := func(ss StatisticsSource) []interface{} {
+		var r []interface{}
+		add := func(v interface{}, err error) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = append(r, v)
+		}
+		add(ss.DocumentFrequency("retinopathy", "text"))
+		add(ss.TotalTermFrequency("diabet*", "title"))
+		add(ss.InverseDocumentFrequency("unindexed", "title"))
+		add(ss.TermFrequency("retinopathy", "text", "2"))
+		add(ss.VocabularySize("title_abstract"))
+		add(ss.CollectionSize())
+		add(ss.RetrievalSize(query.Query))
+		add(ss.TermVector("1"))
+		add(ss.Execute(query, ss.SearchOptions()))
+		return r
+	}
+
+	fixture := NewFixture()
+	recorded := requests(NewRecordingStatisticsSource(testIndex(t), fixture))
+	if fixture.Len() != len(recorded) {
+		t.Errorf("expected %d recorded requests, got %d", len(recorded), fixture.Len())
+	}
+	file := filepath.Join(dir, "fixture.json")
+	if err := fixture.Write(file); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ReadFixture(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay := NewReplayStatisticsSource(f)
+	replayed := requests(replay)
+	if !reflect.DeepEqual(recorded, replayed) {
+		t.Errorf("expected the replayed results %v to be the recorded results %v", replayed, recorded)
+	}
+
+	if _, err := replay.DocumentFrequency("retinopathy", "title"); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("expected ErrNotRecorded for a request that was not recorded, got %v", err)
+	}
+
+	// Statistics requested in a batch are recorded, and replayed, one at a time.
+	b := &countingBatchSource{IndexStatisticsSource: testIndex(t)}
+	batched := NewFixture()
+	terms := []TermField{{"retinopathy", "text"}, {"diabet*", "title"}}
+	df, err := DocumentFrequencies(NewRecordingStatisticsSource(b, batched), terms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.batches != 1 {
+		t.Errorf("expected the document frequencies to be requested in a batch, got %d batches", b.batches)
+	}
+	replayedDF, err := DocumentFrequencies(NewReplayStatisticsSource(batched), terms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(df, replayedDF) {
+		t.Errorf("expected the replayed document frequencies %v to be %v", replayedDF, df)
+	}
+}
@@ -0,0 +1,850 @@
+package stats
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/guru"
+	"github.com/hscells/meshexp"
+	"github.com/hscells/transmute/fields"
+	"github.com/hscells/trecresults"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// IndexStatisticsSource is a statistics source over an inverted index of MEDLINE documents held in memory, so that
+// statistics can be computed and queries executed without a search engine (e.g. in tests and small experiments).
+//
+// The title and abstract of each document are tokenised into lowercase words, while MeSH headings, publication types,
+// authors and PMIDs are indexed as whole values. Queries may use the fields of the transmute fields package (e.g.
+// title, text, title_abstract, mesh_headings), truncation ("diabet*"), phrases (a keyword of several words), adjacency
+// (the adj and adjN operators), and the explosion of MeSH headings using a MeSH tree.
+type IndexStatisticsSource struct {
+	index      *medlineIndex
+	mesh       *meshTree
+	options    SearchOptions
+	parameters map[string]float64
+	ctx        context.Context
+}
+
+// tokenised are the fields whose text is split into words. The remaining fields are indexed as whole values.
+var tokenised = map[string]bool{
+	fields.Title:    true,
+	fields.Abstract: true,
+}
+
+// indexFields maps the fields a query may use to the fields of the index they search.
+var indexFields = map[string][]string{
+	fields.Title:                 {fields.Title},
+	fields.Abstract:              {fields.Abstract},
+	"abstract":                   {fields.Abstract},
+	fields.TitleAbstract:         {fields.Title, fields.Abstract},
+	fields.TextWord:              {fields.Title, fields.Abstract},
+	fields.AllFields:             {fields.Title, fields.Abstract, fields.MeshHeadings, fields.MeSHSubheading, fields.PublicationType, fields.Authors},
+	fields.MeshHeadings:          {fields.MeshHeadings},
+	fields.MeSHTerms:             {fields.MeshHeadings},
+	fields.MajorFocusMeshHeading: {fields.MajorFocusMeshHeading},
+	fields.MeSHMajorTopic:        {fields.MajorFocusMeshHeading},
+	fields.MeSHSubheading:        {fields.MeSHSubheading},
+	fields.PublicationType:       {fields.PublicationType},
+	fields.Author:                {fields.Authors},
+	fields.Authors:               {fields.Authors},
+	fields.PMID:                  {fields.PMID},
+}
+
+// posting is the positions of a term in a document.
+type posting struct {
+	doc       int
+	positions []int
+}
+
+type indexTerm struct {
+	// postings are in the order documents were indexed.
+	postings []posting
+	ttf      int
+}
+
+type indexField struct {
+	terms map[string]*indexTerm
+	// vocabulary is the sorted terms of the field, for truncation.
+	vocabulary []string
+	// length is the number of tokens in the field across every document.
+	length int
+}
+
+type medlineIndex struct {
+	pmids []string
+	docs  map[string]int
+	// fields are the fields of the index by name.
+	fields map[string]*indexField
+	// vectors are the term frequencies of each document, by field.
+	vectors []map[string]map[string]int
+}
+
+// meshTree is the MeSH tree of a statistics source, which is only loaded when a query first explodes a heading.
+type meshTree struct {
+	once sync.Once
+	tree *meshexp.MeSHTree
+	err  error
+}
+
+func (m *meshTree) load() (*meshexp.MeSHTree, error) {
+	m.once.Do(func() {
+		if m.tree == nil {
+			m.tree, m.err = meshexp.Default()
+		}
+	})
+	return m.tree, m.err
+}
+
+// IndexSearchOptions sets the search options for the statistics source.
+func IndexSearchOptions(options SearchOptions) func(*IndexStatisticsSource) {
+	return func(s *IndexStatisticsSource) {
+		s.options = options
+	}
+}
+
+// IndexParameters sets the parameters for the statistics source.
+func IndexParameters(params map[string]float64) func(*IndexStatisticsSource) {
+	return func(s *IndexStatisticsSource) {
+		s.parameters = params
+	}
+}
+
+// IndexMeSHTree sets the MeSH tree that exploded MeSH headings are expanded with. By default, the MeSH tree of the
+// meshexp package is used.
+func IndexMeSHTree(tree *meshexp.MeSHTree) func(*IndexStatisticsSource) {
+	return func(s *IndexStatisticsSource) {
+		s.mesh = &meshTree{tree: tree}
+	}
+}
+
+// NewIndexStatisticsSource indexes the documents and creates a statistics source over the index. Documents without a
+// PMID, or with the PMID of a document already indexed, are ignored.
+func NewIndexStatisticsSource(documents guru.MedlineDocuments, options ...func(*IndexStatisticsSource)) *IndexStatisticsSource {
+	s := &IndexStatisticsSource{
+		index:      newMedlineIndex(documents),
+		mesh:       &meshTree{},
+		parameters: make(map[string]float64),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+func newMedlineIndex(documents guru.MedlineDocuments) *medlineIndex {
+	ix := &medlineIndex{
+		docs:   make(map[string]int, len(documents)),
+		fields: make(map[string]*indexField),
+	}
+	for _, doc := range documents {
+		ix.add(doc)
+	}
+	for _, f := range ix.fields {
+		f.vocabulary = make([]string, 0, len(f.terms))
+		for term := range f.terms {
+			f.vocabulary = append(f.vocabulary, term)
+		}
+		sort.Strings(f.vocabulary)
+	}
+	return ix
+}
+
+// add indexes a document.
+func (ix *medlineIndex) add(doc guru.MedlineDocument) {
+	pmid := strings.TrimSpace(doc.PMID)
+	if _, ok := ix.docs[pmid]; ok || len(pmid) == 0 {
+		return
+	}
+	id := len(ix.pmids)
+	ix.pmids = append(ix.pmids, pmid)
+	ix.docs[pmid] = id
+	ix.vectors = append(ix.vectors, make(map[string]map[string]int))
+
+	values := map[string][]string{
+		fields.Title:    tokenise(doc.TI, false),
+		fields.Abstract: tokenise(doc.AB, false),
+		fields.PMID:     {pmid},
+	}
+	for _, mh := range doc.MH {
+		descriptor, qualifiers, major := meshHeading(mh)
+		values[fields.MeshHeadings] = append(values[fields.MeshHeadings], descriptor)
+		values[fields.MeSHSubheading] = append(values[fields.MeSHSubheading], qualifiers...)
+		if major {
+			values[fields.MajorFocusMeshHeading] = append(values[fields.MajorFocusMeshHeading], descriptor)
+		}
+	}
+	for _, pt := range doc.PT {
+		values[fields.PublicationType] = append(values[fields.PublicationType], normalise(pt))
+	}
+	for _, au := range doc.AU {
+		values[fields.Authors] = append(values[fields.Authors], normalise(au))
+	}
+
+	for name, tokens := range values {
+		if len(tokens) == 0 {
+			continue
+		}
+		f, ok := ix.fields[name]
+		if !ok {
+			f = &indexField{terms: make(map[string]*indexTerm)}
+			ix.fields[name] = f
+		}
+		vector := make(map[string]int)
+		for pos, token := range tokens {
+			t, ok := f.terms[token]
+			if !ok {
+				t = &indexTerm{}
+				f.terms[token] = t
+			}
+			if n := len(t.postings); n == 0 || t.postings[n-1].doc != id {
+				t.postings = append(t.postings, posting{doc: id})
+			}
+			p := &t.postings[len(t.postings)-1]
+			p.positions = append(p.positions, pos)
+			t.ttf++
+			vector[token]++
+		}
+		f.length += len(tokens)
+		ix.vectors[id][name] = vector
+	}
+}
+
+// tokenise splits text into lowercase words of letters and digits. When wildcards is true, the wildcards of truncated
+// query terms (* and ?) are kept as part of the words.
+func tokenise(text string, wildcards bool) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		if wildcards && (r == '*' || r == '?') {
+			return false
+		}
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// normalise is the form whole values are indexed and searched in.
+func normalise(value string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Trim(strings.TrimSpace(value), `"`)))
+}
+
+// meshHeading splits a MeSH heading as it appears in MEDLINE (e.g. "Diabetes Mellitus/*drug therapy") into its
+// descriptor and qualifiers, and reports whether the heading is a major topic of the document.
+func meshHeading(mh string) (descriptor string, qualifiers []string, major bool) {
+	for i, part := range strings.Split(mh, "/") {
+		part = strings.TrimSpace(part)
+		if strings.HasPrefix(part, "*") {
+			major = true
+			part = part[1:]
+		}
+		if i == 0 {
+			descriptor = normalise(part)
+		} else if len(part) > 0 {
+			qualifiers = append(qualifiers, normalise(part))
+		}
+	}
+	return
+}
+
+// span is the first and last position of an occurrence of a query in a field.
+type span struct {
+	start, end int
+}
+
+// occurrences are the spans of a query in each field of each document it occurs in.
+type occurrences map[int]map[string][]span
+
+func (o occurrences) add(doc int, field string, s ...span) {
+	if len(s) == 0 {
+		return
+	}
+	if _, ok := o[doc]; !ok {
+		o[doc] = make(map[string][]span)
+	}
+	o[doc][field] = append(o[doc][field], s...)
+}
+
+func (o occurrences) merge(other occurrences) {
+	for doc, fs := range other {
+		for field, spans := range fs {
+			o.add(doc, field, spans...)
+		}
+	}
+}
+
+// documents are the sorted documents the query occurs in.
+func (o occurrences) documents() []int {
+	docs := make([]int, 0, len(o))
+	for doc := range o {
+		docs = append(docs, doc)
+	}
+	sort.Ints(docs)
+	return docs
+}
+
+// count is the number of times the query occurs.
+func (o occurrences) count() int {
+	var n int
+	for _, fs := range o {
+		for _, spans := range fs {
+			n += len(spans)
+		}
+	}
+	return n
+}
+
+// near finds the occurrences of both queries within distance words of each other, in either order.
+func (o occurrences) near(other occurrences, distance int) occurrences {
+	r := make(occurrences)
+	for doc, fs := range o {
+		for field, as := range fs {
+			bs := other[doc][field]
+			for _, a := range as {
+				for _, b := range bs {
+					var d int
+					switch {
+					case a.end < b.start:
+						d = b.start - a.end
+					case b.end < a.start:
+						d = a.start - b.end
+					default:
+						continue
+					}
+					if d <= distance {
+						r.add(doc, field, span{start: min(a.start, b.start), end: max(a.end, b.end)})
+					}
+				}
+			}
+		}
+	}
+	return r
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// expand finds the terms of a field that match a term, which may be truncated.
+func (f *indexField) expand(term string) []*indexTerm {
+	i := strings.IndexAny(term, "*?")
+	if i < 0 {
+		if t, ok := f.terms[term]; ok {
+			return []*indexTerm{t}
+		}
+		return nil
+	}
+	var terms []*indexTerm
+	prefix := term[:i]
+	for j := sort.SearchStrings(f.vocabulary, prefix); j < len(f.vocabulary) && strings.HasPrefix(f.vocabulary[j], prefix); j++ {
+		if ok, _ := path.Match(term, f.vocabulary[j]); ok {
+			terms = append(terms, f.terms[f.vocabulary[j]])
+		}
+	}
+	return terms
+}
+
+// positions are the sorted positions of the terms in each document.
+func positions(terms []*indexTerm) map[int][]int {
+	pos := make(map[int][]int)
+	for _, t := range terms {
+		for _, p := range t.postings {
+			pos[p.doc] = append(pos[p.doc], p.positions...)
+		}
+	}
+	if len(terms) > 1 {
+		for _, p := range pos {
+			sort.Ints(p)
+		}
+	}
+	return pos
+}
+
+// phrase finds the occurrences of the words, one after the other, in a field.
+func (f *indexField) phrase(words []string) map[int][]span {
+	if len(words) == 0 {
+		return nil
+	}
+	pos := make([]map[int][]int, len(words))
+	for i, word := range words {
+		pos[i] = positions(f.expand(word))
+	}
+	spans := make(map[int][]span)
+	for doc, starts := range pos[0] {
+	start:
+		for _, p := range starts {
+			for i := 1; i < len(words); i++ {
+				next := pos[i][doc]
+				j := sort.SearchInts(next, p+i)
+				if j == len(next) || next[j] != p+i {
+					continue start
+				}
+			}
+			spans[doc] = append(spans[doc], span{start: p, end: p + len(words) - 1})
+		}
+	}
+	return spans
+}
+
+// values finds the occurrences of whole values, which may be truncated, in a field.
+func (f *indexField) values(values []string) map[int][]span {
+	var terms []*indexTerm
+	for _, v := range values {
+		terms = append(terms, f.expand(v)...)
+	}
+	spans := make(map[int][]span)
+	for doc, ps := range positions(terms) {
+		for _, p := range ps {
+			spans[doc] = append(spans[doc], span{start: p, end: p})
+		}
+	}
+	return spans
+}
+
+// match finds the occurrences of a keyword in the index.
+func (s *IndexStatisticsSource) match(k cqr.Keyword) (occurrences, error) {
+	query := k.QueryString
+	if truncated, ok := k.GetOption(cqr.TruncatedString).(bool); ok && truncated && !strings.ContainsAny(query, "*?") {
+		query += "*"
+	}
+	exploded, _ := k.GetOption(cqr.ExplodedString).(bool)
+
+	queryFields := k.Fields
+	if len(queryFields) == 0 {
+		queryFields = []string{fields.AllFields}
+	}
+
+	o := make(occurrences)
+	seen := make(map[string]bool)
+	for _, qf := range queryFields {
+		names, ok := indexFields[qf]
+		if !ok {
+			return nil, fmt.Errorf("the field %q is not indexed", qf)
+		}
+		for _, name := range names {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			f, ok := s.index.fields[name]
+			if !ok {
+				continue
+			}
+
+			var spans map[int][]span
+			if tokenised[name] {
+				spans = f.phrase(tokenise(query, true))
+			} else {
+				values := []string{normalise(strings.TrimSuffix(strings.TrimSpace(query), "/"))}
+				if exploded && (name == fields.MeshHeadings || name == fields.MajorFocusMeshHeading) {
+					tree, err := s.mesh.load()
+					if err != nil {
+						return nil, err
+					}
+					for _, heading := range tree.Explode(values[0]) {
+						values = append(values, normalise(heading))
+					}
+				}
+				spans = f.values(values)
+			}
+			for doc, sp := range spans {
+				o.add(doc, name, sp...)
+			}
+		}
+	}
+	return o, nil
+}
+
+// adjacency is the distance of an adjacency operator (adj or adjN), or false if the operator is not one.
+func adjacency(operator string) (int, bool, error) {
+	operator = strings.ToLower(operator)
+	if !strings.HasPrefix(operator, "adj") {
+		return 0, false, nil
+	}
+	if operator == "adj" {
+		return 1, true, nil
+	}
+	n, err := strconv.Atoi(operator[3:])
+	if err != nil || n < 1 {
+		return 0, true, fmt.Errorf("invalid adjacency operator %q", operator)
+	}
+	return n, true, nil
+}
+
+// occurrences finds the occurrences of a query that is part of an adjacency: a keyword, or an adjacency or
+// disjunction of them.
+func (s *IndexStatisticsSource) occurrences(query cqr.CommonQueryRepresentation) (occurrences, error) {
+	switch q := query.(type) {
+	case cqr.Keyword:
+		return s.match(q)
+	case cqr.BooleanQuery:
+		distance, ok, err := adjacency(q.Operator)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return s.adjacent(q.Children, distance)
+		}
+		if strings.ToLower(q.Operator) != cqr.OR {
+			return nil, fmt.Errorf("the %q operator cannot be used within an adjacency", q.Operator)
+		}
+		o := make(occurrences)
+		for _, child := range q.Children {
+			c, err := s.occurrences(child)
+			if err != nil {
+				return nil, err
+			}
+			o.merge(c)
+		}
+		return o, nil
+	}
+	return nil, fmt.Errorf("unsupported query %T", query)
+}
+
+// adjacent finds the occurrences of the queries within distance words of each other, in the same field.
+func (s *IndexStatisticsSource) adjacent(queries []cqr.CommonQueryRepresentation, distance int) (occurrences, error) {
+	var o occurrences
+	for i, query := range queries {
+		c, err := s.occurrences(query)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			o = c
+		} else {
+			o = o.near(c, distance)
+		}
+	}
+	return o, nil
+}
+
+// documents evaluates a query, returning the sorted documents it retrieves.
+func (s *IndexStatisticsSource) documents(query cqr.CommonQueryRepresentation) ([]int, error) {
+	switch q := query.(type) {
+	case cqr.Keyword:
+		o, err := s.match(q)
+		if err != nil {
+			return nil, err
+		}
+		return o.documents(), nil
+	case cqr.BooleanQuery:
+		distance, ok, err := adjacency(q.Operator)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			o, err := s.adjacent(q.Children, distance)
+			if err != nil {
+				return nil, err
+			}
+			return o.documents(), nil
+		}
+
+		children := make([][]int, len(q.Children))
+		for i, child := range q.Children {
+			children[i], err = s.documents(child)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(children) == 0 {
+			return nil, nil
+		}
+		switch strings.ToLower(q.Operator) {
+		case cqr.AND:
+			docs := children[0]
+			for _, c := range children[1:] {
+				docs = intersection(docs, c)
+			}
+			return docs, nil
+		case cqr.OR:
+			var docs []int
+			for _, c := range children {
+				docs = union(docs, c)
+			}
+			return docs, nil
+		case cqr.NOT:
+			var excluded []int
+			for _, c := range children[1:] {
+				excluded = union(excluded, c)
+			}
+			return difference(children[0], excluded), nil
+		}
+		return nil, fmt.Errorf("unsupported operator %q", q.Operator)
+	}
+	return nil, fmt.Errorf("unsupported query %T", query)
+}
+
+func intersection(a, b []int) []int {
+	var r []int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			r = append(r, a[i])
+			i++
+			j++
+		}
+	}
+	return r
+}
+
+func union(a, b []int) []int {
+	r := make([]int, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			r = append(r, a[i])
+			i++
+		case a[i] > b[j]:
+			r = append(r, b[j])
+			j++
+		default:
+			r = append(r, a[i])
+			i++
+			j++
+		}
+	}
+	r = append(r, a[i:]...)
+	return append(r, b[j:]...)
+}
+
+func difference(a, b []int) []int {
+	var r []int
+	for i, j := 0, 0; i < len(a); i++ {
+		for j < len(b) && b[j] < a[i] {
+			j++
+		}
+		if j == len(b) || b[j] != a[i] {
+			r = append(r, a[i])
+		}
+	}
+	return r
+}
+
+// WithContext returns a copy of the statistics source whose requests fail once ctx is done.
+func (s *IndexStatisticsSource) WithContext(ctx context.Context) StatisticsSource {
+	c := *s
+	c.ctx = ctx
+	return &c
+}
+
+// err is the error of the context of the statistics source, if it is done.
+func (s *IndexStatisticsSource) err() error {
+	if s.ctx == nil {
+		return nil
+	}
+	return s.ctx.Err()
+}
+
+// SearchOptions gets the immutable execute options for the statistics source.
+func (s *IndexStatisticsSource) SearchOptions() SearchOptions {
+	return s.options
+}
+
+// Parameters gets the immutable parameters for the statistics source.
+func (s *IndexStatisticsSource) Parameters() map[string]float64 {
+	return s.parameters
+}
+
+// keyword matches a term in a field, as the statistics of a term are computed for.
+func (s *IndexStatisticsSource) keyword(term, field string) (occurrences, error) {
+	if err := s.err(); err != nil {
+		return nil, err
+	}
+	return s.match(cqr.NewKeyword(term, field))
+}
+
+// TermFrequency is the number of times the term occurs in the field of the document.
+func (s *IndexStatisticsSource) TermFrequency(term, field, document string) (float64, error) {
+	o, err := s.keyword(term, field)
+	if err != nil {
+		return 0, err
+	}
+	doc, ok := s.index.docs[document]
+	if !ok {
+		return 0, nil
+	}
+	var n int
+	for _, spans := range o[doc] {
+		n += len(spans)
+	}
+	return float64(n), nil
+}
+
+// TermVector is the terms of each field of the document, or nil if the document is not indexed.
+func (s *IndexStatisticsSource) TermVector(document string) (TermVector, error) {
+	if err := s.err(); err != nil {
+		return nil, err
+	}
+	doc, ok := s.index.docs[document]
+	if !ok {
+		return nil, nil
+	}
+	var tv TermVector
+	for _, name := range sortedFields(s.index.vectors[doc]) {
+		f := s.index.fields[name]
+		vector := s.index.vectors[doc][name]
+		terms := make([]string, 0, len(vector))
+		for term := range vector {
+			terms = append(terms, term)
+		}
+		sort.Strings(terms)
+		for _, term := range terms {
+			t := f.terms[term]
+			tv = append(tv, TermVectorTerm{
+				DocumentFrequency:  float64(len(t.postings)),
+				TotalTermFrequency: float64(t.ttf),
+				TermFrequency:      float64(vector[term]),
+				Field:              name,
+				Term:               term,
+			})
+		}
+	}
+	return tv, nil
+}
+
+func sortedFields(vectors map[string]map[string]int) []string {
+	names := make([]string, 0, len(vectors))
+	for name := range vectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DocumentFrequency is the number of documents the term occurs in.
+func (s *IndexStatisticsSource) DocumentFrequency(term, field string) (float64, error) {
+	o, err := s.keyword(term, field)
+	if err != nil {
+		return 0, err
+	}
+	return float64(len(o)), nil
+}
+
+// TotalTermFrequency is the number of times the term occurs in the collection.
+func (s *IndexStatisticsSource) TotalTermFrequency(term, field string) (float64, error) {
+	o, err := s.keyword(term, field)
+	if err != nil {
+		return 0, err
+	}
+	return float64(o.count()), nil
+}
+
+// InverseDocumentFrequency is the ratio of of documents in the collection to the number of documents the term appears
+// in, logarithmically smoothed.
+func (s *IndexStatisticsSource) InverseDocumentFrequency(term, field string) (float64, error) {
+	df, err := s.DocumentFrequency(term, field)
+	if err != nil {
+		return 0, err
+	}
+	return idf(float64(len(s.index.pmids)), df), nil
+}
+
+// RetrievalSize is the number of documents the query retrieves.
+func (s *IndexStatisticsSource) RetrievalSize(query cqr.CommonQueryRepresentation) (float64, error) {
+	if err := s.err(); err != nil {
+		return 0, err
+	}
+	docs, err := s.documents(query)
+	if err != nil {
+		return 0, err
+	}
+	return float64(len(docs)), nil
+}
+
+// VocabularySize is the number of tokens in the field across every document.
+func (s *IndexStatisticsSource) VocabularySize(field string) (float64, error) {
+	if err := s.err(); err != nil {
+		return 0, err
+	}
+	names, ok := indexFields[field]
+	if !ok {
+		return 0, fmt.Errorf("the field %q is not indexed", field)
+	}
+	var n int
+	for _, name := range names {
+		if f, ok := s.index.fields[name]; ok {
+			n += f.length
+		}
+	}
+	return float64(n), nil
+}
+
+// Execute retrieves the documents of a Boolean query, most recent (i.e. highest PMID) first, as PubMed orders them.
+// The results are scored by their rank.
+func (s *IndexStatisticsSource) Execute(query pipeline.Query, options SearchOptions) (trecresults.ResultList, error) {
+	if err := s.err(); err != nil {
+		return nil, err
+	}
+	docs, err := s.documents(query.Query)
+	if err != nil {
+		return nil, err
+	}
+	pmids := make([]string, len(docs))
+	for i, doc := range docs {
+		pmids[i] = s.index.pmids[doc]
+	}
+	sort.Slice(pmids, func(i, j int) bool {
+		if len(pmids[i]) != len(pmids[j]) {
+			return len(pmids[i]) > len(pmids[j])
+		}
+		return pmids[i] > pmids[j]
+	})
+	if options.Size > 0 && len(pmids) > options.Size {
+		pmids = pmids[:options.Size]
+	}
+
+	results := make(trecresults.ResultList, len(pmids))
+	for i, pmid := range pmids {
+		results[i] = &trecresults.Result{
+			Topic:     query.Topic,
+			Iteration: "Q0",
+			DocId:     pmid,
+			Rank:      int64(i),
+			Score:     float64(len(pmids) - i),
+			RunName:   options.RunName,
+		}
+	}
+	return results, nil
+}
+
+// Identity is a hash of the documents in the index and the size of each of their fields.
+func (s *IndexStatisticsSource) Identity() string {
+	h := sha256.New()
+	for _, pmid := range s.index.pmids {
+		fmt.Fprintln(h, pmid)
+	}
+	names := make([]string, 0, len(s.index.fields))
+	for name := range s.index.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(h, name, s.index.fields[name].length)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// CollectionSize is the number of documents in the index.
+func (s *IndexStatisticsSource) CollectionSize() (float64, error) {
+	return float64(len(s.index.pmids)), nil
+}
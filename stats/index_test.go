@@ -0,0 +1,247 @@
+package stats
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/meshexp"
+	"strings"
+	"testing"
+)
+
+const testMedline = `PMID- 1
+TI  - Metformin for type 2 diabetes mellitus.
+AB  - Metformin lowers blood glucose in adults with type 2 diabetes.
+MH  - *Diabetes Mellitus, Type 2/drug therapy
+MH  - Humans
+MH  - Metformin/*therapeutic use
+PT  - Randomized Controlled Trial
+AU  - Smith J
+
+PMID- 2
+TI  - Diabetic retinopathy screening with fundus photography.
+AB  - Retinopathy is a complication of diabetes. Screening detects
+      retinopathy early.
+MH  - Diabetic Retinopathy/*diagnosis
+MH  - Humans
+PT  - Journal Article
+AU  - Jones A
+
+PMID- 3
+TI  - Blood pressure in children.
+AB  - Hypertension in children is rarely screened for.
+MH  - Hypertension
+MH  - Child
+PT  - Journal Article
+`
+
+const testPubmedXML = `<?xml version="1.0" encoding="utf-8"?>
+<!DOCTYPE PubmedArticleSet>
+<PubmedArticleSet>
+<PubmedArticle>
+  <MedlineCitation Status="MEDLINE" Owner="NLM">
+    <PMID Version="1">4</PMID>
+    <DateCompleted><Year>2020</Year><Month>01</Month><Day>31</Day></DateCompleted>
+    <Article>
+      <ArticleTitle>Insulin in <i>type 1</i> diabetes.</ArticleTitle>
+      <Abstract>
+        <AbstractText Label="BACKGROUND">Insulin pumps are common.</AbstractText>
+        <AbstractText Label="RESULTS">Pumps improved control.</AbstractText>
+      </Abstract>
+      <AuthorList><Author><LastName>Brown</LastName><Initials>K</Initials></Author></AuthorList>
+      <PublicationTypeList><PublicationType UI="D016428">Journal Article</PublicationType></PublicationTypeList>
+    </Article>
+    <MeshHeadingList>
+      <MeshHeading>
+        <DescriptorName UI="D003922" MajorTopicYN="Y">Diabetes Mellitus, Type 1</DescriptorName>
+        <QualifierName UI="Q000188" MajorTopicYN="N">drug therapy</QualifierName>
+      </MeshHeading>
+    </MeshHeadingList>
+  </MedlineCitation>
+</PubmedArticle>
+</PubmedArticleSet>
+`
+
+const testMeSHTree = `Endocrine System Diseases;C18
+Metabolic Diseases;C18.452
+Glucose Metabolism Disorders;C18.452.394
+Diabetes Mellitus;C18.452.394.750
+Diabetes Mellitus, Type 1;C18.452.394.750.124
+Diabetes Mellitus, Type 2;C18.452.394.750.149
+Diabetic Retinopathy;C18.452.394.750.149.500
+Cardiovascular Diseases;C14
+Vascular Diseases;C14.907
+Hypertension;C14.907.489
+`
+
+func testIndex(t *testing.T) *IndexStatisticsSource {
+	docs, err := ReadMedline(strings.NewReader(testMedline))
+	if err != nil {
+		t.Fatal(err)
+	}
+	xml, err := ReadMedline(strings.NewReader(testPubmedXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := meshexp.MeSHTreeFromReader(strings.NewReader(testMeSHTree))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewIndexStatisticsSource(append(docs, xml...), IndexMeSHTree(tree))
+}
+
+func TestReadMedline(t *testing.T) {
+	docs, err := ReadMedline(strings.NewReader(testPubmedXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 {
+		t.Fatalf("expected 1 document, got %d", len(docs))
+	}
+	doc := docs[0]
+	if doc.PMID != "4" || doc.TI != "Insulin in type 1 diabetes." || doc.DCOM != "20200131" {
+		t.Errorf("unexpected document %+v", doc)
+	}
+	if doc.AB != "BACKGROUND: Insulin pumps are common. RESULTS: Pumps improved control." {
+		t.Errorf("unexpected abstract %q", doc.AB)
+	}
+	if len(doc.MH) != 1 || doc.MH[0] != "*Diabetes Mellitus, Type 1/drug therapy" {
+		t.Errorf("unexpected MeSH headings %v", doc.MH)
+	}
+
+	docs, err = ReadMedline(strings.NewReader(testMedline))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 3 {
+		t.Fatalf("expected 3 documents, got %d", len(docs))
+	}
+	if len(docs[0].AU) != 1 || docs[0].AU[0] != "Smith J" {
+		t.Errorf("unexpected authors %v", docs[0].AU)
+	}
+	if docs[1].AB != "Retinopathy is a complication of diabetes. Screening detects retinopathy early." {
+		t.Errorf("unexpected abstract %q", docs[1].AB)
+	}
+}
+
+func TestIndexStatisticsSource_Execute(t *testing.T) {
+	s := testIndex(t)
+	title := []string{"title"}
+	tiab := []string{"title", "text"}
+	mesh := func(heading string, exploded bool) cqr.CommonQueryRepresentation {
+		return cqr.NewKeyword(heading, "mesh_headings").SetOption(cqr.ExplodedString, exploded)
+	}
+
+	for _, c := range []struct {
+		name  string
+		query cqr.CommonQueryRepresentation
+		want  []string
+	}{
+		{"term", cqr.NewKeyword("metformin", tiab...), []string{"1"}},
+		{"field", cqr.NewKeyword("retinopathy", title...), []string{"2"}},
+		{"truncation", cqr.NewKeyword("diabet*", tiab...), []string{"4", "2", "1"}},
+		{"truncation option", cqr.NewKeyword("diabet", title...).SetOption(cqr.TruncatedString, true), []string{"4", "2", "1"}},
+		{"phrase", cqr.NewKeyword("type 2 diabetes", tiab...), []string{"1"}},
+		{"phrase across words", cqr.NewKeyword("blood glucose", title...), nil},
+		{"adjacency", cqr.NewBooleanQuery("adj2", []cqr.CommonQueryRepresentation{
+			cqr.NewKeyword("screening", tiab...),
+			cqr.NewKeyword("retinopathy", tiab...),
+		}), []string{"2"}},
+		{"adjacency distance", cqr.NewBooleanQuery("adj", []cqr.CommonQueryRepresentation{
+			cqr.NewKeyword("hypertension", tiab...),
+			cqr.NewKeyword("screened", tiab...),
+		}), nil},
+		{"adjacency disjunction", cqr.NewBooleanQuery("adj3", []cqr.CommonQueryRepresentation{
+			cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{
+				cqr.NewKeyword("hypertension", tiab...),
+				cqr.NewKeyword("blood pressure", tiab...),
+			}),
+			cqr.NewKeyword("child*", tiab...),
+		}), []string{"3"}},
+		{"mesh", mesh("Diabetes Mellitus", false), nil},
+		{"mesh explosion", mesh("Diabetes Mellitus", true), []string{"4", "2", "1"}},
+		{"major mesh", cqr.NewKeyword("metformin", "major_mesh_headings"), []string{"1"}},
+		{"and", cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{
+			mesh("Humans", false),
+			cqr.NewKeyword("screening", tiab...),
+		}), []string{"2"}},
+		{"not", cqr.NewBooleanQuery(cqr.NOT, []cqr.CommonQueryRepresentation{
+			cqr.NewKeyword("journal article", "publication_type"),
+			mesh("Diabetic Retinopathy", false),
+		}), []string{"4", "3"}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			results, err := s.Execute(pipeline.NewQuery("test", "1", c.query), s.SearchOptions())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range results {
+				got = append(got, r.DocId)
+			}
+			if strings.Join(got, ",") != strings.Join(c.want, ",") {
+				t.Errorf("expected %v, got %v", c.want, got)
+			}
+			n, err := s.RetrievalSize(c.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int(n) != len(c.want) {
+				t.Errorf("expected a retrieval size of %d, got %f", len(c.want), n)
+			}
+		})
+	}
+
+	_, err := s.RetrievalSize(cqr.NewBooleanQuery("adj", []cqr.CommonQueryRepresentation{
+		cqr.NewBooleanQuery(cqr.AND, nil),
+	}))
+	if err == nil {
+		t.Error("expected an error for a conjunction within an adjacency")
+	}
+	_, err = s.RetrievalSize(cqr.NewKeyword("metformin", "journal_title"))
+	if err == nil {
+		t.Error("expected an error for a field that is not indexed")
+	}
+}
+
+func TestIndexStatisticsSource_Statistics(t *testing.T) {
+	s := testIndex(t)
+
+	for _, c := range []struct {
+		name string
+		stat func() (float64, error)
+		want float64
+	}{
+		{"CollectionSize", s.CollectionSize, 4},
+		{"DocumentFrequency", func() (float64, error) { return s.DocumentFrequency("retinopathy", "text") }, 1},
+		{"TotalTermFrequency", func() (float64, error) { return s.TotalTermFrequency("retinopathy", "title_abstract") }, 3},
+		{"TotalTermFrequency truncated", func() (float64, error) { return s.TotalTermFrequency("diabet*", "title") }, 3},
+		{"TermFrequency", func() (float64, error) { return s.TermFrequency("retinopathy", "text", "2") }, 2},
+		{"TermFrequency missing document", func() (float64, error) { return s.TermFrequency("retinopathy", "text", "5") }, 0},
+		{"VocabularySize", func() (float64, error) { return s.VocabularySize("title") }, 21},
+	} {
+		got, err := c.stat()
+		if err != nil {
+			t.Fatal(c.name, err)
+		}
+		if got != c.want {
+			t.Errorf("%s: expected %f, got %f", c.name, c.want, got)
+		}
+	}
+
+	tv, err := s.TermVector("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, term := range tv {
+		if term.Field == "text" && term.Term == "retinopathy" {
+			found = true
+			if term.TermFrequency != 2 || term.DocumentFrequency != 1 || term.TotalTermFrequency != 2 {
+				t.Errorf("unexpected term vector term %+v", term)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("retinopathy is not in the term vector %v", tv)
+	}
+}
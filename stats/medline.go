@@ -0,0 +1,268 @@
+package stats
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"github.com/hscells/guru"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// ReadMedline reads documents in either the MEDLINE format that PubMed exports (also known as NBIB), or PubMed XML (a
+// PubmedArticleSet, as in the PubMed baseline). Either may be gzip compressed.
+func ReadMedline(r io.Reader) (guru.MedlineDocuments, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	// PubMed XML starts with an element (or a declaration), while MEDLINE starts with a field.
+	for {
+		c, _, err := br.ReadRune()
+		if err == io.EOF {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if unicode.IsSpace(c) || c == '\uFEFF' {
+			continue
+		}
+		if err := br.UnreadRune(); err != nil {
+			return nil, err
+		}
+		if c == '<' {
+			return readPubmedXML(br)
+		}
+		return readMedlineText(br)
+	}
+}
+
+// ReadMedlineFiles reads the documents in each of the files (see ReadMedline).
+func ReadMedlineFiles(files ...string) (guru.MedlineDocuments, error) {
+	var docs guru.MedlineDocuments
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		d, err := ReadMedline(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		docs = append(docs, d...)
+	}
+	return docs, nil
+}
+
+// readMedlineText reads documents in the MEDLINE format. Unlike guru.UnmarshalMedline, the last field of each document
+// is not lost.
+func readMedlineText(r io.Reader) (guru.MedlineDocuments, error) {
+	var (
+		docs     guru.MedlineDocuments
+		doc      guru.MedlineDocument
+		tag      string
+		value    strings.Builder
+		bti, cti string
+	)
+	// field adds the field that has been read to the document.
+	field := func() {
+		v := strings.TrimSpace(value.String())
+		switch tag {
+		case "PMID":
+			doc.PMID = v
+		case "TI":
+			doc.TI = v
+		case "BTI":
+			bti = v
+		case "CTI":
+			cti = v
+		case "AB":
+			doc.AB = v
+		case "DCOM":
+			doc.DCOM = v
+		case "MH":
+			doc.MH = append(doc.MH, v)
+		case "PT":
+			doc.PT = append(doc.PT, v)
+		case "AU":
+			doc.AU = append(doc.AU, v)
+		}
+		tag = ""
+		value.Reset()
+	}
+	// document adds the document that has been read.
+	document := func() {
+		field()
+		if len(doc.TI) == 0 && len(bti) > 0 {
+			doc.TI = bti
+		} else if len(doc.TI) == 0 {
+			doc.TI = cti
+		}
+		if len(doc.PMID) > 0 {
+			docs = append(docs, doc)
+		}
+		doc = guru.MedlineDocument{}
+		bti, cti = "", ""
+	}
+
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case len(strings.TrimSpace(line)) == 0:
+			document()
+		case strings.HasPrefix(line, "      "):
+			value.WriteString(" ")
+			value.WriteString(strings.TrimSpace(line))
+		case len(line) >= 5 && line[4] == '-':
+			field()
+			tag = strings.TrimSpace(line[:4])
+			value.WriteString(strings.TrimSpace(line[5:]))
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	document()
+	return docs, nil
+}
+
+type pubmedArticle struct {
+	PMID          string            `xml:"MedlineCitation>PMID"`
+	DateCompleted pubmedDate        `xml:"MedlineCitation>DateCompleted"`
+	Title         pubmedText        `xml:"MedlineCitation>Article>ArticleTitle"`
+	Abstract      []pubmedText      `xml:"MedlineCitation>Article>Abstract>AbstractText"`
+	Authors       []pubmedAuthor    `xml:"MedlineCitation>Article>AuthorList>Author"`
+	Types         []string          `xml:"MedlineCitation>Article>PublicationTypeList>PublicationType"`
+	MeSHHeadings  []pubmedMeSHEntry `xml:"MedlineCitation>MeshHeadingList>MeshHeading"`
+}
+
+type pubmedDate struct {
+	Year  string
+	Month string
+	Day   string
+}
+
+type pubmedAuthor struct {
+	LastName string
+	Initials string
+}
+
+type pubmedMeSHEntry struct {
+	Descriptor pubmedMeSHName   `xml:"DescriptorName"`
+	Qualifiers []pubmedMeSHName `xml:"QualifierName"`
+}
+
+type pubmedMeSHName struct {
+	Name       string `xml:",chardata"`
+	MajorTopic string `xml:"MajorTopicYN,attr"`
+}
+
+// pubmedText is the text of an element, including the text of any markup inside it (e.g. <i>).
+type pubmedText struct {
+	Label string
+	Text  string
+}
+
+func (t *pubmedText) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "Label" {
+			t.Label = attr.Value
+		}
+	}
+	var b strings.Builder
+	for depth := 0; ; {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.CharData:
+			b.Write(tok)
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				t.Text = strings.TrimSpace(b.String())
+				return nil
+			}
+			depth--
+		}
+	}
+}
+
+// readPubmedXML reads the articles of a PubmedArticleSet one at a time.
+func readPubmedXML(r io.Reader) (guru.MedlineDocuments, error) {
+	var docs guru.MedlineDocuments
+	d := xml.NewDecoder(r)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return docs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "PubmedArticle" {
+			continue
+		}
+		var a pubmedArticle
+		if err := d.DecodeElement(&a, &start); err != nil {
+			return nil, err
+		}
+		docs = append(docs, a.medline())
+	}
+}
+
+// medline converts an article into a MEDLINE document, as it would appear in the MEDLINE format.
+func (a pubmedArticle) medline() guru.MedlineDocument {
+	doc := guru.MedlineDocument{
+		PMID: strings.TrimSpace(a.PMID),
+		TI:   a.Title.Text,
+		PT:   a.Types,
+	}
+	if len(a.DateCompleted.Year) > 0 {
+		doc.DCOM = a.DateCompleted.Year + a.DateCompleted.Month + a.DateCompleted.Day
+	}
+	abstract := make([]string, len(a.Abstract))
+	for i, text := range a.Abstract {
+		if len(text.Label) > 0 {
+			abstract[i] = text.Label + ": " + text.Text
+		} else {
+			abstract[i] = text.Text
+		}
+	}
+	doc.AB = strings.Join(abstract, " ")
+	for _, au := range a.Authors {
+		if len(au.LastName) > 0 {
+			doc.AU = append(doc.AU, strings.TrimSpace(au.LastName+" "+au.Initials))
+		}
+	}
+	for _, mh := range a.MeSHHeadings {
+		heading := mh.Descriptor.name()
+		for _, q := range mh.Qualifiers {
+			heading += "/" + q.name()
+		}
+		doc.MH = append(doc.MH, heading)
+	}
+	return doc
+}
+
+// name is the name of a descriptor or qualifier, marked with an asterisk if it is a major topic.
+func (n pubmedMeSHName) name() string {
+	if n.MajorTopic == "Y" {
+		return "*" + strings.TrimSpace(n.Name)
+	}
+	return strings.TrimSpace(n.Name)
+}
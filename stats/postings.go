@@ -0,0 +1,85 @@
+package stats
+
+import (
+	"fmt"
+	"github.com/hscells/cqr"
+	"strings"
+)
+
+// postingsFunc calls fn with each document that contains a term in a field, in ascending order of document, and the
+// frequency of the term in the document.
+type postingsFunc func(term, field string, fn func(doc, tf int)) error
+
+// evaluatePostings evaluates a Boolean query on the postings of its terms, returning the sorted documents it
+// retrieves. Keywords without fields search the default field. The words of a phrase, and the children of an
+// adjacency, are only required to occur in the same document, so the documents of these queries are a superset of
+// the documents they match.
+func evaluatePostings(query cqr.CommonQueryRepresentation, field string, postings postingsFunc) ([]int, error) {
+	switch q := query.(type) {
+	case cqr.Keyword:
+		queryFields := q.Fields
+		if len(queryFields) == 0 {
+			queryFields = []string{field}
+		}
+		var docs []int
+		for _, f := range queryFields {
+			var fieldDocs []int
+			for i, word := range tokenise(q.QueryString, false) {
+				var wordDocs []int
+				err := postings(word, f, func(doc, tf int) {
+					wordDocs = append(wordDocs, doc)
+				})
+				if err != nil {
+					return nil, err
+				}
+				if i == 0 {
+					fieldDocs = wordDocs
+				} else {
+					fieldDocs = intersection(fieldDocs, wordDocs)
+				}
+			}
+			docs = union(docs, fieldDocs)
+		}
+		return docs, nil
+	case cqr.BooleanQuery:
+		children := make([][]int, len(q.Children))
+		for i, child := range q.Children {
+			var err error
+			children[i], err = evaluatePostings(child, field, postings)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(children) == 0 {
+			return nil, nil
+		}
+		operator := strings.ToLower(q.Operator)
+		if _, ok, err := adjacency(operator); err != nil {
+			return nil, err
+		} else if ok {
+			operator = cqr.AND
+		}
+		switch operator {
+		case cqr.AND:
+			docs := children[0]
+			for _, c := range children[1:] {
+				docs = intersection(docs, c)
+			}
+			return docs, nil
+		case cqr.OR:
+			var docs []int
+			for _, c := range children {
+				docs = union(docs, c)
+			}
+			return docs, nil
+		case cqr.NOT:
+			var excluded []int
+			for _, c := range children[1:] {
+				excluded = union(excluded, c)
+			}
+			return difference(children[0], excluded), nil
+		}
+		return nil, fmt.Errorf("unsupported operator %q", q.Operator)
+	}
+	return nil, fmt.Errorf("unsupported query %T", query)
+}
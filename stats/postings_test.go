@@ -0,0 +1,103 @@
+package stats
+
+import (
+	"errors"
+	"github.com/hscells/cqr"
+	"reflect"
+	"testing"
+)
+
+// testPostings are the documents of the terms of each field.
+var testPostings = map[string]map[string][]int{
+	"title": {
+		"metformin": {1, 4},
+		"diabetes":  {1, 2, 4},
+		"type":      {1, 3},
+	},
+	"abstract": {
+		"metformin": {2, 5},
+		"insulin":   {3, 5},
+	},
+}
+
+func (p postingsFunc) of(t *testing.T, query cqr.CommonQueryRepresentation) []int {
+	docs, err := evaluatePostings(query, "title", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return docs
+}
+
+func TestEvaluatePostings(t *testing.T) {
+	var requested []string
+	postings := postingsFunc(func(term, field string, fn func(doc, tf int)) error {
+		requested = append(requested, field+":"+term)
+		for _, doc := range testPostings[field][term] {
+			fn(doc, 1)
+		}
+		return nil
+	})
+	k := func(s string, fields ...string) cqr.Keyword { return cqr.NewKeyword(s, fields...) }
+	tests := []struct {
+		name  string
+		query cqr.CommonQueryRepresentation
+		docs  []int
+	}{
+		{"default field", k("Metformin"), []int{1, 4}},
+		{"field", k("metformin", "abstract"), []int{2, 5}},
+		{"fields", k("metformin", "title", "abstract"), []int{1, 2, 4, 5}},
+		{"missing term", k("aspirin"), nil},
+		{"phrase", k("type diabetes"), []int{1}},
+		{"and", cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{k("diabetes"), k("type")}), []int{1}},
+		{"or", cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{k("type"), k("insulin", "abstract")}), []int{1, 3, 5}},
+		{"not", cqr.NewBooleanQuery(cqr.NOT, []cqr.CommonQueryRepresentation{k("diabetes"), k("metformin"), k("type")}), []int{2}},
+		{"adjacency", cqr.NewBooleanQuery("adj3", []cqr.CommonQueryRepresentation{k("metformin"), k("diabetes")}), []int{1, 4}},
+		{"nested", cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{
+			cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{k("metformin"), k("metformin", "abstract")}),
+			cqr.NewBooleanQuery(cqr.NOT, []cqr.CommonQueryRepresentation{k("diabetes"), k("type")}),
+		}), []int{2, 4}},
+		{"empty", cqr.NewBooleanQuery(cqr.OR, nil), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if docs := postings.of(t, tt.query); len(docs) != len(tt.docs) || (len(docs) > 0 && !reflect.DeepEqual(docs, tt.docs)) {
+				t.Errorf("expected %v, got %v", tt.docs, docs)
+			}
+		})
+	}
+
+	// The words of a keyword are lowercased, and looked up in each of its fields.
+	requested = nil
+	postings.of(t, k("Type Diabetes", "title", "abstract"))
+	if want := []string{"title:type", "title:diabetes", "abstract:type", "abstract:diabetes"}; !reflect.DeepEqual(requested, want) {
+		t.Errorf("expected the postings of %v, got %v", want, requested)
+	}
+}
+
+func TestEvaluatePostingsErrors(t *testing.T) {
+	failed := errors.New("postings")
+	postings := postingsFunc(func(term, field string, fn func(doc, tf int)) error {
+		if term == "fail" {
+			return failed
+		}
+		return nil
+	})
+	tests := []struct {
+		name  string
+		query cqr.CommonQueryRepresentation
+	}{
+		{"postings", cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{cqr.NewKeyword("ok"), cqr.NewKeyword("fail")})},
+		{"operator", cqr.NewBooleanQuery("xor", []cqr.CommonQueryRepresentation{cqr.NewKeyword("ok")})},
+		{"adjacency", cqr.NewBooleanQuery("adjx", []cqr.CommonQueryRepresentation{cqr.NewKeyword("ok")})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := evaluatePostings(tt.query, "title", postings); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+	if _, err := evaluatePostings(cqr.NewKeyword("fail"), "title", postings); err != failed {
+		t.Errorf("expected the error of the postings, got %v", err)
+	}
+}
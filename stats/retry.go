@@ -0,0 +1,157 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrRateLimited is the kind of error of a request that was refused because too many requests were made.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrQuerySyntax is the kind of error of a request whose query could not be parsed.
+	ErrQuerySyntax = errors.New("query syntax error")
+	// ErrNetwork is the kind of error of a request that failed in transit or on the server.
+	ErrNetwork = errors.New("network failure")
+	// ErrEmptyResult is the kind of error of a request that was answered with an empty response.
+	ErrEmptyResult = errors.New("empty result")
+	// ErrRejected is the kind of error of any other request that was refused (e.g. with an invalid API key).
+	ErrRejected = errors.New("request rejected")
+)
+
+// EntrezError is an error of a request to the E-utilities. It matches its kind with errors.Is (e.g.
+// errors.Is(err, ErrRateLimited)), and wraps the error that caused it, if any.
+type EntrezError struct {
+	// Kind is one of ErrRateLimited, ErrQuerySyntax, ErrNetwork, ErrEmptyResult or ErrRejected.
+	Kind error
+	// Util is the E-utility the request was made to (e.g. esearch).
+	Util string
+	// StatusCode is the HTTP status of the response, if there was one.
+	StatusCode int
+	// Message is the error reported by the E-utility, if any.
+	Message string
+	// RetryAfter is how long the E-utility asked to wait before the request is made again.
+	RetryAfter time.Duration
+	// Attempts is the number of times the request was made, when the request failed (rather than its response
+	// reporting an error).
+	Attempts int
+	Err      error
+}
+
+func (e *EntrezError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Util)
+	b.WriteString(": ")
+	b.WriteString(e.Kind.Error())
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&b, " (%d %s)", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	if len(e.Message) > 0 {
+		b.WriteString(": ")
+		b.WriteString(e.Message)
+	}
+	if e.Err != nil {
+		b.WriteString(": ")
+		b.WriteString(e.Err.Error())
+	}
+	if e.Attempts > 1 {
+		fmt.Fprintf(&b, " after %d attempts", e.Attempts)
+	}
+	return b.String()
+}
+
+func (e *EntrezError) Is(target error) bool {
+	return e.Kind == target
+}
+
+func (e *EntrezError) Unwrap() error {
+	return e.Err
+}
+
+// transient reports whether a request that failed with the error may succeed if it is made again.
+func (e *EntrezError) transient() bool {
+	return e.Kind == ErrRateLimited || e.Kind == ErrNetwork
+}
+
+// RetryPolicy is how requests that fail with a transient error (rate limiting or a network failure) are made again.
+// Requests are retried with an exponential backoff: the delay before the first retry is Backoff, and it doubles with
+// each retry up to MaxBackoff. A server that asks for a longer delay (with Retry-After) is waited for instead.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a request is made, including the first. A request is always made once.
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	// Jitter is the fraction of each delay that is random, so that concurrent requests are not retried in lockstep.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is the retry policy of statistics sources that are not given one.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	Backoff:     time.Second,
+	MaxBackoff:  time.Minute,
+	Jitter:      0.5,
+}
+
+// delay is how long to wait before the retry of a request that has been attempted a number of times.
+func (p RetryPolicy) delay(attempts int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempts && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		j := float64(d) * p.Jitter
+		d = d - time.Duration(j) + time.Duration(rand.Float64()*j)
+	}
+	return d
+}
+
+// do makes a request until it succeeds, fails with an error that is not transient, runs out of attempts, or ctx is
+// done.
+func (p RetryPolicy) do(ctx context.Context, request func() error) error {
+	for attempts := 1; ; attempts++ {
+		err := request()
+		var ee *EntrezError
+		if !errors.As(err, &ee) {
+			return err
+		}
+		ee.Attempts = attempts
+		if !ee.transient() || attempts >= p.MaxAttempts {
+			return err
+		}
+
+		d := p.delay(attempts)
+		if ee.RetryAfter > d {
+			d = ee.RetryAfter
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// retryAfter parses a Retry-After header, which is either a number of seconds or a date.
+func retryAfter(header string, now time.Time) time.Duration {
+	if len(header) == 0 {
+		return 0
+	}
+	if s, err := strconv.Atoi(header); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,533 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/trecresults"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SnapshotVersion is the version of the snapshot files written by Snapshot.Write. Snapshots of later versions cannot
+// be read.
+const SnapshotVersion = 1
+
+// ErrNotCaptured is returned by a snapshot statistics source for a statistic that is not in its snapshot.
+var ErrNotCaptured = errors.New("statistic not captured")
+
+// Snapshot is a frozen copy of the collection statistics of a statistics source: the collection size, vocabulary
+// sizes, the statistics of terms, and the retrieval sizes of queries. A snapshot of the statistics used by an
+// experiment (see SnapshotRecorder) can be shipped with its results, so that the experiment can be reproduced once the
+// collection has changed. A snapshot is safe for concurrent use.
+type Snapshot struct {
+	mu         sync.Mutex
+	created    time.Time
+	source     string
+	options    SearchOptions
+	parameters map[string]float64
+
+	collectionSize *fixtureFloat
+	vocabulary     map[string]fixtureFloat
+	terms          map[TermField]*snapshotTerm
+	retrievalSizes map[string]snapshotQuery
+}
+
+type snapshotTerm struct {
+	Term                     string        `json:"term"`
+	Field                    string        `json:"field"`
+	DocumentFrequency        *fixtureFloat `json:"df,omitempty"`
+	TotalTermFrequency       *fixtureFloat `json:"cf,omitempty"`
+	InverseDocumentFrequency *fixtureFloat `json:"idf,omitempty"`
+}
+
+type snapshotQuery struct {
+	Query         json.RawMessage `json:"query"`
+	RetrievalSize fixtureFloat    `json:"retrieval_size"`
+}
+
+type snapshotFile struct {
+	Version         int                     `json:"version"`
+	Created         time.Time               `json:"created"`
+	Source          string                  `json:"source"`
+	SearchOptions   SearchOptions           `json:"search_options"`
+	Parameters      map[string]float64      `json:"parameters,omitempty"`
+	CollectionSize  *fixtureFloat           `json:"collection_size,omitempty"`
+	VocabularySizes map[string]fixtureFloat `json:"vocabulary_sizes,omitempty"`
+	Terms           []*snapshotTerm         `json:"terms"`
+	RetrievalSizes  []snapshotQuery         `json:"retrieval_sizes,omitempty"`
+}
+
+// NewSnapshot creates an empty snapshot of the statistics of a statistics source.
+func NewSnapshot(ss StatisticsSource) *Snapshot {
+	source := fmt.Sprintf("%T", ss)
+	if s, ok := ss.(IdentifiedStatisticsSource); ok {
+		source += "(" + s.Identity() + ")"
+	}
+	return &Snapshot{
+		created:        time.Now().UTC(),
+		source:         source,
+		options:        ss.SearchOptions(),
+		parameters:     ss.Parameters(),
+		vocabulary:     make(map[string]fixtureFloat),
+		terms:          make(map[TermField]*snapshotTerm),
+		retrievalSizes: make(map[string]snapshotQuery),
+	}
+}
+
+// ReadSnapshot reads a snapshot from a file written by Snapshot.Write.
+func ReadSnapshot(file string) (*Snapshot, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var sf snapshotFile
+	if err := json.Unmarshal(b, &sf); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if sf.Version < 1 || sf.Version > SnapshotVersion {
+		return nil, fmt.Errorf("%s: unsupported snapshot version %d", file, sf.Version)
+	}
+	s := &Snapshot{
+		created:        sf.Created,
+		source:         sf.Source,
+		options:        sf.SearchOptions,
+		parameters:     sf.Parameters,
+		collectionSize: sf.CollectionSize,
+		vocabulary:     sf.VocabularySizes,
+		terms:          make(map[TermField]*snapshotTerm, len(sf.Terms)),
+		retrievalSizes: make(map[string]snapshotQuery, len(sf.RetrievalSizes)),
+	}
+	if s.vocabulary == nil {
+		s.vocabulary = make(map[string]fixtureFloat)
+	}
+	for _, t := range sf.Terms {
+		s.terms[TermField{Term: t.Term, Field: t.Field}] = t
+	}
+	for _, q := range sf.RetrievalSizes {
+		var key bytes.Buffer
+		if err := json.Compact(&key, q.Query); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		q.Query = key.Bytes()
+		s.retrievalSizes[key.String()] = q
+	}
+	return s, nil
+}
+
+// Write writes the snapshot to a file. Terms and queries are sorted, so that snapshots of the same statistics are
+// identical (apart from when they were created).
+func (s *Snapshot) Write(file string) error {
+	s.mu.Lock()
+	sf := snapshotFile{
+		Version:         SnapshotVersion,
+		Created:         s.created,
+		Source:          s.source,
+		SearchOptions:   s.options,
+		Parameters:      s.parameters,
+		CollectionSize:  s.collectionSize,
+		VocabularySizes: s.vocabulary,
+		Terms:           make([]*snapshotTerm, 0, len(s.terms)),
+		RetrievalSizes:  make([]snapshotQuery, 0, len(s.retrievalSizes)),
+	}
+	for _, t := range s.terms {
+		sf.Terms = append(sf.Terms, t)
+	}
+	keys := make([]string, 0, len(s.retrievalSizes))
+	for key := range s.retrievalSizes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sf.RetrievalSizes = append(sf.RetrievalSizes, s.retrievalSizes[key])
+	}
+	s.mu.Unlock()
+
+	sort.Slice(sf.Terms, func(i, j int) bool {
+		if sf.Terms[i].Field != sf.Terms[j].Field {
+			return sf.Terms[i].Field < sf.Terms[j].Field
+		}
+		return sf.Terms[i].Term < sf.Terms[j].Term
+	})
+	b, err := json.MarshalIndent(sf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, b, 0644)
+}
+
+// Created is when the statistics of the snapshot were captured.
+func (s *Snapshot) Created() time.Time {
+	return s.created
+}
+
+// Source is the type (and identity) of the statistics source the snapshot was captured from.
+func (s *Snapshot) Source() string {
+	return s.source
+}
+
+// Len is the number of terms in the snapshot.
+func (s *Snapshot) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.terms)
+}
+
+// term is the entry of a term in the snapshot, which is added if add is true. The snapshot must be locked.
+func (s *Snapshot) term(term, field string, add bool) *snapshotTerm {
+	k := TermField{Term: term, Field: field}
+	t, ok := s.terms[k]
+	if !ok && add {
+		t = &snapshotTerm{Term: term, Field: field}
+		s.terms[k] = t
+	}
+	return t
+}
+
+// setTerm captures a statistic of a term.
+func (s *Snapshot) setTerm(term, field string, v float64, statistic func(t *snapshotTerm) **fixtureFloat) {
+	f := fixtureFloat(v)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*statistic(s.term(term, field, true)) = &f
+}
+
+// getTerm is a captured statistic of a term.
+func (s *Snapshot) getTerm(method, term, field string, statistic func(t *snapshotTerm) **fixtureFloat) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t := s.term(term, field, false); t != nil && *statistic(t) != nil {
+		return float64(**statistic(t)), nil
+	}
+	return 0, fmt.Errorf("%w: %s(%q, %q)", ErrNotCaptured, method, term, field)
+}
+
+func documentFrequency(t *snapshotTerm) **fixtureFloat {
+	return &t.DocumentFrequency
+}
+
+func totalTermFrequency(t *snapshotTerm) **fixtureFloat {
+	return &t.TotalTermFrequency
+}
+
+func inverseDocumentFrequency(t *snapshotTerm) **fixtureFloat {
+	return &t.InverseDocumentFrequency
+}
+
+// queryKey is the key of a query in the snapshot.
+func queryKey(query cqr.CommonQueryRepresentation) (json.RawMessage, error) {
+	return json.Marshal(query)
+}
+
+// SnapshotRecorder is a statistics source that captures the collection statistics requested from another statistics
+// source in a snapshot. Requests that fail are not captured, and the statistics of documents (term frequencies, term
+// vectors and retrieval) are not captured at all.
+type SnapshotRecorder struct {
+	source   StatisticsSource
+	snapshot *Snapshot
+}
+
+// NewSnapshotRecorder creates a statistics source that captures the collection statistics requested from ss in the
+// snapshot.
+func NewSnapshotRecorder(ss StatisticsSource, snapshot *Snapshot) SnapshotRecorder {
+	return SnapshotRecorder{source: ss, snapshot: snapshot}
+}
+
+// Snapshot is the snapshot the statistics are captured in.
+func (r SnapshotRecorder) Snapshot() *Snapshot {
+	return r.snapshot
+}
+
+// Capture requests (and so captures) the statistics that measurements of the queries commonly use: the collection
+// size, and the vocabulary size of each field, the statistics of each keyword in each of its fields, and the retrieval
+// size of each query.
+func (r SnapshotRecorder) Capture(queries []pipeline.Query) error {
+	if _, err := r.CollectionSize(); err != nil {
+		return err
+	}
+
+	var (
+		terms  []TermField
+		seen   = make(map[TermField]bool)
+		fields = make(map[string]bool)
+	)
+	for _, q := range queries {
+		for _, k := range keywords(q.Query) {
+			for _, field := range k.Fields {
+				t := TermField{Term: k.QueryString, Field: field}
+				if !seen[t] {
+					seen[t] = true
+					terms = append(terms, t)
+				}
+				if !fields[field] {
+					fields[field] = true
+					if _, err := r.VocabularySize(field); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	if _, err := r.DocumentFrequencies(terms); err != nil {
+		return err
+	}
+	if _, err := r.TotalTermFrequencies(terms); err != nil {
+		return err
+	}
+	if _, err := r.InverseDocumentFrequencies(terms); err != nil {
+		return err
+	}
+
+	sizes := make([]cqr.CommonQueryRepresentation, len(queries))
+	for i, q := range queries {
+		sizes[i] = q.Query
+	}
+	_, err := r.RetrievalSizes(sizes)
+	return err
+}
+
+// keywords are the keywords of a query.
+func keywords(query cqr.CommonQueryRepresentation) []cqr.Keyword {
+	switch q := query.(type) {
+	case cqr.Keyword:
+		return []cqr.Keyword{q}
+	case cqr.BooleanQuery:
+		var k []cqr.Keyword
+		for _, child := range q.Children {
+			k = append(k, keywords(child)...)
+		}
+		return k
+	}
+	return nil
+}
+
+// WithContext binds the recorded statistics source to ctx, if it supports cancellation.
+func (r SnapshotRecorder) WithContext(ctx context.Context) StatisticsSource {
+	r.source = WithContext(ctx, r.source)
+	return r
+}
+
+// Concurrency is the number of requests that may be made to the recorded statistics source at once.
+func (r SnapshotRecorder) Concurrency() int {
+	if s, ok := r.source.(ConcurrencyLimiter); ok {
+		return s.Concurrency()
+	}
+	return 0
+}
+
+// Identity is the identity of the recorded statistics source.
+func (r SnapshotRecorder) Identity() string {
+	if s, ok := r.source.(IdentifiedStatisticsSource); ok {
+		return s.Identity()
+	}
+	return ""
+}
+
+func (r SnapshotRecorder) SearchOptions() SearchOptions {
+	return r.source.SearchOptions()
+}
+
+func (r SnapshotRecorder) Parameters() map[string]float64 {
+	return r.source.Parameters()
+}
+
+func (r SnapshotRecorder) TermFrequency(term, field, document string) (float64, error) {
+	return r.source.TermFrequency(term, field, document)
+}
+
+func (r SnapshotRecorder) TermVector(document string) (TermVector, error) {
+	return r.source.TermVector(document)
+}
+
+func (r SnapshotRecorder) DocumentFrequency(term, field string) (float64, error) {
+	v, err := r.source.DocumentFrequency(term, field)
+	if err == nil {
+		r.snapshot.setTerm(term, field, v, documentFrequency)
+	}
+	return v, err
+}
+
+func (r SnapshotRecorder) TotalTermFrequency(term, field string) (float64, error) {
+	v, err := r.source.TotalTermFrequency(term, field)
+	if err == nil {
+		r.snapshot.setTerm(term, field, v, totalTermFrequency)
+	}
+	return v, err
+}
+
+func (r SnapshotRecorder) InverseDocumentFrequency(term, field string) (float64, error) {
+	v, err := r.source.InverseDocumentFrequency(term, field)
+	if err == nil {
+		r.snapshot.setTerm(term, field, v, inverseDocumentFrequency)
+	}
+	return v, err
+}
+
+func (r SnapshotRecorder) RetrievalSize(query cqr.CommonQueryRepresentation) (float64, error) {
+	v, err := r.source.RetrievalSize(query)
+	if err != nil {
+		return v, err
+	}
+	return v, r.captureRetrievalSize(query, v)
+}
+
+func (r SnapshotRecorder) captureRetrievalSize(query cqr.CommonQueryRepresentation, v float64) error {
+	key, err := queryKey(query)
+	if err != nil {
+		return err
+	}
+	r.snapshot.mu.Lock()
+	defer r.snapshot.mu.Unlock()
+	r.snapshot.retrievalSizes[string(key)] = snapshotQuery{Query: key, RetrievalSize: fixtureFloat(v)}
+	return nil
+}
+
+func (r SnapshotRecorder) VocabularySize(field string) (float64, error) {
+	v, err := r.source.VocabularySize(field)
+	if err == nil {
+		r.snapshot.mu.Lock()
+		r.snapshot.vocabulary[field] = fixtureFloat(v)
+		r.snapshot.mu.Unlock()
+	}
+	return v, err
+}
+
+func (r SnapshotRecorder) Execute(query pipeline.Query, options SearchOptions) (trecresults.ResultList, error) {
+	return r.source.Execute(query, options)
+}
+
+func (r SnapshotRecorder) CollectionSize() (float64, error) {
+	v, err := r.source.CollectionSize()
+	if err == nil {
+		f := fixtureFloat(v)
+		r.snapshot.mu.Lock()
+		r.snapshot.collectionSize = &f
+		r.snapshot.mu.Unlock()
+	}
+	return v, err
+}
+
+// batch captures a statistic of each term, requested in a batch from the recorded statistics source.
+func (r SnapshotRecorder) batch(terms []TermField, v []float64, err error, statistic func(t *snapshotTerm) **fixtureFloat) ([]float64, error) {
+	if err != nil {
+		return nil, err
+	}
+	for i, t := range terms {
+		r.snapshot.setTerm(t.Term, t.Field, v[i], statistic)
+	}
+	return v, nil
+}
+
+func (r SnapshotRecorder) DocumentFrequencies(terms []TermField) ([]float64, error) {
+	v, err := DocumentFrequencies(r.source, terms)
+	return r.batch(terms, v, err, documentFrequency)
+}
+
+func (r SnapshotRecorder) TotalTermFrequencies(terms []TermField) ([]float64, error) {
+	v, err := TotalTermFrequencies(r.source, terms)
+	return r.batch(terms, v, err, totalTermFrequency)
+}
+
+func (r SnapshotRecorder) InverseDocumentFrequencies(terms []TermField) ([]float64, error) {
+	v, err := InverseDocumentFrequencies(r.source, terms)
+	return r.batch(terms, v, err, inverseDocumentFrequency)
+}
+
+func (r SnapshotRecorder) RetrievalSizes(queries []cqr.CommonQueryRepresentation) ([]float64, error) {
+	v, err := RetrievalSizes(r.source, queries)
+	if err != nil {
+		return nil, err
+	}
+	for i, query := range queries {
+		if err := r.captureRetrievalSize(query, v[i]); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// SnapshotStatisticsSource is a statistics source that serves the collection statistics captured in a snapshot. A
+// statistic that was not captured, and any statistic of documents (term frequencies, term vectors and retrieval),
+// fails with ErrNotCaptured.
+type SnapshotStatisticsSource struct {
+	snapshot *Snapshot
+}
+
+// NewSnapshotStatisticsSource creates a statistics source that serves the statistics captured in the snapshot.
+func NewSnapshotStatisticsSource(snapshot *Snapshot) SnapshotStatisticsSource {
+	return SnapshotStatisticsSource{snapshot: snapshot}
+}
+
+// Identity is the statistics source the snapshot was captured from, and when.
+func (s SnapshotStatisticsSource) Identity() string {
+	return s.snapshot.source + "@" + s.snapshot.created.Format(time.RFC3339)
+}
+
+func (s SnapshotStatisticsSource) SearchOptions() SearchOptions {
+	return s.snapshot.options
+}
+
+func (s SnapshotStatisticsSource) Parameters() map[string]float64 {
+	return s.snapshot.parameters
+}
+
+func (s SnapshotStatisticsSource) TermFrequency(term, field, document string) (float64, error) {
+	return 0, fmt.Errorf("%w: term frequencies of documents are not captured", ErrNotCaptured)
+}
+
+func (s SnapshotStatisticsSource) TermVector(document string) (TermVector, error) {
+	return nil, fmt.Errorf("%w: term vectors are not captured", ErrNotCaptured)
+}
+
+func (s SnapshotStatisticsSource) DocumentFrequency(term, field string) (float64, error) {
+	return s.snapshot.getTerm("DocumentFrequency", term, field, documentFrequency)
+}
+
+func (s SnapshotStatisticsSource) TotalTermFrequency(term, field string) (float64, error) {
+	return s.snapshot.getTerm("TotalTermFrequency", term, field, totalTermFrequency)
+}
+
+func (s SnapshotStatisticsSource) InverseDocumentFrequency(term, field string) (float64, error) {
+	return s.snapshot.getTerm("InverseDocumentFrequency", term, field, inverseDocumentFrequency)
+}
+
+func (s SnapshotStatisticsSource) RetrievalSize(query cqr.CommonQueryRepresentation) (float64, error) {
+	key, err := queryKey(query)
+	if err != nil {
+		return 0, err
+	}
+	s.snapshot.mu.Lock()
+	defer s.snapshot.mu.Unlock()
+	if q, ok := s.snapshot.retrievalSizes[string(key)]; ok {
+		return float64(q.RetrievalSize), nil
+	}
+	return 0, fmt.Errorf("%w: RetrievalSize(%s)", ErrNotCaptured, key)
+}
+
+func (s SnapshotStatisticsSource) VocabularySize(field string) (float64, error) {
+	s.snapshot.mu.Lock()
+	defer s.snapshot.mu.Unlock()
+	if v, ok := s.snapshot.vocabulary[field]; ok {
+		return float64(v), nil
+	}
+	return 0, fmt.Errorf("%w: VocabularySize(%q)", ErrNotCaptured, field)
+}
+
+func (s SnapshotStatisticsSource) Execute(query pipeline.Query, options SearchOptions) (trecresults.ResultList, error) {
+	return nil, fmt.Errorf("%w: retrieval is not captured", ErrNotCaptured)
+}
+
+func (s SnapshotStatisticsSource) CollectionSize() (float64, error) {
+	s.snapshot.mu.Lock()
+	defer s.snapshot.mu.Unlock()
+	if s.snapshot.collectionSize == nil {
+		return 0, fmt.Errorf("%w: CollectionSize()", ErrNotCaptured)
+	}
+	return float64(*s.snapshot.collectionSize), nil
+}
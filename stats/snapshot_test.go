@@ -0,0 +1,80 @@
+package stats
+
+import (
+	"errors"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/pipeline"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := testIndex(t)
+	query := cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{
+		cqr.NewKeyword("diabet*", "title", "text"),
+		cqr.NewKeyword("retinopathy", "text"),
+	})
+	snapshot := NewSnapshot(s)
+	recorder := NewSnapshotRecorder(s, snapshot)
+	if err := recorder.Capture([]pipeline.Query{pipeline.NewQuery("test", "1", query)}); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Len() != 3 {
+		t.Errorf("expected 3 captured terms, got %d", snapshot.Len())
+	}
+	file := filepath.Join(dir, "snapshot.json")
+	if err := snapshot.Write(file); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ReadSnapshot(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := NewSnapshotStatisticsSource(f)
+	for _, r := range []struct {
+		name      string
+		statistic func(ss StatisticsSource) (float64, error)
+	}{
+		{"CollectionSize", func(ss StatisticsSource) (float64, error) { return ss.CollectionSize() }},
+		{"VocabularySize", func(ss StatisticsSource) (float64, error) { return ss.VocabularySize("text") }},
+		{"DocumentFrequency", func(ss StatisticsSource) (float64, error) { return ss.DocumentFrequency("diabet*", "title") }},
+		{"TotalTermFrequency", func(ss StatisticsSource) (float64, error) { return ss.TotalTermFrequency("retinopathy", "text") }},
+		{"InverseDocumentFrequency", func(ss StatisticsSource) (float64, error) { return ss.InverseDocumentFrequency("diabet*", "text") }},
+		{"RetrievalSize", func(ss StatisticsSource) (float64, error) { return ss.RetrievalSize(query) }},
+	} {
+		want, err := r.statistic(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.statistic(ss)
+		if err != nil {
+			t.Errorf("%s: %v", r.name, err)
+		} else if got != want {
+			t.Errorf("%s: expected %f, got %f", r.name, want, got)
+		}
+	}
+
+	if _, err := ss.DocumentFrequency("retinopathy", "title"); !errors.Is(err, ErrNotCaptured) {
+		t.Errorf("expected ErrNotCaptured for a term that was not captured, got %v", err)
+	}
+	if _, err := ss.TermVector("1"); !errors.Is(err, ErrNotCaptured) {
+		t.Errorf("expected ErrNotCaptured for a term vector, got %v", err)
+	}
+
+	future := filepath.Join(dir, "future.json")
+	if err := ioutil.WriteFile(future, []byte(`{"version": 2}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadSnapshot(future); err == nil {
+		t.Error("expected an error for an unsupported snapshot version")
+	}
+}
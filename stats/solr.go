@@ -0,0 +1,650 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hscells/cqr"
+	gpipeline "github.com/hscells/groove/pipeline"
+	"github.com/hscells/trecresults"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// solrPageSize is the number of documents retrieved in each page of a search.
+const solrPageSize = 1000
+
+// SolrStatisticsSource is a way of gathering statistics for a collection using Solr. Statistics are computed with
+// function queries (e.g. docfreq and totaltermfreq) and the term vector component, and queries are searched with the
+// standard Lucene query parser.
+//
+// Terms given to the statistics methods are not analysed, so they must be in the form they are indexed in.
+type SolrStatisticsSource struct {
+	client *http.Client
+	url    string
+	core   string
+	// field is the field that keywords without fields are searched in.
+	field string
+	// idField is the unique key of the documents in the core.
+	idField string
+
+	options    SearchOptions
+	parameters map[string]float64
+
+	Analyser     string
+	AnalyseField string
+
+	ctx         context.Context
+	concurrency int
+}
+
+// SolrError is an error reported by Solr in response to a request.
+type SolrError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *SolrError) Error() string {
+	return fmt.Sprintf("solr: %s (%d %s)", e.Message, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// WithContext returns a copy of the statistics source whose requests to Solr are bound to ctx.
+func (s *SolrStatisticsSource) WithContext(ctx context.Context) StatisticsSource {
+	c := *s
+	c.ctx = ctx
+	return &c
+}
+
+// context is the context requests to Solr are made with.
+func (s *SolrStatisticsSource) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// request makes a request to a handler of the core (e.g. select), and decodes the response into v. Parameters are
+// sent in the body of the request, so that long queries are not limited by the length of a URL.
+func (s *SolrStatisticsSource) request(handler string, params url.Values, v interface{}) error {
+	params.Set("wt", "json")
+	u := fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(s.url, "/"), url.PathEscape(s.core), handler)
+	req, err := http.NewRequestWithContext(s.context(), http.MethodPost, u, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error struct {
+				Msg string `json:"msg"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(body, &e) != nil || len(e.Error.Msg) == 0 {
+			e.Error.Msg = strings.TrimSpace(string(body))
+		}
+		return &SolrError{StatusCode: resp.StatusCode, Message: e.Error.Msg}
+	}
+	return json.Unmarshal(body, v)
+}
+
+// solrSelectResponse is the response of a search.
+type solrSelectResponse struct {
+	Response struct {
+		NumFound float64                  `json:"numFound"`
+		Docs     []map[string]interface{} `json:"docs"`
+	} `json:"response"`
+	NextCursorMark string `json:"nextCursorMark"`
+}
+
+// functions evaluates function queries on the first document q retrieves, in a single request. Each function is
+// zero if q retrieves no documents.
+func (s *SolrStatisticsSource) functions(q string, functions []string) ([]float64, error) {
+	v := make([]float64, len(functions))
+	if len(functions) == 0 {
+		return v, nil
+	}
+	fl := make([]string, len(functions))
+	for i, f := range functions {
+		fl[i] = fmt.Sprintf("f%d:%s", i, f)
+	}
+
+	var resp solrSelectResponse
+	err := s.request("select", url.Values{
+		"q":    {q},
+		"fl":   {strings.Join(fl, ",")},
+		"rows": {"1"},
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Response.Docs) == 0 {
+		return v, nil
+	}
+	for i := range functions {
+		if f, ok := resp.Response.Docs[0][fmt.Sprintf("f%d", i)].(float64); ok {
+			v[i] = f
+		}
+	}
+	return v, nil
+}
+
+// termFunction is a function query (e.g. docfreq) of a term in a field.
+func termFunction(function string, t TermField) string {
+	term := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(t.Term)
+	return fmt.Sprintf("%s(%s,'%s')", function, t.Field, term)
+}
+
+// termFunctions evaluates a function query for each term, in a single request.
+func (s *SolrStatisticsSource) termFunctions(function string, terms []TermField) ([]float64, error) {
+	f := make([]string, len(terms))
+	for i, t := range terms {
+		f[i] = termFunction(function, t)
+	}
+	return s.functions("*:*", f)
+}
+
+// SearchOptions gets the immutable execute options for the statistics source.
+func (s *SolrStatisticsSource) SearchOptions() SearchOptions {
+	return s.options
+}
+
+// Parameters gets the immutable parameters for the statistics source.
+func (s *SolrStatisticsSource) Parameters() map[string]float64 {
+	return s.parameters
+}
+
+// TermFrequency is the term frequency in the field.
+func (s *SolrStatisticsSource) TermFrequency(term, field, document string) (float64, error) {
+	v, err := s.functions(s.idField+":"+escapeLucene(document, false), []string{termFunction("termfreq", TermField{Term: term, Field: field})})
+	if err != nil {
+		return 0, err
+	}
+	return v[0], nil
+}
+
+// TermVector retrieves the term vector for a document, using the term vector component (the tvrh handler).
+func (s *SolrStatisticsSource) TermVector(document string) (TermVector, error) {
+	var resp struct {
+		TermVectors map[string]json.RawMessage `json:"termVectors"`
+	}
+	err := s.request("tvrh", url.Values{
+		"q":       {s.idField + ":" + escapeLucene(document, false)},
+		"fl":      {s.idField},
+		"tv":      {"true"},
+		"tv.tf":   {"true"},
+		"tv.df":   {"true"},
+		"json.nl": {"map"},
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var tv TermVector
+	doc, ok := resp.TermVectors[document]
+	if !ok {
+		return tv, nil
+	}
+	var vectors map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &vectors); err != nil {
+		return nil, err
+	}
+	var terms []TermField
+	for field, vector := range vectors {
+		if field == "uniqueKey" {
+			continue
+		}
+		var stats map[string]struct {
+			TF float64 `json:"tf"`
+			DF float64 `json:"df"`
+		}
+		if err := json.Unmarshal(vector, &stats); err != nil {
+			return nil, err
+		}
+		for term, stat := range stats {
+			tv = append(tv, TermVectorTerm{
+				Term:              term,
+				Field:             field,
+				DocumentFrequency: stat.DF,
+				TermFrequency:     stat.TF,
+			})
+			terms = append(terms, TermField{Term: term, Field: field})
+		}
+	}
+
+	// The term vector component does not compute total term frequencies.
+	ttf, err := s.TotalTermFrequencies(terms)
+	if err != nil {
+		return nil, err
+	}
+	for i := range tv {
+		tv[i].TotalTermFrequency = ttf[i]
+	}
+	return tv, nil
+}
+
+// DocumentFrequency is the document frequency (the number of documents containing the current term).
+func (s *SolrStatisticsSource) DocumentFrequency(term, field string) (float64, error) {
+	v, err := s.DocumentFrequencies([]TermField{{Term: term, Field: field}})
+	if err != nil {
+		return 0, err
+	}
+	return v[0], nil
+}
+
+// TotalTermFrequency is a sum of total term frequencies (the sum of total term frequencies of each term in this field).
+func (s *SolrStatisticsSource) TotalTermFrequency(term, field string) (float64, error) {
+	v, err := s.TotalTermFrequencies([]TermField{{Term: term, Field: field}})
+	if err != nil {
+		return 0, err
+	}
+	return v[0], nil
+}
+
+// InverseDocumentFrequency is the ratio of of documents in the collection to the number of documents the term appears
+// in, logarithmically smoothed.
+func (s *SolrStatisticsSource) InverseDocumentFrequency(term, field string) (float64, error) {
+	v, err := s.InverseDocumentFrequencies([]TermField{{Term: term, Field: field}})
+	if err != nil {
+		return 0, err
+	}
+	return v[0], nil
+}
+
+// RetrievalSize is the minimum number of documents that contains at least one of the query terms.
+func (s *SolrStatisticsSource) RetrievalSize(query cqr.CommonQueryRepresentation) (float64, error) {
+	q, err := s.lucene(query)
+	if err != nil {
+		return 0, err
+	}
+	var resp solrSelectResponse
+	err = s.request("select", url.Values{"q": {q}, "defType": {"lucene"}, "rows": {"0"}}, &resp)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Response.NumFound, nil
+}
+
+// VocabularySize is the total number of terms in the vocabulary.
+func (s *SolrStatisticsSource) VocabularySize(field string) (float64, error) {
+	v, err := s.functions("*:*", []string{fmt.Sprintf("sumtotaltermfreq(%s)", field)})
+	if err != nil {
+		return 0, err
+	}
+	return v[0], nil
+}
+
+// Execute runs the query on Solr and returns results in trec format. Results are paged through with a cursor, so any
+// number of results (or, if the size of the search options is zero, all of them) can be retrieved.
+func (s *SolrStatisticsSource) Execute(query gpipeline.Query, options SearchOptions) (trecresults.ResultList, error) {
+	q, err := s.lucene(query.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := solrPageSize
+	if options.Size > 0 && options.Size < rows {
+		rows = options.Size
+	}
+	var results trecresults.ResultList
+	for cursor := "*"; ; {
+		var resp solrSelectResponse
+		err := s.request("select", url.Values{
+			"q":          {q},
+			"defType":    {"lucene"},
+			"fl":         {s.idField + ",score"},
+			"rows":       {strconv.Itoa(rows)},
+			"sort":       {"score desc," + s.idField + " asc"},
+			"cursorMark": {cursor},
+		}, &resp)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range resp.Response.Docs {
+			if options.Size > 0 && len(results) >= options.Size {
+				break
+			}
+			score, _ := doc["score"].(float64)
+			results = append(results, &trecresults.Result{
+				Topic:     query.Topic,
+				Iteration: "Q0",
+				DocId:     fmt.Sprint(doc[s.idField]),
+				Rank:      int64(len(results)),
+				Score:     score,
+				RunName:   options.RunName,
+			})
+		}
+		// The cursor does not change once every result has been retrieved.
+		if (options.Size > 0 && len(results) >= options.Size) || len(resp.Response.Docs) == 0 || resp.NextCursorMark == cursor {
+			break
+		}
+		cursor = resp.NextCursorMark
+	}
+	return results, nil
+}
+
+// CollectionSize is the number of documents in the core.
+func (s *SolrStatisticsSource) CollectionSize() (float64, error) {
+	var resp solrSelectResponse
+	err := s.request("select", url.Values{"q": {"*:*"}, "rows": {"0"}}, &resp)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Response.NumFound, nil
+}
+
+// DocumentFrequencies are the document frequencies of the terms, requested in a single request.
+func (s *SolrStatisticsSource) DocumentFrequencies(terms []TermField) ([]float64, error) {
+	return s.termFunctions("docfreq", terms)
+}
+
+// TotalTermFrequencies are the total term frequencies of the terms, requested in a single request.
+func (s *SolrStatisticsSource) TotalTermFrequencies(terms []TermField) ([]float64, error) {
+	return s.termFunctions("totaltermfreq", terms)
+}
+
+// InverseDocumentFrequencies are the inverse document frequencies of the terms, requested in a single request.
+func (s *SolrStatisticsSource) InverseDocumentFrequencies(terms []TermField) ([]float64, error) {
+	N, err := s.CollectionSize()
+	if err != nil {
+		return nil, err
+	}
+	v, err := s.DocumentFrequencies(terms)
+	if err != nil {
+		return nil, err
+	}
+	for i, nt := range v {
+		if nt > 0 {
+			v[i] = idf(N, nt)
+		}
+	}
+	return v, nil
+}
+
+// RetrievalSizes are the number of documents each query retrieves. Solr cannot make several searches in one request,
+// so the searches are made concurrently.
+func (s *SolrStatisticsSource) RetrievalSizes(queries []cqr.CommonQueryRepresentation) ([]float64, error) {
+	return concurrently(len(queries), s.concurrency, func(i int) (float64, error) {
+		return s.RetrievalSize(queries[i])
+	})
+}
+
+// Analyse runs the analyser of a field type on text, using the field analysis handler. If analyser is empty, text is
+// analysed the way the default field is.
+func (s *SolrStatisticsSource) Analyse(text, analyser string) ([]string, error) {
+	params := url.Values{"analysis.fieldvalue": {text}}
+	if len(analyser) > 0 {
+		params.Set("analysis.fieldtype", analyser)
+	} else {
+		params.Set("analysis.fieldname", s.field)
+	}
+	// The stages of the analysis are a flat list of the name of each stage, followed by the tokens it produced.
+	var resp struct {
+		Analysis struct {
+			FieldTypes map[string]struct {
+				Index []json.RawMessage `json:"index"`
+			} `json:"field_types"`
+			FieldNames map[string]struct {
+				Index []json.RawMessage `json:"index"`
+			} `json:"field_names"`
+		} `json:"analysis"`
+	}
+	if err := s.request("analysis/field", params, &resp); err != nil {
+		return nil, err
+	}
+
+	stages := resp.Analysis.FieldTypes[analyser].Index
+	if len(analyser) == 0 {
+		stages = resp.Analysis.FieldNames[s.field].Index
+	}
+	if len(stages) == 0 {
+		return nil, nil
+	}
+	var tokens []struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(stages[len(stages)-1], &tokens); err != nil {
+		return nil, err
+	}
+	t := make([]string, len(tokens))
+	for i, token := range tokens {
+		t[i] = token.Text
+	}
+	return t, nil
+}
+
+// Analysis is the analyser queries are analysed with, and the field that analysed text is searched in.
+func (s *SolrStatisticsSource) Analysis() (analyser, field string) {
+	return s.Analyser, s.AnalyseField
+}
+
+// lucene translates a query into the syntax of the standard Lucene query parser. Keywords without fields are searched
+// in the default field of the statistics source. Adjacency operators become sloppy phrases, and so may only contain
+// keywords that are not truncated. Exploded MeSH headings are not expanded.
+func (s *SolrStatisticsSource) lucene(query cqr.CommonQueryRepresentation) (string, error) {
+	switch q := query.(type) {
+	case cqr.Keyword:
+		term, err := luceneTerm(q)
+		if err != nil {
+			return "", err
+		}
+		return s.fielded(q.Fields, term), nil
+	case cqr.BooleanQuery:
+		if len(q.Children) == 0 {
+			return "", fmt.Errorf("the %q query has no children", q.Operator)
+		}
+		distance, ok, err := adjacency(q.Operator)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return s.phrase(q.Children, distance)
+		}
+
+		children := make([]string, len(q.Children))
+		for i, child := range q.Children {
+			children[i], err = s.lucene(child)
+			if err != nil {
+				return "", err
+			}
+		}
+		switch strings.ToLower(q.Operator) {
+		case cqr.AND:
+			return "(" + strings.Join(children, " AND ") + ")", nil
+		case cqr.OR:
+			return "(" + strings.Join(children, " OR ") + ")", nil
+		case cqr.NOT:
+			if len(children) == 1 {
+				return children[0], nil
+			}
+			return "(" + children[0] + " AND NOT " + strings.Join(children[1:], " AND NOT ") + ")", nil
+		}
+		return "", fmt.Errorf("unsupported operator %q", q.Operator)
+	}
+	return "", fmt.Errorf("unsupported query %T", query)
+}
+
+// fielded searches for a term in any of the fields.
+func (s *SolrStatisticsSource) fielded(fields []string, term string) string {
+	if len(fields) == 0 {
+		fields = []string{s.field}
+	}
+	clauses := make([]string, len(fields))
+	for i, field := range fields {
+		clauses[i] = field + ":" + term
+	}
+	if len(clauses) == 1 {
+		return clauses[0]
+	}
+	return "(" + strings.Join(clauses, " OR ") + ")"
+}
+
+// phrase translates an adjacency of keywords, which must be searched in the same fields, into a sloppy phrase.
+func (s *SolrStatisticsSource) phrase(children []cqr.CommonQueryRepresentation, distance int) (string, error) {
+	var (
+		words  []string
+		fields []string
+	)
+	for i, child := range children {
+		k, ok := child.(cqr.Keyword)
+		if !ok {
+			return "", fmt.Errorf("only keywords can be used within an adjacency")
+		}
+		if truncated, _ := k.GetOption(cqr.TruncatedString).(bool); truncated || strings.ContainsAny(k.QueryString, "*?") {
+			return "", fmt.Errorf("the truncated keyword %q cannot be used within an adjacency", k.QueryString)
+		}
+		if i == 0 {
+			fields = k.Fields
+		} else if strings.Join(k.Fields, ",") != strings.Join(fields, ",") {
+			return "", fmt.Errorf("the keywords of an adjacency must be searched in the same fields")
+		}
+		words = append(words, strings.Fields(k.QueryString)...)
+	}
+	return s.fielded(fields, fmt.Sprintf(`"%s"~%d`, escapePhrase(strings.Join(words, " ")), distance-1)), nil
+}
+
+// luceneTerm is the query string of a keyword, as a (possibly wildcard) term or a phrase.
+func luceneTerm(k cqr.Keyword) (string, error) {
+	query := strings.TrimSpace(k.QueryString)
+	if len(query) == 0 {
+		return "", fmt.Errorf("the keyword has no query string")
+	}
+	if truncated, _ := k.GetOption(cqr.TruncatedString).(bool); truncated && !strings.ContainsAny(query, "*?") {
+		query += "*"
+	}
+	if strings.ContainsAny(query, " \t\n") {
+		if strings.ContainsAny(query, "*?") {
+			return "", fmt.Errorf("the truncated phrase %q cannot be searched", query)
+		}
+		return `"` + escapePhrase(query) + `"`, nil
+	}
+	return escapeLucene(query, true), nil
+}
+
+// escapeLucene escapes the characters of a term that are special to the Lucene query parser. The wildcards * and ?
+// are not escaped when wildcards is true.
+func escapeLucene(term string, wildcards bool) string {
+	var b strings.Builder
+	for _, r := range term {
+		if strings.ContainsRune(`\+-!():^[]"{}~*?|&/`, r) && !(wildcards && (r == '*' || r == '?')) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// escapePhrase escapes the characters of a phrase that are special within quotes.
+func escapePhrase(phrase string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(phrase)
+}
+
+// SolrURL sets the URL of Solr. By default, this is http://localhost:8983/solr.
+func SolrURL(u string) func(*SolrStatisticsSource) {
+	return func(s *SolrStatisticsSource) {
+		s.url = u
+	}
+}
+
+// SolrClient sets the HTTP client requests to Solr are made with.
+func SolrClient(client *http.Client) func(*SolrStatisticsSource) {
+	return func(s *SolrStatisticsSource) {
+		s.client = client
+	}
+}
+
+// SolrCore sets the core (or collection) that statistics are computed for.
+func SolrCore(core string) func(*SolrStatisticsSource) {
+	return func(s *SolrStatisticsSource) {
+		s.core = core
+	}
+}
+
+// SolrField sets the field that keywords without fields are searched in. By default, this is text.
+func SolrField(field string) func(*SolrStatisticsSource) {
+	return func(s *SolrStatisticsSource) {
+		s.field = field
+	}
+}
+
+// SolrIDField sets the unique key of the documents in the core. By default, this is id.
+func SolrIDField(field string) func(*SolrStatisticsSource) {
+	return func(s *SolrStatisticsSource) {
+		s.idField = field
+	}
+}
+
+// SolrSearchOptions sets the execute options for the statistic source.
+func SolrSearchOptions(options SearchOptions) func(*SolrStatisticsSource) {
+	return func(s *SolrStatisticsSource) {
+		s.options = options
+	}
+}
+
+// SolrParameters sets the parameters for the statistic source.
+func SolrParameters(params map[string]float64) func(*SolrStatisticsSource) {
+	return func(s *SolrStatisticsSource) {
+		s.parameters = params
+	}
+}
+
+// SolrAnalyser sets the analyser (the name of a field type) for the statistic source.
+func SolrAnalyser(analyser string) func(*SolrStatisticsSource) {
+	return func(s *SolrStatisticsSource) {
+		s.Analyser = analyser
+	}
+}
+
+// SolrAnalysedField sets the analysed field for the statistic source.
+func SolrAnalysedField(field string) func(*SolrStatisticsSource) {
+	return func(s *SolrStatisticsSource) {
+		s.AnalyseField = field
+	}
+}
+
+// SolrConcurrency sets the number of requests that may be made to Solr at once. By default there is no limit.
+func SolrConcurrency(n int) func(*SolrStatisticsSource) {
+	return func(s *SolrStatisticsSource) {
+		s.concurrency = n
+	}
+}
+
+// Concurrency is the number of requests that may be made to Solr at once, or zero for no limit.
+func (s *SolrStatisticsSource) Concurrency() int {
+	return s.concurrency
+}
+
+// Identity is the Solr core that is searched, and how it is analysed.
+func (s *SolrStatisticsSource) Identity() string {
+	return strings.Join([]string{s.url, s.core, s.field, s.Analyser, s.AnalyseField}, "/")
+}
+
+// NewSolrStatisticsSource creates a new SolrStatisticsSource using functional options. A core must be given.
+func NewSolrStatisticsSource(options ...func(*SolrStatisticsSource)) (*SolrStatisticsSource, error) {
+	s := &SolrStatisticsSource{
+		client:  http.DefaultClient,
+		url:     "http://localhost:8983/solr",
+		field:   "text",
+		idField: "id",
+	}
+	for _, option := range options {
+		option(s)
+	}
+	if len(s.core) == 0 {
+		return nil, fmt.Errorf("solr: a core is required")
+	}
+	return s, nil
+}
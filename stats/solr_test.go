@@ -0,0 +1,247 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hscells/cqr"
+	gpipeline "github.com/hscells/groove/pipeline"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// solrFunction matches the function queries of a field list (e.g. f0:docfreq(title,'diabetes')).
+var solrFunction = regexp.MustCompile(`(f\d+):(\w+)\((\w+)(?:,'((?:[^'\\]|\\.)*)')?\)`)
+
+// solrStub is a Solr core of ten documents, whose term statistics are given by the length of the terms.
+func solrStub(t *testing.T, requests *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.FormValue("wt") != "json" {
+			t.Errorf("expected a form with wt=json, got %v", r.Form)
+		}
+		*requests = append(*requests, r.URL.Path)
+		reply := func(v interface{}) {
+			json.NewEncoder(w).Encode(v)
+		}
+		switch r.URL.Path {
+		case "/solr/pubmed/select":
+			q := r.FormValue("q")
+			if strings.Contains(q, "invalid") {
+				w.WriteHeader(http.StatusBadRequest)
+				reply(map[string]interface{}{"error": map[string]interface{}{"msg": "org.apache.solr.search.SyntaxError: Cannot parse", "code": 400}})
+				return
+			}
+			if fl := r.FormValue("fl"); strings.Contains(fl, "(") {
+				doc := make(map[string]interface{})
+				for _, m := range solrFunction.FindAllStringSubmatch(fl, -1) {
+					m[4] = strings.Replace(m[4], `\'`, "'", -1)
+					switch m[2] {
+					case "docfreq":
+						doc[m[1]] = len(m[4])
+					case "totaltermfreq":
+						doc[m[1]] = 2 * len(m[4])
+					case "termfreq":
+						doc[m[1]] = 1
+					case "sumtotaltermfreq":
+						doc[m[1]] = 100
+					}
+				}
+				reply(map[string]interface{}{"response": map[string]interface{}{"numFound": 10, "docs": []interface{}{doc}}})
+				return
+			}
+			if q == "*:*" {
+				reply(map[string]interface{}{"response": map[string]interface{}{"numFound": 10, "docs": []interface{}{}}})
+				return
+			}
+			cursor := r.FormValue("cursorMark")
+			if len(cursor) == 0 {
+				reply(map[string]interface{}{"response": map[string]interface{}{"numFound": len(q), "docs": []interface{}{}}})
+				return
+			}
+			from := 0
+			if cursor != "*" {
+				from, _ = strconv.Atoi(cursor)
+			}
+			rows, _ := strconv.Atoi(r.FormValue("rows"))
+			var docs []interface{}
+			for i := from; i < from+rows && i < 10; i++ {
+				docs = append(docs, map[string]interface{}{"id": fmt.Sprintf("doc%d", i), "score": 10 - float64(i)})
+			}
+			next := strconv.Itoa(from + len(docs))
+			reply(map[string]interface{}{"response": map[string]interface{}{"numFound": 10, "docs": docs}, "nextCursorMark": next})
+		case "/solr/pubmed/tvrh":
+			if r.FormValue("json.nl") != "map" {
+				t.Errorf("expected json.nl=map, got %q", r.FormValue("json.nl"))
+			}
+			reply(map[string]interface{}{"termVectors": map[string]interface{}{
+				"uniqueKeyFieldName": "id",
+				"doc1": map[string]interface{}{
+					"uniqueKey": "doc1",
+					"title":     map[string]interface{}{"diabetes": map[string]int{"tf": 2, "df": 8}},
+				},
+			}})
+		case "/solr/pubmed/analysis/field":
+			var tokens, filtered []interface{}
+			for _, token := range strings.Fields(r.FormValue("analysis.fieldvalue")) {
+				tokens = append(tokens, map[string]string{"text": token})
+				filtered = append(filtered, map[string]string{"text": strings.ToLower(token)})
+			}
+			reply(map[string]interface{}{"analysis": map[string]interface{}{"field_types": map[string]interface{}{
+				r.FormValue("analysis.fieldtype"): map[string]interface{}{
+					"index": []interface{}{"org.apache.lucene.analysis.standard.StandardTokenizer", tokens, "org.apache.lucene.analysis.core.LowerCaseFilter", filtered},
+				},
+			}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestSolrStatisticsSource(t *testing.T) {
+	var requests []string
+	ts := solrStub(t, &requests)
+	defer ts.Close()
+	s, err := NewSolrStatisticsSource(SolrURL(ts.URL+"/solr"), SolrCore("pubmed"), SolrField("title"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	df, err := DocumentFrequencies(s, []TermField{{"cat", "title"}, {"o'brien", "text"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(df, []float64{3, 7}) || len(requests) != 1 {
+		t.Errorf("expected the document frequencies [3 7] in 1 request, got %v in %d", df, len(requests))
+	}
+	ttf, err := s.TotalTermFrequency("diabetes", "title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttf != 16 {
+		t.Errorf("expected a total term frequency of 16, got %f", ttf)
+	}
+	n, err := s.CollectionSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.Errorf("expected a collection size of 10, got %f", n)
+	}
+	v, err := s.VocabularySize("title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 100 {
+		t.Errorf("expected a vocabulary size of 100, got %f", v)
+	}
+	size, err := s.RetrievalSize(cqr.NewKeyword("diabetes", "title"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != float64(len("title:diabetes")) {
+		t.Errorf("expected a retrieval size of %d, got %f", len("title:diabetes"), size)
+	}
+
+	tv, err := s.TermVector("doc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := TermVector{{Term: "diabetes", Field: "title", DocumentFrequency: 8, TermFrequency: 2, TotalTermFrequency: 16}}
+	if !reflect.DeepEqual(tv, want) {
+		t.Errorf("expected the term vector %v, got %v", want, tv)
+	}
+
+	tokens, err := s.Analyse("Diabetes Mellitus", "text_en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tokens, []string{"diabetes", "mellitus"}) {
+		t.Errorf("unexpected tokens %v", tokens)
+	}
+
+	_, err = s.RetrievalSize(cqr.NewKeyword("invalid", "title"))
+	if e, ok := err.(*SolrError); !ok || e.StatusCode != http.StatusBadRequest || !strings.Contains(e.Message, "SyntaxError") {
+		t.Errorf("expected a syntax error, got %v", err)
+	}
+}
+
+func TestSolrStatisticsSource_Execute(t *testing.T) {
+	var requests []string
+	ts := solrStub(t, &requests)
+	defer ts.Close()
+	s, err := NewSolrStatisticsSource(SolrURL(ts.URL+"/solr"), SolrCore("pubmed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := gpipeline.NewQuery("q", "1", cqr.NewKeyword("diabetes", "title"))
+	for _, c := range []struct {
+		size, results, requests int
+	}{
+		{0, 10, 2},
+		{4, 4, 1},
+		{1000, 10, 2},
+	} {
+		requests = nil
+		results, err := s.Execute(query, SearchOptions{Size: c.size, RunName: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != c.results || len(requests) != c.requests {
+			t.Errorf("size %d: expected %d results in %d requests, got %d in %d", c.size, c.results, c.requests, len(results), len(requests))
+			continue
+		}
+		if r := results[len(results)-1]; r.DocId != fmt.Sprintf("doc%d", c.results-1) || r.Rank != int64(c.results-1) || r.Score != float64(11-c.results) {
+			t.Errorf("size %d: unexpected last result %+v", c.size, r)
+		}
+	}
+
+	if _, err := NewSolrStatisticsSource(); err == nil {
+		t.Error("expected an error for a statistics source without a core")
+	}
+}
+
+func TestSolrStatisticsSource_Lucene(t *testing.T) {
+	s, err := NewSolrStatisticsSource(SolrCore("pubmed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		query cqr.CommonQueryRepresentation
+		want  string
+	}{
+		{cqr.NewKeyword("diabetes"), "text:diabetes"},
+		{cqr.NewKeyword("diabet*", "title"), "title:diabet*"},
+		{cqr.NewKeyword("diabet", "title").SetOption(cqr.TruncatedString, true), "title:diabet*"},
+		{cqr.NewKeyword("type 2 diabetes", "title", "text"), `(title:"type 2 diabetes" OR text:"type 2 diabetes")`},
+		{cqr.NewKeyword("covid-19", "title"), `title:covid\-19`},
+		{cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{
+			cqr.NewKeyword("diabetes", "title"),
+			cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{cqr.NewKeyword("cat", "title"), cqr.NewKeyword("dog", "title")}),
+		}), "(title:diabetes AND (title:cat OR title:dog))"},
+		{cqr.NewBooleanQuery(cqr.NOT, []cqr.CommonQueryRepresentation{
+			cqr.NewKeyword("humans", "mesh_headings"), cqr.NewKeyword("animals", "mesh_headings"), cqr.NewKeyword("mice", "mesh_headings"),
+		}), "(mesh_headings:humans AND NOT mesh_headings:animals AND NOT mesh_headings:mice)"},
+		{cqr.NewBooleanQuery("adj3", []cqr.CommonQueryRepresentation{cqr.NewKeyword("blood", "title"), cqr.NewKeyword("glucose", "title")}), `title:"blood glucose"~2`},
+		{cqr.NewBooleanQuery("adj3", []cqr.CommonQueryRepresentation{cqr.NewKeyword("blood", "title"), cqr.NewKeyword("gluc*", "title")}), ""},
+		{cqr.NewBooleanQuery("adj3", []cqr.CommonQueryRepresentation{cqr.NewKeyword("blood", "title"), cqr.NewKeyword("glucose", "text")}), ""},
+		{cqr.NewBooleanQuery("near", []cqr.CommonQueryRepresentation{cqr.NewKeyword("blood", "title")}), ""},
+	} {
+		got, err := s.lucene(c.query)
+		if len(c.want) == 0 {
+			if err == nil {
+				t.Errorf("%v: expected an error, got %q", c.query, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", c.query, err)
+		} else if got != c.want {
+			t.Errorf("%v: expected %q, got %q", c.query, c.want, got)
+		}
+	}
+}
@@ -2,6 +2,7 @@
 package stats
 
 import (
+	"context"
 	"errors"
 	"github.com/hscells/cqr"
 	"github.com/hscells/groove/pipeline"
@@ -48,6 +49,50 @@ type StatisticsSource interface {
 	CollectionSize() (float64, error)
 }
 
+// ContextStatisticsSource is a statistics source whose requests can be bound to a context. Once the context is done,
+// any in-flight or subsequent requests made by the bound source return an error.
+type ContextStatisticsSource interface {
+	StatisticsSource
+	// WithContext returns a copy of the statistics source whose requests are bound to ctx.
+	WithContext(ctx context.Context) StatisticsSource
+}
+
+// WithContext binds a statistics source to ctx if it supports cancellation, otherwise the source is returned as is.
+func WithContext(ctx context.Context, ss StatisticsSource) StatisticsSource {
+	if s, ok := ss.(ContextStatisticsSource); ok {
+		return s.WithContext(ctx)
+	}
+	return ss
+}
+
+// ConcurrencyLimiter is a statistics source that limits how many requests may be made to it at once, for instance to
+// respect the rate limits of a remote service.
+type ConcurrencyLimiter interface {
+	StatisticsSource
+	// Concurrency is the maximum number of requests that may be made at once, or zero for no limit.
+	Concurrency() int
+}
+
+// Concurrency limits n concurrent workers to the number of requests the statistics source allows at once.
+func Concurrency(ss StatisticsSource, n int) int {
+	if s, ok := ss.(ConcurrencyLimiter); ok {
+		if limit := s.Concurrency(); limit > 0 && limit < n {
+			return limit
+		}
+	}
+	return n
+}
+
+// AnalysingStatisticsSource is a statistics source that can analyse text the way its collection is analysed, such as
+// Elasticsearch (both ElasticsearchStatisticsSource and Elasticsearch7StatisticsSource).
+type AnalysingStatisticsSource interface {
+	StatisticsSource
+	// Analyse runs an analyser on text and returns the tokens it produces.
+	Analyse(text, analyser string) ([]string, error)
+	// Analysis is the analyser queries are analysed with, and the field that analysed text is searched in.
+	Analysis() (analyser, field string)
+}
+
 // ToPipelineQuery creates a pipeline query from a term vector. This can be used to perform analysis on documents (since
 // the term vector is a representation of a document).
 func (tv TermVector) ToPipelineQuery(topic, name string) pipeline.Query {
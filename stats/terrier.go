@@ -1,4 +1,5 @@
-//+build windows
+//go:build windows
+// +build windows
 
 package stats
 
@@ -13,8 +14,10 @@ import (
 	"github.com/hscells/trecresults"
 	"github.com/magiconair/properties"
 	"log"
+	"math"
 	"path/filepath"
 	"strconv"
+	"strings"
 )
 
 // TerrierStatisticsSource is a source of statistics using the terrier information retrieval project;
@@ -27,8 +30,12 @@ type TerrierStatisticsSource struct {
 	env          *jnigi.Env
 	idx          *jnigi.ObjectRef
 	queryManager *jnigi.ObjectRef
+	// termPipeline is the term pipeline (e.g. stopwords and stemming) of the index, if it has one.
+	termPipeline *jnigi.ObjectRef
 
 	field string
+	// fields are the names of the fields of the index, in the order of their statistics.
+	fields []string
 
 	options    SearchOptions
 	parameters map[string]float64
@@ -44,76 +51,171 @@ func (t TerrierStatisticsSource) Parameters() map[string]float64 {
 	return t.parameters
 }
 
-// TermFrequency does not work.
-// TODO implement this.
+// TermFrequency is the term frequency in the field of a document, found with the direct index. Documents are
+// identified by their Terrier document ID (the IDs Execute retrieves).
 func (t TerrierStatisticsSource) TermFrequency(term, field, document string) (float64, error) {
-	panic("implement me")
 	docID, err := strconv.Atoi(document)
 	if err != nil {
 		return 0.0, err
 	}
 
-	// Grab the lexicon object from the index.
-	postingRef, err := t.idx.CallMethod(t.env, "getInvertedIndex", "org/terrier/structures/PostingIndex")
+	entry, err := t.lexiconEntry(term)
+	if err != nil || entry == nil {
+		return 0.0, err
+	}
+	termIDRef, err := entry.CallMethod(t.env, "getTermId", jnigi.Int)
 	if err != nil {
 		return 0.0, err
 	}
+	termID := termIDRef.(int)
 
-	// Cast the lexicon to an appropriate implementation.
-	posting := postingRef.(*jnigi.ObjectRef)
-	posting = posting.Cast("org/terrier/structures/bit/BitPostingIndex")
+	f := t.fieldIndex(field)
+	var tf float64
+	err = t.documentPostings(docID, func(id, freq int, fieldFreqs []int) {
+		if id != termID {
+			return
+		}
+		if f < 0 {
+			tf = float64(freq)
+		} else if f < len(fieldFreqs) {
+			tf = float64(fieldFreqs[f])
+		}
+	})
+	return tf, err
+}
 
-	documentIndexRef, err := t.idx.CallMethod(t.env, "getDocumentIndex", "org/terrier/structures/DocumentIndex")
+// TermVector retrieves the term vector for a document from the direct index. When the index has fields, there is a
+// term in the vector for each field the term occurs in. Documents are identified by their Terrier document ID.
+func (t TerrierStatisticsSource) TermVector(document string) (TermVector, error) {
+	docID, err := strconv.Atoi(document)
 	if err != nil {
-		return 0.0, err
+		return nil, err
 	}
-	documentIndex := documentIndexRef.(*jnigi.ObjectRef)
-	documentEntry, err := documentIndex.CallMethod(t.env, "getDocumentEntry", "org/terrier/structures/DocumentIndexEntry", docID)
-
-	postingsRef, err := posting.CallMethod(t.env, "getPostings", "org/terrier/structures/postings/IterablePosting", documentEntry)
+	lexicon, err := t.lexicon()
 	if err != nil {
-		return 0.0, err
+		return nil, err
 	}
-	postings := postingsRef.(*jnigi.ObjectRef)
 
-	log.Println(postings)
+	var (
+		tv   TermVector
+		errs []error
+	)
+	err = t.documentPostings(docID, func(id, freq int, fieldFreqs []int) {
+		// The lexicon maps the ID of the term to the term and its statistics.
+		pairRef, err := lexicon.CallMethod(t.env, "getLexiconEntry", "java/util/Map$Entry", id)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		pair := pairRef.(*jnigi.ObjectRef)
+		if pair.IsNil() {
+			return
+		}
+		keyRef, err := pair.CallMethod(t.env, "getKey", "java/lang/Object")
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		term, err := goString(t.env, keyRef.(*jnigi.ObjectRef).Cast("java/lang/String"))
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		valueRef, err := pair.CallMethod(t.env, "getValue", "java/lang/Object")
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		entry := valueRef.(*jnigi.ObjectRef).Cast("org/terrier/structures/LexiconEntry")
+		df, err := entryStatistic(t.env, entry, "getDocumentFrequency")
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
 
-	return 0.0, err
-}
+		if len(t.fields) == 0 {
+			ttf, err := entryStatistic(t.env, entry, "getFrequency")
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			tv = append(tv, TermVectorTerm{
+				Term:               term,
+				Field:              t.field,
+				DocumentFrequency:  df,
+				TermFrequency:      float64(freq),
+				TotalTermFrequency: ttf,
+			})
+			return
+		}
 
-// TermVector does not work.
-// TODO implement this.
-func (t TerrierStatisticsSource) TermVector(document string) (TermVector, error) {
-	panic("Implement me")
+		ttfs, err := fieldFrequencies(t.env, entry)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		for f, tf := range fieldFreqs {
+			if tf == 0 || f >= len(t.fields) || f >= len(ttfs) {
+				continue
+			}
+			tv = append(tv, TermVectorTerm{
+				Term:               term,
+				Field:              t.fields[f],
+				DocumentFrequency:  df,
+				TermFrequency:      float64(tf),
+				TotalTermFrequency: float64(ttfs[f]),
+			})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return tv, nil
 }
 
-// DocumentFrequency is the document frequency (the number of documents containing the current term).
+// DocumentFrequency is the document frequency (the number of documents containing the current term). The document
+// frequency in a field is counted from the postings of the term.
 func (t TerrierStatisticsSource) DocumentFrequency(term, field string) (float64, error) {
-	nt, err := lexiconEntryForTerm(t.env, t.idx, "term", "getDocumentFrequency")
-	if err != nil {
-		return 0.0, err
-	}
-	return nt, nil
+	f := t.fieldIndex(field)
+	if f < 0 {
+		return t.lexiconStatistic(term, "getDocumentFrequency")
+	}
+	var nt float64
+	err := t.postings(term, f, func(doc, tf int) {
+		nt++
+	})
+	return nt, err
 }
 
 // TotalTermFrequency is a sum of total term frequencies (the sum of total term frequencies of each term in this field).
 func (t TerrierStatisticsSource) TotalTermFrequency(term, field string) (float64, error) {
-	tf, err := lexiconEntryForTerm(t.env, t.idx, "term", "getFrequency")
-	if err != nil {
+	f := t.fieldIndex(field)
+	if f < 0 {
+		return t.lexiconStatistic(term, "getFrequency")
+	}
+	entry, err := t.lexiconEntry(term)
+	if err != nil || entry == nil {
+		return 0.0, err
+	}
+	ttfs, err := fieldFrequencies(t.env, entry)
+	if err != nil || f >= len(ttfs) {
 		return 0.0, err
 	}
-	return tf, nil
+	return float64(ttfs[f]), nil
 }
 
 // InverseDocumentFrequency is the ratio of of documents in the collection to the number of documents the term appears
 // in, logarithmically smoothed.
 func (t TerrierStatisticsSource) InverseDocumentFrequency(term, field string) (float64, error) {
-	N, err := t.DocumentFrequency(term, field)
+	N, err := t.CollectionSize()
 	if err != nil {
 		return 0.0, err
 	}
 
-	nt, err := t.TotalTermFrequency(term, field)
+	nt, err := t.DocumentFrequency(term, field)
 	if err != nil {
 		return 0.0, err
 	}
@@ -121,28 +223,38 @@ func (t TerrierStatisticsSource) InverseDocumentFrequency(term, field string) (f
 	return idf(N, nt), nil
 }
 
-// RetrievalSize is the minimum number of documents that contains at least one of the query terms.
+// RetrievalSize is the number of documents a Boolean query retrieves, found by evaluating the query on the postings
+// of its terms. The words of a phrase, and the children of an adjacency, are only required to occur in the same
+// document, so the retrieval size of these queries is an upper bound.
 func (t TerrierStatisticsSource) RetrievalSize(query cqr.CommonQueryRepresentation) (float64, error) {
-	resultSet, err := execute(t.env, query, SearchOptions{}, t)
+	docs, err := evaluatePostings(query, t.field, func(term, field string, fn func(doc, tf int)) error {
+		return t.postings(term, t.fieldIndex(field), fn)
+	})
 	if err != nil {
 		return 0.0, err
 	}
-	resultSize, err := resultSet.CallMethod(t.env, "getResultSize", jnigi.Int)
-	if err != nil {
-		return 0.0, err
-	}
-
-	return float64(resultSize.(int)), nil
+	return float64(len(docs)), nil
 }
 
 // VocabularySize is the total number of terms in the vocabulary.
 func (t TerrierStatisticsSource) VocabularySize(field string) (float64, error) {
-	collStatsRef, err := t.idx.CallMethod(t.env, "getCollectionStatistics", "org/terrier/structures/CollectionStatistics")
+	collStats, err := t.collectionStatistics()
 	if err != nil {
 		return 0.0, err
 	}
 
-	collStats := collStatsRef.(*jnigi.ObjectRef)
+	if f := t.fieldIndex(field); f >= 0 {
+		tokensRef, err := collStats.CallMethod(t.env, "getFieldTokens", jnigi.Long|jnigi.Array)
+		if err != nil {
+			return 0.0, err
+		}
+		tokens := tokensRef.([]int64)
+		if f >= len(tokens) {
+			return 0.0, nil
+		}
+		return float64(tokens[f]), nil
+	}
+
 	vocabRef, err := collStats.CallMethod(t.env, "getNumberOfTokens", jnigi.Long)
 	if err != nil {
 		return 0.0, err
@@ -153,10 +265,6 @@ func (t TerrierStatisticsSource) VocabularySize(field string) (float64, error) {
 
 // Execute issues a query to terrier.
 func (t TerrierStatisticsSource) Execute(query gpipeline.Query, options SearchOptions) (trecresults.ResultList, error) {
-	var (
-		scores, docIDs []int64
-		N              int
-	)
 	trecResultSet := trecresults.ResultList{}
 
 	// Grab the result set from terrier.
@@ -172,25 +280,19 @@ func (t TerrierStatisticsSource) Execute(query gpipeline.Query, options SearchOp
 	}
 
 	// Get the doc ids and scores from terrier.
-	N = resultSize.(int)
+	N := resultSize.(int)
 	docIdsRef, err := resultSet.CallMethod(t.env, "getDocids", jnigi.Int|jnigi.Array)
 	if err != nil {
 		return trecResultSet, err
 	}
-	scoresRef, err := resultSet.CallMethod(t.env, "getScores", jnigi.Int|jnigi.Array)
+	scoresRef, err := resultSet.CallMethod(t.env, "getScores", jnigi.Double|jnigi.Array)
 	if err != nil {
 		return trecResultSet, err
 	}
-
-	// Cast everything to Go.
-	docIDs = make([]int64, N)
-	for i, docID := range docIdsRef.([]interface{}) {
-		docIDs[i] = docID.(int64)
-	}
-
-	scores = make([]int64, N)
-	for i, score := range scoresRef.([]interface{}) {
-		scores[i] = score.(int64)
+	docIDs := docIdsRef.([]int)
+	scores := scoresRef.([]float64)
+	if len(docIDs) < N {
+		N = len(docIDs)
 	}
 
 	// Populate the trec results list.
@@ -199,9 +301,9 @@ func (t TerrierStatisticsSource) Execute(query gpipeline.Query, options SearchOp
 		trecResultSet[i] = &trecresults.Result{
 			Topic:     query.Topic,
 			Iteration: "Q0",
-			DocId:     strconv.FormatInt(docIDs[i], 10),
+			DocId:     strconv.Itoa(docIDs[i]),
 			Rank:      int64(i),
-			Score:     float64(scores[i]),
+			Score:     scores[i],
 			RunName:   options.RunName,
 		}
 	}
@@ -209,8 +311,17 @@ func (t TerrierStatisticsSource) Execute(query gpipeline.Query, options SearchOp
 	return trecResultSet, nil
 }
 
+// CollectionSize is the number of documents in the index.
 func (t TerrierStatisticsSource) CollectionSize() (float64, error) {
-	panic("implement me")
+	collStats, err := t.collectionStatistics()
+	if err != nil {
+		return 0.0, err
+	}
+	n, err := collStats.CallMethod(t.env, "getNumberOfDocuments", jnigi.Int)
+	if err != nil {
+		return 0.0, err
+	}
+	return float64(n.(int)), nil
 }
 
 // execute executes a query on terrier.
@@ -266,46 +377,196 @@ func execute(env *jnigi.Env, query cqr.CommonQueryRepresentation, options Search
 
 }
 
-// lexiconEntryForTerm
-func lexiconEntryForTerm(env *jnigi.Env, idx *jnigi.ObjectRef, term, statistic string) (float64, error) {
-	// Grab the lexicon object from the index.
-	lexiconRef, err := idx.CallMethod(env, "getLexicon", "org/terrier/structures/Lexicon")
+// eol is the ID that postings iterators return once they are exhausted (IterablePosting.EOL).
+const eol = math.MaxInt32
+
+// collectionStatistics gets the collection statistics of the index.
+func (t TerrierStatisticsSource) collectionStatistics() (*jnigi.ObjectRef, error) {
+	collStatsRef, err := t.idx.CallMethod(t.env, "getCollectionStatistics", "org/terrier/structures/CollectionStatistics")
 	if err != nil {
-		return 0.0, err
+		return nil, err
 	}
+	return collStatsRef.(*jnigi.ObjectRef), nil
+}
 
-	// Cast the lexicon to an appropriate implementation.
-	lexicon := lexiconRef.(*jnigi.ObjectRef)
-	lexicon = lexicon.Cast("org/terrier/structures/FSOMapFileLexicon")
+// lexicon gets the lexicon of the index.
+func (t TerrierStatisticsSource) lexicon() (*jnigi.ObjectRef, error) {
+	lexiconRef, err := t.idx.CallMethod(t.env, "getLexicon", "org/terrier/structures/Lexicon")
+	if err != nil {
+		return nil, err
+	}
+	return lexiconRef.(*jnigi.ObjectRef), nil
+}
 
-	// Wrap the term to analyse.
-	jTerm, err := env.NewObject("java/lang/String", []byte(term))
+// lexiconEntry gets the lexicon entry of a term, or nil if the term is not in the index. Terms are lowercased and
+// passed through the term pipeline of the index, so they are looked up in the form they are indexed in (e.g. stemmed).
+func (t TerrierStatisticsSource) lexiconEntry(term string) (*jnigi.ObjectRef, error) {
+	lexicon, err := t.lexicon()
 	if err != nil {
-		return 0.0, err
+		return nil, err
+	}
+
+	// Wrap the term to look up.
+	jTerm, err := t.env.NewObject("java/lang/String", []byte(strings.ToLower(term)))
+	if err != nil {
+		return nil, err
+	}
+	if t.termPipeline != nil {
+		termRef, err := t.termPipeline.CallMethod(t.env, "pipelineTerm", "java/lang/String", jTerm)
+		if err != nil {
+			return nil, err
+		}
+		jTerm = termRef.(*jnigi.ObjectRef)
+		// Terms removed by the pipeline (e.g. stopwords) are not in the index.
+		if jTerm.IsNil() {
+			return nil, nil
+		}
 	}
 
 	// Get the LexiconEntry object for the term.
-	lexEntryRef, err := lexicon.CallMethod(env, "getLexiconEntry", "org/terrier/structures/LexiconEntry", jTerm)
+	lexEntryRef, err := lexicon.CallMethod(t.env, "getLexiconEntry", "org/terrier/structures/LexiconEntry", jTerm)
 	if err != nil {
-		return 0.0, err
+		return nil, err
 	}
 	lexEntry := lexEntryRef.(*jnigi.ObjectRef)
 
-	// If the term isn't in the index, we want to return 0 instead of an error.
+	// If the term isn't in the index, there is no entry instead of an error.
 	if lexEntry.IsNil() {
-		return 0.0, nil
+		return nil, nil
 	}
+	return lexEntry, nil
+}
 
-	// Otherwise, the term exists and we can get a statistic for it.
-	result, err := lexEntry.CallMethod(env, statistic, jnigi.Int)
-	if err != nil {
+// lexiconStatistic gets a statistic (e.g. getDocumentFrequency) of the lexicon entry of a term, or zero if the term
+// is not in the index.
+func (t TerrierStatisticsSource) lexiconStatistic(term, statistic string) (float64, error) {
+	entry, err := t.lexiconEntry(term)
+	if err != nil || entry == nil {
 		return 0.0, err
 	}
+	return entryStatistic(t.env, entry, statistic)
+}
 
-	// And then return that statistic as a Go type.
+// entryStatistic gets a statistic of a lexicon entry.
+func entryStatistic(env *jnigi.Env, entry *jnigi.ObjectRef, statistic string) (float64, error) {
+	result, err := entry.CallMethod(env, statistic, jnigi.Int)
+	if err != nil {
+		return 0.0, err
+	}
 	return float64(result.(int)), nil
 }
 
+// fieldFrequencies gets the total term frequency in each field of the lexicon entry of a term.
+func fieldFrequencies(env *jnigi.Env, entry *jnigi.ObjectRef) ([]int, error) {
+	freqs, err := entry.Cast("org/terrier/structures/FieldLexiconEntry").CallMethod(env, "getFieldFrequencies", jnigi.Int|jnigi.Array)
+	if err != nil {
+		return nil, err
+	}
+	return freqs.([]int), nil
+}
+
+// goString converts a Java string to a Go string.
+func goString(env *jnigi.Env, s *jnigi.ObjectRef) (string, error) {
+	b, err := s.CallMethod(env, "getBytes", jnigi.Byte|jnigi.Array)
+	if err != nil {
+		return "", err
+	}
+	return string(b.([]byte)), nil
+}
+
+// fieldIndex is the position of a field in the fields of the index, or -1 if the index has no such field (in which
+// case statistics are of whole documents).
+func (t TerrierStatisticsSource) fieldIndex(field string) int {
+	for i, f := range t.fields {
+		if strings.EqualFold(f, field) {
+			return i
+		}
+	}
+	return -1
+}
+
+// iterate calls fn for each posting of an iterator, until the iterator is exhausted. The frequencies of each field
+// are only read when fields is true.
+func (t TerrierStatisticsSource) iterate(postings *jnigi.ObjectRef, fields bool, fn func(id, freq int, fieldFreqs []int)) error {
+	defer postings.CallMethod(t.env, "close", jnigi.Void)
+	fieldPostings := postings.Cast("org/terrier/structures/postings/FieldPosting")
+	for {
+		idRef, err := postings.CallMethod(t.env, "next", jnigi.Int)
+		if err != nil {
+			return err
+		}
+		id := idRef.(int)
+		if id == eol {
+			return nil
+		}
+		freqRef, err := postings.CallMethod(t.env, "getFrequency", jnigi.Int)
+		if err != nil {
+			return err
+		}
+		var fieldFreqs []int
+		if fields {
+			freqs, err := fieldPostings.CallMethod(t.env, "getFieldFrequencies", jnigi.Int|jnigi.Array)
+			if err != nil {
+				return err
+			}
+			fieldFreqs = freqs.([]int)
+		}
+		fn(id, freqRef.(int), fieldFreqs)
+	}
+}
+
+// postings calls fn with each document that contains a term, in the order of the inverted index, and the frequency
+// of the term in the document. If field is not negative, only the documents that contain the term in the field are
+// given, with the frequency of the term in the field.
+func (t TerrierStatisticsSource) postings(term string, field int, fn func(doc, tf int)) error {
+	entry, err := t.lexiconEntry(term)
+	if err != nil || entry == nil {
+		return err
+	}
+	invertedRef, err := t.idx.CallMethod(t.env, "getInvertedIndex", "org/terrier/structures/PostingIndex")
+	if err != nil {
+		return err
+	}
+	postingsRef, err := invertedRef.(*jnigi.ObjectRef).CallMethod(t.env, "getPostings", "org/terrier/structures/postings/IterablePosting", entry.Cast("org/terrier/structures/Pointer"))
+	if err != nil {
+		return err
+	}
+	return t.iterate(postingsRef.(*jnigi.ObjectRef), field >= 0, func(id, freq int, fieldFreqs []int) {
+		if field < 0 {
+			fn(id, freq)
+		} else if field < len(fieldFreqs) && fieldFreqs[field] > 0 {
+			fn(id, fieldFreqs[field])
+		}
+	})
+}
+
+// documentPostings calls fn with the ID of each term in a document, its frequency in the document, and (if the index
+// has fields) its frequency in each field, using the direct index.
+func (t TerrierStatisticsSource) documentPostings(docID int, fn func(id, freq int, fieldFreqs []int)) error {
+	documentIndexRef, err := t.idx.CallMethod(t.env, "getDocumentIndex", "org/terrier/structures/DocumentIndex")
+	if err != nil {
+		return err
+	}
+	documentEntryRef, err := documentIndexRef.(*jnigi.ObjectRef).CallMethod(t.env, "getDocumentEntry", "org/terrier/structures/DocumentIndexEntry", docID)
+	if err != nil {
+		return err
+	}
+	documentEntry := documentEntryRef.(*jnigi.ObjectRef)
+	if documentEntry.IsNil() {
+		return fmt.Errorf("no document %d in the index", docID)
+	}
+
+	directRef, err := t.idx.CallMethod(t.env, "getDirectIndex", "org/terrier/structures/PostingIndex")
+	if err != nil {
+		return err
+	}
+	postingsRef, err := directRef.(*jnigi.ObjectRef).CallMethod(t.env, "getPostings", "org/terrier/structures/postings/IterablePosting", documentEntry.Cast("org/terrier/structures/Pointer"))
+	if err != nil {
+		return err
+	}
+	return t.iterate(postingsRef.(*jnigi.ObjectRef), len(t.fields) > 0, fn)
+}
+
 // TerrierPropertiesPath sets the properties path field.
 func TerrierPropertiesPath(path string) func(*TerrierStatisticsSource) {
 	return func(t *TerrierStatisticsSource) {
@@ -338,6 +599,11 @@ func TerrierParameters(params map[string]float64) func(*TerrierStatisticsSource)
 	}
 }
 
+// Identity is the Terrier index that is searched.
+func (t TerrierStatisticsSource) Identity() string {
+	return t.indexPath + "/" + t.indexPrefix + "/" + t.field
+}
+
 // NewTerrierStatisticsSource creates a new terrier statistics source.
 func NewTerrierStatisticsSource(options ...func(*TerrierStatisticsSource)) *TerrierStatisticsSource {
 	t := TerrierStatisticsSource{}
@@ -384,5 +650,56 @@ func NewTerrierStatisticsSource(options ...func(*TerrierStatisticsSource)) *Terr
 	t.idx = idx
 	t.env = env
 	t.queryManager = queryManager
+
+	// The fields of the index (e.g. TITLE,ABSTRACT) are recorded in its properties.
+	names, err := indexProperty(env, idx, "index.inverted.fields.names", "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			t.fields = append(t.fields, name)
+		}
+	}
+
+	// Terms are looked up with the term pipeline the index was built with, which defaults to the term pipeline of
+	// the properties (and to that of Terrier).
+	pipes, err := indexProperty(env, idx, "termpipelines", p.GetString("termpipelines", "Stopwords,PorterStemmer"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var jPipes []*jnigi.ObjectRef
+	for _, pipe := range strings.Split(pipes, ",") {
+		if pipe = strings.TrimSpace(pipe); len(pipe) > 0 {
+			jPipe, err := env.NewObject("java/lang/String", []byte(pipe))
+			if err != nil {
+				log.Fatal(err)
+			}
+			jPipes = append(jPipes, jPipe)
+		}
+	}
+	if len(jPipes) > 0 {
+		t.termPipeline, err = env.NewObject("org/terrier/terms/BaseTermPipelineAccessor", env.ToObjectArray(jPipes, "java/lang/String"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 	return &t
 }
+
+// indexProperty gets a property of an index, or the default value if the index does not have the property.
+func indexProperty(env *jnigi.Env, idx *jnigi.ObjectRef, key, def string) (string, error) {
+	jKey, err := env.NewObject("java/lang/String", []byte(key))
+	if err != nil {
+		return "", err
+	}
+	jDefault, err := env.NewObject("java/lang/String", []byte(def))
+	if err != nil {
+		return "", err
+	}
+	valueRef, err := idx.CallMethod(env, "getIndexProperty", "java/lang/String", jKey, jDefault)
+	if err != nil {
+		return "", err
+	}
+	return goString(env, valueRef.(*jnigi.ObjectRef))
+}
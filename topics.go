@@ -0,0 +1,142 @@
+package groove
+
+import (
+	"fmt"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/pipeline"
+	"hash/fnv"
+	"math/rand"
+	"path"
+	"sort"
+)
+
+// TopicSelection selects the topics of a pipeline that are processed. The zero value selects every topic.
+type TopicSelection struct {
+	// Include lists the topics that are processed, as topic IDs or glob patterns (see path.Match). When empty, every
+	// topic is included.
+	Include []string
+	// Exclude lists the topics that are not processed, as topic IDs or glob patterns. Exclusions take precedence over
+	// inclusions.
+	Exclude []string
+	// Shard and Shards split the topics across Shards runs, of which this run processes the topics in Shard (counting
+	// from zero). Topics are assigned to shards by a hash of their ID, so each topic is in exactly one shard no matter
+	// which other topics are loaded.
+	Shard, Shards int
+}
+
+// SelectTopics configures which topics of the pipeline are processed.
+func SelectTopics(selection TopicSelection) func() interface{} {
+	return func() interface{} {
+		return selection
+	}
+}
+
+// Validate reports whether the patterns and shard of the selection are valid.
+func (s TopicSelection) Validate() error {
+	for _, pattern := range append(append([]string{}, s.Include...), s.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("topic pattern %q: %w", pattern, err)
+		}
+	}
+	if s.Shards < 0 || (s.Shards > 0 && (s.Shard < 0 || s.Shard >= s.Shards)) {
+		return fmt.Errorf("shard %d of %d does not exist", s.Shard, s.Shards)
+	}
+	return nil
+}
+
+// Selects reports whether a topic is selected.
+func (s TopicSelection) Selects(topic string) bool {
+	if len(s.Include) > 0 && !matchTopic(s.Include, topic) {
+		return false
+	}
+	if matchTopic(s.Exclude, topic) {
+		return false
+	}
+	if s.Shards > 1 {
+		h := fnv.New32a()
+		h.Write([]byte(topic))
+		return int(h.Sum32()%uint32(s.Shards)) == s.Shard
+	}
+	return true
+}
+
+// Select returns the selected queries, in the same order.
+func (s TopicSelection) Select(queries []pipeline.Query) []pipeline.Query {
+	var selected []pipeline.Query
+	for _, q := range queries {
+		if s.Selects(q.Topic) {
+			selected = append(selected, q)
+		}
+	}
+	return selected
+}
+
+func matchTopic(patterns []string, topic string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, topic); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Scheduler orders the queries of a pipeline before they are processed. The queries are given after they have been
+// preprocessed and transformed, in the order they were loaded by the query source.
+type Scheduler func(p Pipeline, queries []pipeline.Query)
+
+// ScheduleTopics configures the order the topics of the pipeline are processed in. By default, the topics are
+// processed in ascending order of complexity.
+func ScheduleTopics(scheduler Scheduler) func() interface{} {
+	return func() interface{} {
+		return scheduler
+	}
+}
+
+// complexity is the number of Boolean sub-queries in a query.
+func complexity(q pipeline.Query) int {
+	return len(analysis.QueryBooleanQueries(q.Query))
+}
+
+// ComplexityAscending processes the simplest queries (those with the fewest Boolean sub-queries) first.
+func ComplexityAscending(_ Pipeline, queries []pipeline.Query) {
+	sort.SliceStable(queries, func(i, j int) bool {
+		return complexity(queries[i]) < complexity(queries[j])
+	})
+}
+
+// ComplexityDescending processes the most complex queries (those with the most Boolean sub-queries) first.
+func ComplexityDescending(_ Pipeline, queries []pipeline.Query) {
+	sort.SliceStable(queries, func(i, j int) bool {
+		return complexity(queries[i]) > complexity(queries[j])
+	})
+}
+
+// EstimatedCost processes the queries that make the fewest requests to the statistics source first. The requests are
+// estimated as they are by Pipeline.Plan, without making them.
+func EstimatedCost(p Pipeline, queries []pipeline.Query) {
+	cost := make(map[string]int, len(queries))
+	for _, q := range queries {
+		for _, m := range p.Measurements {
+			calls, _ := countRequests(m, q, p.StatisticsSource)
+			for _, n := range calls {
+				cost[q.Topic] += n
+			}
+		}
+	}
+	sort.SliceStable(queries, func(i, j int) bool {
+		return cost[queries[i].Topic] < cost[queries[j].Topic]
+	})
+}
+
+// FileOrder processes the queries in the order they were loaded by the query source.
+func FileOrder(Pipeline, []pipeline.Query) {}
+
+// Random processes the queries in a random order, which is the same for the same seed and queries.
+func Random(seed int64) Scheduler {
+	return func(_ Pipeline, queries []pipeline.Query) {
+		r := rand.New(rand.NewSource(seed))
+		r.Shuffle(len(queries), func(i, j int) {
+			queries[i], queries[j] = queries[j], queries[i]
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package groove
+
+import (
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/preprocess"
+	"github.com/hscells/groove/stats"
+	"reflect"
+	"testing"
+)
+
+func TestTopicSelection(t *testing.T) {
+	queries := topics("CD007394", "CD007427", "CD008054", "CD009020", "CD009593")
+	tests := []struct {
+		name      string
+		selection TopicSelection
+		selected  []string
+	}{
+		{"all", TopicSelection{}, []string{"CD007394", "CD007427", "CD008054", "CD009020", "CD009593"}},
+		{"include", TopicSelection{Include: []string{"CD008054", "CD0093*"}}, []string{"CD008054"}},
+		{"include glob", TopicSelection{Include: []string{"CD0074*"}}, []string{"CD007427"}},
+		{"exclude", TopicSelection{Exclude: []string{"CD007*"}}, []string{"CD008054", "CD009020", "CD009593"}},
+		{"exclude included", TopicSelection{Include: []string{"CD009*"}, Exclude: []string{"CD009020"}}, []string{"CD009593"}},
+		{"one shard", TopicSelection{Shards: 1}, []string{"CD007394", "CD007427", "CD008054", "CD009020", "CD009593"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.selection.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			var selected []string
+			for _, q := range tt.selection.Select(queries) {
+				selected = append(selected, q.Topic)
+			}
+			if !reflect.DeepEqual(selected, tt.selected) {
+				t.Errorf("expected %v, got %v", tt.selected, selected)
+			}
+		})
+	}
+}
+
+func TestLoadQueries(t *testing.T) {
+	p := Pipeline{
+		QueriesSource: topics("CD007394", "CD008054"),
+		Topics:        TopicSelection{Include: []string{"CD008*"}},
+		Preprocess:    []preprocess.QueryProcessor{preprocess.Lowercase},
+	}
+	queries, err := p.LoadQueries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 1 || queries[0].Topic != "CD008054" {
+		t.Fatalf("expected topic CD008054 to be selected, got %v", queries)
+	}
+	q, err := p.ProcessQuery(queries[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := q.Query.(cqr.Keyword); !ok || k.QueryString != "topic cd008054" {
+		t.Errorf("expected the query to be preprocessed, got %v", q.Query)
+	}
+
+	p.Topics = TopicSelection{Shards: -1}
+	if _, err := p.LoadQueries(); err == nil {
+		t.Error("expected an error loading an invalid topic selection")
+	}
+}
+
+func TestTopicSelectionValidate(t *testing.T) {
+	for _, s := range []TopicSelection{
+		{Include: []string{"CD["}},
+		{Exclude: []string{"["}},
+		{Shard: 3, Shards: 3},
+		{Shard: -1, Shards: 2},
+		{Shards: -1},
+	} {
+		if err := s.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", s)
+		}
+	}
+}
+
+func TestTopicSelectionShards(t *testing.T) {
+	var queries []pipeline.Query
+	for i := 0; i < 200; i++ {
+		queries = append(queries, topics(fmt.Sprintf("CD%06d", i))...)
+	}
+	for _, shards := range []int{2, 3, 7} {
+		t.Run(fmt.Sprint(shards), func(t *testing.T) {
+			// Every topic is in exactly one shard, and (for enough topics) every shard has topics.
+			seen := make(map[string]int)
+			for shard := 0; shard < shards; shard++ {
+				s := TopicSelection{Shard: shard, Shards: shards}
+				selected := s.Select(queries)
+				if len(selected) == 0 {
+					t.Errorf("shard %d of %d has no topics", shard, shards)
+				}
+				for _, q := range selected {
+					seen[q.Topic]++
+				}
+			}
+			for _, q := range queries {
+				if seen[q.Topic] != 1 {
+					t.Errorf("expected topic %s in one shard, got %d", q.Topic, seen[q.Topic])
+				}
+			}
+
+			// The shard of a topic does not depend on the other topics loaded.
+			s := TopicSelection{Shard: 1, Shards: shards}
+			for _, q := range queries[:10] {
+				if s.Selects(q.Topic) != (len(s.Select([]pipeline.Query{q})) == 1) {
+					t.Errorf("expected topic %s to be selected alone as it is with other topics", q.Topic)
+				}
+			}
+		})
+	}
+}
+
+func TestSchedulers(t *testing.T) {
+	// Topic a has one Boolean query, b three, c none, and d two.
+	k := func(s string) cqr.CommonQueryRepresentation { return cqr.NewKeyword(s, "title") }
+	or := func(children ...cqr.CommonQueryRepresentation) cqr.CommonQueryRepresentation {
+		return cqr.NewBooleanQuery(cqr.OR, children)
+	}
+	queries := []pipeline.Query{
+		pipeline.NewQuery("a", "a", or(k("x"), k("y"))),
+		pipeline.NewQuery("b", "b", or(or(k("x"), k("y")), or(k("z"), k("w")))),
+		pipeline.NewQuery("c", "c", k("x")),
+		pipeline.NewQuery("d", "d", or(or(k("x"), k("y")), k("z"))),
+	}
+	// Each keyword of a query is a request to the statistics source.
+	keywords := measurementFunc{name: "Keywords", fn: func(q pipeline.Query, ss stats.StatisticsSource) (float64, error) {
+		var n float64
+		for _, kw := range analysis.QueryKeywords(q.Query) {
+			df, err := ss.DocumentFrequency(kw.QueryString, "title")
+			if err != nil {
+				return 0, err
+			}
+			n += df
+		}
+		return n, nil
+	}}
+	p := NewGroovePipeline(queryCopy(queries), statisticsSource{}, measuring(keywords))
+
+	tests := []struct {
+		name      string
+		scheduler Scheduler
+		order     []string
+	}{
+		{"file order", FileOrder, []string{"a", "b", "c", "d"}},
+		{"complexity ascending", ComplexityAscending, []string{"c", "a", "d", "b"}},
+		{"complexity descending", ComplexityDescending, []string{"b", "d", "a", "c"}},
+		{"estimated cost", EstimatedCost, []string{"c", "a", "d", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := queryCopy(queries)
+			tt.scheduler(p, q)
+			if order := topicsOf(q); !reflect.DeepEqual(order, tt.order) {
+				t.Errorf("expected %v, got %v", tt.order, order)
+			}
+		})
+	}
+}
+
+func TestRandomScheduler(t *testing.T) {
+	var queries []pipeline.Query
+	for i := 0; i < 20; i++ {
+		queries = append(queries, topics(fmt.Sprint(i))...)
+	}
+	order := func(seed int64) []string {
+		q := queryCopy(queries)
+		Random(seed)(Pipeline{}, q)
+		return topicsOf(q)
+	}
+	if a, b := order(42), order(42); !reflect.DeepEqual(a, b) {
+		t.Errorf("expected the same order for the same seed, got %v and %v", a, b)
+	}
+	if a, b := order(42), order(43); reflect.DeepEqual(a, b) {
+		t.Errorf("expected different orders for different seeds, got %v", a)
+	}
+	if reflect.DeepEqual(order(42), topicsOf(queries)) {
+		t.Error("expected the queries to be shuffled")
+	}
+}
+
+func queryCopy(queries []pipeline.Query) queriesSource {
+	return append(queriesSource{}, queries...)
+}
+
+func topicsOf(queries []pipeline.Query) []string {
+	var t []string
+	for _, q := range queries {
+		t = append(t, q.Topic)
+	}
+	return t
+}